@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Clients that request lots of different articles in a short time, like
+// scripts flooding unique titles or crawlers walking every link, are quietly
+// slowed down. Each time a client goes over the limit its throttle level
+// rises and every generation it starts waits a little longer first. Nothing
+// tells the client it is being throttled. Levels drop again after a quiet
+// spell.
+
+// abuseWindow is the period unique titles are counted over.
+const abuseWindow = time.Minute
+
+// abuseCooldown is how long a client must stay under the limit to drop a
+// throttle level.
+const abuseCooldown = 15 * time.Minute
+
+// abuseDelays is how long each throttle level waits before generating.
+var abuseDelays = []time.Duration{0, 5 * time.Second, 20 * time.Second, time.Minute}
+
+type abuseClient struct {
+	starts    []abuseStart
+	level     int
+	changed   time.Time
+	lastStart time.Time
+}
+
+type abuseStart struct {
+	at    time.Time
+	title string
+}
+
+var abuse = struct {
+	mu      sync.Mutex
+	clients map[string]*abuseClient
+	pruned  time.Time
+}{
+	clients: map[string]*abuseClient{},
+}
+
+// throttle records that a request is starting a generation and waits out the
+// client's throttle, if any. It returns early with an error if the request is
+// cancelled while waiting.
+func throttle(ctx context.Context, r *http.Request, title string) error {
+	delay := recordStart(ctx, clientIP(r), title)
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recordStart notes a generation by client and returns how long it should
+// be held back.
+func recordStart(ctx context.Context, client, title string) time.Duration {
+	limit := settings.AbuseThreshold
+	if limit <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+
+	abuse.mu.Lock()
+	defer abuse.mu.Unlock()
+
+	// Forget clients that have gone quiet
+	if now.Sub(abuse.pruned) > abuseCooldown {
+		for ip, c := range abuse.clients {
+			if now.Sub(c.lastStart) > abuseCooldown && c.level == 0 {
+				delete(abuse.clients, ip)
+			}
+		}
+		abuse.pruned = now
+	}
+
+	c := abuse.clients[client]
+	if c == nil {
+		c = &abuseClient{changed: now}
+		abuse.clients[client] = c
+	}
+	c.lastStart = now
+
+	// Drop a level for every cooldown spent below the limit
+	for c.level > 0 && now.Sub(c.changed) > abuseCooldown {
+		c.level--
+		c.changed = c.changed.Add(abuseCooldown)
+	}
+
+	recent := c.starts[:0]
+	for _, start := range c.starts {
+		if now.Sub(start.at) < abuseWindow {
+			recent = append(recent, start)
+		}
+	}
+	c.starts = append(recent, abuseStart{at: now, title: title})
+
+	unique := map[string]bool{}
+	for _, start := range c.starts {
+		unique[start.title] = true
+	}
+
+	// Every multiple of the limit within the window is another level
+	level := 0
+	for n := limit; len(unique) > n && level < len(abuseDelays)-1; n *= 2 {
+		level++
+	}
+	if level > c.level {
+		c.level = level
+		c.changed = now
+		audit(AuditEvent{
+			Event:  "throttle",
+			Client: client,
+			Wiki:   wikiFrom(ctx).Name,
+			Detail: fmt.Sprintf("level %d after %d unique titles in %s", level, len(unique), abuseWindow),
+		})
+	}
+
+	return abuseDelays[c.level]
+}
+
+// throttleLevel returns how far over the limit a client has gone lately, from
+// 0 for not at all.
+func throttleLevel(client string) int {
+	abuse.mu.Lock()
+	defer abuse.mu.Unlock()
+
+	if c, ok := abuse.clients[client]; ok {
+		return c.level
+	}
+	return 0
+}
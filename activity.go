@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ActivityEvent is a generation starting or finishing somewhere on the
+// instance, shown in the live ticker.
+type ActivityEvent struct {
+	Type  string    `json:"type"` // "generating" or "completed"
+	Title string    `json:"title"`
+	Kind  string    `json:"kind,omitempty"`
+	Time  time.Time `json:"time"`
+
+	// Summary is the opening of a completed article for announcements. The
+	// ticker only shows titles, so it isn't sent to readers.
+	Summary string `json:"-"`
+}
+
+// maxRecentActivity is how many recent completions a new subscriber sees.
+const maxRecentActivity = 20
+
+// maxPopularTitles is how many titles have their completions counted. Past
+// it, every count is halved so old favorites fade out.
+const maxPopularTitles = 1000
+
+type activityHub struct {
+	mu          sync.Mutex
+	subscribers map[chan ActivityEvent]struct{}
+	recent      []ActivityEvent
+	popularity  map[string]int
+}
+
+// activity is the default wiki's feed. Other wikis have their own.
+var activity = newActivityHub()
+
+func newActivityHub() *activityHub {
+	return &activityHub{
+		subscribers: map[chan ActivityEvent]struct{}{},
+		popularity:  map[string]int{},
+	}
+}
+
+// publish sends an event to every subscriber. Slow subscribers miss events
+// rather than holding up generation.
+func (h *activityHub) publish(event ActivityEvent) {
+	event.Time = time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if event.Type == "completed" {
+		h.recent = append(h.recent, event)
+		if len(h.recent) > maxRecentActivity {
+			h.recent = h.recent[len(h.recent)-maxRecentActivity:]
+		}
+
+		// Only encyclopedia articles count, the other kinds live elsewhere
+		if event.Kind == "" {
+			h.popularity[event.Title]++
+			if len(h.popularity) > maxPopularTitles {
+				for title, count := range h.popularity {
+					if count/2 == 0 {
+						delete(h.popularity, title)
+					} else {
+						h.popularity[title] = count / 2
+					}
+				}
+			}
+		}
+	}
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of new events along with the recent
+// completions so the ticker doesn't start empty.
+func (h *activityHub) subscribe() (chan ActivityEvent, []ActivityEvent) {
+	ch := make(chan ActivityEvent, 16)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.subscribers[ch] = struct{}{}
+	return ch, append([]ActivityEvent(nil), h.recent...)
+}
+
+// recentCompletions returns the latest completed articles, oldest first.
+func (h *activityHub) recentCompletions() []ActivityEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]ActivityEvent(nil), h.recent...)
+}
+
+// popularTitles returns how often each article has been generated lately.
+func (h *activityHub) popularTitles() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := make(map[string]int, len(h.popularity))
+	for title, count := range h.popularity {
+		counts[title] = count
+	}
+	return counts
+}
+
+func (h *activityHub) unsubscribe(ch chan ActivityEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, ch)
+}
+
+func activityHandler(w http.ResponseWriter, r *http.Request) {
+	if !settingsFor(r.Context()).Activity {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Set headers for Server-Sent Events
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	hub := activityFor(r.Context())
+	ch, recent := hub.subscribe()
+	defer hub.unsubscribe(ch)
+
+	for _, event := range recent {
+		writeActivityEvent(w, event)
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			writeActivityEvent(w, event)
+		}
+	}
+}
+
+func writeActivityEvent(w http.ResponseWriter, event ActivityEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "event: activity\ndata: %s\n\n", data)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// The admin panel at /admin manages the wikis in WIKIS_FILE, the site banner
+// and the moderation queue of reported articles, and the admin cache API
+// manages what is cached. They are only served when ADMIN_PASSWORD is set,
+// behind HTTP basic auth.
+
+// requireAdmin guards an admin handler with the admin password.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		password := os.Getenv("ADMIN_PASSWORD")
+		if password == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, given, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="endless wiki admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Browsers resend basic auth on their own, so make sure changes
+		// come from the panel itself
+		if r.Method != http.MethodGet {
+			if origin := r.Header.Get("Origin"); origin != "" {
+				if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+					http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// adminWiki is a wiki as shown in the panel.
+type adminWiki struct {
+	Name     string
+	Hosts    string
+	Model    string
+	SiteName string
+	Settings string
+	Default  bool
+}
+
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	wikisMu.RLock()
+	var list []adminWiki
+	for _, wiki := range allWikisLocked() {
+		entry := adminWiki{
+			Name:     wiki.Name,
+			Hosts:    strings.Join(wiki.Hosts, ", "),
+			Model:    wiki.Settings.Model,
+			SiteName: wiki.Settings.siteName(),
+			Default:  wiki == defaultWiki,
+		}
+		if config, ok := wikiConfigs[wiki.Name]; ok {
+			entry.Settings = string(config.Settings)
+		}
+		list = append(list, entry)
+	}
+	wikisMu.RUnlock()
+
+	data := struct {
+		Wikis    []adminWiki
+		Editable bool
+		Banner   Banner
+		Reports  []adminReport
+		// Store is whether articles are kept, and Compression what
+		// compressing them has saved
+		Store       bool
+		Compression CompressionSummary
+		// Hosts are the ollama hosts generations are balanced over
+		Hosts []HostStatus
+	}{
+		Wikis:       list,
+		Editable:    wikisFile != "",
+		Banner:      siteBanner(),
+		Reports:     moderationQueue(),
+		Store:       articles != nil,
+		Compression: compressionSummary(),
+		Hosts:       hostStatuses(),
+	}
+
+	renderPage(w, "admin.html", data)
+}
+
+// adminSaveWikiHandler creates or updates a wiki from the panel.
+func adminSaveWikiHandler(w http.ResponseWriter, r *http.Request) {
+	config := wikiConfig{Name: strings.TrimSpace(r.FormValue("name"))}
+	for _, host := range strings.Split(r.FormValue("hosts"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			config.Hosts = append(config.Hosts, host)
+		}
+	}
+	if raw := strings.TrimSpace(r.FormValue("settings")); raw != "" {
+		if !json.Valid([]byte(raw)) {
+			http.Error(w, "Settings must be a JSON object", http.StatusBadRequest)
+			return
+		}
+		config.Settings = json.RawMessage(raw)
+	}
+
+	wikisMu.Lock()
+	for _, host := range config.Hosts {
+		if other, ok := wikisByHost[strings.ToLower(host)]; ok && other.Name != config.Name {
+			wikisMu.Unlock()
+			http.Error(w, "Host "+host+" already belongs to "+other.Name, http.StatusBadRequest)
+			return
+		}
+	}
+	wiki, err := newWiki(config, wikis[config.Name])
+	if err != nil {
+		wikisMu.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	addWiki(wiki, config)
+	wikisMu.Unlock()
+	dropComponents(wiki.Name)
+
+	if err := saveWikis(); err != nil {
+		log.Printf("Error saving wikis: %v", err)
+		http.Error(w, "Saved until restart, but the wikis file could not be written", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Saved wiki '%s'", wiki.Name)
+	go ensureModelDownloaded(wiki.Settings.Model)
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// adminDeleteWikiHandler removes a wiki. Its hosts fall back to the default
+// wiki.
+func adminDeleteWikiHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	wikisMu.Lock()
+	wiki, ok := wikis[name]
+	if ok {
+		for _, host := range wiki.Hosts {
+			delete(wikisByHost, host)
+		}
+		delete(wikis, name)
+		delete(wikiConfigs, name)
+	}
+	wikisMu.Unlock()
+	dropComponents(name)
+	dropStoredArticles(name)
+
+	if !ok {
+		http.Error(w, "Wiki not found", http.StatusNotFound)
+		return
+	}
+	if err := saveWikis(); err != nil {
+		log.Printf("Error saving wikis: %v", err)
+		http.Error(w, "Deleted until restart, but the wikis file could not be written", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Deleted wiki '%s'", name)
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// With ADMIN_EMAIL set, errors that need the admin's attention, like the
+// model failing to generate or the article store being unreachable, are
+// emailed to them as well as logged. Errors are gathered and sent together
+// at most once every ADMIN_ALERT_INTERVAL, so an outage sends one email
+// rather than one per reader.
+
+// maxAlertErrors caps how many errors one alert lists.
+const maxAlertErrors = 50
+
+// alertCheckInterval is how often gathered errors are looked at.
+const alertCheckInterval = time.Minute
+
+// AlertedError is an error in an admin alert.
+type AlertedError struct {
+	Time    time.Time
+	Message string
+}
+
+var adminAlerts = struct {
+	mu      sync.Mutex
+	enabled bool
+	errors  []AlertedError
+	dropped int
+}{}
+
+// alertAdmin logs an error and gathers it for the next admin alert.
+func alertAdmin(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Print(message)
+
+	adminAlerts.mu.Lock()
+	defer adminAlerts.mu.Unlock()
+
+	if !adminAlerts.enabled {
+		return
+	}
+	if len(adminAlerts.errors) >= maxAlertErrors {
+		adminAlerts.dropped++
+		return
+	}
+	adminAlerts.errors = append(adminAlerts.errors, AlertedError{Time: time.Now(), Message: message})
+}
+
+// startAdminAlerts emails gathered errors to ADMIN_EMAIL until the process
+// exits, if it is set and the instance can send email.
+func startAdminAlerts() {
+	to := os.Getenv("ADMIN_EMAIL")
+	if to == "" || !emailEnabled() {
+		return
+	}
+	interval := envDuration("ADMIN_ALERT_INTERVAL", time.Hour)
+
+	adminAlerts.mu.Lock()
+	adminAlerts.enabled = true
+	adminAlerts.mu.Unlock()
+
+	go func() {
+		var last time.Time
+		for {
+			time.Sleep(alertCheckInterval)
+			if time.Since(last) < interval {
+				continue
+			}
+
+			adminAlerts.mu.Lock()
+			errors, dropped := adminAlerts.errors, adminAlerts.dropped
+			adminAlerts.errors, adminAlerts.dropped = nil, 0
+			adminAlerts.mu.Unlock()
+			if len(errors) == 0 {
+				continue
+			}
+
+			data := struct {
+				Site    string
+				Errors  []AlertedError
+				Dropped int
+				Admin   string
+			}{settings.siteName(), errors, dropped, publicLink("/admin")}
+			if err := sendTemplatedEmail(to, "admin-alert", "", data); err != nil {
+				log.Printf("Error sending an admin alert: %v", err)
+				continue
+			}
+			last = time.Now()
+		}
+	}()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Announcements post every newly generated article to a Matrix room and/or a
+// Telegram channel. They follow the activity feed, so they see the same
+// completions as the live ticker.
+
+const defaultAnnounceTemplate = `New article: {{.Title}}
+
+{{.Summary}}
+
+{{.URL}}`
+
+// maxSummaryLength caps the summary of an article in an announcement.
+const maxSummaryLength = 300
+
+// Announcement is the data an announcement template is rendered with.
+type Announcement struct {
+	Title   string
+	Kind    string
+	Summary string
+	URL     string
+}
+
+// announceTarget is a chat service announcements can be posted to.
+type announceTarget struct {
+	name string
+	send func(text string) error
+}
+
+// startAnnouncer posts completed articles to the configured chat services
+// until the process exits. It does nothing when none are configured.
+func startAnnouncer() {
+	var targets []announceTarget
+	if token, chat := os.Getenv("TELEGRAM_BOT_TOKEN"), os.Getenv("TELEGRAM_CHAT_ID"); token != "" && chat != "" {
+		targets = append(targets, announceTarget{"Telegram", func(text string) error {
+			return sendTelegram(token, chat, text)
+		}})
+	}
+	if homeserver, token, room := os.Getenv("MATRIX_HOMESERVER"), os.Getenv("MATRIX_ACCESS_TOKEN"), os.Getenv("MATRIX_ROOM_ID"); homeserver != "" && token != "" && room != "" {
+		targets = append(targets, announceTarget{"Matrix", func(text string) error {
+			return sendMatrix(homeserver, token, room, text)
+		}})
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	text := defaultAnnounceTemplate
+	if path := os.Getenv("ANNOUNCE_TEMPLATE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Error reading announcement template '%s': %v", path, err)
+		} else {
+			text = string(data)
+		}
+	}
+	tmpl, err := template.New("announce").Parse(text)
+	if err != nil {
+		log.Printf("Error parsing announcement template, using the default: %v", err)
+		tmpl = template.Must(template.New("announce").Parse(defaultAnnounceTemplate))
+	}
+
+	ch, _ := activity.subscribe()
+	go func() {
+		for event := range ch {
+			if event.Type != "completed" {
+				continue
+			}
+
+			var message bytes.Buffer
+			if err := tmpl.Execute(&message, newAnnouncement(event)); err != nil {
+				log.Printf("Error rendering announcement for '%s': %v", event.Title, err)
+				continue
+			}
+			for _, target := range targets {
+				if err := target.send(message.String()); err != nil {
+					log.Printf("Error announcing '%s' on %s: %v", event.Title, target.name, err)
+				}
+			}
+		}
+	}()
+}
+
+func newAnnouncement(event ActivityEvent) Announcement {
+	kind := event.Kind
+	if kind == "" {
+		kind = "wiki"
+	}
+
+	announcement := Announcement{
+		Title:   event.Title,
+		Kind:    event.Kind,
+		Summary: event.Summary,
+		URL:     "/" + kind + "/" + slugPath(event.Title),
+	}
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		announcement.URL = strings.TrimRight(base, "/") + announcement.URL
+	}
+	return announcement
+}
+
+// markdownEmphasis strips the markdown markers that show up in article prose.
+var markdownEmphasis = strings.NewReplacer("**", "", "*", "", "`", "")
+
+// articleSummary returns the first paragraph of prose in an article as plain
+// text, with [[Topic]] links flattened.
+func articleSummary(content string) string {
+	content = markdownEmphasis.Replace(topicLinkPattern.ReplaceAllString(content, "$1"))
+
+	for _, paragraph := range strings.Split(content, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" || strings.HasPrefix(paragraph, "#") || strings.HasPrefix(paragraph, "```") {
+			continue
+		}
+
+		runes := []rune(strings.Join(strings.Fields(paragraph), " "))
+		if len(runes) > maxSummaryLength {
+			return string(runes[:maxSummaryLength]) + "…"
+		}
+		return string(runes)
+	}
+	return ""
+}
+
+func sendTelegram(token, chat, text string) error {
+	body, _ := json.Marshal(map[string]string{"chat_id": chat, "text": text})
+	return postAnnouncement("POST", "https://api.telegram.org/bot"+token+"/sendMessage", "", body)
+}
+
+func sendMatrix(homeserver, token, room, text string) error {
+	body, _ := json.Marshal(map[string]string{"msgtype": "m.notice", "body": text})
+	// Matrix deduplicates sends on the transaction id
+	txn := fmt.Sprintf("endless-wiki-%d", time.Now().UnixNano())
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", strings.TrimRight(homeserver, "/"), url.PathEscape(room), txn)
+	return postAnnouncement("PUT", endpoint, "Bearer "+token, body)
+}
+
+func postAnnouncement(method, endpoint, authorization string, body []byte) error {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Don't log the URL, it contains the Telegram token
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// With PROVIDER=anthropic articles are generated with the Anthropic Messages
+// API instead of ollama, for running a wiki without a GPU. ANTHROPIC_API_KEY
+// authenticates and ANTHROPIC_MODEL is the model every wiki uses. The API
+// has no seeds, so a seeded generation, as DETERMINISTIC asks for, is written
+// at temperature zero instead, which comes close. Nothing has to be pulled,
+// inspected or kept loaded, so those steps are skipped. Suggestions still
+// embed with ollama at OLLAMA_HOST when EMBEDDING_MODEL is set.
+
+// anthropicVersion is the version of the API the requests are written for.
+const anthropicVersion = "2023-06-01"
+
+// defaultAnthropicMaxTokens caps an article, as the API needs a cap.
+const defaultAnthropicMaxTokens = 4096
+
+// maxAnthropicEvent caps one line of the event stream.
+const maxAnthropicEvent = 1 << 20
+
+type AnthropicRequest struct {
+	Model         string             `json:"model"`
+	MaxTokens     int                `json:"max_tokens"`
+	System        string             `json:"system,omitempty"`
+	Messages      []AnthropicMessage `json:"messages"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	TopK          int                `json:"top_k,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// AnthropicEvent is one event of a streamed answer. Only the fields the
+// events that matter carry are decoded.
+type AnthropicEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *AnthropicError `json:"error"`
+}
+
+// AnthropicResponse is an answer that isn't streamed.
+type AnthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func (e *AnthropicError) Error() string {
+	return fmt.Sprintf("anthropic %s: %s", e.Type, e.Message)
+}
+
+// anthropicBaseURL is where the Messages API is.
+func anthropicBaseURL() string {
+	if base := os.Getenv("ANTHROPIC_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return "https://api.anthropic.com"
+}
+
+// newAnthropicRequest makes an authenticated request to the API.
+func newAnthropicRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, anthropicBaseURL()+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", os.Getenv("ANTHROPIC_API_KEY"))
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+	return req, nil
+}
+
+// anthropicRequestBody writes the request for a prompt under a system
+// prompt, with what of ollama's options the API has.
+func anthropicRequestBody(model, system, prompt string, options *GenerateOptions, stream bool) AnthropicRequest {
+	body := AnthropicRequest{
+		Model:     model,
+		MaxTokens: envInt("ANTHROPIC_MAX_TOKENS", defaultAnthropicMaxTokens),
+		System:    system,
+		Messages:  []AnthropicMessage{{Role: "user", Content: prompt}},
+		Stream:    stream,
+	}
+	if options == nil {
+		return body
+	}
+	if options.NumPredict > 0 {
+		body.MaxTokens = options.NumPredict
+	}
+	for _, stop := range options.Stop {
+		// The API refuses stop sequences of only whitespace
+		if strings.TrimSpace(stop) != "" {
+			body.StopSequences = append(body.StopSequences, stop)
+		}
+	}
+	if options.Seed != 0 {
+		zero := 0.0
+		body.Temperature = &zero
+	}
+	// A temperature that's set wins over the seed's
+	if options.Temperature != nil {
+		// The API takes temperatures up to 1, ollama up to 2
+		temperature := min(*options.Temperature, 1)
+		body.Temperature = &temperature
+	}
+	body.TopP, body.TopK = options.TopP, options.TopK
+	return body
+}
+
+// anthropicStatusError reads the error the API answered a request with.
+func anthropicStatusError(resp *http.Response) error {
+	var answer struct {
+		Error *AnthropicError `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&answer); err == nil && answer.Error != nil {
+		return answer.Error
+	}
+	return fmt.Errorf("anthropic returned status %d", resp.StatusCode)
+}
+
+type anthropicProvider struct{}
+
+func (anthropicProvider) Name() string { return "anthropic" }
+
+func (anthropicProvider) Host() string { return anthropicBaseURL() }
+
+// Stream gives the reason the model stopped the way ollama gives it, so
+// running out of tokens is "length" and anything else "stop".
+func (anthropicProvider) Stream(ctx context.Context, model, system, prompt string, options *GenerateOptions) <-chan Chunk {
+	chunks := make(chan Chunk)
+	go func() {
+		defer close(chunks)
+		fail := func(err error) { sendChunk(ctx, chunks, Chunk{Err: err}) }
+
+		jsonData, err := json.Marshal(anthropicRequestBody(model, system, prompt, options, true))
+		if err != nil {
+			fail(err)
+			return
+		}
+		req, err := newAnthropicRequest(ctx, "POST", "/v1/messages", bytes.NewReader(jsonData))
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			fail(anthropicStatusError(resp))
+			return
+		}
+
+		done := Chunk{Done: true}
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64<<10), maxAnthropicEvent)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			var event AnthropicEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+				fail(fmt.Errorf("decoding anthropic event: %v", err))
+				return
+			}
+
+			switch event.Type {
+			case "content_block_delta":
+				if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+					if !sendChunk(ctx, chunks, Chunk{Text: event.Delta.Text}) {
+						return
+					}
+				}
+			case "message_delta":
+				done.Tokens = event.Usage.OutputTokens
+				done.DoneReason = "stop"
+				if event.Delta.StopReason == "max_tokens" {
+					done.DoneReason = "length"
+				}
+			case "message_stop":
+				sendChunk(ctx, chunks, done)
+				return
+			case "error":
+				if event.Error != nil {
+					fail(event.Error)
+				} else {
+					fail(errors.New("anthropic stream failed"))
+				}
+				return
+			}
+		}
+		switch {
+		case ctx.Err() != nil:
+			fail(ctx.Err())
+		case scanner.Err() != nil:
+			fail(scanner.Err())
+		default:
+			fail(errors.New("anthropic stream ended early"))
+		}
+	}()
+	return chunks
+}
+
+// GenerateJSON asks for the object and cuts it out of the answer, as the
+// Messages API has no JSON mode.
+func (anthropicProvider) GenerateJSON(ctx context.Context, model, prompt string, v interface{}) error {
+	prompt += "\n\nAnswer with a single JSON object and nothing else."
+	jsonData, err := json.Marshal(anthropicRequestBody(model, "", prompt, nil, false))
+	if err != nil {
+		return err
+	}
+	req, err := newAnthropicRequest(ctx, "POST", "/v1/messages", bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return anthropicStatusError(resp)
+	}
+
+	var answer AnthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return err
+	}
+	var text strings.Builder
+	for _, block := range answer.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	object := text.String()
+	start, end := strings.Index(object, "{"), strings.LastIndex(object, "}")
+	if start < 0 || end < start {
+		return errors.New("anthropic answered without a JSON object")
+	}
+	return json.Unmarshal([]byte(object[start:end+1]), v)
+}
+
+// Up asks the API for its models.
+func (anthropicProvider) Up(ctx context.Context) bool {
+	req, err := newAnthropicRequest(ctx, "GET", "/v1/models", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
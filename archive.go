@@ -0,0 +1,425 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The article cache can be moved between instances as an archive. GET
+// /admin/export streams a tar.gz of the stored articles of every wiki, or of
+// the one asked for with ?wiki=, and POST /admin/import stores the articles
+// of an archive, replacing any stored under the same titles. The archive is
+// laid out like the disk store, each article a markdown file with its front
+// matter under a folder for its wiki and kind, so it can also be unpacked
+// straight into ARTICLE_CACHE. A manifest.json at the top says where and
+// when it was made. The export and import subcommands do the same against a
+// running server, with the ADMIN_PASSWORD.
+
+// archiveFormat is the version of the archive layout, in its manifest.
+const archiveFormat = 1
+
+const (
+	// maxImportSize is the largest archive, as uploaded, that can be
+	// imported.
+	maxImportSize = 1 << 30
+	// maxImportFile is the largest article file that is imported when
+	// MAX_ARTICLE_SIZE doesn't limit articles.
+	maxImportFile = 16 << 20
+	// maxImportUnpacked is how much an archive may unpack to, so a small
+	// archive of a lot of zeroes can't keep the server busy for good.
+	maxImportUnpacked = 4 << 30
+)
+
+// errArchiveTooLarge is an archive that unpacks to more than
+// maxImportUnpacked.
+var errArchiveTooLarge = errors.New("the archive unpacks to more than 4 GiB")
+
+// unpackBudget reads until it has read its budget, then fails.
+type unpackBudget struct {
+	r    io.Reader
+	left int64
+}
+
+func (b *unpackBudget) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+// archiveManifest describes an archive.
+type archiveManifest struct {
+	Format   int            `json:"format"`
+	Site     string         `json:"site"`
+	Exported time.Time      `json:"exported"`
+	Articles map[string]int `json:"articles"`
+}
+
+// archiveResult is what an import did.
+type archiveResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	// Missing lists the wikis in the archive that this instance doesn't
+	// have, whose articles were skipped
+	Missing []string `json:"missing,omitempty"`
+}
+
+// archivedArticle is a stored article waiting to be exported.
+type archivedArticle struct {
+	wiki, kind, title string
+}
+
+// archiveKindDir names the folder of a kind of article, the way the disk
+// store does.
+func archiveKindDir(kind string) string {
+	if kind == "" {
+		return "wiki"
+	}
+	return kind
+}
+
+func adminExportHandler(w http.ResponseWriter, r *http.Request) {
+	if articles == nil {
+		http.Error(w, "There is no article store to export", http.StatusConflict)
+		return
+	}
+	export := allWikis()
+	if r.URL.Query().Get("wiki") != "" {
+		wiki, ok := cacheWiki(w, r)
+		if !ok {
+			return
+		}
+		export = []*Wiki{wiki}
+	}
+
+	// List everything first, so a store that can't be read fails the
+	// request instead of cutting the archive short
+	manifest := archiveManifest{Format: archiveFormat, Site: settings.siteName(), Exported: time.Now().UTC(), Articles: map[string]int{}}
+	var list []archivedArticle
+	for _, wiki := range export {
+		for _, kind := range cacheKinds(r) {
+			titles, err := articles.List(r.Context(), wiki.Name, kind)
+			if err != nil {
+				log.Printf("Error listing stored articles of wiki '%s': %v", wiki.Name, err)
+				http.Error(w, "Error listing stored articles", http.StatusBadGateway)
+				return
+			}
+			sort.Strings(titles)
+			for _, title := range titles {
+				list = append(list, archivedArticle{wiki: wiki.Name, kind: kind, title: title})
+			}
+			manifest.Articles[wiki.Name] += len(titles)
+		}
+	}
+
+	name := fmt.Sprintf("endless-wiki-%s.tar.gz", manifest.Exported.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	err := writeArchiveFile(tw, "manifest.json", data, manifest.Exported)
+	exported := 0
+	for _, entry := range list {
+		if err != nil {
+			break
+		}
+		article, ok, getErr := articles.Get(r.Context(), entry.wiki, entry.kind, entry.title)
+		if getErr != nil {
+			// The archive is already on its way, so leave the article out
+			// rather than the rest of them
+			log.Printf("Error reading stored article '%s' of wiki '%s' to export: %v", entry.title, entry.wiki, getErr)
+			continue
+		}
+		if !ok {
+			// Deleted or expired since it was listed
+			continue
+		}
+		file := path.Join(entry.wiki, archiveKindDir(entry.kind), articleFileName(entry.title))
+		if err = writeArchiveFile(tw, file, []byte(formatArticleFile(article)), article.Generated); err == nil {
+			exported++
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		// The archive is already on its way, so all that's left is to leave
+		// it broken rather than complete
+		log.Printf("Error exporting stored articles: %v", err)
+		return
+	}
+	log.Printf("Exported %d stored articles", exported)
+}
+
+// writeArchiveFile adds a file to an archive.
+func writeArchiveFile(tw *tar.Writer, name string, data []byte, modified time.Time) error {
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modified, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	if articles == nil {
+		http.Error(w, "There is no article store to import into", http.StatusConflict)
+		return
+	}
+	var into *Wiki
+	if r.URL.Query().Get("wiki") != "" {
+		wiki, ok := cacheWiki(w, r)
+		if !ok {
+			return
+		}
+		into = wiki
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	result, err := importArchive(r, into)
+	if err != nil {
+		log.Printf("Error importing stored articles: %v", err)
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, errArchiveTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, "Error importing the archive: "+err.Error(), status)
+		return
+	}
+	log.Printf("Imported %d stored articles, skipped %d", result.Imported, result.Skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// importArchive stores the articles of the archive in a request's body, into
+// the wikis they came from or all into one. Articles that can't be stored
+// here, of a wiki this instance doesn't have or with a title or kind it
+// doesn't know, are skipped.
+func importArchive(r *http.Request, into *Wiki) (archiveResult, error) {
+	result := archiveResult{}
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return result, err
+	}
+	defer zr.Close()
+
+	// Leave room for the front matter around the longest article
+	maxFile := int64(maxImportFile)
+	if settings.MaxArticleSize > 0 {
+		maxFile = int64(settings.MaxArticleSize) + 64<<10
+	}
+	missing := map[string]bool{}
+	tr := tar.NewReader(&unpackBudget{r: zr, left: maxImportUnpacked})
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".md") {
+			continue
+		}
+
+		parts := strings.Split(path.Clean(header.Name), "/")
+		if len(parts) != 3 || header.Size > maxFile {
+			result.Skipped++
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return result, err
+		}
+
+		article := parseArticleFile(string(data))
+		if article.Title == "" {
+			article.Title, _ = url.PathUnescape(strings.TrimSuffix(parts[2], ".md"))
+		}
+		if parts[1] != archiveKindDir(article.Kind) {
+			article.Kind = parts[1]
+			if article.Kind == "wiki" {
+				article.Kind = ""
+			}
+		}
+		title, titleErr := normalizeTitle(article.Title)
+		if _, known := articleKinds[article.Kind]; titleErr != nil || (article.Kind != "" && !known) {
+			result.Skipped++
+			continue
+		}
+		article.Title = title
+
+		wiki := into
+		if wiki == nil {
+			var ok bool
+			if wiki, ok = wikiNamed(parts[0]); !ok {
+				missing[parts[0]] = true
+				result.Skipped++
+				continue
+			}
+		}
+		if err := articles.Put(r.Context(), wiki.Name, article); err != nil {
+			return result, fmt.Errorf("storing '%s': %v", article.Title, err)
+		}
+		// Its slug and those of its links lead to it here like they did
+		// where it was written
+		rememberArticleTitles(context.WithValue(r.Context(), wikiContextKey{}, wiki), article)
+		result.Imported++
+	}
+
+	for name := range missing {
+		result.Missing = append(result.Missing, name)
+	}
+	sort.Strings(result.Missing)
+	return result, nil
+}
+
+// runExport saves a running server's stored articles as an archive, with the
+// export subcommand's arguments.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	server := archiveServerFlag(fs)
+	wiki := fs.String("wiki", "", "only export this wiki")
+	out := fs.String("o", "", "file to save the archive to, named after the date by default")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: endless-wiki export [-server URL] [-wiki NAME] [-o FILE]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	query := url.Values{}
+	if *wiki != "" {
+		query.Set("wiki", *wiki)
+	}
+	resp, err := archiveRequest(http.MethodGet, *server, "/admin/export?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if *out == "" {
+		*out = fmt.Sprintf("endless-wiki-%s.tar.gz", time.Now().Format("2006-01-02"))
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Saved the articles to %s\n", *out)
+	return nil
+}
+
+// runImport sends an archive to a running server, with the import
+// subcommand's arguments.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	server := archiveServerFlag(fs)
+	wiki := fs.String("wiki", "", "import every article into this wiki, instead of the ones they came from")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: endless-wiki import [-server URL] [-wiki NAME] FILE")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	query := url.Values{}
+	if *wiki != "" {
+		query.Set("wiki", *wiki)
+	}
+	resp, err := archiveRequest(http.MethodPost, *server, "/admin/import?"+query.Encode(), file)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result archiveResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d articles, skipped %d\n", result.Imported, result.Skipped)
+	if len(result.Missing) > 0 {
+		fmt.Printf("The server has no wikis named %s, use -wiki to import them into one it has\n", strings.Join(result.Missing, ", "))
+	}
+	return nil
+}
+
+// archiveServerFlag adds the -server flag the archive subcommands share.
+func archiveServerFlag(fs *flag.FlagSet) *string {
+	defaultServer := os.Getenv("ENDLESS_WIKI_URL")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+	return fs.String("server", defaultServer, "address of the endless wiki server, whose ADMIN_PASSWORD must be set here too")
+}
+
+// archiveRequest makes an admin request for the archive subcommands, failing
+// unless it succeeds.
+func archiveRequest(method, server, endpoint string, body io.Reader) (*http.Response, error) {
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		return nil, errors.New("set ADMIN_PASSWORD to the server's admin password")
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(server, "/")+endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("admin", password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/gzip")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		return nil, fmt.Errorf("the server answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMemoryStore stores articles in memory for the rest of a test.
+func useMemoryStore(t *testing.T) {
+	t.Helper()
+	savedArticles, savedSlugs := articles, slugStore
+	t.Cleanup(func() { articles, slugStore = savedArticles, savedSlugs })
+	articles, slugStore = newMemoryStore(), nil
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	useMemoryStore(t)
+	ctx := context.Background()
+	stored := []StoredArticle{
+		{Title: "Archived Carthage", Model: "llama2", Topic: "place", Content: "A city that fought the [[Archived Punic Wars]].", Generated: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{Title: "Archived Rome/Roads", Model: "llama2", Content: "Roads led there.", Generated: time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC)},
+	}
+	for _, article := range stored {
+		if err := articles.Put(ctx, defaultWikiName, article); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	adminExportHandler(w, httptest.NewRequest("GET", "/admin/export", nil))
+	if w.Code != 200 {
+		t.Fatalf("export answered %d: %s", w.Code, w.Body)
+	}
+	archive := w.Body.Bytes()
+
+	articles = newMemoryStore()
+	result, err := importArchive(httptest.NewRequest("POST", "/admin/import", bytes.NewReader(archive)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != len(stored) || result.Skipped != 0 {
+		t.Errorf("imported %d and skipped %d, want %d and 0", result.Imported, result.Skipped, len(stored))
+	}
+	for _, want := range stored {
+		got, ok, err := articles.Get(ctx, defaultWikiName, "", want.Title)
+		if err != nil || !ok {
+			t.Fatalf("%q wasn't imported: %v", want.Title, err)
+		}
+		if strings.TrimSpace(got.Content) != want.Content || got.Model != want.Model || !got.Generated.Equal(want.Generated) {
+			t.Errorf("imported %+v, want %+v", got, want)
+		}
+	}
+
+	// The slugs of the imported articles and their links lead back to them
+	for _, title := range []string{"Archived Carthage", "Archived Punic Wars", "Archived Rome/Roads", "Archived Rome"} {
+		if got, _ := slugTitle(ctx, defaultWikiName, slugify(title)); got != title {
+			t.Errorf("slug %q stands for %q after the import, want %q", slugify(title), got, title)
+		}
+	}
+}
+
+// archiveOf packs files into an archive.
+func archiveOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, content := range files {
+		if err := writeArchiveFile(tw, name, []byte(content), time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImportSkips(t *testing.T) {
+	useMemoryStore(t)
+	archive := archiveOf(t, map[string]string{
+		"manifest.json":                     "{}",
+		"default/wiki/Archived Thebes.md":   "A city.",
+		"nowhere/wiki/Archived Athens.md":   "A city.",
+		"default/nokind/Archived Sparta.md": "A city.",
+		"Archived Troy.md":                  "A city.",
+	})
+	result, err := importArchive(httptest.NewRequest("POST", "/admin/import", bytes.NewReader(archive)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 1 || result.Skipped != 3 || len(result.Missing) != 1 || result.Missing[0] != "nowhere" {
+		t.Errorf("import = %+v, want one imported, three skipped and nowhere missing", result)
+	}
+	if _, ok, _ := articles.Get(context.Background(), defaultWikiName, "", "Archived Thebes"); !ok {
+		t.Errorf("the article of a known wiki wasn't imported")
+	}
+}
+
+func TestImportRejectsGarbage(t *testing.T) {
+	useMemoryStore(t)
+	if _, err := importArchive(httptest.NewRequest("POST", "/admin/import", strings.NewReader("not an archive")), nil); err == nil {
+		t.Errorf("importing garbage succeeded")
+	}
+}
+
+func TestUnpackBudget(t *testing.T) {
+	data, err := io.ReadAll(&unpackBudget{r: strings.NewReader("hello world"), left: 5})
+	if !errors.Is(err, errArchiveTooLarge) || string(data) != "hello" {
+		t.Errorf("read %q, %v, want %q, %v", data, err, "hello", errArchiveTooLarge)
+	}
+	data, err = io.ReadAll(&unpackBudget{r: strings.NewReader("hello"), left: 100})
+	if err != nil || string(data) != "hello" {
+		t.Errorf("read %q, %v, want %q within the budget", data, err, "hello")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// The audit log records security relevant events, one JSON object per line,
+// to AUDIT_LOG. Without it they go to the regular log.
+
+// AuditEvent is one line of the audit log.
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Client string    `json:"client,omitempty"`
+	Wiki   string    `json:"wiki,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+func audit(event AuditEvent) {
+	event.Time = time.Now()
+
+	path := os.Getenv("AUDIT_LOG")
+	if path == "" {
+		log.Printf("Audit: %s client=%s wiki=%s %s", event.Event, event.Client, event.Wiki, event.Detail)
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding audit event: %v", err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Error opening audit log '%s': %v", path, err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The site banner is a notice shown at the top of every page of every wiki,
+// for maintenance windows, model changes and the like. BANNER sets one at
+// startup and the admin panel can change or clear it until the next restart.
+// Readers can dismiss it, and it stays dismissed in their browser until the
+// banner changes.
+
+// maxBannerLength caps the length of the banner message.
+const maxBannerLength = 500
+
+// Banner is the notice shown at the top of the pages. ID changes whenever the
+// banner does, so a new banner shows even to readers who dismissed the last.
+type Banner struct {
+	ID      string
+	Message string
+	// Level is "info" or "warning"
+	Level string
+}
+
+var (
+	banner   Banner
+	bannerMu sync.RWMutex
+)
+
+// loadBanner sets the banner from BANNER.
+func loadBanner() {
+	if message := strings.TrimSpace(os.Getenv("BANNER")); message != "" {
+		setBanner(message, "info")
+	}
+}
+
+func setBanner(message, level string) {
+	bannerMu.Lock()
+	defer bannerMu.Unlock()
+
+	if message == "" {
+		banner = Banner{}
+		return
+	}
+	sum := sha256.Sum256([]byte(level + "\x00" + message + "\x00" + time.Now().String()))
+	banner = Banner{ID: hex.EncodeToString(sum[:6]), Message: message, Level: level}
+}
+
+// siteBanner is the current banner, with an empty message when there is none.
+func siteBanner() Banner {
+	bannerMu.RLock()
+	defer bannerMu.RUnlock()
+
+	return banner
+}
+
+// readerBanner is the banner readers see: the admin's, or while generation
+// is down or degraded, a warning about it.
+func readerBanner() Banner {
+	if current := siteBanner(); current.Message != "" {
+		return current
+	}
+	if breakerOpen() {
+		return Banner{ID: "unavailable", Message: unavailableMessage, Level: "warning"}
+	}
+	return degradedBanner()
+}
+
+// adminBannerHandler sets or clears the banner from the panel.
+func adminBannerHandler(w http.ResponseWriter, r *http.Request) {
+	message := strings.TrimSpace(r.FormValue("message"))
+	if len(message) > maxBannerLength {
+		http.Error(w, "The banner is too long", http.StatusBadRequest)
+		return
+	}
+	level := r.FormValue("level")
+	if level != "warning" {
+		level = "info"
+	}
+	if r.FormValue("clear") != "" {
+		message = ""
+	}
+
+	setBanner(message, level)
+	if message == "" {
+		log.Printf("Cleared the site banner")
+	} else {
+		log.Printf("Set the site banner: %s", message)
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// benchArticle is an article of a typical length, as a model might write it.
+var benchArticle = func() string {
+	var b strings.Builder
+	b.WriteString("Sure, here's an article about Ancient Rome.\n\n# Ancient Rome\n\n")
+	for i := 0; i < 8; i++ {
+		b.WriteString("## Section\n\n")
+		b.WriteString("**Ancient Rome** was a civilization that grew from a small town on the [[Tiber]] into an empire around the [[Mediterranean Sea]]. ")
+		b.WriteString("Its *republic* and later emperors like [[Augustus]] left a mark on law, language and architecture that lasts to this day.\n\n")
+		b.WriteString("### Subsection\n\n- A point\n- Another point\n\n```\n# not a heading\n```\n\n")
+	}
+	b.WriteString("Let me know if you'd like to know more!")
+	return b.String()
+}()
+
+// benchTrimRules cut the preamble and sign-off of benchArticle.
+var benchTrimRules = []string{"^(?i)(sure|certainly)[^\\n]*\\n+", "(?i)\\n+let me know if[^\\n]*$"}
+
+// benchChunks splits text into chunks about the size of the tokens a model
+// streams.
+func benchChunks(text string) []string {
+	var chunks []string
+	for len(text) > 4 {
+		chunks = append(chunks, text[:4])
+		text = text[4:]
+	}
+	return append(chunks, text)
+}
+
+func BenchmarkApplyTrimRules(b *testing.B) {
+	rules, err := compileTrimRules(benchTrimRules)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(benchArticle)))
+	for i := 0; i < b.N; i++ {
+		applyTrimRules(benchArticle, rules)
+	}
+}
+
+// BenchmarkStreamChecks runs the checks made on every chunk of an article
+// streaming in, for articles of growing length. They should take about as
+// long a byte whatever the length.
+func BenchmarkStreamChecks(b *testing.B) {
+	rules, err := compileTrimRules(benchTrimRules)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, sections := range []int{1, 4, 16} {
+		text := strings.Repeat(benchArticle+"\n\n", sections)
+		chunks := benchChunks(text)
+		b.Run(fmt.Sprintf("%dKB", len(text)/1024), func(b *testing.B) {
+			b.SetBytes(int64(len(text)))
+			for i := 0; i < b.N; i++ {
+				trim := &trimmer{rules: rules}
+				loops := newLoopWatcher()
+				var content strings.Builder
+				for _, chunk := range chunks {
+					content.WriteString(chunk)
+					trim.update(content.String())
+					loops.write(content.String())
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindRepetition(b *testing.B) {
+	looping := benchArticle + strings.Repeat(" The empire was very large and very old indeed.", loopRepeats+1)
+	b.Run("none", func(b *testing.B) {
+		b.SetBytes(int64(len(benchArticle)))
+		for i := 0; i < b.N; i++ {
+			findRepetition(benchArticle)
+		}
+	})
+	b.Run("loop", func(b *testing.B) {
+		b.SetBytes(int64(len(looping)))
+		for i := 0; i < b.N; i++ {
+			findRepetition(looping)
+		}
+	})
+}
+
+func BenchmarkArticleLinkTitles(b *testing.B) {
+	b.SetBytes(int64(len(benchArticle)))
+	for i := 0; i < b.N; i++ {
+		articleLinkTitles(benchArticle)
+	}
+}
+
+// BenchmarkPlainText renders an article's markdown down to the plain text
+// of its summary, its description and its search index.
+func BenchmarkPlainText(b *testing.B) {
+	for name, render := range map[string]func(string) string{
+		"summary":     articleSummary,
+		"description": metaDescription,
+		"search":      searchText,
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(benchArticle)))
+			for i := 0; i < b.N; i++ {
+				render(benchArticle)
+			}
+		})
+	}
+}
+
+func BenchmarkHeadingFilter(b *testing.B) {
+	chunks := benchChunks(benchArticle)
+	job := &articleJob{Title: "Ancient Rome"}
+	b.SetBytes(int64(len(benchArticle)))
+	for i := 0; i < b.N; i++ {
+		f := newHeadingFilter(job)
+		for _, chunk := range chunks {
+			f.write(chunk)
+		}
+		f.flush()
+	}
+}
+
+func BenchmarkReasoningFilter(b *testing.B) {
+	text := "<think>\n" + strings.Repeat("The reader wants to know about Rome. ", 40) + "\n</think>\n\n" + benchArticle
+	chunks := benchChunks(text)
+	for _, mode := range []string{"strip", "collapse"} {
+		b.Run(mode, func(b *testing.B) {
+			b.SetBytes(int64(len(text)))
+			for i := 0; i < b.N; i++ {
+				f := newReasoningFilter(mode)
+				for _, chunk := range chunks {
+					f.write(chunk)
+				}
+				f.flush()
+			}
+		})
+	}
+}
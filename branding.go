@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Every wiki can be branded with its own name, a logo and an accent color.
+// The accent colors links and buttons through the --accent variable in
+// /theme.css, and the favicon and app icons are drawn in it, so even an
+// unbranded wiki gets icons instead of 404s.
+
+// defaultAccent is the accent color of an unbranded wiki.
+const defaultAccent = "#007cba"
+
+var accentPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// iconSizes are the sizes the PNG app icons come in.
+var iconSizes = []int{32, 180, 192, 512}
+
+// Branding is how a wiki presents itself on its pages, along with the site
+// banner every page shows.
+type Branding struct {
+	SiteName   string
+	Stylesheet string
+	Logo       string
+	Accent     string
+	Banner     Banner
+}
+
+// brandingFor returns the branding of the wiki serving a request.
+func brandingFor(ctx context.Context) Branding {
+	current := settingsFor(ctx)
+	return Branding{
+		SiteName:   current.siteName(),
+		Stylesheet: current.Stylesheet,
+		Logo:       current.Logo,
+		Accent:     current.accent(),
+		Banner:     readerBanner(),
+	}
+}
+
+// accent is the wiki's accent color.
+func (s *Settings) accent() string {
+	if s.AccentColor == "" {
+		return defaultAccent
+	}
+	return s.AccentColor
+}
+
+// themeHandler serves the wiki's accent as a CSS variable. Pages link it with
+// the accent in the query, so it can be cached for good.
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	fmt.Fprintf(w, ":root { --accent: %s; }\n", settingsFor(r.Context()).accent())
+}
+
+// manifestHandler serves the web app manifest, so the wiki can be installed
+// to a home screen.
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	current := settingsFor(r.Context())
+
+	type manifestIcon struct {
+		Src   string `json:"src"`
+		Sizes string `json:"sizes"`
+		Type  string `json:"type"`
+	}
+	icons := []manifestIcon{{Src: "/icons/icon.svg", Sizes: "any", Type: "image/svg+xml"}}
+	for _, size := range iconSizes {
+		icons = append(icons, manifestIcon{
+			Src:   fmt.Sprintf("/icons/%d.png", size),
+			Sizes: fmt.Sprintf("%dx%d", size, size),
+			Type:  "image/png",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":             current.siteName(),
+		"short_name":       current.siteName(),
+		"start_url":        "/",
+		"display":          "browser",
+		"theme_color":      current.accent(),
+		"background_color": "#ffffff",
+		"icons":            icons,
+	})
+}
+
+// iconHandler serves the icon of the given name: icon.svg or a PNG size.
+func iconHandler(w http.ResponseWriter, r *http.Request) {
+	accent := settingsFor(r.Context()).accent()
+	name := mux.Vars(r)["icon"]
+
+	if name == "icon.svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		fmt.Fprintf(w, iconSVG, accent)
+		return
+	}
+
+	for _, size := range iconSizes {
+		if name == strconv.Itoa(size)+".png" {
+			servePNG(w, iconPNG(accent, size))
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// appleTouchIconHandler serves the icon iOS asks for at a fixed path.
+func appleTouchIconHandler(w http.ResponseWriter, r *http.Request) {
+	servePNG(w, iconPNG(settingsFor(r.Context()).accent(), 180))
+}
+
+// faviconHandler serves favicon.ico, for the browsers that ask for it
+// whatever the page says. An ICO file can hold a PNG as it is.
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	icon := iconPNG(settingsFor(r.Context()).accent(), 32)
+
+	var ico bytes.Buffer
+	// Header: reserved, type 1 for icons, one image
+	binary.Write(&ico, binary.LittleEndian, [3]uint16{0, 1, 1})
+	// Directory entry: 32x32, no palette, 1 plane, 32 bits per pixel, size
+	// and offset of the image
+	ico.Write([]byte{32, 32, 0, 0})
+	binary.Write(&ico, binary.LittleEndian, [2]uint16{1, 32})
+	binary.Write(&ico, binary.LittleEndian, [2]uint32{uint32(len(icon)), 22})
+	ico.Write(icon)
+
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(ico.Bytes())
+}
+
+func servePNG(w http.ResponseWriter, icon []byte) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(icon)
+}
+
+// iconSVG is the icon, two linked rings for an infinity sign on a rounded
+// square of the accent color.
+const iconSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 64 64"><rect width="64" height="64" rx="12" fill="%s"/><g fill="none" stroke="#fff" stroke-width="5"><circle cx="22" cy="32" r="10"/><circle cx="42" cy="32" r="10"/></g></svg>`
+
+var icons = struct {
+	mu       sync.Mutex
+	rendered map[string][]byte
+}{
+	rendered: map[string][]byte{},
+}
+
+// iconPNG draws the icon at a size, caching it per accent.
+func iconPNG(accent string, size int) []byte {
+	key := fmt.Sprintf("%s/%d", accent, size)
+	icons.mu.Lock()
+	defer icons.mu.Unlock()
+
+	if cached, ok := icons.rendered[key]; ok {
+		return cached
+	}
+
+	background := parseAccent(accent)
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	s := float64(size) / 64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Work in the 64 unit space of the SVG
+			px, py := (float64(x)+0.5)/s, (float64(y)+0.5)/s
+			if !inRoundedSquare(px, py, 64, 12) {
+				continue
+			}
+			img.Set(x, y, background)
+			// Two rings side by side make the infinity sign
+			left := math.Hypot(px-22, py-32)
+			right := math.Hypot(px-42, py-32)
+			if math.Abs(left-10) < 2.5 || math.Abs(right-10) < 2.5 {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	if len(icons.rendered) < 100 {
+		icons.rendered[key] = buf.Bytes()
+	}
+	return buf.Bytes()
+}
+
+// inRoundedSquare reports whether a point falls inside a square with rounded
+// corners.
+func inRoundedSquare(x, y, side, radius float64) bool {
+	cx := math.Max(radius, math.Min(x, side-radius))
+	cy := math.Max(radius, math.Min(y, side-radius))
+	return math.Hypot(x-cx, y-cy) <= radius
+}
+
+// parseAccent turns a #rgb or #rrggbb color into a color.
+func parseAccent(accent string) color.RGBA {
+	hex := accent[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	value, _ := strconv.ParseUint(hex, 16, 32)
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A circuit breaker keeps readers from waiting on an ollama that is down.
+// Once BREAKER_FAILURES generations in a row fail, it opens and the wikis go
+// read-only: stored articles are served as usual, but no new ones are
+// attempted. Readers asking for one are told generation is temporarily
+// unavailable and, with an article store, its title is queued. Every
+// BREAKER_COOLDOWN ollama, or the Anthropic API with PROVIDER=anthropic, is
+// asked for its models, and once it answers the breaker closes and the
+// queued titles are written into the store in the background, so they're
+// ready the next time they're asked for. Generations readers walk away from
+// say nothing about ollama and aren't counted.
+
+// maxQueuedTitles caps how many titles wait for ollama to come back.
+const maxQueuedTitles = 200
+
+// breakerProbeTimeout bounds asking ollama whether it's back.
+const breakerProbeTimeout = 5 * time.Second
+
+const unavailableMessage = "Article generation is temporarily unavailable, but articles written before can still be read."
+
+var errGenerationUnavailable = errors.New(unavailableMessage)
+
+// queuedTitle is an article waiting for ollama to come back.
+type queuedTitle struct {
+	wiki, kind, title string
+}
+
+var breaker = struct {
+	mu       sync.Mutex
+	failures int
+	open     bool
+	queue    []queuedTitle
+	queued   map[queuedTitle]bool
+}{
+	queued: map[queuedTitle]bool{},
+}
+
+// observeBackend counts a finished generation towards opening the breaker.
+func observeBackend(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	threshold := envInt("BREAKER_FAILURES", 5)
+	if threshold <= 0 {
+		return
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if err == nil {
+		breaker.failures = 0
+		return
+	}
+	breaker.failures++
+	if !breaker.open && breaker.failures >= threshold {
+		breaker.open = true
+		log.Printf("Opening the circuit breaker after %d failed generations, serving stored articles only", breaker.failures)
+		go probeBackend()
+	}
+}
+
+// breakerOpen reports whether generation is off while ollama is down.
+func breakerOpen() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	return breaker.open
+}
+
+// generationUnavailable reports whether the article a request asks for can't
+// be generated while the breaker is open, queueing it for when ollama is
+// back if it can be stored then. queued reports whether it was.
+func generationUnavailable(r *http.Request, title, kind string) (unavailable, queued bool) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if !breaker.open {
+		return false, false
+	}
+	if !articleStorable(r) {
+		return true, false
+	}
+	entry := queuedTitle{wiki: wikiFrom(r.Context()).Name, kind: kind, title: title}
+	if !breaker.queued[entry] && len(breaker.queue) < maxQueuedTitles {
+		breaker.queued[entry] = true
+		breaker.queue = append(breaker.queue, entry)
+	}
+	return true, breaker.queued[entry]
+}
+
+// probeBackend waits for ollama to answer again, then closes the breaker and
+// writes the queued titles.
+func probeBackend() {
+	cooldown := envDuration("BREAKER_COOLDOWN", 30*time.Second)
+	for {
+		time.Sleep(cooldown)
+		ctx, cancel := context.WithTimeout(context.Background(), breakerProbeTimeout)
+		up := generationProvider().Up(ctx)
+		cancel()
+		if up {
+			break
+		}
+	}
+
+	breaker.mu.Lock()
+	queue := breaker.queue
+	breaker.open = false
+	breaker.failures = 0
+	breaker.queue = nil
+	breaker.queued = map[queuedTitle]bool{}
+	breaker.mu.Unlock()
+
+	log.Printf("Ollama is back, closing the circuit breaker and writing %d queued articles", len(queue))
+	writeQueued(queue)
+}
+
+// writeQueued writes the articles readers asked for while ollama was down
+// into the store, one at a time. Any it doesn't get to before the breaker
+// opens again are queued again.
+func writeQueued(queue []queuedTitle) {
+	for _, entry := range queue {
+		wiki, ok := wikiNamed(entry.wiki)
+		if !ok {
+			continue
+		}
+		ctx := context.WithValue(context.Background(), wikiContextKey{}, wiki)
+		query := url.Values{}
+		if entry.kind != "" {
+			query.Set("kind", entry.kind)
+		}
+		r, _ := http.NewRequestWithContext(ctx, "GET", "/stream/"+url.PathEscape(entry.title)+"?"+query.Encode(), nil)
+		if _, _, err := generateWhole(ctx, r, "queued", entry.title); err != nil && err != errGenerationUnavailable {
+			log.Printf("Error writing queued article '%s' of wiki '%s': %v", entry.title, entry.wiki, err)
+		}
+	}
+}
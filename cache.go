@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// The admin cache API manages what the wikis have cached without a restart:
+// the articles in the article store and the components cached around them.
+// GET /admin/cache lists the stored articles, DELETE /admin/cache/{article}
+// forgets one, DELETE /admin/cache purges them all, and POST
+// /admin/regenerate/{article} writes an article afresh, replacing the stored
+// copy. Each takes ?wiki= for a wiki other than the one the request arrives
+// on and ?kind= for other kinds of article. It sits behind the admin
+// password like the panel.
+
+// cachedArticle is a stored article as the cache API lists it.
+type cachedArticle struct {
+	Wiki  string `json:"wiki"`
+	Kind  string `json:"kind,omitempty"`
+	Title string `json:"title"`
+}
+
+type regenerateContextKey struct{}
+
+// regenerating reports whether a request is forcing its article to be
+// written afresh.
+func regenerating(ctx context.Context) bool {
+	forced, _ := ctx.Value(regenerateContextKey{}).(bool)
+	return forced
+}
+
+// cacheWiki returns the wiki a cache request is for: the one named by
+// ?wiki=, or the one it arrived on.
+func cacheWiki(w http.ResponseWriter, r *http.Request) (*Wiki, bool) {
+	name := r.URL.Query().Get("wiki")
+	if name == "" {
+		return wikiFrom(r.Context()), true
+	}
+	wiki, ok := wikiNamed(name)
+	if !ok {
+		http.Error(w, "Wiki not found", http.StatusNotFound)
+	}
+	return wiki, ok
+}
+
+// cacheKinds returns the kinds of article a cache request is for: the one
+// asked for, or all of them.
+func cacheKinds(r *http.Request) []string {
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		return []string{articleKinds[kind].Name}
+	}
+	kinds := []string{""}
+	for name := range articleKinds {
+		kinds = append(kinds, name)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// cacheTitle reads the article a cache request is for.
+func cacheTitle(w http.ResponseWriter, r *http.Request) (string, bool) {
+	requested, err := articleVar(r)
+	if err == nil {
+		requested, err = normalizeTitle(requested)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return requested, true
+}
+
+func adminCacheHandler(w http.ResponseWriter, r *http.Request) {
+	wiki, ok := cacheWiki(w, r)
+	if !ok {
+		return
+	}
+
+	list := []cachedArticle{}
+	if articles != nil {
+		for _, kind := range cacheKinds(r) {
+			titles, err := articles.List(r.Context(), wiki.Name, kind)
+			if err != nil {
+				log.Printf("Error listing stored articles of wiki '%s': %v", wiki.Name, err)
+				http.Error(w, "Error listing stored articles", http.StatusBadGateway)
+				return
+			}
+			sort.Strings(titles)
+			for _, title := range titles {
+				list = append(list, cachedArticle{Wiki: wiki.Name, Kind: kind, Title: title})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"store":      articles != nil,
+		"articles":   list,
+		"components": countComponents(wiki.Name),
+	})
+}
+
+// adminForgetHandler deletes an article's stored copy and the components
+// made from its title, so the next visit writes it afresh.
+func adminForgetHandler(w http.ResponseWriter, r *http.Request) {
+	wiki, ok := cacheWiki(w, r)
+	if !ok {
+		return
+	}
+	title, ok := cacheTitle(w, r)
+	if !ok {
+		return
+	}
+
+	forgot := dropTitleComponents(wiki.Name, title)
+	if articles != nil {
+		for _, kind := range cacheKinds(r) {
+			_, stored, err := articles.Get(r.Context(), wiki.Name, kind, title)
+			if err == nil && stored {
+				err = articles.Delete(r.Context(), wiki.Name, kind, title)
+			}
+			if err != nil {
+				log.Printf("Error deleting stored article '%s' of wiki '%s': %v", title, wiki.Name, err)
+				http.Error(w, "Error deleting the stored article", http.StatusBadGateway)
+				return
+			}
+			forgot = forgot || stored
+		}
+	}
+
+	if !forgot {
+		http.Error(w, "Nothing is cached for that article", http.StatusNotFound)
+		return
+	}
+	log.Printf("Forgot the cached article '%s' of wiki '%s'", title, wiki.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminPurgeHandler deletes every stored article and cached component of a
+// wiki, or of every wiki unless one is asked for.
+func adminPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	purge := allWikis()
+	if r.URL.Query().Get("wiki") != "" {
+		wiki, ok := cacheWiki(w, r)
+		if !ok {
+			return
+		}
+		purge = []*Wiki{wiki}
+	}
+
+	deleted := 0
+	for _, wiki := range purge {
+		deleted += dropStoredArticles(wiki.Name)
+		dropComponents(wiki.Name)
+		log.Printf("Purged the cache of wiki '%s'", wiki.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
+
+// adminRegenerateHandler writes an article afresh and stores it over the
+// copy it replaces, answering once it's done.
+func adminRegenerateHandler(w http.ResponseWriter, r *http.Request) {
+	wiki, ok := cacheWiki(w, r)
+	if !ok {
+		return
+	}
+	title, ok := cacheTitle(w, r)
+	if !ok {
+		return
+	}
+	kind := articleKinds[r.URL.Query().Get("kind")].Name
+
+	// Generate as a plain request for the article, without the admin's
+	// lens, so it is the one the wiki stores
+	ctx := context.WithValue(r.Context(), wikiContextKey{}, wiki)
+	ctx = context.WithValue(ctx, regenerateContextKey{}, true)
+	query := url.Values{}
+	if kind != "" {
+		query.Set("kind", kind)
+	}
+	articleReq, _ := http.NewRequestWithContext(ctx, "GET", "/stream/"+url.PathEscape(title)+"?"+query.Encode(), nil)
+
+	dropTitleComponents(wiki.Name, title)
+	content, replayID, err := generateWhole(ctx, articleReq, clientIP(r), title)
+	if err != nil {
+		log.Printf("Error regenerating '%s' of wiki '%s': %v", title, wiki.Name, err)
+		http.Error(w, "Error regenerating the article: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Printf("Regenerated '%s' of wiki '%s'", title, wiki.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"wiki":   wiki.Name,
+		"kind":   kind,
+		"title":  title,
+		"size":   len(content),
+		"stored": articles != nil,
+		"replay": replayID,
+	})
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Recent changes at /recent lists what happened to a wiki's articles lately,
+// like MediaWiki's Special:RecentChanges, so a community sharing an instance
+// can follow along: articles written for the first time, articles written
+// again and articles edited by hand in the disk store. Each change keeps the
+// text it left, so it can be diffed against the change before. /api/recent
+// lists the same as JSON. Articles written for another model, seed or lens
+// aren't the wiki's article and don't show up. The log lasts until the next
+// restart.
+
+// maxChanges is how many changes each wiki's log keeps.
+const maxChanges = 500
+
+// defaultChangesLimit is how many changes are listed unless asked otherwise.
+const defaultChangesLimit = 50
+
+// maxDiffCells caps the work of diffing two versions of an article, as the
+// number of pairs of changed lines compared. Past it, the whole changed part
+// is shown as removed and added.
+const maxDiffCells = 4000000
+
+// diffContext is how many unchanged lines are shown around each change in a
+// diff.
+const diffContext = 3
+
+// Change is something that happened to an article.
+type Change struct {
+	ID int `json:"id"`
+	// Type is "new", "regenerated" or "edited"
+	Type  string    `json:"type"`
+	Title string    `json:"title"`
+	Kind  string    `json:"kind,omitempty"`
+	Model string    `json:"model,omitempty"`
+	Size  int       `json:"size"`
+	Delta int       `json:"delta"`
+	Time  time.Time `json:"time"`
+	// Diff is the page comparing the change with the one before, if any
+	Diff string `json:"diff,omitempty"`
+
+	content  string
+	previous string
+}
+
+type changeLog struct {
+	mu      sync.Mutex
+	nextID  int
+	changes []Change
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{nextID: 1}
+}
+
+// changesFor returns the change log of the wiki a request is for.
+func changesFor(ctx context.Context) *changeLog {
+	return wikiFrom(ctx).changes
+}
+
+// latest returns the text an article was left with by its last change.
+func (l *changeLog) latest(kind, title string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := len(l.changes) - 1; i >= 0; i-- {
+		if l.changes[i].Title == title && l.changes[i].Kind == kind {
+			return l.changes[i].content, true
+		}
+	}
+	return "", false
+}
+
+// add logs a change that left an article with content and returns it as
+// logged. previous is what it replaced, if known.
+func (l *changeLog) add(change Change, content, previous string, replaced bool) Change {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	change.ID = l.nextID
+	l.nextID++
+	change.Time = time.Now()
+	change.Size = len(content)
+	change.Delta = len(content)
+	change.content = content
+	if replaced {
+		change.Delta -= len(previous)
+		change.previous = previous
+		change.Diff = "/diff/" + strconv.Itoa(change.ID)
+	}
+
+	l.changes = append(l.changes, change)
+	if len(l.changes) > maxChanges {
+		l.changes = l.changes[len(l.changes)-maxChanges:]
+	}
+	return change
+}
+
+// find returns a change by its id.
+func (l *changeLog) find(id int) (Change, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, change := range l.changes {
+		if change.ID == id {
+			return change, true
+		}
+	}
+	return Change{}, false
+}
+
+// since returns the changes made after a time, oldest first.
+func (l *changeLog) since(t time.Time) []Change {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var changes []Change
+	for _, change := range l.changes {
+		if change.Time.After(t) {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// list returns the latest changes first, of one type if asked.
+func (l *changeLog) list(changeType string, limit int) []Change {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var changes []Change
+	for i := len(l.changes) - 1; i >= 0 && len(changes) < limit; i-- {
+		if changeType == "" || l.changes[i].Type == changeType {
+			changes = append(changes, l.changes[i])
+		}
+	}
+	return changes
+}
+
+// recordChange logs a freshly generated article. It has to run before
+// the article is stored, so a regeneration can be diffed against what the
+// store held.
+func recordChange(r *http.Request, job *articleJob, content string) {
+	if !plainRequest(r) {
+		return
+	}
+	ctx := r.Context()
+	changes := changesFor(ctx)
+
+	previous, replaced := changes.latest(job.Kind.Name, job.Title)
+	if !replaced && articles != nil {
+		if stored, ok, err := articles.Get(ctx, wikiFrom(ctx).Name, job.Kind.Name, job.Title); err == nil && ok {
+			previous, replaced = stored.Content, true
+		}
+	}
+
+	change := Change{Type: "new", Title: job.Title, Kind: job.Kind.Name, Model: job.Model}
+	if replaced {
+		change.Type = "regenerated"
+	}
+	notifyWatchers(ctx, changes.add(change, content, previous, replaced), previous)
+}
+
+// noticeEdit logs a stored article that no longer reads as it did at its
+// last change, as it was edited by hand since. Stores may add a final
+// newline, so whitespace around the article doesn't count.
+func noticeEdit(ctx context.Context, stored StoredArticle) {
+	changes := changesFor(ctx)
+	previous, ok := changes.latest(stored.Kind, stored.Title)
+	if !ok || strings.TrimSpace(previous) == strings.TrimSpace(stored.Content) {
+		return
+	}
+	change := Change{Type: "edited", Title: stored.Title, Kind: stored.Kind, Model: stored.Model}
+	notifyWatchers(ctx, changes.add(change, stored.Content, previous, true), previous)
+}
+
+// changeFilters reads the type and limit a request for changes asks for.
+func changeFilters(r *http.Request) (changeType string, limit int) {
+	switch changeType = r.URL.Query().Get("type"); changeType {
+	case "new", "regenerated", "edited":
+	default:
+		changeType = ""
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultChangesLimit
+	}
+	if limit > maxChanges {
+		limit = maxChanges
+	}
+	return changeType, limit
+}
+
+// changeDay is the changes of one day, as the page groups them.
+type changeDay struct {
+	Date    string
+	Changes []Change
+}
+
+func recentChangesHandler(w http.ResponseWriter, r *http.Request) {
+	changeType, limit := changeFilters(r)
+
+	var days []changeDay
+	for _, change := range changesFor(r.Context()).list(changeType, limit) {
+		date := change.Time.Format("2 January 2006")
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, changeDay{Date: date})
+		}
+		days[len(days)-1].Changes = append(days[len(days)-1].Changes, change)
+	}
+
+	data := struct {
+		Branding
+		Days  []changeDay
+		Type  string
+		Limit int
+	}{
+		Branding: brandingFor(r.Context()),
+		Days:     days,
+		Type:     changeType,
+		Limit:    limit,
+	}
+	renderPage(w, "recent.html", data)
+}
+
+func recentChangesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	changeType, limit := changeFilters(r)
+	changes := changesFor(r.Context()).list(changeType, limit)
+	if changes == nil {
+		changes = []Change{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"changes": changes})
+}
+
+// DiffLine is a line of a diff: added ("+"), removed ("-"), unchanged (" ")
+// or standing in for unchanged lines left out ("…").
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		renderError(w, http.StatusNotFound, "There is no such change")
+		return
+	}
+	change, ok := changesFor(r.Context()).find(id)
+	if !ok || change.Diff == "" {
+		renderError(w, http.StatusNotFound, "That change has nothing to compare with, or it is too old to be kept")
+		return
+	}
+
+	data := struct {
+		Branding
+		Change Change
+		Lines  []DiffLine
+	}{
+		Branding: brandingFor(r.Context()),
+		Change:   change,
+		Lines:    diffLines(strings.Split(change.previous, "\n"), strings.Split(change.content, "\n")),
+	}
+	renderPage(w, "diff.html", data)
+}
+
+// diffLines compares two versions of an article line by line, keeping a few
+// unchanged lines around each change.
+func diffLines(before, after []string) []DiffLine {
+	// Only the part between the common start and end needs comparing
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix && before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	var lines []DiffLine
+	for _, line := range before[:prefix] {
+		lines = append(lines, DiffLine{" ", line})
+	}
+	lines = append(lines, diffMiddle(before[prefix:len(before)-suffix], after[prefix:len(after)-suffix])...)
+	for _, line := range before[len(before)-suffix:] {
+		lines = append(lines, DiffLine{" ", line})
+	}
+	return trimDiffContext(lines)
+}
+
+// diffMiddle diffs the changed part of two versions with the longest common
+// subsequence of their lines.
+func diffMiddle(before, after []string) []DiffLine {
+	var lines []DiffLine
+	if len(before)*len(after) > maxDiffCells {
+		for _, line := range before {
+			lines = append(lines, DiffLine{"-", line})
+		}
+		for _, line := range after {
+			lines = append(lines, DiffLine{"+", line})
+		}
+		return lines
+	}
+
+	// common[i][j] is the longest common subsequence of before[i:] and after[j:]
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			lines = append(lines, DiffLine{" ", before[i]})
+			i++
+			j++
+		case i < len(before) && (j == len(after) || common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, DiffLine{"-", before[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{"+", after[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// trimDiffContext leaves out unchanged lines far from any change.
+func trimDiffContext(lines []DiffLine) []DiffLine {
+	near := make([]bool, len(lines))
+	for i, line := range lines {
+		if line.Op == " " {
+			continue
+		}
+		for j := max(0, i-diffContext); j <= min(len(lines)-1, i+diffContext); j++ {
+			near[j] = true
+		}
+	}
+
+	var trimmed []DiffLine
+	for i, line := range lines {
+		if near[i] {
+			trimmed = append(trimmed, line)
+		} else if len(trimmed) == 0 || trimmed[len(trimmed)-1].Op != "…" {
+			trimmed = append(trimmed, DiffLine{Op: "…"})
+		}
+	}
+	return trimmed
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An article page is assembled from components: the prose, the type of topic
+// it is, its infobox and its glossary. The prose is written afresh on every
+// view, but the other components are cached separately, each for as long as
+// it stays good, so regenerating the prose doesn't rerun every pass. Each is
+// keyed on what it is made from. The topic type and infobox only depend on
+// the title and model, so they survive regeneration. The glossary depends on
+// the prose, so it is only reused when the prose comes out the same, as it
+// does with DETERMINISTIC. Suggestions and the preview summary are remade
+// with every generation on purpose.
+
+// componentTTLs is how long each component is kept.
+var componentTTLs = map[string]time.Duration{
+	"topic":    7 * 24 * time.Hour,
+	"route":    7 * 24 * time.Hour,
+	"infobox":  24 * time.Hour,
+	"glossary": 24 * time.Hour,
+}
+
+// maxComponents caps how many components are cached.
+const maxComponents = 10000
+
+type componentKey struct {
+	wiki, component, key string
+}
+
+type cachedComponent struct {
+	value   interface{}
+	expires time.Time
+}
+
+var components = struct {
+	mu      sync.Mutex
+	entries map[componentKey]cachedComponent
+}{
+	entries: map[componentKey]cachedComponent{},
+}
+
+// component returns the cached component for key, building it if it's
+// missing or stale. build returns nil when it fails, which isn't cached.
+func component(ctx context.Context, name, key string, build func() interface{}) interface{} {
+	k := componentKey{wiki: wikiFrom(ctx).Name, component: name, key: key}
+	now := time.Now()
+
+	components.mu.Lock()
+	if cached, ok := components.entries[k]; ok && now.Before(cached.expires) {
+		components.mu.Unlock()
+		return cached.value
+	}
+	components.mu.Unlock()
+
+	value := build()
+	if value == nil || ctx.Err() != nil {
+		return value
+	}
+
+	components.mu.Lock()
+	defer components.mu.Unlock()
+
+	if len(components.entries) >= maxComponents {
+		for k, cached := range components.entries {
+			if now.After(cached.expires) {
+				delete(components.entries, k)
+			}
+		}
+	}
+	if len(components.entries) < maxComponents {
+		components.entries[k] = cachedComponent{value: value, expires: now.Add(componentTTLs[name])}
+	}
+	return value
+}
+
+// cachedExtra caches the result of an extra as a component.
+func cachedExtra(name, key string, task extraTask) extraTask {
+	return func(ctx context.Context) interface{} {
+		return component(ctx, name, key, func() interface{} { return task(ctx) })
+	}
+}
+
+// contentKey identifies a component made from the prose.
+func contentKey(model, content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return model + "\x00" + hex.EncodeToString(sum[:])
+}
+
+// dropComponents forgets the components of a wiki, after its settings
+// change.
+func dropComponents(wiki string) {
+	components.mu.Lock()
+	defer components.mu.Unlock()
+
+	for k := range components.entries {
+		if k.wiki == wiki {
+			delete(components.entries, k)
+		}
+	}
+}
+
+// dropTitleComponents forgets the components of one article of a wiki that
+// are made from its title, for every model. It reports whether there were
+// any.
+func dropTitleComponents(wiki, title string) bool {
+	components.mu.Lock()
+	defer components.mu.Unlock()
+
+	dropped := false
+	for k := range components.entries {
+		if k.wiki == wiki && strings.HasSuffix(k.key, "\x00"+title) {
+			delete(components.entries, k)
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+// countComponents counts the cached components of a wiki.
+func countComponents(wiki string) int {
+	components.mu.Lock()
+	defer components.mu.Unlock()
+
+	count := 0
+	for k := range components.entries {
+		if k.wiki == wiki {
+			count++
+		}
+	}
+	return count
+}
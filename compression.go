@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Articles are prose, which compresses well, and a store that has been
+// collecting them for a while holds a lot of it. With ARTICLE_COMPRESSION
+// the redis, S3 and memory stores compress articles with zstd as they write
+// them: the redis store the JSON it keeps, the S3 store the article file and
+// the memory store the text. The disk store's files are there to be read,
+// grepped and edited by hand, so they are only compressed when
+// ARTICLE_CACHE_COMPRESSION is set as well. Articles are compressed and
+// decompressed as they stream to and from the store, and reading sniffs for
+// zstd's magic bytes, so articles stored before compression was turned on,
+// or after it was turned off, and files edited by hand still read as they
+// are. Compressed article files keep their names, and zstdcat reads them.
+// The admin panel shows how much compression has saved since startup.
+
+// zstdMagic starts every zstd frame, and never an article.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+var compressionStats = struct {
+	mu sync.Mutex
+	// articles is how many articles were compressed, from size bytes down
+	// to compressed bytes
+	articles   int
+	size       int64
+	compressed int64
+	// decompressed is how many compressed articles were read
+	decompressed int
+}{}
+
+// Encoders and decoders hold on to sizable buffers, so they're reused.
+var (
+	zstdEncoders = sync.Pool{New: func() any {
+		// Articles are read far more often than they're written, so it's
+		// worth the slower better compression
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression), zstd.WithEncoderConcurrency(1))
+		return zw
+	}}
+	zstdDecoders = sync.Pool{New: func() any {
+		zr, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		return zr
+	}}
+)
+
+// articleCompression reports whether a store compresses the articles it
+// writes. disk is whether it's the disk store.
+func articleCompression(disk bool) bool {
+	if disk {
+		return envBool("ARTICLE_COMPRESSION", false) && envBool("ARTICLE_CACHE_COMPRESSION", false)
+	}
+	return envBool("ARTICLE_COMPRESSION", false)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeStoredArticle writes a stored article's data to w, compressing it on
+// the way if compress is set.
+func writeStoredArticle(w io.Writer, data []byte, compress bool) error {
+	if !compress {
+		_, err := w.Write(data)
+		return err
+	}
+
+	counted := &countingWriter{w: w}
+	zw := zstdEncoders.Get().(*zstd.Encoder)
+	defer zstdEncoders.Put(zw)
+	zw.Reset(counted)
+	if _, err := zw.Write(data); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	compressionStats.mu.Lock()
+	compressionStats.articles++
+	compressionStats.size += int64(len(data))
+	compressionStats.compressed += counted.n
+	compressionStats.mu.Unlock()
+	return nil
+}
+
+// compressArticle compresses a stored article's data for a store that
+// isn't the disk store, if ARTICLE_COMPRESSION is on.
+func compressArticle(data []byte) []byte {
+	if !articleCompression(false) {
+		return data
+	}
+	var buf bytes.Buffer
+	if err := writeStoredArticle(&buf, data, true); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// isCompressed reports whether stored data was compressed.
+func isCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, zstdMagic)
+}
+
+// zstdReader decompresses an article as it's read, and hands its decoder
+// back once the article is closed.
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (z zstdReader) Close() error {
+	z.Decoder.Reset(nil)
+	zstdDecoders.Put(z.Decoder)
+	return nil
+}
+
+// openArticle returns a reader of a stored article's data, that decompresses
+// it as it's read if it was compressed.
+func openArticle(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	if !isCompressed(magic) {
+		return io.NopCloser(buffered), nil
+	}
+
+	zr := zstdDecoders.Get().(*zstd.Decoder)
+	if err := zr.Reset(buffered); err != nil {
+		zstdDecoders.Put(zr)
+		return nil, err
+	}
+	compressionStats.mu.Lock()
+	compressionStats.decompressed++
+	compressionStats.mu.Unlock()
+	return zstdReader{zr}, nil
+}
+
+// readArticle reads a stored article's data, decompressing it as it's read
+// if it was compressed.
+func readArticle(r io.Reader) ([]byte, error) {
+	article, err := openArticle(r)
+	if err != nil {
+		return nil, err
+	}
+	defer article.Close()
+	return io.ReadAll(article)
+}
+
+// CompressionSummary is what compression has saved since startup, for the
+// admin panel.
+type CompressionSummary struct {
+	Enabled bool
+	// Settings is what turns compression on for the store
+	Settings     string
+	Articles     int
+	Size         int64
+	Compressed   int64
+	Decompressed int
+	// Percent is how much smaller the compressed articles are
+	Percent int
+}
+
+func compressionSummary() CompressionSummary {
+	compressionStats.mu.Lock()
+	defer compressionStats.mu.Unlock()
+
+	_, disk := slugStore.(*diskStore)
+	summary := CompressionSummary{
+		Enabled:      articleCompression(disk),
+		Settings:     "ARTICLE_COMPRESSION",
+		Articles:     compressionStats.articles,
+		Size:         compressionStats.size,
+		Compressed:   compressionStats.compressed,
+		Decompressed: compressionStats.decompressed,
+	}
+	if disk {
+		summary.Settings = "ARTICLE_COMPRESSION and ARTICLE_CACHE_COMPRESSION"
+	}
+	if summary.Size > 0 {
+		summary.Percent = int(100 - 100*summary.Compressed/summary.Size)
+	}
+	return summary
+}
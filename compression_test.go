@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStoredArticleRoundTrip(t *testing.T) {
+	article := []byte("---\ntitle: Ancient Rome\n---\n\n" + strings.Repeat("**Ancient Rome** was a civilization. ", 200))
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := writeStoredArticle(&buf, article, compress); err != nil {
+			t.Fatalf("writeStoredArticle(compress %v): %v", compress, err)
+		}
+		if isCompressed(buf.Bytes()) != compress {
+			t.Errorf("isCompressed = %v, want %v", !compress, compress)
+		}
+		if compress && buf.Len() >= len(article) {
+			t.Errorf("compressed %d bytes to %d", len(article), buf.Len())
+		}
+		read, err := readArticle(&buf)
+		if err != nil {
+			t.Fatalf("readArticle(compress %v): %v", compress, err)
+		}
+		if !bytes.Equal(read, article) {
+			t.Errorf("readArticle(compress %v) didn't read back what was written", compress)
+		}
+	}
+}
+
+func TestDiskCompressionIsOptIn(t *testing.T) {
+	t.Setenv("ARTICLE_COMPRESSION", "true")
+	if !articleCompression(false) || articleCompression(true) {
+		t.Errorf("ARTICLE_COMPRESSION alone should compress every store but the disk store")
+	}
+	t.Setenv("ARTICLE_CACHE_COMPRESSION", "true")
+	if !articleCompression(true) {
+		t.Errorf("ARTICLE_CACHE_COMPRESSION should compress the disk store too")
+	}
+}
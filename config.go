@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Settings holds the portable part of the runtime configuration. It can be
+// exported as a single JSON bundle and loaded on another instance through
+// SETTINGS_FILE, so a curated "wiki flavor" can be shared between users.
+type Settings struct {
+	Model string `json:"model"`
+	// Fallbacks are the models tried in turn when Model fails
+	Fallbacks []string `json:"fallbacks,omitempty"`
+	Prompt    string   `json:"prompt"`
+	// System is the system prompt articles are written under, which gives
+	// the wiki its voice
+	System        string `json:"system"`
+	Deterministic bool   `json:"deterministic"`
+	Glossary      bool   `json:"glossary"`
+	Infobox       bool   `json:"infobox"`
+	TopicTypes    bool   `json:"topic_types"`
+	Activity      bool   `json:"activity"`
+	Suggestions   bool   `json:"suggestions"`
+
+	// Routes send classes of topics, like "code" or "creative", to models
+	// of their own, as RoutingModel classifies the title. Titles of no
+	// routed class are written with Model.
+	Routes       map[string]string `json:"routes,omitempty"`
+	RoutingModel string            `json:"routing_model,omitempty"`
+
+	// EmbeddingModel ranks suggestions by similarity when set
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+
+	// Reasoning is what to do with the <think> sections of reasoning
+	// models: "strip", "collapse" or "keep"
+	Reasoning string `json:"reasoning,omitempty"`
+
+	// Lede streams a quick opening paragraph before the rest of the
+	// article
+	Lede bool `json:"lede,omitempty"`
+
+	// Stop sequences end generation when the model writes one, and
+	// whatever the trim rules match is cut from articles
+	Stop      []string `json:"stop,omitempty"`
+	TrimRules []string `json:"trim_rules,omitempty"`
+
+	// SiteName and Stylesheet, a URL of CSS added to every page, theme the
+	// wiki, along with the URL of a Logo and an AccentColor like #007cba
+	SiteName    string `json:"site_name,omitempty"`
+	Stylesheet  string `json:"stylesheet,omitempty"`
+	Logo        string `json:"logo,omitempty"`
+	AccentColor string `json:"accent_color,omitempty"`
+
+	// HomeIntro and HomeSections make the home page the wiki's own
+	HomeIntro    string        `json:"home_intro,omitempty"`
+	HomeSections []HomeSection `json:"home_sections,omitempty"`
+
+	// NavLinks replace the Home and Back links at the top of articles
+	NavLinks []NavLink `json:"nav_links,omitempty"`
+
+	// Capacity limits belong to the instance, not the flavor, so they
+	// aren't exported
+	MaxStreams          int `json:"-"`
+	MaxStreamsPerClient int `json:"-"`
+
+	// MaxArticleSize is how many bytes of text a generation may produce
+	// before it is cut off
+	MaxArticleSize int `json:"-"`
+
+	// AbuseThreshold is how many different articles a client may start in
+	// a minute before being throttled, or zero for no limit
+	AbuseThreshold int `json:"-"`
+
+	// ReportHideThreshold is how many readers may report an article on a
+	// public instance before it is hidden, or zero to leave hiding to the
+	// moderators
+	ReportHideThreshold int `json:"-"`
+
+	// Gate is the challenge new sessions solve before generating: "pow",
+	// "turnstile", "hcaptcha" or empty for none. GateThrottledOnly limits
+	// it to throttled clients.
+	Gate              string `json:"-"`
+	GateThrottledOnly bool   `json:"-"`
+	GateDifficulty    int    `json:"-"`
+
+	// Metrics serves generation metrics at /metrics
+	Metrics bool `json:"-"`
+
+	// FeaturedInterval is how often a new featured article is invented, or
+	// zero to never feature one
+	FeaturedInterval time.Duration `json:"-"`
+}
+
+const defaultPrompt = `Write the article about "%s".`
+
+const defaultSystemPrompt = `You are a wiki article generator. You write comprehensive informative articles in markdown format about the subjects you are given.
+
+Requirements:
+- Write like wikipedia in an encyclopedic style
+- Include multiple sections with clear markdown headers (## Section Name)
+- Use proper markdown formatting including **bold**, *italic*, lists, etc.
+- Include relevant subsections where appropriate
+- Make the article detailed and informative
+- Provide only the markdown text of the article, no followup questions`
+
+var settings = defaultSettings()
+
+func defaultSettings() Settings {
+	return Settings{
+		Model:          "llama2",
+		Prompt:         defaultPrompt,
+		System:         defaultSystemPrompt,
+		Glossary:       true,
+		Infobox:        true,
+		TopicTypes:     true,
+		Suggestions:    true,
+		MaxArticleSize: 200000,
+		AbuseThreshold: 30,
+		GateDifficulty: 16,
+	}
+}
+
+// loadSettings builds the settings from the defaults, an optional bundle at
+// SETTINGS_FILE and finally the environment variables.
+func loadSettings() Settings {
+	s := defaultSettings()
+
+	if path := os.Getenv("SETTINGS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Error reading settings file '%s': %v", path, err)
+		} else if err := json.Unmarshal(data, &s); err != nil {
+			log.Printf("Error parsing settings file '%s': %v", path, err)
+			s = defaultSettings()
+		} else {
+			log.Printf("Loaded settings from '%s'", path)
+		}
+	}
+
+	if system := os.Getenv("SYSTEM_PROMPT"); system != "" {
+		s.System = system
+	}
+	if path := os.Getenv("SYSTEM_PROMPT_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Error reading system prompt file '%s': %v", path, err)
+		} else {
+			s.System = strings.TrimSpace(string(data))
+		}
+	}
+	if models := os.Getenv("OLLAMA_MODEL"); models != "" {
+		s.setModels(models)
+	}
+	switch provider := os.Getenv("PROVIDER"); provider {
+	case "", "ollama":
+	case "anthropic":
+		if os.Getenv("ANTHROPIC_API_KEY") == "" || os.Getenv("ANTHROPIC_MODEL") == "" {
+			log.Fatalf("PROVIDER=anthropic needs ANTHROPIC_API_KEY and ANTHROPIC_MODEL")
+		}
+		s.setModels(os.Getenv("ANTHROPIC_MODEL"))
+	default:
+		log.Fatalf("Unknown PROVIDER %q, it must be ollama or anthropic", provider)
+	}
+	switch balance := os.Getenv("OLLAMA_BALANCE"); balance {
+	case "", "least-busy", "round-robin":
+	default:
+		log.Fatalf("Unknown OLLAMA_BALANCE %q, it must be least-busy or round-robin", balance)
+	}
+	if err := checkOptionEnv(); err != nil {
+		log.Fatalf("Invalid %v", err)
+	}
+	if list := os.Getenv("MODEL_ROUTES"); list != "" {
+		routes, err := parseRoutes(list)
+		if err != nil {
+			log.Printf("Ignoring MODEL_ROUTES: %v", err)
+		} else {
+			s.Routes = routes
+		}
+	}
+	if model := os.Getenv("ROUTING_MODEL"); model != "" {
+		s.RoutingModel = model
+	}
+	s.Deterministic = envBool("DETERMINISTIC", s.Deterministic)
+	s.Glossary = envBool("GLOSSARY", s.Glossary)
+	s.Infobox = envBool("INFOBOX", s.Infobox)
+	s.TopicTypes = envBool("TOPIC_TYPES", s.TopicTypes)
+	s.Activity = envBool("ACTIVITY_TICKER", s.Activity)
+	s.Suggestions = envBool("SUGGESTIONS", s.Suggestions)
+	if model := os.Getenv("EMBEDDING_MODEL"); model != "" {
+		s.EmbeddingModel = model
+	}
+	s.Lede = envBool("LEDE", s.Lede)
+	if name := os.Getenv("SITE_NAME"); name != "" {
+		s.SiteName = name
+	}
+	if logo := os.Getenv("SITE_LOGO"); logo != "" {
+		s.Logo = logo
+	}
+	if accent := os.Getenv("ACCENT_COLOR"); accent != "" {
+		s.AccentColor = accent
+	}
+	if list := os.Getenv("NAV_LINKS"); list != "" {
+		links, err := parseNavLinks(list)
+		if err != nil {
+			log.Printf("Ignoring NAV_LINKS: %v", err)
+		} else {
+			s.NavLinks = links
+		}
+	}
+	if reasoning := os.Getenv("REASONING"); reasoning != "" {
+		s.Reasoning = reasoning
+	}
+	s.MaxStreams = envInt("MAX_STREAMS", s.MaxStreams)
+	s.MaxStreamsPerClient = envInt("MAX_STREAMS_PER_CLIENT", s.MaxStreamsPerClient)
+	s.MaxArticleSize = envInt("MAX_ARTICLE_SIZE", s.MaxArticleSize)
+	s.AbuseThreshold = envInt("ABUSE_THRESHOLD", s.AbuseThreshold)
+	s.ReportHideThreshold = envInt("REPORT_HIDE_THRESHOLD", s.ReportHideThreshold)
+	s.Metrics = envBool("METRICS", s.Metrics)
+	if gate := os.Getenv("GENERATION_GATE"); gate != "" {
+		s.Gate = gate
+	}
+	s.GateThrottledOnly = envBool("GATE_THROTTLED_ONLY", s.GateThrottledOnly)
+	s.GateDifficulty = envInt("GATE_DIFFICULTY", s.GateDifficulty)
+	s.FeaturedInterval = envDuration("FEATURED_INTERVAL", s.FeaturedInterval)
+
+	if _, err := compileTrimRules(s.TrimRules); err != nil {
+		log.Printf("Ignoring trim rules: %v", err)
+		s.TrimRules = nil
+	}
+
+	if err := checkRoutes(s.Routes); err != nil {
+		log.Printf("Ignoring model routes: %v", err)
+		s.Routes = nil
+	}
+
+	if s.AccentColor != "" && !accentPattern.MatchString(s.AccentColor) {
+		log.Printf("Ignoring ACCENT_COLOR %q, it must be a color like #007cba", s.AccentColor)
+		s.AccentColor = ""
+	}
+
+	if kind := unknownSectionKind(s.HomeSections); kind != "" {
+		log.Printf("Ignoring home sections, there is no %q kind of article", kind)
+		s.HomeSections = nil
+	}
+	if err := checkNavLinks(s.NavLinks); err != nil {
+		log.Printf("Ignoring nav links: %v", err)
+		s.NavLinks = nil
+	}
+
+	switch s.Reasoning {
+	case "", "strip", "collapse", "keep":
+	default:
+		log.Printf("Unknown REASONING %q, stripping it", s.Reasoning)
+		s.Reasoning = "strip"
+	}
+
+	switch s.Gate {
+	case "", "pow", "turnstile", "hcaptcha":
+	default:
+		log.Printf("Unknown GENERATION_GATE %q, using proof of work", s.Gate)
+		s.Gate = "pow"
+	}
+
+	if err := checkPrompt(s.Prompt); err != nil {
+		log.Printf("Invalid prompt, using the default prompt: %v", err)
+		s.Prompt = defaultPrompt
+	}
+
+	return s
+}
+
+// checkPrompt reports what's wrong with a prompt. It is a format string that
+// receives the article title, so it needs exactly one %s and no other verbs,
+// which would come out as errors in the prompt. %% is a percent sign.
+func checkPrompt(prompt string) error {
+	placeholders := 0
+	for i := 0; i < len(prompt); i++ {
+		if prompt[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(prompt) {
+			return errors.New("the prompt ends in a lone %, write %% for a percent sign")
+		}
+		switch prompt[i] {
+		case '%':
+		case 's':
+			placeholders++
+		default:
+			verb, _ := utf8.DecodeRuneInString(prompt[i:])
+			return fmt.Errorf("the prompt has %q where only %%s for the title is allowed, write %%%% for a percent sign", "%"+string(verb))
+		}
+	}
+	if placeholders != 1 {
+		return fmt.Errorf("the prompt needs exactly one %%s placeholder for the title, not %d", placeholders)
+	}
+	return nil
+}
+
+// envBool reads a true/false environment variable, keeping the current value
+// when it isn't set.
+func envBool(name string, current bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return current
+	}
+	return value == "true"
+}
+
+// envInt reads a numeric environment variable, keeping the current value
+// when it isn't set or isn't a number.
+func envInt(name string, current int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return current
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring %s=%q, it must be a number", name, value)
+		return current
+	}
+	return n
+}
+
+// envDuration reads a duration like "1h" or "30m" from the environment,
+// keeping the current value when it isn't set or isn't a duration.
+func envDuration(name string, current time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return current
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Ignoring %s=%q, it must be a duration like 1h or 30m", name, value)
+		return current
+	}
+	return d
+}
+
+// setModels sets the model from a comma separated list in order of
+// preference, the rest of which are its fallbacks.
+func (s *Settings) setModels(list string) {
+	s.Model, s.Fallbacks = "", nil
+	for _, model := range strings.Split(list, ",") {
+		if model = strings.TrimSpace(model); model == "" {
+			continue
+		}
+		if s.Model == "" {
+			s.Model = model
+		} else {
+			s.Fallbacks = append(s.Fallbacks, model)
+		}
+	}
+}
+
+// fallbacksFor lists the models to fall back on when model fails: the
+// wiki's own, if it was routed elsewhere, and then its fallbacks.
+func (s *Settings) fallbacksFor(model string) []string {
+	var fallbacks []string
+	for _, fallback := range append([]string{s.Model}, s.Fallbacks...) {
+		if fallback != model {
+			fallbacks = append(fallbacks, fallback)
+		}
+	}
+	return fallbacks
+}
+
+// siteName is the name the wiki is shown under.
+func (s *Settings) siteName() string {
+	if s.SiteName == "" {
+		return "Endless Wiki"
+	}
+	return s.SiteName
+}
+
+func settingsExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="endless-wiki-settings.json"`)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(settingsFor(r.Context())); err != nil {
+		log.Printf("Error encoding settings: %v", err)
+	}
+}
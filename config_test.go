@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestCheckPrompt(t *testing.T) {
+	tests := []struct {
+		prompt string
+		ok     bool
+	}{
+		{defaultPrompt, true},
+		{"Write about %s.", true},
+		{"Write about %s, 100%% accurately.", true},
+		{"%%s is not a placeholder, %s is", true},
+		{"Write about the title.", false},
+		{"Write about %%s.", false},
+		{"Write about %s and %s.", false},
+		{"Write about %s in %d words.", false},
+		{"Write about %v.", false},
+		{"Write about %5s.", false},
+		{"Write about %s, 100% accurately.", false},
+		{"Write about %s at 100%", false},
+		{"Write about %s in %é", false},
+	}
+	for _, test := range tests {
+		if err := checkPrompt(test.prompt); (err == nil) != test.ok {
+			t.Errorf("checkPrompt(%q) = %v, want ok %v", test.prompt, err, test.ok)
+		}
+	}
+}
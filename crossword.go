@@ -0,0 +1,303 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /special/crossword is a small crossword built from the wiki's stored
+// articles, as a playful way to revisit them. Its answers are the titles of
+// one word of 3 to 12 letters, and its clues their articles' descriptions
+// with the answer blanked out. ?seed= picks the puzzle, so one can be shared,
+// and the page links to a new one. Answers are checked in the browser.
+
+const (
+	// maxCrosswordWords is how many answers a crossword has at most
+	maxCrosswordWords = 12
+	// maxCrosswordCandidates is how many stored articles are read for one
+	maxCrosswordCandidates = 60
+)
+
+// crosswordAnswer is what titles have to look like to be answers.
+var crosswordAnswer = regexp.MustCompile(`^[A-Za-z]{3,12}$`)
+
+// crosswordWord is an answer and its clue.
+type crosswordWord struct {
+	Title  string
+	Answer string
+	Clue   string
+}
+
+// crosswordEntry is an answer placed on the grid, across or down from its
+// first letter.
+type crosswordEntry struct {
+	crosswordWord
+	Row, Col int
+	Down     bool
+	Number   int
+}
+
+// CrosswordCell is a square of the grid: a letter of an answer, numbered if
+// an answer starts there, or a block.
+type CrosswordCell struct {
+	Letter string
+	Number int
+}
+
+// CrosswordClue is a clue as the page lists it.
+type CrosswordClue struct {
+	Number int
+	Clue   string
+	Length int
+	Title  string
+}
+
+// gridPos is a square of a grid being built.
+type gridPos struct{ row, col int }
+
+// crosswordGrid is a grid being built, by its squares' letters and the
+// directions of the answers through them.
+type crosswordGrid struct {
+	letters map[gridPos]byte
+	across  map[gridPos]bool
+	down    map[gridPos]bool
+	entries []crosswordEntry
+}
+
+func newCrosswordGrid() *crosswordGrid {
+	return &crosswordGrid{letters: map[gridPos]byte{}, across: map[gridPos]bool{}, down: map[gridPos]bool{}}
+}
+
+// fits reports whether an answer can go at a place: it agrees with the
+// letters it crosses, crosses at least one once the grid has answers,
+// and doesn't run into any other alongside or at its ends.
+func (g *crosswordGrid) fits(answer string, row, col int, down bool) bool {
+	dr, dc := 0, 1
+	if down {
+		dr, dc = 1, 0
+	}
+	if _, ok := g.letters[gridPos{row - dr, col - dc}]; ok {
+		return false
+	}
+	if _, ok := g.letters[gridPos{row + len(answer)*dr, col + len(answer)*dc}]; ok {
+		return false
+	}
+	crossings := 0
+	for i := 0; i < len(answer); i++ {
+		pos := gridPos{row + i*dr, col + i*dc}
+		if letter, ok := g.letters[pos]; ok {
+			if letter != answer[i] || (down && g.down[pos]) || (!down && g.across[pos]) {
+				return false
+			}
+			crossings++
+			continue
+		}
+		// An empty square mustn't have letters on either side of it
+		if _, ok := g.letters[gridPos{pos.row + dc, pos.col + dr}]; ok {
+			return false
+		}
+		if _, ok := g.letters[gridPos{pos.row - dc, pos.col - dr}]; ok {
+			return false
+		}
+	}
+	return crossings > 0 || len(g.entries) == 0
+}
+
+// place puts an answer on the grid.
+func (g *crosswordGrid) place(word crosswordWord, row, col int, down bool) {
+	dr, dc := 0, 1
+	if down {
+		dr, dc = 1, 0
+	}
+	for i := 0; i < len(word.Answer); i++ {
+		pos := gridPos{row + i*dr, col + i*dc}
+		g.letters[pos] = word.Answer[i]
+		if down {
+			g.down[pos] = true
+		} else {
+			g.across[pos] = true
+		}
+	}
+	g.entries = append(g.entries, crosswordEntry{crosswordWord: word, Row: row, Col: col, Down: down})
+}
+
+// add puts an answer where it first crosses the grid, reporting false if it
+// fits nowhere.
+func (g *crosswordGrid) add(word crosswordWord) bool {
+	if len(g.entries) == 0 {
+		g.place(word, 0, 0, false)
+		return true
+	}
+	for _, placed := range g.entries {
+		for i := 0; i < len(placed.Answer); i++ {
+			for j := 0; j < len(word.Answer); j++ {
+				if placed.Answer[i] != word.Answer[j] {
+					continue
+				}
+				down := !placed.Down
+				row, col := placed.Row, placed.Col+i
+				if placed.Down {
+					row, col = placed.Row+i, placed.Col
+				}
+				if down {
+					row -= j
+				} else {
+					col -= j
+				}
+				if g.fits(word.Answer, row, col, down) {
+					g.place(word, row, col, down)
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// layoutCrossword lays words out on a grid greedily, longest first, each
+// crossing one already placed, and numbers the answers in reading order.
+// Words that cross nothing are left out.
+func layoutCrossword(words []crosswordWord) (rows [][]CrosswordCell, entries []crosswordEntry) {
+	sorted := append([]crosswordWord(nil), words...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Answer) > len(sorted[j].Answer) })
+
+	g := newCrosswordGrid()
+	for _, word := range sorted {
+		if len(g.entries) == maxCrosswordWords {
+			break
+		}
+		g.add(word)
+	}
+	if len(g.entries) == 0 {
+		return nil, nil
+	}
+
+	top, left, bottom, right := 0, 0, 0, 0
+	for pos := range g.letters {
+		top, left = min(top, pos.row), min(left, pos.col)
+		bottom, right = max(bottom, pos.row), max(right, pos.col)
+	}
+	rows = make([][]CrosswordCell, bottom-top+1)
+	for r := range rows {
+		rows[r] = make([]CrosswordCell, right-left+1)
+	}
+	for pos, letter := range g.letters {
+		rows[pos.row-top][pos.col-left].Letter = string(letter)
+	}
+
+	entries = g.entries
+	for i := range entries {
+		entries[i].Row -= top
+		entries[i].Col -= left
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Row != entries[j].Row {
+			return entries[i].Row < entries[j].Row
+		}
+		return entries[i].Col < entries[j].Col
+	})
+	number := 0
+	for i := range entries {
+		cell := &rows[entries[i].Row][entries[i].Col]
+		if cell.Number == 0 {
+			number++
+			cell.Number = number
+		}
+		entries[i].Number = cell.Number
+	}
+	return rows, entries
+}
+
+// crosswordClue blanks an answer out of an article's description.
+func crosswordClue(title, content string) string {
+	blank := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(title) + `\w*`)
+	return blank.ReplaceAllStringFunc(metaDescription(content), func(word string) string {
+		return strings.Repeat("_", len(word))
+	})
+}
+
+// crosswordWords picks the stored articles that can be answers, in an order
+// given by seed.
+func crosswordWords(r *http.Request, seed int64) []crosswordWord {
+	ctx := r.Context()
+	wiki := wikiFrom(ctx).Name
+	titles, err := articles.List(ctx, wiki, "")
+	if err != nil {
+		log.Printf("Error listing stored articles of wiki '%s': %v", wiki, err)
+		return nil
+	}
+	sort.Strings(titles)
+	random := rand.New(rand.NewSource(seed))
+	random.Shuffle(len(titles), func(i, j int) { titles[i], titles[j] = titles[j], titles[i] })
+
+	var words []crosswordWord
+	seen := map[string]bool{}
+	read := 0
+	for _, title := range titles {
+		answer := strings.ToUpper(title)
+		if !crosswordAnswer.MatchString(title) || seen[answer] || articleHidden(ctx, title, "") {
+			continue
+		}
+		if read == maxCrosswordCandidates {
+			break
+		}
+		read++
+		article, ok, err := articles.Get(ctx, wiki, "", title)
+		if err != nil {
+			log.Printf("Error reading stored article '%s' of wiki '%s': %v", title, wiki, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		clue := crosswordClue(title, article.Content)
+		if strings.Trim(clue, "_ ") == "" {
+			continue
+		}
+		seen[answer] = true
+		words = append(words, crosswordWord{Title: article.Title, Answer: answer, Clue: clue})
+	}
+	return words
+}
+
+func crosswordHandler(w http.ResponseWriter, r *http.Request) {
+	seed, err := strconv.ParseInt(r.URL.Query().Get("seed"), 10, 64)
+	if err != nil {
+		seed = time.Now().UnixNano() % 1000000
+	}
+
+	data := struct {
+		Branding
+		Seed, Next   int64
+		Rows         [][]CrosswordCell
+		Across, Down []CrosswordClue
+	}{
+		Branding: brandingFor(r.Context()),
+		Seed:     seed,
+		Next:     seed + 1,
+	}
+	var entries []crosswordEntry
+	if articles != nil {
+		data.Rows, entries = layoutCrossword(crosswordWords(r, seed))
+	}
+	// A single answer crosses nothing, which is no crossword
+	if len(entries) >= 2 {
+		for _, entry := range entries {
+			clue := CrosswordClue{Number: entry.Number, Clue: entry.Clue, Length: len(entry.Answer), Title: entry.Title}
+			if entry.Down {
+				data.Down = append(data.Down, clue)
+			} else {
+				data.Across = append(data.Across, clue)
+			}
+		}
+	} else {
+		data.Rows = nil
+	}
+	renderPage(w, "crossword.html", data)
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestLayoutCrossword(t *testing.T) {
+	words := []crosswordWord{
+		{Title: "Rome", Answer: "ROME"},
+		{Title: "Carthage", Answer: "CARTHAGE"},
+		{Title: "Greece", Answer: "GREECE"},
+		{Title: "Egypt", Answer: "EGYPT"},
+		{Title: "Quiz", Answer: "QUIZ"},
+	}
+	rows, entries := layoutCrossword(words)
+	if len(entries) < 2 {
+		t.Fatalf("placed %d answers, want at least 2", len(entries))
+	}
+
+	for _, entry := range entries {
+		if entry.Answer == "QUIZ" {
+			t.Errorf("QUIZ shares no letter with the others but was placed")
+		}
+		// Every answer reads out of the grid where it was placed
+		for i := 0; i < len(entry.Answer); i++ {
+			row, col := entry.Row, entry.Col+i
+			if entry.Down {
+				row, col = entry.Row+i, entry.Col
+			}
+			if got := rows[row][col].Letter; got != string(entry.Answer[i]) {
+				t.Errorf("%s: square %d,%d is %q, want %q", entry.Answer, row, col, got, string(entry.Answer[i]))
+			}
+		}
+		if rows[entry.Row][entry.Col].Number != entry.Number {
+			t.Errorf("%s starts at a square numbered %d, not %d", entry.Answer, rows[entry.Row][entry.Col].Number, entry.Number)
+		}
+	}
+}
+
+func TestCrosswordClue(t *testing.T) {
+	got := crosswordClue("Rome", "Rome is the capital of Italy. ROME hosts the Romeo festival.")
+	want := "____ is the capital of Italy. ____ hosts the _____ festival."
+	if got != want {
+		t.Errorf("crosswordClue = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Readers who opt in on their watchlist get a weekly digest by email of
+// what their wiki wrote that week, from its recent changes. A digest is due
+// a week after the last one, checked every digestCheckInterval, so restarts
+// only delay it. Weeks with nothing written send nothing.
+
+// digestInterval is how often digests are sent.
+const digestInterval = 7 * 24 * time.Hour
+
+// digestCheckInterval is how often due digests are looked for.
+const digestCheckInterval = time.Hour
+
+// maxDigestArticles caps how many new articles a digest lists by name.
+const maxDigestArticles = 30
+
+// DigestArticle is an article listed in a digest.
+type DigestArticle struct {
+	Title string
+	URL   string
+}
+
+// Digest is what a digest email is rendered from.
+type Digest struct {
+	Site        string
+	Since       time.Time
+	NewCount    int
+	New         []DigestArticle
+	More        int
+	Regenerated int
+	Edited      int
+	Recent      string
+	Watchlist   string
+	Unsubscribe string
+}
+
+// startDigests sends weekly digests until the process exits, if the
+// instance can send email.
+func startDigests() {
+	if !emailEnabled() {
+		return
+	}
+
+	go func() {
+		for {
+			sendDigests()
+			time.Sleep(digestCheckInterval)
+		}
+	}()
+}
+
+// sendDigests sends every digest that is due.
+func sendDigests() {
+	type due struct {
+		email       string
+		wiki        string
+		since       time.Time
+		unsubscribe string
+	}
+
+	now := time.Now()
+	var sending []due
+	watchers.mu.Lock()
+	for _, watcher := range watchers.byID {
+		if !watcher.Digest || watcher.Email == "" || now.Sub(watcher.LastDigest) < digestInterval {
+			continue
+		}
+		sending = append(sending, due{watcher.Email, watcher.DigestWiki, watcher.LastDigest, watcher.unsubscribeLink()})
+		watcher.LastDigest = now
+	}
+	watchers.mu.Unlock()
+	if len(sending) == 0 {
+		return
+	}
+	saveWatchlists()
+
+	sent := 0
+	for _, d := range sending {
+		wiki, ok := wikiNamed(d.wiki)
+		if !ok {
+			continue
+		}
+		digest, ok := buildDigest(wiki, d.since)
+		if !ok {
+			continue
+		}
+		digest.Unsubscribe = d.unsubscribe
+		if err := sendTemplatedEmail(d.email, "digest", d.unsubscribe, digest); err != nil {
+			log.Printf("Error sending the weekly digest of wiki '%s': %v", wiki.Name, err)
+			continue
+		}
+		sent++
+	}
+	if sent > 0 {
+		log.Printf("Sent %d weekly digests", sent)
+	}
+}
+
+// buildDigest sums up what a wiki wrote since a time. It reports false if it
+// wrote nothing.
+func buildDigest(wiki *Wiki, since time.Time) (Digest, bool) {
+	ctx := context.WithValue(context.Background(), wikiContextKey{}, wiki)
+	digest := Digest{
+		Site:      wiki.Settings.siteName(),
+		Since:     since,
+		Recent:    publicLink("/recent"),
+		Watchlist: publicLink("/watchlist"),
+	}
+
+	changes := wiki.changes.since(since)
+	for _, change := range changes {
+		if articleHidden(ctx, change.Title, change.Kind) {
+			continue
+		}
+		switch change.Type {
+		case "new":
+			digest.NewCount++
+			if len(digest.New) < maxDigestArticles {
+				digest.New = append(digest.New, DigestArticle{change.Title, publicLink(articlePath(change.Kind, change.Title))})
+			} else {
+				digest.More++
+			}
+		case "regenerated":
+			digest.Regenerated++
+		case "edited":
+			digest.Edited++
+		}
+	}
+	return digest, digest.NewCount > 0 || digest.Regenerated > 0 || digest.Edited > 0
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The Discord bot answers a /wiki slash command with the start of a freshly
+// generated article and a link to the full page. It uses Discord's HTTP
+// interactions, so the application's Interactions Endpoint URL needs to point
+// at /discord/interactions on a publicly reachable instance.
+
+const discordAPI = "https://discord.com/api/v10"
+
+// discordTimeout is how long the bot may take to finish an article. Discord
+// stops accepting follow ups to an interaction after 15 minutes.
+const discordTimeout = 14 * time.Minute
+
+// discordDescriptionLength is how much of the article goes in the embed.
+const discordDescriptionLength = 1000
+
+const (
+	discordPing               = 1
+	discordApplicationCommand = 2
+
+	discordPong                   = 1
+	discordChannelMessage         = 4
+	discordDeferredChannelMessage = 5
+	discordStringOption           = 3
+	discordEphemeralFlag          = 64
+)
+
+type discordInteraction struct {
+	Type          int    `json:"type"`
+	Token         string `json:"token"`
+	ApplicationID string `json:"application_id"`
+	Data          struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+type discordEmbed struct {
+	Title       string `json:"title"`
+	URL         string `json:"url,omitempty"`
+	Description string `json:"description"`
+}
+
+// discordPublicKey is the application's key for verifying interactions, or
+// nil when the bot isn't configured.
+func discordPublicKey() ed25519.PublicKey {
+	key, err := hex.DecodeString(os.Getenv("DISCORD_PUBLIC_KEY"))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil
+	}
+	return key
+}
+
+// registerDiscordCommands creates or updates the /wiki command for the
+// application. It needs the bot token and is skipped without one.
+func registerDiscordCommands() {
+	appID := os.Getenv("DISCORD_APPLICATION_ID")
+	token := os.Getenv("DISCORD_BOT_TOKEN")
+	if appID == "" || token == "" {
+		return
+	}
+
+	commands := []map[string]interface{}{{
+		"name":        "wiki",
+		"description": "Generate an endless wiki article",
+		"options": []map[string]interface{}{{
+			"type":        discordStringOption,
+			"name":        "topic",
+			"description": "What the article is about",
+			"required":    true,
+		}},
+	}}
+
+	body, _ := json.Marshal(commands)
+	req, err := http.NewRequest("PUT", discordAPI+"/applications/"+appID+"/commands", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error registering Discord commands: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bot "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error registering Discord commands: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Printf("Error registering Discord commands: %s: %s", resp.Status, msg)
+		return
+	}
+	log.Printf("Registered the Discord /wiki command")
+}
+
+// discordInteractionsHandler receives slash commands from Discord.
+func discordInteractionsHandler(w http.ResponseWriter, r *http.Request) {
+	key := discordPublicKey()
+	if key == nil {
+		http.Error(w, "Discord bot is not configured", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+
+	// Discord signs every request and periodically checks that bad
+	// signatures are rejected
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	timestamp := r.Header.Get("X-Signature-Timestamp")
+	if err != nil || !ed25519.Verify(key, append([]byte(timestamp), body...), signature) {
+		http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction discordInteraction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, "Invalid interaction", http.StatusBadRequest)
+		return
+	}
+
+	switch interaction.Type {
+	case discordPing:
+		writeDiscordResponse(w, map[string]interface{}{"type": discordPong})
+	case discordApplicationCommand:
+		var topic string
+		for _, option := range interaction.Data.Options {
+			if option.Name == "topic" {
+				topic = option.Value
+			}
+		}
+
+		title, err := normalizeTitle(topic)
+		if err != nil {
+			writeDiscordResponse(w, map[string]interface{}{
+				"type": discordChannelMessage,
+				"data": map[string]interface{}{"content": err.Error(), "flags": discordEphemeralFlag},
+			})
+			return
+		}
+
+		// Generation takes longer than Discord waits for a reply, so
+		// acknowledge now and edit the reply once the article is done
+		writeDiscordResponse(w, map[string]interface{}{"type": discordDeferredChannelMessage})
+		go discordGenerate(interaction, title)
+	default:
+		http.Error(w, "Unsupported interaction", http.StatusBadRequest)
+	}
+}
+
+func writeDiscordResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding Discord response: %v", err)
+	}
+}
+
+// discordGenerate generates the article for a slash command and replaces the
+// deferred reply with it.
+func discordGenerate(interaction discordInteraction, title string) {
+	ctx, cancel := context.WithTimeout(context.Background(), discordTimeout)
+	defer cancel()
+
+	r, _ := http.NewRequestWithContext(ctx, "GET", "/wiki/"+url.PathEscape(title), nil)
+	content, _, err := generateWhole(ctx, r, "discord", title)
+	if err != nil {
+		alertAdmin("Error generating article for Discord: %v", err)
+		editDiscordReply(interaction, map[string]interface{}{"content": "Failed to generate **" + title + "**"})
+		return
+	}
+
+	embed := discordEmbed{
+		Title:       title,
+		Description: discordExcerpt(content),
+	}
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		embed.URL = strings.TrimRight(base, "/") + "/wiki/" + slugPath(title)
+	}
+	editDiscordReply(interaction, map[string]interface{}{"embeds": []discordEmbed{embed}})
+}
+
+// discordExcerpt trims an article down to whole paragraphs that fit in an
+// embed, with [[Topic]] links flattened to plain text.
+func discordExcerpt(content string) string {
+	content = topicLinkPattern.ReplaceAllString(strings.TrimSpace(content), "$1")
+
+	var excerpt strings.Builder
+	for _, paragraph := range strings.Split(content, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if excerpt.Len()+len(paragraph) > discordDescriptionLength {
+			if excerpt.Len() == 0 {
+				// A single long paragraph is cut off mid way instead
+				runes := []rune(paragraph)
+				if len(runes) > discordDescriptionLength {
+					runes = runes[:discordDescriptionLength]
+				}
+				excerpt.WriteString(string(runes) + "…")
+			}
+			break
+		}
+		if excerpt.Len() > 0 {
+			excerpt.WriteString("\n\n")
+		}
+		excerpt.WriteString(paragraph)
+	}
+	return excerpt.String()
+}
+
+func editDiscordReply(interaction discordInteraction, message map[string]interface{}) {
+	body, _ := json.Marshal(message)
+	endpoint := fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", discordAPI, interaction.ApplicationID, interaction.Token)
+
+	req, err := http.NewRequest("PATCH", endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error replying on Discord: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error replying on Discord: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Printf("Error replying on Discord: %s: %s", resp.Status, msg)
+	}
+}
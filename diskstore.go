@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The disk store keeps each article as a markdown file named after its
+// title, under a folder for the wiki and kind it belongs to, with what it was
+// generated from in a front matter block at the top. The files can be backed
+// up, grepped and edited by hand, and edits are picked up on the next visit.
+// Deleting a file has the article written again.
+
+// maxArticleFileName caps the length of a cached article's file name, to
+// stay well inside what filesystems allow.
+const maxArticleFileName = 180
+
+// diskStore keeps articles as files under dir.
+type diskStore struct {
+	dir string
+}
+
+// path is where an article of a wiki is kept.
+func (s *diskStore) path(wiki, kind, title string) string {
+	return filepath.Join(s.kindDir(wiki, kind), articleFileName(title))
+}
+
+func (s *diskStore) kindDir(wiki, kind string) string {
+	if kind == "" {
+		kind = "wiki"
+	}
+	return filepath.Join(s.dir, wiki, kind)
+}
+
+// articleFileName names the file an article is kept in after its title.
+// Characters that aren't safe in file names on every system are escaped the
+// way URLs escape them, and titles too long for a file name are cut short
+// and told apart by a hash.
+func articleFileName(title string) string {
+	var name strings.Builder
+	for i, c := range title {
+		if c < ' ' || strings.ContainsRune(`%/\:*?"<>|`, c) || (i == 0 && c == '.') {
+			fmt.Fprintf(&name, "%%%02X", c)
+		} else {
+			name.WriteRune(c)
+		}
+	}
+	if name.Len() > maxArticleFileName {
+		sum := sha256.Sum256([]byte(title))
+		return truncateBytes(name.String(), maxArticleFileName-17) + "-" + hex.EncodeToString(sum[:8]) + ".md"
+	}
+	return name.String() + ".md"
+}
+
+func (s *diskStore) Get(ctx context.Context, wiki, kind, title string) (StoredArticle, bool, error) {
+	data, err := s.read(s.path(wiki, kind, title))
+	if os.IsNotExist(err) {
+		return StoredArticle{}, false, nil
+	}
+	if err != nil {
+		return StoredArticle{}, false, err
+	}
+
+	// The file's name says what it's the article for, whatever its front
+	// matter says after a hand edit
+	article := parseArticleFile(string(data))
+	article.Title, article.Kind = title, kind
+	return article, true, nil
+}
+
+func (s *diskStore) Put(ctx context.Context, wiki string, article StoredArticle) error {
+	path := s.path(wiki, article.Kind, article.Title)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see half an article
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".article-*")
+	if err != nil {
+		return err
+	}
+	if err := writeStoredArticle(tmp, []byte(formatArticleFile(article)), articleCompression(true)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *diskStore) List(ctx context.Context, wiki, kind string) ([]string, error) {
+	entries, err := os.ReadDir(s.kindDir(wiki, kind))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var titles []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".md") || strings.HasPrefix(name, ".article-") {
+			continue
+		}
+		data, err := s.read(filepath.Join(s.kindDir(wiki, kind), name))
+		if err != nil {
+			return nil, err
+		}
+		// Files written by hand are titled by their name
+		title := parseArticleFile(string(data)).Title
+		if title == "" {
+			title, _ = url.PathUnescape(strings.TrimSuffix(name, ".md"))
+		}
+		titles = append(titles, title)
+	}
+	return titles, nil
+}
+
+// read reads an article file, compressed or not.
+func (s *diskStore) read(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readArticle(file)
+}
+
+func (s *diskStore) Delete(ctx context.Context, wiki, kind, title string) error {
+	err := os.Remove(s.path(wiki, kind, title))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// parseArticleFile reads an article file. A file without front matter, as
+// someone might write by hand, is all content.
+func parseArticleFile(data string) StoredArticle {
+	var article StoredArticle
+	rest, ok := strings.CutPrefix(data, "---\n")
+	if !ok {
+		article.Content = data
+		return article
+	}
+	front, content, ok := strings.Cut(rest, "\n---\n")
+	if !ok {
+		article.Content = data
+		return article
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(front))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), ":")
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "title":
+			article.Title = value
+		case "kind":
+			article.Kind = value
+		case "model":
+			article.Model = value
+		case "topic":
+			article.Topic = value
+		case "generated":
+			article.Generated, _ = time.Parse(time.RFC3339, value)
+		case "duration":
+			article.Duration, _ = time.ParseDuration(value)
+		case "tokens":
+			article.Tokens, _ = strconv.Atoi(value)
+		}
+	}
+	article.Content = strings.TrimLeft(content, "\n")
+	return article
+}
+
+// formatArticleFile writes an article file.
+func formatArticleFile(article StoredArticle) string {
+	var file strings.Builder
+	file.WriteString("---\n")
+	fmt.Fprintf(&file, "title: %s\n", article.Title)
+	if article.Kind != "" {
+		fmt.Fprintf(&file, "kind: %s\n", article.Kind)
+	}
+	fmt.Fprintf(&file, "model: %s\n", article.Model)
+	if article.Topic != "" {
+		fmt.Fprintf(&file, "topic: %s\n", article.Topic)
+	}
+	fmt.Fprintf(&file, "generated: %s\n", article.Generated.UTC().Format(time.RFC3339))
+	if article.Duration > 0 {
+		fmt.Fprintf(&file, "duration: %s\n", article.Duration.Round(time.Millisecond))
+	}
+	if article.Tokens > 0 {
+		fmt.Fprintf(&file, "tokens: %d\n", article.Tokens)
+	}
+	file.WriteString("---\n\n")
+	file.WriteString(article.Content)
+	if !strings.HasSuffix(article.Content, "\n") {
+		file.WriteString("\n")
+	}
+	return file.String()
+}
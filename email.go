@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Emails are sent through the SMTP server in SMTP_HOST, from SMTP_FROM, and
+// link back to the wiki at PUBLIC_URL, so all three are needed. Each email
+// is a text template in templates/email, which defines its "subject" and
+// writes its body. Readers opt in to them from their watchlist: alerts
+// about watched articles, and a weekly digest of what their wiki wrote. The
+// admin can get alerts about errors at ADMIN_EMAIL.
+
+var emailTemplates map[string]*template.Template
+
+// loadEmailTemplates parses every email template. Like the page templates,
+// a broken one stops the server.
+func loadEmailTemplates() {
+	files, err := filepath.Glob("templates/email/*.txt")
+	if err != nil {
+		log.Fatalf("Error listing email templates: %v", err)
+	}
+
+	emailTemplates = map[string]*template.Template{}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".txt")
+		tmpl, err := template.New(filepath.Base(file)).ParseFiles(file)
+		if err != nil {
+			log.Fatalf("Error parsing email template: %v", err)
+		}
+		if tmpl.Lookup("subject") == nil {
+			log.Fatalf("Email template %s doesn't define a subject", file)
+		}
+		emailTemplates[name] = tmpl
+	}
+}
+
+// emailEnabled reports whether the instance can send email.
+func emailEnabled() bool {
+	return os.Getenv("SMTP_HOST") != "" && os.Getenv("SMTP_FROM") != "" && os.Getenv("PUBLIC_URL") != ""
+}
+
+// sendTemplatedEmail renders an email template and sends it. unsubscribe is
+// the link that stops emails like it, if there is one.
+func sendTemplatedEmail(to, name, unsubscribe string, data interface{}) error {
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		return fmt.Errorf("no email template %q", name)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return err
+	}
+	if err := tmpl.Execute(&body, data); err != nil {
+		return err
+	}
+	return sendEmail(to, strings.TrimSpace(subject.String()), unsubscribe, body.String())
+}
+
+// sendEmail sends a plain text email. Servers offering STARTTLS are talked
+// to over TLS.
+func sendEmail(to, subject, unsubscribe, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from, err := mail.ParseAddress(os.Getenv("SMTP_FROM"))
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_FROM: %v", err)
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", from.String())
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if unsubscribe != "" {
+		fmt.Fprintf(&message, "List-Unsubscribe: <%s>\r\n", unsubscribe)
+	}
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(strings.TrimLeft(body, "\n"), "\n", "\r\n"))
+
+	return smtp.SendMail(net.JoinHostPort(host, port), auth, from.Address, []string{to}, []byte(message.String()))
+}
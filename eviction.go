@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The article store can be kept within limits. ARTICLE_STORE_MAX caps how
+// many articles it holds and ARTICLE_STORE_MAX_SIZE how many bytes of text,
+// and past either the articles read least recently are evicted. ARTICLE_TTL
+// has articles written afresh once they are that old. Eviction runs in the
+// background every evictionInterval, after the store has been walked at
+// startup to learn what it holds, so requests never wait on it. Expired
+// articles are never served in between.
+
+// evictionInterval is how often the store is brought back within its limits.
+const evictionInterval = time.Minute
+
+type storeKey struct {
+	wiki, kind, title string
+}
+
+type storeEntry struct {
+	size      int
+	used      time.Time
+	generated time.Time
+}
+
+// evictingStore keeps another store within the limits.
+type evictingStore struct {
+	ArticleStore
+	maxArticles int
+	maxSize     int
+	ttl         time.Duration
+
+	mu      sync.Mutex
+	entries map[storeKey]*storeEntry
+	size    int
+}
+
+// limitArticleStore wraps a store in the limits set in the environment, if
+// any are.
+func limitArticleStore(store ArticleStore) ArticleStore {
+	s := &evictingStore{
+		ArticleStore: store,
+		maxArticles:  envInt("ARTICLE_STORE_MAX", 0),
+		maxSize:      envInt("ARTICLE_STORE_MAX_SIZE", 0),
+		ttl:          envDuration("ARTICLE_TTL", 0),
+		entries:      map[storeKey]*storeEntry{},
+	}
+	if s.maxArticles <= 0 && s.maxSize <= 0 && s.ttl <= 0 {
+		return store
+	}
+	return s
+}
+
+// expired reports whether an article is too old to serve. Articles written
+// by hand, without a time they were generated, never expire.
+func (s *evictingStore) expired(generated time.Time) bool {
+	return s.ttl > 0 && !generated.IsZero() && time.Since(generated) > s.ttl
+}
+
+func (s *evictingStore) Get(ctx context.Context, wiki, kind, title string) (StoredArticle, bool, error) {
+	article, ok, err := s.ArticleStore.Get(ctx, wiki, kind, title)
+	if err != nil || !ok || s.expired(article.Generated) {
+		return StoredArticle{}, false, err
+	}
+	s.use(storeKey{wiki, kind, title}, article)
+	return article, true, nil
+}
+
+func (s *evictingStore) Put(ctx context.Context, wiki string, article StoredArticle) error {
+	if err := s.ArticleStore.Put(ctx, wiki, article); err != nil {
+		return err
+	}
+	s.use(storeKey{wiki, article.Kind, article.Title}, article)
+	return nil
+}
+
+// use notes that an article was just read or written.
+func (s *evictingStore) use(key storeKey, article StoredArticle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &storeEntry{}
+		s.entries[key] = entry
+	}
+	s.size += len(article.Content) - entry.size
+	entry.size = len(article.Content)
+	entry.generated = article.Generated
+	entry.used = time.Now()
+}
+
+func (s *evictingStore) Delete(ctx context.Context, wiki, kind, title string) error {
+	if err := s.ArticleStore.Delete(ctx, wiki, kind, title); err != nil {
+		return err
+	}
+	s.forget(storeKey{wiki, kind, title})
+	return nil
+}
+
+func (s *evictingStore) forget(key storeKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		s.size -= entry.size
+		delete(s.entries, key)
+	}
+}
+
+// index learns what the store already holds, counting every article as last
+// read when it was generated.
+func (s *evictingStore) index(ctx context.Context) {
+	kinds := []string{""}
+	for name := range articleKinds {
+		kinds = append(kinds, name)
+	}
+
+	count := 0
+	for _, wiki := range allWikis() {
+		for _, kind := range kinds {
+			titles, err := s.ArticleStore.List(ctx, wiki.Name, kind)
+			if err != nil {
+				log.Printf("Error listing stored articles of wiki '%s': %v", wiki.Name, err)
+				continue
+			}
+			for _, title := range titles {
+				article, ok, err := s.ArticleStore.Get(ctx, wiki.Name, kind, title)
+				if err != nil || !ok {
+					continue
+				}
+
+				s.mu.Lock()
+				key := storeKey{wiki.Name, kind, title}
+				// Articles read or written since startup are known better
+				if _, ok := s.entries[key]; !ok {
+					s.entries[key] = &storeEntry{
+						size:      len(article.Content),
+						used:      article.Generated,
+						generated: article.Generated,
+					}
+					s.size += len(article.Content)
+				}
+				s.mu.Unlock()
+				count++
+			}
+		}
+	}
+	log.Printf("Found %d stored articles", count)
+}
+
+// evict deletes expired articles, then the least recently read until the
+// store is within its limits.
+func (s *evictingStore) evict(ctx context.Context) {
+	type candidate struct {
+		key  storeKey
+		used time.Time
+	}
+
+	s.mu.Lock()
+	var victims, live []candidate
+	count, size := 0, s.size
+	for key, entry := range s.entries {
+		if s.expired(entry.generated) {
+			victims = append(victims, candidate{key, entry.used})
+			size -= entry.size
+			continue
+		}
+		live = append(live, candidate{key, entry.used})
+		count++
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].used.Before(live[j].used) })
+	for _, c := range live {
+		if (s.maxArticles <= 0 || count <= s.maxArticles) && (s.maxSize <= 0 || size <= s.maxSize) {
+			break
+		}
+		victims = append(victims, c)
+		count--
+		size -= s.entries[c.key].size
+	}
+	s.mu.Unlock()
+
+	evicted := 0
+	for _, victim := range victims {
+		// Leave articles that were read or written again meanwhile
+		s.mu.Lock()
+		entry, ok := s.entries[victim.key]
+		fresh := ok && entry.used.After(victim.used)
+		s.mu.Unlock()
+		if !ok || fresh {
+			continue
+		}
+
+		key := victim.key
+		if err := s.Delete(ctx, key.wiki, key.kind, key.title); err != nil {
+			log.Printf("Error evicting stored article '%s': %v", key.title, err)
+			continue
+		}
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("Evicted %d stored articles", evicted)
+	}
+}
+
+// startEviction walks the store and keeps it within its limits from then
+// on, if it has any.
+func startEviction() {
+	store, ok := articles.(*evictingStore)
+	if !ok {
+		return
+	}
+
+	go func() {
+		ctx := context.Background()
+		store.index(ctx)
+		for {
+			store.evict(ctx)
+			time.Sleep(evictionInterval)
+		}
+	}()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// GlossaryTerm is a technical term from an article with a one-line
+// definition, shown as a tooltip instead of a full article link.
+type GlossaryTerm struct {
+	Term       string `json:"term"`
+	Definition string `json:"definition"`
+}
+
+const glossaryPrompt = `Here is a wiki article about "%s":
+
+%s
+
+List up to 10 technical terms from the article that a general reader might not know, each with a one-line definition. Every term must appear word for word in the article. Respond with JSON in the form {"terms": [{"term": "...", "definition": "..."}]}.`
+
+// maxGlossaryTerms caps how many terms are sent to the page.
+const maxGlossaryTerms = 10
+
+// generateGlossary asks the model for definitions of the technical terms in
+// a finished article. Terms that don't appear in the article are dropped.
+func generateGlossary(ctx context.Context, articleName, model, content string) ([]GlossaryTerm, error) {
+	var result struct {
+		Terms []GlossaryTerm `json:"terms"`
+	}
+	prompt := fmt.Sprintf(glossaryPrompt, articleName, content)
+	if err := generateJSON(ctx, model, prompt, &result); err != nil {
+		return nil, err
+	}
+
+	var terms []GlossaryTerm
+	for _, term := range result.Terms {
+		term.Term = strings.TrimSpace(term.Term)
+		term.Definition = strings.TrimSpace(term.Definition)
+		if term.Term == "" || term.Definition == "" || !strings.Contains(content, term.Term) {
+			continue
+		}
+		terms = append(terms, term)
+		if len(terms) == maxGlossaryTerms {
+			break
+		}
+	}
+	return terms, nil
+}
+
+// glossaryExtra generates the glossary for a finished article. Failures are
+// logged and otherwise ignored since the article itself is already complete.
+func glossaryExtra(articleName, model, content string) extraTask {
+	return func(ctx context.Context) interface{} {
+		terms, err := generateGlossary(ctx, articleName, model, content)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error generating glossary for '%s': %v", articleName, err)
+			}
+			return nil
+		}
+		if len(terms) == 0 {
+			return nil
+		}
+		return terms
+	}
+}
+
+// InfoboxField is a labelled fact shown in the box at the top of an article.
+type InfoboxField struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+const infoboxPrompt = `Give the key facts about "%s" (a %s) for an encyclopedia infobox.
+
+Respond with JSON in the form {%s}. Use an empty string for anything you don't know or that doesn't apply.`
+
+const wordInfoboxHint = ` The pronunciation must use IPA between slashes. The etymology should be one or two sentences.`
+
+// isWordOrName reports whether a title is a single word or looks like a
+// proper name, the topics where readers expect pronunciation and etymology.
+func isWordOrName(articleName string) bool {
+	words := strings.Fields(articleName)
+	if len(words) == 1 {
+		return true
+	}
+	if len(words) > 3 {
+		return false
+	}
+	for _, word := range words {
+		if word[0] < 'A' || word[0] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// generateInfobox runs the structured pass for an article using the schema
+// of its topic type and returns the fields to show in its infobox.
+func generateInfobox(ctx context.Context, articleName, model string, topic TopicType) ([]InfoboxField, error) {
+	keys := topic.Infobox
+	hint := ""
+	if isWordOrName(articleName) {
+		keys = append([]infoboxKey{{"pronunciation", "Pronunciation"}, {"etymology", "Etymology"}}, keys...)
+		hint = wordInfoboxHint
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	var schema []string
+	for _, key := range keys {
+		schema = append(schema, fmt.Sprintf(`"%s": "..."`, key.Key))
+	}
+
+	var result map[string]interface{}
+	prompt := fmt.Sprintf(infoboxPrompt, articleName, topic.Name, strings.Join(schema, ", ")) + hint
+	if err := generateJSON(ctx, model, prompt, &result); err != nil {
+		return nil, err
+	}
+
+	var fields []InfoboxField
+	for _, key := range keys {
+		// Models sometimes answer with numbers, so format whatever came back
+		value, ok := result[key.Key]
+		if !ok || value == nil {
+			continue
+		}
+		if text := strings.TrimSpace(fmt.Sprint(value)); text != "" {
+			fields = append(fields, InfoboxField{Label: key.Label, Value: text})
+		}
+	}
+	return fields, nil
+}
+
+// infoboxExtra generates the infobox for an article.
+func infoboxExtra(articleName, model string, topic TopicType) extraTask {
+	return func(ctx context.Context) interface{} {
+		fields, err := generateInfobox(ctx, articleName, model, topic)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error generating infobox for '%s': %v", articleName, err)
+			}
+			return nil
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+		return fields
+	}
+}
+
+// sendJSONEvent writes an SSE event with a JSON payload and flushes it.
+func sendJSONEvent(w http.ResponseWriter, event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event, err)
+		return
+	}
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With FEATURED_INTERVAL set, the instance invents a new topic on a schedule,
+// generates it and features it on the homepage. The finished article goes out
+// on the activity feed like any other, so it is announced too.
+
+// maxFeaturedHistory is how many past featured topics the brainstorm is told
+// to avoid.
+const maxFeaturedHistory = 24
+
+const brainstormPrompt = `You pick the featured article for an endless encyclopedia where any topic can have an article. Invent one new topic that would make a delightful, surprising article: specific, evocative and a little unexpected, like "The Lighthouse Keepers' Strike of 1911" or "Competitive Cloud Naming".
+
+%s
+Respond with JSON in the form {"topic": "..."}.`
+
+// FeaturedArticle is the article currently featured on the homepage.
+type FeaturedArticle struct {
+	Title    string
+	Summary  string
+	ReplayID string
+	Time     time.Time
+}
+
+var (
+	featured        *FeaturedArticle
+	featuredHistory []string
+	featuredMu      sync.Mutex
+)
+
+func currentFeatured() *FeaturedArticle {
+	featuredMu.Lock()
+	defer featuredMu.Unlock()
+
+	return featured
+}
+
+// startFeatured features a new article every FeaturedInterval, starting now.
+func startFeatured() {
+	if settings.FeaturedInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			featureArticle()
+			time.Sleep(settings.FeaturedInterval)
+		}
+	}()
+}
+
+// featureArticle invents a topic, generates it and features it.
+func featureArticle() {
+	ctx, cancel := context.WithTimeout(context.Background(), settings.FeaturedInterval)
+	defer cancel()
+
+	topic, err := brainstormTopic(ctx)
+	if err != nil {
+		alertAdmin("Error inventing a featured topic: %v", err)
+		return
+	}
+
+	r, _ := http.NewRequestWithContext(ctx, "GET", "/wiki/"+url.PathEscape(topic), nil)
+	content, replayID, err := generateWhole(ctx, r, "featured", topic)
+	if err != nil {
+		alertAdmin("Error generating featured article '%s': %v", topic, err)
+		return
+	}
+	log.Printf("Featuring '%s'", topic)
+
+	featuredMu.Lock()
+	defer featuredMu.Unlock()
+
+	featured = &FeaturedArticle{
+		Title:    topic,
+		Summary:  articleSummary(content),
+		ReplayID: replayID,
+		Time:     time.Now(),
+	}
+	featuredHistory = append(featuredHistory, topic)
+	if len(featuredHistory) > maxFeaturedHistory {
+		featuredHistory = featuredHistory[len(featuredHistory)-maxFeaturedHistory:]
+	}
+}
+
+// brainstormTopic asks the model for a fresh topic, nudged by what readers
+// have been generating lately and steered away from past featured topics.
+func brainstormTopic(ctx context.Context) (string, error) {
+	var recent []string
+	for _, event := range activity.recentCompletions() {
+		recent = append(recent, event.Title)
+	}
+
+	featuredMu.Lock()
+	past := append([]string(nil), featuredHistory...)
+	featuredMu.Unlock()
+
+	var hints strings.Builder
+	if len(recent) > 0 {
+		fmt.Fprintf(&hints, "Readers have lately been reading about: %s. Draw on their themes for inspiration, but pick a different topic.\n", strings.Join(recent, "; "))
+	}
+	if len(past) > 0 {
+		fmt.Fprintf(&hints, "These were featured before and must not be repeated: %s.\n", strings.Join(past, "; "))
+	}
+
+	var result struct {
+		Topic string `json:"topic"`
+	}
+	if err := generateJSON(ctx, settings.Model, fmt.Sprintf(brainstormPrompt, hints.String()), &result); err != nil {
+		return "", err
+	}
+	return normalizeTitle(result.Topic)
+}
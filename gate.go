@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The generation gate makes a browser prove it isn't a bot before its
+// session may generate articles, protecting public instances from scripts
+// exhausting the GPU. Depending on GENERATION_GATE the page solves a small
+// proof-of-work puzzle or a Turnstile or hCaptcha challenge, and its session
+// gets a pass for a day in return.
+
+// passLifetime is how long a solved challenge lets a browser generate.
+const passLifetime = 24 * time.Hour
+
+// challengeLifetime is how long a proof-of-work puzzle may take to solve.
+const challengeLifetime = 10 * time.Minute
+
+// Challenge tells the page what it has to solve.
+type Challenge struct {
+	Type       string `json:"type"`
+	SiteKey    string `json:"site_key,omitempty"`
+	Puzzle     string `json:"puzzle,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+}
+
+var gateSecret = loadGateSecret()
+
+// loadGateSecret returns the key puzzles are signed with. Without
+// GATE_SECRET puzzles are only good until the next restart.
+func loadGateSecret() []byte {
+	if secret := os.Getenv("GATE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Error creating gate secret: %v", err)
+	}
+	return secret
+}
+
+func sign(key []byte, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedUntil returns a token valid until expiry, signed with key for
+// purpose.
+func signedUntil(key []byte, purpose string, expiry time.Time) string {
+	unix := strconv.FormatInt(expiry.Unix(), 10)
+	return unix + "." + sign(key, purpose+":"+unix)
+}
+
+// validUntil checks a token made by signedUntil.
+func validUntil(key []byte, purpose, token string) bool {
+	unix, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(key, purpose+":"+unix))) {
+		return false
+	}
+	expiry, err := strconv.ParseInt(unix, 10, 64)
+	return err == nil && time.Now().Unix() < expiry
+}
+
+// gateRequired reports whether a request must solve a challenge before it
+// may generate.
+func gateRequired(r *http.Request) bool {
+	if settings.Gate == "" {
+		return false
+	}
+	if settings.GateThrottledOnly && throttleLevel(clientIP(r)) == 0 {
+		return false
+	}
+	return time.Now().Unix() >= sessionFrom(r).PassUntil
+}
+
+// newChallenge makes the challenge for the configured gate.
+func newChallenge() Challenge {
+	switch settings.Gate {
+	case "turnstile":
+		return Challenge{Type: "turnstile", SiteKey: os.Getenv("TURNSTILE_SITE_KEY")}
+	case "hcaptcha":
+		return Challenge{Type: "hcaptcha", SiteKey: os.Getenv("HCAPTCHA_SITE_KEY")}
+	default:
+		return Challenge{
+			Type:       "pow",
+			Puzzle:     signedUntil(gateSecret, "puzzle", time.Now().Add(challengeLifetime)),
+			Difficulty: settings.GateDifficulty,
+		}
+	}
+}
+
+// refuseUngated answers a non-browser request that hasn't passed the gate.
+func refuseUngated(w http.ResponseWriter) {
+	http.Error(w, "Open the wiki in a browser first, this instance asks new visitors to prove they aren't a bot", http.StatusForbidden)
+}
+
+// gateHandler checks a solved challenge and hands out a pass.
+func gateHandler(w http.ResponseWriter, r *http.Request) {
+	var solution struct {
+		Puzzle string `json:"puzzle"`
+		Nonce  string `json:"nonce"`
+		Token  string `json:"token"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&solution); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch settings.Gate {
+	case "":
+		http.NotFound(w, r)
+		return
+	case "turnstile":
+		err = verifyCaptcha(r, "https://challenges.cloudflare.com/turnstile/v0/siteverify", os.Getenv("TURNSTILE_SECRET_KEY"), solution.Token)
+	case "hcaptcha":
+		err = verifyCaptcha(r, "https://api.hcaptcha.com/siteverify", os.Getenv("HCAPTCHA_SECRET_KEY"), solution.Token)
+	default:
+		err = verifyProofOfWork(solution.Puzzle, solution.Nonce, settings.GateDifficulty)
+	}
+	if err != nil {
+		audit(AuditEvent{Event: "gate_failed", Client: clientIP(r), Wiki: wikiFrom(r.Context()).Name, Detail: err.Error()})
+		http.Error(w, "Challenge failed", http.StatusForbidden)
+		return
+	}
+
+	session := sessionFrom(r)
+	session.PassUntil = time.Now().Add(passLifetime).Unix()
+	saveSession(w, r, session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyProofOfWork checks that sha256(puzzle:nonce) starts with enough zero
+// bits.
+func verifyProofOfWork(puzzle, nonce string, difficulty int) error {
+	if !validUntil(gateSecret, "puzzle", puzzle) {
+		return fmt.Errorf("puzzle expired or forged")
+	}
+
+	sum := sha256.Sum256([]byte(puzzle + ":" + nonce))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	if zeros < difficulty {
+		return fmt.Errorf("proof of work has %d leading zero bits, %d needed", zeros, difficulty)
+	}
+	return nil
+}
+
+// verifyCaptcha checks a Turnstile or hCaptcha token with its provider.
+func verifyCaptcha(r *http.Request, endpoint, secret, token string) error {
+	if token == "" {
+		return fmt.Errorf("no captcha token")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm(endpoint, url.Values{
+		"secret":   {secret},
+		"response": {token},
+		"remoteip": {clientIP(r)},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("captcha rejected: %s", strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
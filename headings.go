@@ -0,0 +1,200 @@
+package main
+
+import "strings"
+
+// Models often open an article by repeating its title, as "# Cats" or just
+// "Cats", under the page's own heading, and pick whatever heading levels they
+// like. As an article streams in, a line at the top that only echoes the
+// title is dropped, and the headings are shifted so the model's first heading
+// becomes an H2 and the rest keep their place relative to it, never skipping
+// a level on the way down. Code blocks and collapsed reasoning are left alone.
+
+// maxEchoPadding is how much longer than the title the first line may be and
+// still be held back in case it turns out to be an echo of it, to leave room
+// for markup like "# **" and a kind label like "Portal: ".
+const maxEchoPadding = 24
+
+// headingFilter tidies the headings of a stream of chunks. Only whole lines
+// can be judged, so a line that might be a heading, a fence or the echoed
+// title is held back until it ends. Other lines are passed on as they come.
+type headingFilter struct {
+	echoes []string
+
+	// echo is whether the title could still be echoed
+	echo bool
+	// underline is whether an echoed title was just dropped, so blank lines
+	// and an underline after it go too
+	underline bool
+	// started is whether anything was passed on yet
+	started bool
+	// midLine is whether the start of the current line was already passed on
+	midLine bool
+	inCode  bool
+	inHTML  bool
+	// shift is how far headings move, set by the first one
+	shift    int
+	shiftSet bool
+	// last is the level of the last heading passed on
+	last    int
+	pending string
+}
+
+func newHeadingFilter(job *articleJob) *headingFilter {
+	echoes := []string{job.Title}
+	if job.Kind.Label != "" {
+		echoes = append(echoes, job.Kind.Label+": "+job.Title)
+	}
+	return &headingFilter{echoes: echoes, echo: true}
+}
+
+// write filters a chunk and returns what should be passed on.
+func (f *headingFilter) write(chunk string) string {
+	text := f.pending + chunk
+	f.pending = ""
+
+	var out strings.Builder
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			if f.midLine || !f.holds(text) {
+				out.WriteString(text)
+				f.midLine, f.started = true, true
+				f.echo, f.underline = false, false
+			} else {
+				f.pending = text
+			}
+			break
+		}
+
+		line := text[:i]
+		text = text[i+1:]
+		if f.midLine {
+			out.WriteString(line + "\n")
+			f.midLine = false
+			continue
+		}
+		if line, keep := f.line(line); keep {
+			out.WriteString(line + "\n")
+		}
+	}
+	return out.String()
+}
+
+// flush returns whatever is still held back once the stream has ended.
+func (f *headingFilter) flush() string {
+	text := f.pending
+	f.pending = ""
+	if text == "" || f.midLine {
+		return text
+	}
+	line, keep := f.line(text)
+	if !keep {
+		return ""
+	}
+	return line
+}
+
+// holds reports whether the unfinished start of a line has to wait for the
+// rest of it.
+func (f *headingFilter) holds(line string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if trimmed == "" {
+		return true
+	}
+	if f.echo && len(line) <= len(f.echoes[len(f.echoes)-1])+maxEchoPadding {
+		return true
+	}
+	switch trimmed[0] {
+	case '#', '`', '~', '<':
+		return true
+	}
+	return false
+}
+
+// line tidies a whole line, reporting false if it should be dropped.
+func (f *headingFilter) line(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+
+	if f.underline {
+		if strings.Trim(trimmed, "=-") == "" {
+			return "", false
+		}
+		f.underline = false
+	}
+	f.started = f.started || trimmed != ""
+
+	switch {
+	case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+		f.inCode = !f.inCode
+		f.echo = false
+		return line, true
+	case f.inCode:
+		return line, true
+	case strings.HasPrefix(trimmed, "<details"):
+		f.inHTML = !strings.Contains(trimmed, "</details>")
+		return line, true
+	case f.inHTML:
+		f.inHTML = !strings.Contains(trimmed, "</details>")
+		return line, true
+	}
+
+	if f.echo {
+		if trimmed == "" {
+			// Leading blank lines go, but not ones ending an HTML block
+			return line, f.started
+		}
+		f.echo = false
+		if f.echoesTitle(trimmed) {
+			f.underline = true
+			return "", false
+		}
+	}
+
+	level := headingLevel(trimmed)
+	if level == 0 {
+		return line, true
+	}
+	if !f.shiftSet {
+		f.shift = 2 - level
+		f.shiftSet = true
+	}
+	level += f.shift
+	if level < 2 {
+		level = 2
+	}
+	if f.last > 0 && level > f.last+1 {
+		level = f.last + 1
+	}
+	if level > 6 {
+		level = 6
+	}
+	f.last = level
+	return strings.Repeat("#", level) + strings.TrimLeft(trimmed, "#"), true
+}
+
+// echoesTitle reports whether a line says nothing but the title.
+func (f *headingFilter) echoesTitle(line string) bool {
+	line = strings.Trim(line, "# ")
+	line = strings.TrimSuffix(strings.Trim(line, "*_`\"' "), ":")
+	for _, echo := range f.echoes {
+		if strings.EqualFold(line, echo) {
+			return true
+		}
+	}
+	return false
+}
+
+// headingLevel returns the level of an ATX heading, or 0 if line isn't one.
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0
+	}
+	if level < len(line) && line[level] != ' ' && line[level] != '\t' {
+		return 0
+	}
+	return level
+}
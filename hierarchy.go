@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Titles like "Roman Empire/Military" are sub-articles of their parent. They
+// are generated with the parent's summary as context and show a breadcrumb
+// back up the hierarchy.
+
+// Breadcrumb is one ancestor of a sub-article.
+type Breadcrumb struct {
+	Name string
+	Path string
+}
+
+// parentTitle returns the title of the article a sub-article belongs to.
+func parentTitle(articleName string) (string, bool) {
+	i := strings.LastIndex(articleName, "/")
+	if i == -1 {
+		return "", false
+	}
+	return articleName[:i], true
+}
+
+// breadcrumbs lists the ancestors of a sub-article, outermost first.
+func breadcrumbs(articleName string) []Breadcrumb {
+	segments := strings.Split(articleName, "/")
+
+	var crumbs []Breadcrumb
+	for i := 1; i < len(segments); i++ {
+		title := strings.Join(segments[:i], "/")
+		crumbs = append(crumbs, Breadcrumb{
+			Name: segments[i-1],
+			Path: slugPath(title),
+		})
+	}
+	return crumbs
+}
+
+const parentSummaryPrompt = `Summarize the key facts of a wiki article about "%s" in one paragraph of at most 100 words.
+
+Respond with JSON in the form {"summary": "..."}.`
+
+const subArticlePrompt = `
+
+This is a sub-article of the wiki article "%s" and covers "%s" in depth. Stay consistent with the parent article, which is summarized here:
+
+%s`
+
+// subArticleContext returns the prompt addition for a sub-article, or an
+// empty string for top level articles.
+func subArticleContext(ctx context.Context, articleName, model string) string {
+	parent, ok := parentTitle(articleName)
+	if !ok {
+		return ""
+	}
+
+	var result struct {
+		Summary string `json:"summary"`
+	}
+	if err := generateJSON(ctx, model, fmt.Sprintf(parentSummaryPrompt, parent), &result); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error summarizing parent '%s' of '%s': %v", parent, articleName, err)
+		}
+		return fmt.Sprintf(subArticlePrompt, parent, articleName[len(parent)+1:], "(no summary available)")
+	}
+
+	return fmt.Sprintf(subArticlePrompt, parent, articleName[len(parent)+1:], strings.TrimSpace(result.Summary))
+}
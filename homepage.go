@@ -0,0 +1,50 @@
+package main
+
+// A wiki's home page can be made its own. Its intro replaces the standard
+// welcome text, and its sections replace the example links: each has a
+// title, some text and a list of articles, which can be of a kind, like
+// portals to feature a wiki's main subject areas.
+
+const defaultIntro = "An infinite wiki powered by AI. Search for any topic and get a generated article with links to explore further."
+
+// HomeSection is a section of a wiki's home page.
+type HomeSection struct {
+	Title string   `json:"title,omitempty"`
+	Text  string   `json:"text,omitempty"`
+	Links []string `json:"links,omitempty"`
+	// Kind is the kind of article the links go to, like "portal"
+	Kind string `json:"kind,omitempty"`
+}
+
+// defaultHomeSections are shown on wikis that haven't set their own.
+var defaultHomeSections = []HomeSection{{
+	Title: "Try these examples:",
+	Links: []string{"Quantum Computing", "Ancient Rome", "Machine Learning", "Space Exploration", "Renaissance Art"},
+}}
+
+// homeIntro is the wiki's welcome text.
+func (s *Settings) homeIntro() string {
+	if s.HomeIntro == "" {
+		return defaultIntro
+	}
+	return s.HomeIntro
+}
+
+// homeSections are the sections of the wiki's home page.
+func (s *Settings) homeSections() []HomeSection {
+	if len(s.HomeSections) == 0 {
+		return defaultHomeSections
+	}
+	return s.HomeSections
+}
+
+// unknownSectionKind returns the first kind of article the sections link to
+// that doesn't exist, if any.
+func unknownSectionKind(sections []HomeSection) string {
+	for _, section := range sections {
+		if _, ok := articleKinds[section.Kind]; section.Kind != "" && !ok {
+			return section.Kind
+		}
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Generation can be spread over several ollama servers, like one on each
+// machine with a GPU, by listing them all in OLLAMA_HOST separated by commas.
+// Each generation goes to the least busy host, the one with the fewest
+// generations running on it, taking turns between hosts that are as busy as
+// each other, or with OLLAMA_BALANCE=round-robin simply to the next host in
+// turn. Every OLLAMA_HEALTH_INTERVAL each host is asked for its models, and
+// one that doesn't answer is taken out of rotation until it does again, as
+// is one a generation can't reach in the meantime. Models are pulled and
+// preloaded on every host, and a host found missing a model pulls it. The
+// circuit breaker only opens once generations fail on every host.
+
+// hostCheckTimeout bounds asking a host for its models.
+const hostCheckTimeout = 5 * time.Second
+
+var errHostCheck = errors.New("it didn't answer a health check")
+
+// ollamaHost is how an ollama host is doing.
+type ollamaHost struct {
+	// busy is how many generations are running on it
+	busy int
+	down bool
+}
+
+var ollamaPool = struct {
+	mu    sync.Mutex
+	hosts map[string]*ollamaHost
+	// next is where the next turn between hosts starts
+	next int
+}{
+	hosts: map[string]*ollamaHost{},
+}
+
+// ollamaHosts lists the ollama hosts in OLLAMA_HOST.
+func ollamaHosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(os.Getenv("OLLAMA_HOST"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"http://localhost:11434"}
+	}
+	return hosts
+}
+
+// ollamaHostURL returns the first host in rotation, to ask something quick
+// of like a model's details, which needn't be balanced.
+func ollamaHostURL() string {
+	hosts := ollamaHosts()
+
+	ollamaPool.mu.Lock()
+	defer ollamaPool.mu.Unlock()
+	for _, host := range hosts {
+		if !poolHost(host).down {
+			return host
+		}
+	}
+	return hosts[0]
+}
+
+// poolHost returns how a host is doing. Callers hold ollamaPool.mu.
+func poolHost(host string) *ollamaHost {
+	h, ok := ollamaPool.hosts[host]
+	if !ok {
+		h = &ollamaHost{}
+		ollamaPool.hosts[host] = h
+	}
+	return h
+}
+
+// pickOllamaHost picks the host to generate with and counts the generation
+// towards how busy it is until release is called.
+func pickOllamaHost() (host string, release func()) {
+	hosts := ollamaHosts()
+	roundRobin := os.Getenv("OLLAMA_BALANCE") == "round-robin"
+
+	ollamaPool.mu.Lock()
+	defer ollamaPool.mu.Unlock()
+
+	start := ollamaPool.next % len(hosts)
+	ollamaPool.next = start + 1
+	// With every host down, generations still go to one in turn, to fail
+	// the usual way and let the breaker know
+	host = hosts[start]
+	var picked *ollamaHost
+	for i := range hosts {
+		candidate := hosts[(start+i)%len(hosts)]
+		h := poolHost(candidate)
+		if h.down {
+			continue
+		}
+		if picked == nil || (!roundRobin && h.busy < picked.busy) {
+			host, picked = candidate, h
+		}
+	}
+	if picked == nil {
+		picked = poolHost(host)
+	}
+
+	picked.busy++
+	var once sync.Once
+	return host, func() {
+		once.Do(func() {
+			ollamaPool.mu.Lock()
+			picked.busy--
+			ollamaPool.mu.Unlock()
+		})
+	}
+}
+
+// setHostDown takes a host out of rotation, or puts it back, when there are
+// others to take its place.
+func setHostDown(host string, down bool, reason error) {
+	if len(ollamaHosts()) < 2 {
+		return
+	}
+
+	ollamaPool.mu.Lock()
+	h := poolHost(host)
+	changed := h.down != down
+	h.down = down
+	ollamaPool.mu.Unlock()
+
+	switch {
+	case changed && down:
+		alertAdmin("Taking ollama at '%s' out of rotation: %v", host, reason)
+	case changed:
+		log.Printf("Ollama at '%s' is back, putting it into rotation", host)
+	}
+}
+
+// hostUnreachable takes a host a request couldn't reach out of rotation,
+// unless the request was given up on.
+func hostUnreachable(ctx context.Context, host string, err error) {
+	if ctx.Err() == nil {
+		setHostDown(host, true, err)
+	}
+}
+
+// ollamaUp asks an ollama host for its models.
+func ollamaUp(ctx context.Context, host string) bool {
+	req, err := http.NewRequestWithContext(ctx, "GET", host+"/api/tags", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// startHostChecks checks every OLLAMA_HEALTH_INTERVAL whether each host is
+// up, when there are several to choose from.
+func startHostChecks() {
+	hosts := ollamaHosts()
+	if len(hosts) < 2 || generationProvider().Name() != "ollama" {
+		return
+	}
+	log.Printf("Balancing generations over %d ollama hosts", len(hosts))
+
+	interval := envDuration("OLLAMA_HEALTH_INTERVAL", 10*time.Second)
+	go func() {
+		for {
+			for _, host := range hosts {
+				ctx, cancel := context.WithTimeout(context.Background(), hostCheckTimeout)
+				up := ollamaUp(ctx, host)
+				cancel()
+				setHostDown(host, !up, errHostCheck)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// HostStatus is how an ollama host is doing, for the admin panel.
+type HostStatus struct {
+	URL  string
+	Busy int
+	Down bool
+}
+
+// hostStatuses lists how each ollama host is doing, when there are several.
+func hostStatuses() []HostStatus {
+	hosts := ollamaHosts()
+	if len(hosts) < 2 || generationProvider().Name() != "ollama" {
+		return nil
+	}
+
+	ollamaPool.mu.Lock()
+	defer ollamaPool.mu.Unlock()
+
+	statuses := make([]HostStatus, 0, len(hosts))
+	for _, host := range hosts {
+		h := poolHost(host)
+		statuses = append(statuses, HostStatus{URL: host, Busy: h.busy, Down: h.down})
+	}
+	return statuses
+}
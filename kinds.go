@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"unicode"
+)
+
+// ArticleKind is a namespace of generated pages, like portals, that uses its
+// own prompt and layout instead of the encyclopedia article prompt.
+type ArticleKind struct {
+	Name   string
+	Label  string
+	Prompt string
+	// Dated kinds are told today's date, e.g. for news datelines
+	Dated bool
+}
+
+var articleKinds = map[string]ArticleKind{
+	"portal": {
+		Name:  "portal",
+		Label: "Portal",
+		Prompt: `You are a wiki portal generator. Write the portal page for the broad subject area "%s" in markdown format.
+
+Requirements:
+- Start with a short curated overview of the subject area (## Overview)
+- Add a section listing 10 to 15 key sub-topics (## Key topics), each as a link in the form [[Topic name]] followed by a one-line description
+- Add a featured excerpt (## Featured article) with two paragraphs from an article about one notable sub-topic, with its title as a [[Topic name]] link
+- Add a short section of related subject areas (## Related portals)
+- Provide only the markdown text of the portal, no followup questions
+
+Generate the portal now:`,
+	},
+	"dictionary": {
+		Name:  "dictionary",
+		Label: "Dictionary",
+		Prompt: `You are a dictionary entry generator in the style of Wiktionary. Write the dictionary entry for the word "%s" in markdown format.
+
+Requirements:
+- Start with the pronunciation in IPA
+- Group the definitions under a header for each part of speech (## Noun, ## Verb, etc.) as a numbered list
+- Follow each definition with an italic usage example
+- End each part of speech with a line of synonyms, each written as a [[word]] link
+- Finish with a short etymology section (## Etymology)
+- Provide only the markdown text of the entry, no followup questions
+
+Generate the entry now:`,
+	},
+	"how-to": {
+		Name:  "how-to",
+		Label: "How to",
+		Prompt: `You are a how-to guide generator. Write a step-by-step guide on how to "%s" in markdown format.
+
+Requirements:
+- Start with one paragraph explaining what the guide achieves and how long it takes
+- Add a section listing prerequisites, tools and materials (## Prerequisites) as a bulleted list
+- Add the procedure (## Steps) as a numbered list, one action per step, with details beneath each step where useful
+- Write every warning or safety note as a blockquote starting with **Warning:**
+- Finish with a short troubleshooting section (## Troubleshooting)
+- Provide only the markdown text of the guide, no followup questions
+
+Generate the guide now:`,
+	},
+	"news": {
+		Name:  "news",
+		Label: "News",
+		Dated: true,
+		Prompt: `You are a newspaper reporter in an alternate reality. Write a current events news story about "%s" in markdown format.
+
+Requirements:
+- Start with a headline as a level one header (# Headline)
+- Begin the first paragraph with a dateline in the form **CITY, Month Day, Year** —
+- Write in the inverted pyramid style of a wire service, most important facts first
+- Include quotes from invented officials, experts or witnesses
+- Keep paragraphs short like a newspaper
+- Provide only the markdown text of the story, no followup questions
+
+Write the story now:`,
+	},
+}
+
+// isDictionaryWord reports whether a title is a single lowercase word, for
+// which a dictionary entry is offered next to the article.
+func isDictionaryWord(articleName string) bool {
+	if articleName == "" {
+		return false
+	}
+	for _, r := range articleName {
+		if !unicode.IsLower(r) && r != '-' && r != '\'' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestedWord is the title of an article page as it was asked for, which
+// decides whether it's a dictionary word. The slug's title might have been
+// written another way, like "Run" by an article linking to it.
+func requestedWord(r *http.Request, title string) string {
+	slug, err := articleVar(r)
+	if err != nil || !isSlug(slug) {
+		return title
+	}
+	return unslug(slug)
+}
+
+// buildKindPrompt fills the prompt of a namespace with the title and the
+// reader's session lens, if any.
+func buildKindPrompt(kind ArticleKind, articleName, lens string) string {
+	prompt := fmt.Sprintf(kind.Prompt, articleName)
+	if kind.Dated {
+		prompt += "\n\nToday's date is " + time.Now().Format("January 2, 2006") + "."
+	}
+	return withLens(prompt, lens)
+}
+
+// kindHandler renders the streaming page for a namespace like /portal/{article}.
+func kindHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		articleName, ok := pageTitle(w, r, kind)
+		if !ok {
+			return
+		}
+
+		renderStreamingWikiPage(w, r, articleName, kind)
+	}
+}
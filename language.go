@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Models don't always write in the language they are asked to, so the
+// language of each finished article is detected from its text. Pages tag the
+// article with it so browsers hyphenate it and screen readers pick a voice
+// for the right language.
+
+// maxLanguageSample is how much of an article is looked at.
+const maxLanguageSample = 4000
+
+// scriptLanguages are languages recognised by their script alone.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+	{unicode.Cyrillic, "ru"},
+}
+
+// stopwords are the most common short words of languages written in the
+// Latin script, which tell them apart well even in short texts.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "in", "is", "that", "it", "was", "for", "with", "as", "by", "are"},
+	"es": {"el", "la", "de", "que", "y", "en", "los", "las", "del", "se", "por", "una", "con", "es"},
+	"fr": {"le", "la", "les", "de", "des", "et", "est", "un", "une", "du", "en", "que", "dans", "pour"},
+	"de": {"der", "die", "das", "und", "ist", "ein", "eine", "zu", "den", "von", "mit", "nicht", "auch", "im"},
+	"it": {"il", "la", "di", "che", "e", "un", "una", "per", "del", "della", "sono", "non", "gli", "nel"},
+	"pt": {"o", "a", "de", "que", "e", "do", "da", "em", "um", "uma", "os", "para", "com", "não"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "op", "te", "zijn", "voor", "met", "niet", "ook"},
+	"sv": {"och", "att", "det", "som", "en", "är", "av", "för", "på", "med", "den", "till", "inte", "var"},
+	"pl": {"i", "w", "nie", "na", "się", "z", "jest", "do", "że", "to", "jak", "od", "po", "przez"},
+	"tr": {"ve", "bir", "bu", "da", "de", "için", "ile", "olarak", "olan", "çok", "daha", "gibi", "ancak", "en"},
+}
+
+// detectLanguage returns the BCP 47 code of the language text is written
+// in, or an empty string if it can't tell.
+func detectLanguage(text string) string {
+	if len(text) > maxLanguageSample {
+		text = text[:maxLanguageSample]
+	}
+
+	// Other scripts say which language it is, near enough
+	letters := 0
+	scripts := map[string]int{}
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, script := range scriptLanguages {
+			if unicode.Is(script.table, r) {
+				scripts[script.lang]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kana with Han characters
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+		return "ja"
+	}
+	for _, script := range scriptLanguages {
+		if scripts[script.lang] > letters/2 {
+			return script.lang
+		}
+	}
+
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		counts[word]++
+	}
+	best, bestScore := "", 0
+	for lang, words := range stopwords {
+		score := 0
+		for _, word := range words {
+			score += counts[word]
+		}
+		if score > bestScore || score == bestScore && lang < best {
+			best, bestScore = lang, score
+		}
+	}
+	// Too few common words to be sure of anything
+	if bestScore < 3 {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Slow models can take a while to get into an article, and the reader stares
+// at an empty page meanwhile. With LEDE the opening paragraph is asked for on
+// its own first, which takes a model only a moment, and streamed straight
+// away. The rest of the article is then generated with the lede in its
+// prompt and appended after it.
+
+// ledeTokens caps the lede request, which only needs a paragraph.
+const ledeTokens = 200
+
+const ledePrompt = `%s
+
+Write only the opening paragraph of this article: two or three sentences that sum up the subject, with no heading. The rest of the article will follow it.`
+
+const afterLedePrompt = `%s
+
+The opening paragraph of the article has already been written:
+
+%s
+
+Write the rest of the article after it, starting with its first section. Do not repeat the opening paragraph.`
+
+// writeLede streams the opening paragraph of an article through onChunk and
+// returns the prompt for the rest of it, and how many tokens the lede took.
+func writeLede(ctx context.Context, job *articleJob, onChunk func(string)) (string, int, error) {
+	options := *job.Options
+	options.NumPredict = ledeTokens
+
+	var lede strings.Builder
+	_, tokens, err := streamGenerate(ctx, job.Model, job.System, fmt.Sprintf(ledePrompt, job.Prompt), &options, func(chunk string) {
+		lede.WriteString(chunk)
+		onChunk(chunk)
+	})
+	if err != nil {
+		return "", tokens, err
+	}
+	onChunk("\n\n")
+	return fmt.Sprintf(afterLedePrompt, job.Prompt, strings.TrimSpace(stripReasoning(lede.String()))), tokens, nil
+}
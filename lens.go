@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const maxLensLength = 200
+
+// lensFromRequest returns the reader's session lens, or an empty string if
+// none is set.
+func lensFromRequest(r *http.Request) string {
+	return sessionFrom(r).Lens
+}
+
+// lensHandler sets or clears the session lens.
+func lensHandler(w http.ResponseWriter, r *http.Request) {
+	lens := strings.TrimSpace(r.FormValue("lens"))
+	if len(lens) > maxLensLength {
+		http.Error(w, "Lens is too long", http.StatusBadRequest)
+		return
+	}
+
+	session := sessionFrom(r)
+	session.Lens = lens
+	saveSession(w, r, session)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// withLens appends the reader's lens to a prompt.
+func withLens(prompt, lens string) string {
+	if lens == "" {
+		return prompt
+	}
+	return prompt + "\n\nWrite the entire article through this lens: " + lens
+}
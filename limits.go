@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// streamLimiter caps how many generations run at once, overall and per
+// client. Requests over the limit wait in a first come, first served queue
+// and are told their position while they wait.
+type streamLimiter struct {
+	mu        sync.Mutex
+	active    int
+	perClient map[string]int
+	waiting   []*streamWaiter
+}
+
+type streamWaiter struct {
+	client   string
+	admitted chan struct{}
+	changed  chan struct{}
+}
+
+var streams = &streamLimiter{perClient: map[string]int{}}
+
+// acquire blocks until the client may start a generation. onPosition is
+// called with the client's place in line whenever it changes. The returned
+// function must be called when the generation is done.
+func (l *streamLimiter) acquire(ctx context.Context, client string, onPosition func(int)) (func(), error) {
+	waiter := &streamWaiter{
+		client:   client,
+		admitted: make(chan struct{}),
+		changed:  make(chan struct{}, 1),
+	}
+
+	l.mu.Lock()
+	l.waiting = append(l.waiting, waiter)
+	l.admit()
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		l.active--
+		l.perClient[client]--
+		if l.perClient[client] == 0 {
+			delete(l.perClient, client)
+		}
+		l.admit()
+		l.mu.Unlock()
+	}
+
+	lastPosition := 0
+	for {
+		select {
+		case <-waiter.admitted:
+			return release, nil
+		case <-waiter.changed:
+			if position := l.position(waiter); position > 0 && position != lastPosition {
+				lastPosition = position
+				onPosition(position)
+			}
+		case <-ctx.Done():
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			select {
+			case <-waiter.admitted:
+				// Admitted just as the client went away
+				l.active--
+				l.perClient[client]--
+				if l.perClient[client] == 0 {
+					delete(l.perClient, client)
+				}
+			default:
+				l.remove(waiter)
+			}
+			l.admit()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// admit starts every queued waiter that fits within the limits, in order,
+// and tells the rest their position may have changed. Callers hold l.mu.
+func (l *streamLimiter) admit() {
+	remaining := l.waiting[:0]
+	for _, waiter := range l.waiting {
+		globalFull := settings.MaxStreams > 0 && l.active >= settings.MaxStreams
+		clientFull := settings.MaxStreamsPerClient > 0 && l.perClient[waiter.client] >= settings.MaxStreamsPerClient
+		if globalFull || clientFull {
+			remaining = append(remaining, waiter)
+			continue
+		}
+
+		l.active++
+		l.perClient[waiter.client]++
+		close(waiter.admitted)
+	}
+	l.waiting = remaining
+
+	for _, waiter := range l.waiting {
+		select {
+		case waiter.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (l *streamLimiter) position(waiter *streamWaiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, w := range l.waiting {
+		if w == waiter {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// remove takes a waiter out of the queue. Callers hold l.mu.
+func (l *streamLimiter) remove(waiter *streamWaiter) {
+	for i, w := range l.waiting {
+		if w == waiter {
+			l.waiting = append(l.waiting[:i], l.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// stats returns how many generations are running and how many are waiting.
+func (l *streamLimiter) stats() (active, waiting int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.active, len(l.waiting)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// GET /api/links/{article} lists the articles a stored article links to, in
+// the order they first appear, and whether each of them is stored too, so
+// graph visualizers, crawlers and bots don't have to scrape the page for
+// them. Only stored articles have links to list: one that isn't stored
+// would have to be written afresh, with other links every time. It takes
+// ?kind= like the other APIs.
+
+// ArticleLinks is what the links API answers with.
+type ArticleLinks struct {
+	Title string        `json:"title"`
+	Slug  string        `json:"slug"`
+	Kind  string        `json:"kind,omitempty"`
+	Links []ArticleLink `json:"links"`
+}
+
+// ArticleLink is one of the articles an article links to.
+type ArticleLink struct {
+	Title  string `json:"title"`
+	Slug   string `json:"slug"`
+	URL    string `json:"url"`
+	Stored bool   `json:"stored"`
+}
+
+// articleLinkTitles returns the titles of the articles some markdown links
+// to, in the order they first appear.
+func articleLinkTitles(content string) []string {
+	var titles []string
+	seen := map[string]bool{}
+	for _, match := range topicLinkPattern.FindAllStringSubmatch(content, -1) {
+		title, err := normalizeTitle(match[1])
+		if err != nil || seen[title] {
+			continue
+		}
+		seen[title] = true
+		titles = append(titles, title)
+	}
+	return titles
+}
+
+func articleLinksHandler(w http.ResponseWriter, r *http.Request) {
+	requested, err := articleVar(r)
+	if err == nil {
+		requested, err = normalizeTitle(requested)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	kind := requestKind(r)
+	if articleHidden(ctx, requested, kind) {
+		http.Error(w, hiddenMessage, http.StatusGone)
+		return
+	}
+	if articles == nil {
+		http.Error(w, "This wiki doesn't store articles, so it has no links to list", http.StatusNotFound)
+		return
+	}
+
+	wiki := wikiFrom(ctx).Name
+	article, ok, err := articles.Get(ctx, wiki, kind, requested)
+	if err != nil {
+		log.Printf("Error reading stored article '%s' of wiki '%s': %v", requested, wiki, err)
+		http.Error(w, "Error reading the stored article", http.StatusBadGateway)
+		return
+	}
+	if !ok {
+		http.Error(w, "That article isn't stored", http.StatusNotFound)
+		return
+	}
+
+	answer := ArticleLinks{Title: article.Title, Slug: slugify(article.Title), Kind: kind, Links: []ArticleLink{}}
+	for _, title := range articleLinkTitles(article.Content) {
+		// Links always lead to plain articles
+		_, stored, err := articles.Get(ctx, wiki, "", title)
+		if err != nil {
+			log.Printf("Error reading stored article '%s' of wiki '%s': %v", title, wiki, err)
+		}
+		answer.Links = append(answer.Links, ArticleLink{
+			Title:  title,
+			Slug:   slugify(title),
+			URL:    publicLink(articlePath("", title)),
+			Stored: stored,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(answer); err != nil {
+		log.Printf("Error encoding article links: %v", err)
+	}
+}
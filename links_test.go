@@ -0,0 +1,14 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArticleLinkTitles(t *testing.T) {
+	content := "See [[Exam]], [[ Quiz ]] and [[Exam]] again, [[..]], [[]] and [[Roman Empire/Military]]."
+	want := []string{"Exam", "Quiz", "Roman Empire/Military"}
+	if got := articleLinkTitles(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("articleLinkTitles = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// GET /api/articles lists the metadata of a wiki's stored articles, without
+// their text, for dashboards and sync tools to enumerate the corpus. It
+// takes ?kind= for another kind of article, ?since= for those generated
+// since a time or date, ?category= for those of a topic type like person,
+// ?sort=title, newest or oldest, and ?limit= for how many to list at once.
+// Pages are cursors: an answer with more to come has a next_page, which is
+// passed back as ?page= for the articles after it, so articles stored or
+// deleted between pages don't shift what comes next.
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// listedArticle is a stored article as the listing API lists it.
+type listedArticle struct {
+	Title       string    `json:"title"`
+	Slug        string    `json:"slug"`
+	Kind        string    `json:"kind,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Description string    `json:"description"`
+	Model       string    `json:"model,omitempty"`
+	Generated   time.Time `json:"generated"`
+	// Size is how many bytes of text the article has
+	Size int `json:"size"`
+}
+
+// articleListing is an answer of the listing API.
+type articleListing struct {
+	Articles []listedArticle `json:"articles"`
+	NextPage string          `json:"next_page,omitempty"`
+}
+
+// listQuery is what a request to the listing API asks for.
+type listQuery struct {
+	kind     string
+	since    time.Time
+	category string
+	sort     string
+	limit    int
+	// after is the sort key of the last article of the previous page
+	after string
+}
+
+// parseListQuery reads a request to the listing API.
+func parseListQuery(r *http.Request) (listQuery, error) {
+	query := r.URL.Query()
+	list := listQuery{kind: requestKind(r), category: query.Get("category"), sort: query.Get("sort"), limit: defaultListLimit}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", since); err != nil {
+				return list, errors.New("since must be a time like 2024-01-31T12:00:00Z or a date like 2024-01-31")
+			}
+		}
+		list.since = t
+	}
+	if _, ok := topicTypes[list.category]; list.category != "" && !ok {
+		return list, fmt.Errorf("There is no category %q", list.category)
+	}
+	switch list.sort {
+	case "":
+		list.sort = "title"
+	case "title", "newest", "oldest":
+	default:
+		return list, errors.New("sort must be title, newest or oldest")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			return list, fmt.Errorf("limit must be a whole number from 1 to %d", maxListLimit)
+		}
+		list.limit = n
+	}
+	if page := query.Get("page"); page != "" {
+		after, err := base64.RawURLEncoding.DecodeString(page)
+		if err != nil {
+			return list, errors.New("That page doesn't exist")
+		}
+		list.after = string(after)
+	}
+	return list, nil
+}
+
+// sortKey is where an article falls in a listing, so listings can carry on
+// after it. Titles break ties between articles generated at once.
+func (list listQuery) sortKey(article StoredArticle) string {
+	switch list.sort {
+	case "newest":
+		// Later times sort first once every digit is flipped
+		stamp := []byte(article.Generated.UTC().Format("20060102150405.000000000"))
+		for i, c := range stamp {
+			if c >= '0' && c <= '9' {
+				stamp[i] = '9' - c + '0'
+			}
+		}
+		return string(stamp) + "\x00" + article.Title
+	case "oldest":
+		return article.Generated.UTC().Format("20060102150405.000000000") + "\x00" + article.Title
+	}
+	return article.Title
+}
+
+// matches reports whether an article is one the listing asks for.
+func (list listQuery) matches(article StoredArticle) bool {
+	if !list.since.IsZero() && article.Generated.Before(list.since) {
+		return false
+	}
+	return list.category == "" || article.Topic == list.category
+}
+
+func articleListHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if articles == nil {
+		http.Error(w, "This wiki doesn't store articles", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	wiki := wikiFrom(ctx).Name
+	titles, err := articles.List(ctx, wiki, list.kind)
+	if err != nil {
+		log.Printf("Error listing stored articles of wiki '%s': %v", wiki, err)
+		http.Error(w, "Error listing stored articles", http.StatusBadGateway)
+		return
+	}
+	sort.Strings(titles)
+
+	// Listing by title without filters only needs to read the articles of
+	// the page, anything else all of them
+	readAll := list.sort != "title" || !list.since.IsZero() || list.category != ""
+	var found []StoredArticle
+	for _, title := range titles {
+		if !readAll && title <= list.after {
+			continue
+		}
+		if !readAll && len(found) > list.limit {
+			break
+		}
+		if articleHidden(ctx, title, list.kind) {
+			continue
+		}
+		article, ok, err := articles.Get(ctx, wiki, list.kind, title)
+		if err != nil {
+			log.Printf("Error reading stored article '%s' of wiki '%s': %v", title, wiki, err)
+			http.Error(w, "Error reading stored articles", http.StatusBadGateway)
+			return
+		}
+		if ok && list.matches(article) && list.sortKey(article) > list.after {
+			found = append(found, article)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return list.sortKey(found[i]) < list.sortKey(found[j]) })
+
+	answer := articleListing{Articles: []listedArticle{}}
+	if len(found) > list.limit {
+		found = found[:list.limit]
+		answer.NextPage = base64.RawURLEncoding.EncodeToString([]byte(list.sortKey(found[len(found)-1])))
+	}
+	for _, article := range found {
+		answer.Articles = append(answer.Articles, listedArticle{
+			Title:       article.Title,
+			Slug:        slugify(article.Title),
+			Kind:        article.Kind,
+			Category:    article.Topic,
+			Description: metaDescription(article.Content),
+			Model:       article.Model,
+			Generated:   article.Generated,
+			Size:        len(article.Content),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(answer); err != nil {
+		log.Printf("Error encoding article listing: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestListSortKey(t *testing.T) {
+	start := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	stored := []StoredArticle{
+		{Title: "Bravo", Generated: start},
+		{Title: "Alpha", Generated: start.Add(time.Hour)},
+		{Title: "Charlie", Generated: start.Add(-time.Hour)},
+		{Title: "Delta", Generated: start},
+	}
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"title", []string{"Alpha", "Bravo", "Charlie", "Delta"}},
+		{"newest", []string{"Alpha", "Bravo", "Delta", "Charlie"}},
+		{"oldest", []string{"Charlie", "Bravo", "Delta", "Alpha"}},
+	}
+	for _, test := range tests {
+		list := listQuery{sort: test.sort}
+		sorted := append([]StoredArticle(nil), stored...)
+		sort.Slice(sorted, func(i, j int) bool { return list.sortKey(sorted[i]) < list.sortKey(sorted[j]) })
+		for i, article := range sorted {
+			if article.Title != test.want[i] {
+				t.Errorf("sort=%s: article %d is %q, want %q", test.sort, i, article.Title, test.want[i])
+			}
+		}
+	}
+}
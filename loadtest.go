@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The loadtest subcommand simulates readers against a running server and
+// reports how long they waited for the first words of an article and for the
+// whole of it, to catch performance regressions before a release. With -mock
+// it also serves a fake ollama that writes articles at a steady pace, so the
+// server can be measured without a GPU by pointing its OLLAMA_HOST there.
+
+const mockArticle = `## Overview
+
+**%s** is a subject of some interest. It is often compared with [[Something Else]] and studied alongside [[Another Topic]].
+
+## History
+
+Records of %s go back a long way. Early accounts describe it in passing, and later ones in more detail, until it became a field of its own.
+
+## See also
+
+- [[Something Else]]
+- [[Another Topic]]
+`
+
+// runLoadTest runs the load test with the subcommand's arguments.
+func runLoadTest(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	defaultServer := os.Getenv("ENDLESS_WIKI_URL")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+	server := fs.String("server", defaultServer, "address of the endless wiki server")
+	readers := fs.Int("readers", 10, "readers reading at once")
+	articles := fs.Int("articles", 5, "articles each reader reads")
+	topics := fs.Int("topics", 10, "distinct topics the readers pick from, kept low enough not to trip ABUSE_THRESHOLD")
+	mock := fs.String("mock", "", "also serve a mock ollama on this address, like :11435")
+	delay := fs.Duration("delay", 20*time.Millisecond, "time between words from the mock ollama")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: endless-wiki loadtest [-server URL] [-readers N] [-articles N] [-mock ADDR]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *readers < 1 || *articles < 1 || *topics < 1 {
+		return fmt.Errorf("-readers, -articles and -topics must be at least 1")
+	}
+
+	if *mock != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*mock, mockOllama(*delay)))
+		}()
+		log.Printf("Mock ollama listening on %s", *mock)
+	}
+
+	base := strings.TrimRight(*server, "/")
+	var (
+		mu           sync.Mutex
+		firstWords   []time.Duration
+		whole        []time.Duration
+		failures     int
+		firstFailure error
+	)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for reader := 0; reader < *readers; reader++ {
+		wg.Add(1)
+		go func(reader int) {
+			defer wg.Done()
+			for i := 0; i < *articles; i++ {
+				title := fmt.Sprintf("Load test %d", (reader**articles+i)%*topics)
+				first, total, err := loadTestRead(base, title)
+
+				mu.Lock()
+				if err != nil {
+					failures++
+					if firstFailure == nil {
+						firstFailure = err
+					}
+				} else {
+					firstWords = append(firstWords, first)
+					whole = append(whole, total)
+				}
+				mu.Unlock()
+			}
+		}(reader)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("%d readers read %d articles in %s, %d failed\n", *readers, len(whole), elapsed.Round(time.Millisecond), failures)
+	if firstFailure != nil {
+		fmt.Printf("first failure: %v\n", firstFailure)
+	}
+	if len(whole) == 0 {
+		return nil
+	}
+	fmt.Printf("%-12s %10s %10s %10s %10s\n", "", "p50", "p90", "p99", "max")
+	printPercentiles("first words", firstWords)
+	printPercentiles("whole", whole)
+	return nil
+}
+
+// loadTestRead reads one article from /raw, timing the first words and the
+// whole article.
+func loadTestRead(base, title string) (first, total time.Duration, err error) {
+	start := time.Now()
+	resp, err := http.Get(base + "/raw/" + url.PathEscape(title))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return 0, 0, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 && first == 0 {
+			first = time.Since(start)
+		}
+		if err == io.EOF {
+			return first, time.Since(start), nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
+
+func printPercentiles(label string, durations []time.Duration) {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	at := func(p float64) time.Duration {
+		return durations[int(p*float64(len(durations)-1))].Round(time.Millisecond)
+	}
+	fmt.Printf("%-12s %10s %10s %10s %10s\n", label, at(0.5), at(0.9), at(0.99), durations[len(durations)-1].Round(time.Millisecond))
+}
+
+// mockOllama fakes the parts of the ollama API the server uses. Articles are
+// streamed a word at a time, delay apart, and JSON mode answers with empty
+// values so every extra runs without finding anything.
+func mockOllama(delay time.Duration) http.Handler {
+	routes := http.NewServeMux()
+
+	routes.HandleFunc("/api/chat", func(w http.ResponseWriter, r *http.Request) {
+		var req OllamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		encoder := json.NewEncoder(w)
+		if len(req.Messages) == 0 {
+			// Only loading the model
+			encoder.Encode(OllamaResponse{Done: true, DoneReason: "load"})
+			return
+		}
+		if !req.Stream {
+			encoder.Encode(OllamaResponse{Message: OllamaMessage{Role: "assistant", Content: `{"type": "concept", "terms": [], "topic": ""}`}, Done: true, DoneReason: "stop"})
+			return
+		}
+
+		title := "This topic"
+		if quoted := strings.SplitN(req.Messages[len(req.Messages)-1].Content, `"`, 3); len(quoted) == 3 {
+			title = quoted[1]
+		}
+		flusher, _ := w.(http.Flusher)
+		for _, word := range strings.SplitAfter(fmt.Sprintf(mockArticle, title, title), " ") {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+			encoder.Encode(OllamaResponse{Message: OllamaMessage{Role: "assistant", Content: word}})
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		encoder.Encode(OllamaResponse{Done: true, DoneReason: "stop"})
+	})
+
+	routes.HandleFunc("/api/show", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"details":    map[string]string{"parameter_size": "7B"},
+			"model_info": map[string]interface{}{"llama.context_length": 8192},
+		})
+	})
+
+	routes.HandleFunc("/api/pull", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	})
+
+	routes.HandleFunc("/api/embed", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		embeddings := make([][]float64, len(req.Input))
+		for i, input := range req.Input {
+			embeddings[i] = []float64{float64(len(input)%7) + 1, 1, 1}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"embeddings": embeddings})
+	})
+
+	return routes
+}
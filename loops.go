@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The server keeps the trail of articles a reader has read in their session.
+// When it starts going round the same few articles, the page asks
+// /api/escape for a way out: a topic of a type the loop never touched.
+
+// maxTrail is how many articles of the trail are remembered.
+const maxTrail = 8
+
+// maxLoop is the longest run of articles counted as a loop.
+const maxLoop = 4
+
+const escapePrompt = `A reader of an endless encyclopedia keeps going round in circles between these articles: %s.
+
+Suggest one article that would break them out of the loop. It must be about a %s and unrelated to the articles above, and it should be specific and intriguing.
+
+Respond with JSON in the form {"topic": "..."}.`
+
+// EscapeSuggestion is a topic to break out of a loop with.
+type EscapeSuggestion struct {
+	Topic string `json:"topic"`
+	Type  string `json:"type"`
+}
+
+// findLoop returns the loop at the end of a trail, if any. A loop is the same
+// short run of articles read twice in a row.
+func findLoop(trail []TrailEntry) []TrailEntry {
+	for size := 2; size <= maxLoop && len(trail) >= size*2; size++ {
+		recent := trail[len(trail)-size*2:]
+		looped := true
+		for i := 0; i < size; i++ {
+			if recent[i].Title != recent[i+size].Title {
+				looped = false
+				break
+			}
+		}
+		if looped {
+			return trail[len(trail)-size:]
+		}
+	}
+	return nil
+}
+
+// trailHandler adds a finished article to the reader's trail and reports
+// whether they are going round in circles.
+func trailHandler(w http.ResponseWriter, r *http.Request) {
+	var entry TrailEntry
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&entry); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	title, err := normalizeTitle(entry.Title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry.Title = title
+	if _, ok := topicTypes[entry.Type]; !ok {
+		entry.Type = ""
+	}
+	if _, ok := articleKinds[entry.Kind]; !ok {
+		entry.Kind = ""
+	}
+
+	session := sessionFrom(r)
+	if n := len(session.Trail); n == 0 || session.Trail[n-1].Title != entry.Title || session.Trail[n-1].Kind != entry.Kind {
+		session.Trail = append(session.Trail, entry)
+	}
+	if len(session.Trail) > maxTrail {
+		session.Trail = session.Trail[len(session.Trail)-maxTrail:]
+	}
+	saveSession(w, r, session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"loop": findLoop(session.Trail) != nil})
+}
+
+// backPath is where the Back button of an article goes: the article read
+// before it on the reader's trail, or the home page at the start of the
+// trail. It looks from where the article was last read, so going back again
+// and again walks down the trail instead of between the last two articles.
+func backPath(r *http.Request, title, kind string) string {
+	trail := sessionFrom(r).Trail
+	end := len(trail)
+	for i := len(trail) - 1; i >= 0; i-- {
+		if trail[i].Title == title && trail[i].Kind == kind {
+			end = i
+			break
+		}
+	}
+	if end == 0 {
+		return "/"
+	}
+	previous := trail[end-1]
+	rememberRequestedTitle(r.Context(), previous.Title)
+	return articlePath(previous.Kind, previous.Title)
+}
+
+func escapeHandler(w http.ResponseWriter, r *http.Request) {
+	loop := findLoop(sessionFrom(r).Trail)
+	if loop == nil {
+		http.Error(w, "You aren't going round in circles", http.StatusBadRequest)
+		return
+	}
+
+	// Head for a type of topic the loop hasn't been near
+	var titles []string
+	seen := map[string]bool{}
+	for _, entry := range loop {
+		titles = append(titles, entry.Title)
+		seen[entry.Type] = true
+	}
+	var unexplored []string
+	for name := range topicTypes {
+		if !seen[name] {
+			unexplored = append(unexplored, name)
+		}
+	}
+	if len(unexplored) == 0 {
+		for name := range topicTypes {
+			unexplored = append(unexplored, name)
+		}
+	}
+	sort.Strings(unexplored)
+	topicType := unexplored[rand.Intn(len(unexplored))]
+
+	if gateRequired(r) {
+		refuseUngated(w)
+		return
+	}
+
+	ctx := r.Context()
+	release, err := streams.acquire(ctx, clientIP(r), func(int) {})
+	if err != nil {
+		return
+	}
+	defer release()
+
+	var result struct {
+		Topic string `json:"topic"`
+	}
+	prompt := fmt.Sprintf(escapePrompt, strings.Join(titles, ", "), topicType)
+	if err := generateJSON(ctx, settingsFor(ctx).Model, prompt, &result); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error suggesting a way out of a loop: %v", err)
+			http.Error(w, "Failed to suggest a topic", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	topic, err := normalizeTitle(result.Topic)
+	if err != nil {
+		http.Error(w, "Failed to suggest a topic", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(EscapeSuggestion{Topic: topic, Type: topicType}); err != nil {
+		log.Printf("Error encoding escape suggestion: %v", err)
+	}
+}
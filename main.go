@@ -1,39 +1,142 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
+	"hash/fnv"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 )
 
-type OllamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
-}
-
-type OllamaResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
-}
-
 func main() {
-	// Ensure the preferred model is downloaded on startup
-	ensureModelDownloaded()
+	if len(os.Args) > 1 && os.Args[1] == "tui" {
+		if err := runTUI(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		if err := runLoadTest(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	settings = loadSettings()
+	loadNetworkRules()
+	loadBanner()
+	loadArticleStore()
+	loadAssets()
+	loadTemplates()
+	loadEmailTemplates()
+	loadWikis()
+	loadWatchlists()
+	go loadSlugTitles()
+
+	// Ensure the preferred models are downloaded on startup, readers
+	// finding one still missing wait for it to be pulled
+	go ensureModelsDownloaded()
+	startHostChecks()
+	startPreload()
+	go registerDiscordCommands()
+	startAnnouncer()
+	startFeatured()
+	startEviction()
+	startDigests()
+	startAdminAlerts()
+	startErrorBudget()
 
 	r := mux.NewRouter()
+	// Match on the encoded path so titles can contain slashes. Article pages
+	// take the slashes of a sub-article's slug as they are
+	r.UseEncodedPath()
 
 	r.HandleFunc("/", homeHandler).Methods("GET")
-	r.HandleFunc("/wiki/{article}", wikiHandler).Methods("GET")
+	r.HandleFunc("/wiki/{article:.+}", wikiHandler).Methods("GET")
 	r.HandleFunc("/stream/{article}", streamHandler).Methods("GET")
+	r.HandleFunc("/raw/{article}", rawHandler).Methods("GET")
+	r.HandleFunc("/compare/{article}", compareHandler).Methods("GET")
+	r.HandleFunc("/portal/{article:.+}", kindHandler("portal")).Methods("GET")
+	r.HandleFunc("/dictionary/{article:.+}", kindHandler("dictionary")).Methods("GET")
+	r.HandleFunc("/how-to/{article:.+}", kindHandler("how-to")).Methods("GET")
+	r.HandleFunc("/news/{article:.+}", kindHandler("news")).Methods("GET")
+	r.HandleFunc("/lens", lensHandler).Methods("POST")
+	r.HandleFunc("/profile", profileHandler).Methods("GET")
+	r.HandleFunc("/random", randomHandler).Methods("GET")
+	r.HandleFunc("/nav", navSaveHandler).Methods("POST")
+	r.HandleFunc("/activity", activityHandler).Methods("GET")
+	r.HandleFunc("/search", searchHandler).Methods("GET")
+	r.HandleFunc("/recent", recentChangesHandler).Methods("GET")
+	r.HandleFunc("/special/crossword", crosswordHandler).Methods("GET")
+	r.HandleFunc("/diff/{id}", diffHandler).Methods("GET")
+	r.HandleFunc("/api/recent", recentChangesAPIHandler).Methods("GET")
+	r.HandleFunc("/watchlist", watchlistHandler).Methods("GET")
+	r.HandleFunc("/watchlist", watchlistSaveHandler).Methods("POST")
+	r.HandleFunc("/watchlist/confirm", confirmEmailHandler).Methods("GET")
+	r.HandleFunc("/watchlist/unsubscribe", unsubscribeHandler).Methods("GET")
+	r.HandleFunc("/api/watch", watchHandler).Methods("POST")
+	r.HandleFunc("/room", createRoomHandler).Methods("POST")
+	r.HandleFunc("/room/{room}", joinRoomHandler).Methods("GET")
+	r.HandleFunc("/room/{room}/navigate", navigateRoomHandler).Methods("POST")
+	r.HandleFunc("/room/{room}/events", roomEventsHandler).Methods("GET")
+	r.HandleFunc("/replay/{replay}", replayHandler).Methods("GET")
+	r.HandleFunc("/api/replay/{replay}", replayAPIHandler).Methods("GET")
+	r.HandleFunc("/api/article/{article}", articleMetaHandler).Methods("GET")
+	r.HandleFunc("/api/links/{article}", articleLinksHandler).Methods("GET")
+	r.HandleFunc("/api/articles", articleListHandler).Methods("GET")
+	r.HandleFunc("/api/extras/{id}", extraHandler).Methods("GET")
+	r.HandleFunc("/api/settings", settingsExportHandler).Methods("GET")
+	r.HandleFunc("/discord/interactions", discordInteractionsHandler).Methods("POST")
+	r.HandleFunc("/mcp", mcpHandler).Methods("POST")
+	r.HandleFunc("/api/voice", voiceHandler).Methods("GET", "POST")
+	r.HandleFunc("/api/trail", trailHandler).Methods("POST")
+	r.HandleFunc("/api/report", reportHandler).Methods("POST")
+	r.HandleFunc("/api/escape", escapeHandler).Methods("GET")
+	r.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	r.HandleFunc("/static/{asset}", staticHandler).Methods("GET")
+	r.HandleFunc("/theme.css", themeHandler).Methods("GET")
+	r.HandleFunc("/manifest.webmanifest", manifestHandler).Methods("GET")
+	r.HandleFunc("/favicon.ico", faviconHandler).Methods("GET")
+	r.HandleFunc("/apple-touch-icon.png", appleTouchIconHandler).Methods("GET")
+	r.HandleFunc("/icons/{icon}", iconHandler).Methods("GET")
+	r.HandleFunc("/gate", gateHandler).Methods("POST")
+	r.HandleFunc("/share", shareHandler).Methods("POST")
+	r.HandleFunc("/admin", requireAdmin(adminHandler)).Methods("GET")
+	r.HandleFunc("/admin/wikis", requireAdmin(adminSaveWikiHandler)).Methods("POST")
+	r.HandleFunc("/admin/banner", requireAdmin(adminBannerHandler)).Methods("POST")
+	r.HandleFunc("/admin/reports", requireAdmin(adminReportHandler)).Methods("POST")
+	r.HandleFunc("/admin/wikis/{name}/delete", requireAdmin(adminDeleteWikiHandler)).Methods("POST")
+	r.HandleFunc("/admin/cache", requireAdmin(adminCacheHandler)).Methods("GET")
+	r.HandleFunc("/admin/cache", requireAdmin(adminPurgeHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/cache/{article}", requireAdmin(adminForgetHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/regenerate/{article}", requireAdmin(adminRegenerateHandler)).Methods("POST")
+	r.HandleFunc("/admin/migrate", requireAdmin(adminMigrationHandler)).Methods("GET")
+	r.HandleFunc("/admin/migrate", requireAdmin(adminMigrateHandler)).Methods("POST")
+	r.HandleFunc("/admin/migrate", requireAdmin(adminStopMigrationHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/export", requireAdmin(adminExportHandler)).Methods("GET")
+	r.HandleFunc("/admin/import", requireAdmin(adminImportHandler)).Methods("POST")
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -41,41 +144,70 @@ func main() {
 	}
 
 	log.Printf("Starting endless wiki server on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	log.Fatal(http.ListenAndServe(":"+port, withNetworkRules(withWiki(withPrivacy(r)))))
 }
 
 func homeHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/home.html")
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		return
+	current := settingsFor(r.Context())
+	data := homePage{
+		Branding: brandingFor(r.Context()),
+		Lens:     lensFromRequest(r),
+		Activity: current.Activity,
+		Intro:    current.homeIntro(),
+		Sections: current.homeSections(),
+		ReadOnly: breakerOpen(),
 	}
-
-	w.Header().Set("Content-Type", "text/html")
-	if err := tmpl.Execute(w, nil); err != nil {
-		log.Printf("Template execution error: %v", err)
+	// Featured articles are picked for the default wiki
+	if wikiFrom(r.Context()) == defaultWiki {
+		data.Featured = currentFeatured()
 	}
+
+	renderHomePage(w, data)
 }
 
 func wikiHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	articleName := vars["article"]
-
-	if articleName == "" {
-		http.Error(w, "Article name is required", http.StatusBadRequest)
+	articleName, ok := pageTitle(w, r, "wiki")
+	if !ok {
 		return
 	}
 
 	// Render the streaming page template
-	renderStreamingWikiPage(w, articleName)
+	renderStreamingWikiPage(w, r, articleName, "")
 }
 
 func streamHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	articleName := vars["article"]
+	if wantsPlainText(r) {
+		rawHandler(w, r)
+		return
+	}
 
-	if articleName == "" {
-		http.Error(w, "Article name is required", http.StatusBadRequest)
+	requested, err := articleVar(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	articleName, err := normalizeTitle(requested)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if articleHidden(r.Context(), articleName, requestKind(r)) {
+		http.Error(w, hiddenMessage, http.StatusGone)
+		return
+	}
+
+	seed, err := seedFor(r.Context(), articleName, r.URL.Query().Get("seed"))
+	if err != nil {
+		http.Error(w, "Seed must be an integer", http.StatusBadRequest)
+		return
+	}
+	if err := setRequestOptions(&GenerateOptions{}, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkModelParam(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -88,15 +220,102 @@ func streamHandler(w http.ResponseWriter, r *http.Request) {
 	// Create a context that gets cancelled when the client disconnects
 	ctx := r.Context()
 
+	// Articles written before need no generation slot, nor a challenge or
+	// a share of the throttle
+	if cached, ok := storedArticle(r, articleName, requestKind(r)); ok {
+		serveStoredArticle(w, r, cached)
+		return
+	}
+
+	// New sessions may have to prove they aren't a bot first
+	if gateRequired(r) {
+		sendJSONEvent(w, "challenge", newChallenge())
+		return
+	}
+
+	if err := throttle(ctx, r, articleName); err != nil {
+		return
+	}
+	// While ollama is down only stored articles are served
+	if unavailable, queued := generationUnavailable(r, articleName, requestKind(r)); unavailable {
+		sendJSONEvent(w, "unavailable", queued)
+		return
+	}
+
+	// Wait for a free generation slot, telling the page its place in line
+	release, err := streams.acquire(ctx, clientIP(r), func(position int) {
+		sendJSONEvent(w, "queue", position)
+	})
+	if err != nil {
+		log.Printf("Client left the queue for '%s'", articleName)
+		return
+	}
+	// The slot is handed back as soon as the prose is done
+	release = sync.OnceFunc(release)
+	defer release()
+
+	// A missing model is pulled before it writes, in front of the reader
+	ctx = withPullProgress(ctx, func(progress PullProgress) {
+		sendJSONEvent(w, "pull", progress)
+	})
+	job := prepareArticle(ctx, r, articleName, seed)
+	current := settingsFor(ctx)
+	if current.TopicTypes && job.Kind.Name == "" {
+		sendJSONEvent(w, "topic", job.Topic.Name)
+	}
+
 	// Generate article content using Ollama with streaming
-	err := generateArticleStream(ctx, articleName, w)
+	activityFor(ctx).publish(ActivityEvent{Type: "generating", Title: articleName, Kind: job.Kind.Name})
+	replay := newReplay(articleName, job.Kind.Name)
+	var fullContent strings.Builder
+	trim := &trimmer{rules: job.Trim}
+	pacer := newContentPacer(func(markdownContent string) {
+		// Send the raw markdown content via SSE (will be parsed by frontend)
+		fmt.Fprintf(w, "event: content\ndata: %s\n\n", strings.ReplaceAll(markdownContent, "\n", "\\n"))
+
+		// Flush the response
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	})
+	content, err := generateArticle(ctx, job, func(chunk string) {
+		replay.record(chunk)
+		fullContent.WriteString(chunk)
+		pacer.update(trim.update(fullContent.String()))
+	})
+	pacer.close()
+	if err == nil && content != fullContent.String() {
+		// Take back what was trimmed, or the loop the model got stuck in
+		replay.settle(content)
+		fmt.Fprintf(w, "event: content\ndata: %s\n\n", strings.ReplaceAll(content, "\n", "\\n"))
+	}
+	if err == nil {
+		activityFor(ctx).publish(ActivityEvent{Type: "completed", Title: articleName, Kind: job.Kind.Name, Summary: articleSummary(content)})
+		meta := recordArticle(ctx, job.stored(content))
+		meta.FallbackFrom = job.FallbackFrom
+		sendJSONEvent(w, "meta", meta)
+		replay.Language = meta.Language
+		if replay.Language != "" {
+			sendJSONEvent(w, "language", replay.Language)
+		}
+		if id, err := saveReplay(replay); err == nil {
+			sendJSONEvent(w, "replay", id)
+		}
+		recordChange(r, job, content)
+		storeArticle(r, job, content, err)
+	}
+	release()
+	if err == nil {
+		eager, lazy := articleExtras(ctx, job, content)
+		runExtras(ctx, w, shareArticle(job.Kind.Name, job.Title), eager, lazy)
+	}
 	if err != nil {
 		// Check if it was cancelled due to client disconnect
 		if ctx.Err() == context.Canceled {
 			log.Printf("Article generation cancelled for '%s' (client disconnected)", articleName)
 			return
 		}
-		log.Printf("Error generating article: %v", err)
+		alertAdmin("Error generating article '%s': %v", articleName, err)
 		fmt.Fprintf(w, "event: error\ndata: Failed to generate article\n\n")
 	}
 
@@ -106,154 +325,523 @@ func streamHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func generateArticleStream(ctx context.Context, articleName string, w http.ResponseWriter) error {
-	ollamaHost := os.Getenv("OLLAMA_HOST")
-	if ollamaHost == "" {
-		ollamaHost = "http://localhost:11434"
+// serveStoredArticle sends a stored article down the stream as if it had
+// just been written, with its extras.
+func serveStoredArticle(w http.ResponseWriter, r *http.Request, cached StoredArticle) {
+	ctx := r.Context()
+	job := &articleJob{
+		Title: cached.Title,
+		Model: cached.Model,
+		Kind:  articleKinds[cached.Kind],
+		Topic: TopicType{Name: defaultTopicType},
+	}
+	if topic, ok := topicTypes[cached.Topic]; ok {
+		job.Topic = topic
 	}
 
-	ollamaModel := os.Getenv("OLLAMA_MODEL")
-	if ollamaModel == "" {
-		ollamaModel = "llama2"
+	if settingsFor(ctx).TopicTypes && job.Kind.Name == "" {
+		sendJSONEvent(w, "topic", job.Topic.Name)
+	}
+	noticeEdit(ctx, cached)
+	fmt.Fprintf(w, "event: content\ndata: %s\n\n", strings.ReplaceAll(cached.Content, "\n", "\\n"))
+	meta := recordArticle(ctx, cached)
+	sendJSONEvent(w, "meta", meta)
+	if meta.Language != "" {
+		sendJSONEvent(w, "language", meta.Language)
 	}
 
-	log.Printf("Generating article '%s' using model '%s' at host '%s'", articleName, ollamaModel, ollamaHost)
+	eager, lazy := articleExtras(ctx, job, cached.Content)
+	runExtras(ctx, w, shareArticle(job.Kind.Name, job.Title), eager, lazy)
+	if ctx.Err() == nil {
+		fmt.Fprintf(w, "event: complete\ndata: done\n\n")
+	}
+}
 
-	prompt := fmt.Sprintf(`You are a wiki article generator. Generate a comprehensive informative article about "%s" in markdown format. 
+// articleExtras picks the extras that go with an article, by the wiki's
+// settings.
+func articleExtras(ctx context.Context, job *articleJob, content string) (eager, lazy map[string]extraTask) {
+	current := settingsFor(ctx)
+	eager = map[string]extraTask{}
+	lazy = map[string]extraTask{}
+	if current.Infobox && job.Kind.Name == "" {
+		eager["infobox"] = cachedExtra("infobox", job.Model+"\x00"+job.Topic.Name+"\x00"+job.Title, infoboxExtra(job.Title, job.Model, job.Topic))
+	}
+	if current.Glossary {
+		eager["glossary"] = cachedExtra("glossary", contentKey(job.Model, content), glossaryExtra(job.Title, job.Model, content))
+	}
+	if current.Suggestions {
+		lazy["suggestions"] = suggestionsExtra(job.Title, content)
+	}
+	return eager, lazy
+}
 
-Requirements:
-- Write like wikipedia in an encyclopedic style
-- Include multiple sections with clear markdown headers (## Section Name)
-- Use proper markdown formatting including **bold**, *italic*, lists, etc.
-- Include relevant subsections where appropriate
-- Make the article detailed and informative
-- Provide only the markdown text of the article, no followup questions
+// requestKind is the name of the article kind a request asks for, or empty
+// for a plain article.
+func requestKind(r *http.Request) string {
+	return articleKinds[r.URL.Query().Get("kind")].Name
+}
 
-Generate the article now:`, articleName)
+// articleJob is everything needed to generate one article.
+type articleJob struct {
+	Title string
+	Model string
+	// Fallbacks are the models to try in turn if Model fails, and
+	// FallbackFrom the model that failed when one of them wrote it
+	Fallbacks    []string
+	FallbackFrom string
+	// System is the system prompt the article is written under, and Prompt
+	// asks for the article
+	System    string
+	Prompt    string
+	Options   *GenerateOptions
+	Kind      ArticleKind
+	Topic     TopicType
+	Reasoning string
+	Trim      []*regexp.Regexp
+	// Lede streams the opening paragraph on its own first
+	Lede bool
+
+	// Tokens and Elapsed are what the article took to generate, filled in
+	// by generateArticle
+	Tokens  int
+	Elapsed time.Duration
+
+	// request is the request the article is written for, whose options
+	// are set again for a model fallen back on
+	request *http.Request
+}
 
-	reqBody := OllamaRequest{
-		Model:  ollamaModel,
-		Prompt: prompt,
-		Stream: true,
+// tuneOptions sizes the job's options to a model, then sets the options of
+// the environment and the request over them.
+func (job *articleJob) tuneOptions(model string) {
+	options := &GenerateOptions{Seed: job.Options.Seed, Stop: job.Options.Stop}
+	modelProfileFor(model).tune(options)
+	if job.request != nil {
+		// The request's options were checked by its handler
+		setRequestOptions(options, job.request)
 	}
+	job.Options = options
+}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return err
+// prepareArticle works out the model, options and prompt for an article
+// request. It may ask the model to classify the topic, so it should only run
+// once the reader has a generation slot.
+func prepareArticle(ctx context.Context, r *http.Request, articleName string, seed int) *articleJob {
+	current := settingsFor(ctx)
+	job := &articleJob{
+		Title:     articleName,
+		Topic:     TopicType{Name: defaultTopicType},
+		Reasoning: current.Reasoning,
+		request:   r,
 	}
 
-	// Create HTTP request with context for cancellation
-	req, err := http.NewRequestWithContext(ctx, "POST", ollamaHost+"/api/generate", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+	// Allow a different model to be requested, used by the compare page.
+	// Handlers refuse models the wikis aren't configured with.
+	job.Model = r.URL.Query().Get("model")
+	if !configuredModel(job.Model) {
+		job.Model = ""
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if job.Model == "" {
+		job.Model = routeModel(ctx, articleName)
+		job.Fallbacks = current.fallbacksFor(job.Model)
+	}
+	if len(job.Fallbacks) > 0 {
+		// Don't hold the reader up pulling a model that may be fallen back
+		// from
+		ctx = withBackgroundPull(ctx)
 	}
-	defer resp.Body.Close()
 
-	decoder := json.NewDecoder(resp.Body)
-	var fullContent strings.Builder
+	// Size the article to what the model can handle
+	profile := modelProfileFor(job.Model)
+	job.Options = &GenerateOptions{Seed: seed, Stop: current.Stop}
+	job.tuneOptions(job.Model)
+	// The rules were checked when the settings were loaded
+	job.Trim, _ = compileTrimRules(current.TrimRules)
+
+	// Namespaces like portals bring their own prompt and skip the infobox
+	kind, isKind := articleKinds[r.URL.Query().Get("kind")]
+	if isKind {
+		job.Kind = kind
+		job.Prompt = buildKindPrompt(kind, articleName, lensFromRequest(r))
+		return job
+	}
 
-	for {
-		// Check if context was cancelled
-		select {
-		case <-ctx.Done():
-			log.Printf("Article generation cancelled for '%s'", articleName)
-			return ctx.Err()
-		default:
+	// Route the topic to its type-specific structure and infobox
+	if current.TopicTypes {
+		job.Topic = topicTypes[defaultTopicType]
+		classified := component(ctx, "topic", job.Model+"\x00"+articleName, func() interface{} {
+			if topic, ok := classifyTopic(ctx, articleName, job.Model); ok {
+				return topic
+			}
+			return nil
+		})
+		if topic, ok := classified.(TopicType); ok {
+			job.Topic = topic
 		}
+	}
+	job.System = current.System
+	job.Prompt = buildPrompt(current.Prompt, articleName, job.Topic, lensFromRequest(r)) + subArticleContext(ctx, articleName, job.Model) + profile.lengthHint()
+	job.Lede = current.Lede
+	return job
+}
+
+// buildPrompt fills the wiki's prompt with the article title and appends the
+// structure for the topic type and the reader's session lens, if any.
+func buildPrompt(template, articleName string, topic TopicType, lens string) string {
+	prompt := fmt.Sprintf(template, articleName)
+	if topic.Structure != "" {
+		prompt += "\n\n" + topic.Structure
+	}
+	return withLens(prompt, lens)
+}
+
+// checkModelParam refuses a ?model= the wikis aren't configured with, so
+// readers can't have ollama download any model they like.
+func checkModelParam(r *http.Request) error {
+	if model := r.URL.Query().Get("model"); model != "" && !configuredModel(model) {
+		return fmt.Errorf("Model %q isn't one of this wiki's models", model)
+	}
+	return nil
+}
 
-		var ollamaResp OllamaResponse
-		if err := decoder.Decode(&ollamaResp); err != nil {
-			// Check if it's a context cancellation error
-			if ctx.Err() != nil {
-				return ctx.Err()
+// seedFor picks the generation seed for an article. An explicit seed wins,
+// otherwise deterministic mode derives one from the title so the same title
+// always regenerates identically. Zero means no seed.
+func seedFor(ctx context.Context, articleName, explicit string) (int, error) {
+	if explicit != "" {
+		return strconv.Atoi(explicit)
+	}
+	if !settingsFor(ctx).Deterministic {
+		return 0, nil
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(articleName))
+	// Keep the seed positive and non-zero
+	return int(hash.Sum32()&0x7fffffff) | 1, nil
+}
+
+// maxContinuations caps how many times an article that hit the token limit
+// is continued.
+const maxContinuations = 2
+
+const continuationPrompt = `You are continuing a wiki article about "%s" in markdown format that was cut off. This is the end of the article so far:
+
+%s
+
+Continue writing exactly where the text stops. Do not repeat any of the text above and do not add any preamble. Finish the interrupted sentence first, then bring the article to a natural conclusion.`
+
+// generateArticle generates an article for a job, calling onChunk with every
+// piece of the response as it arrives, and returns the full article. The
+// wiki's trim rules are applied to it, and if the model gets stuck repeating
+// itself the loop is cut off, so the article returned can differ from what
+// was passed to onChunk. A model that fails falls back to the next of the
+// job's fallbacks.
+func generateArticle(ctx context.Context, job *articleJob, onChunk func(string)) (string, error) {
+	models := append([]string{job.Model}, job.Fallbacks...)
+	wrote := false
+	var content string
+	var err error
+	for i, model := range models {
+		if i > 0 {
+			// What the reader has seen can't be taken back, so only a
+			// model that failed before writing anything is fallen back from
+			if err == nil || wrote || ctx.Err() != nil {
+				break
 			}
-			break
+			log.Printf("Model '%s' failed for '%s', falling back to '%s': %v", job.Model, job.Title, model, err)
+			job.FallbackFrom = models[0]
+			job.Model, job.Tokens = model, 0
+			job.tuneOptions(model)
 		}
 
-		if ollamaResp.Response != "" {
-			fullContent.WriteString(ollamaResp.Response)
+		// While there's a model to fall back on, a missing one is pulled
+		// in the background rather than in front of the reader
+		attempt := ctx
+		if i < len(models)-1 {
+			attempt = withBackgroundPull(ctx)
+		}
+		content, err = writeArticle(attempt, job, func(chunk string) {
+			wrote = true
+			onChunk(chunk)
+		})
+	}
+	return content, err
+}
 
-			// Send the raw markdown content via SSE (will be parsed by frontend)
-			markdownContent := fullContent.String()
-			fmt.Fprintf(w, "event: content\ndata: %s\n\n", strings.ReplaceAll(markdownContent, "\n", "\\n"))
+// linkScanBytes is how much of the end of an article is looked through for
+// links as it's written, more than the longest link.
+const linkScanBytes = 1024
 
-			// Flush the response
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
-			}
+// writeArticle is generateArticle with a single model.
+func writeArticle(ctx context.Context, job *articleJob, onChunk func(string)) (string, error) {
+	log.Printf("Generating article '%s' using model '%s' at host '%s'", job.Title, job.Model, generationProvider().Host())
+
+	start := time.Now()
+
+	// A model that never stops would otherwise fill memory, so generation
+	// is cut off at the size limit
+	generating, stop := context.WithCancel(ctx)
+	defer stop()
+	limit := settings.MaxArticleSize
+	cutOff := false
+	// So is a model going round in circles, once the loop is clear
+	loopAt := -1
+	loops := newLoopWatcher()
+
+	var fullContent strings.Builder
+	emit := func(chunk string) {
+		if cutOff {
+			return
+		}
+		if limit > 0 && fullContent.Len()+len(chunk) > limit {
+			chunk = truncateBytes(chunk, limit-fullContent.Len())
+			cutOff = true
+			stop()
+		}
+		fullContent.WriteString(chunk)
+		onChunk(chunk)
+
+		// Readers may follow links before the article is finished, so the
+		// titles of links are remembered as they're written
+		if strings.Contains(chunk, "]") {
+			content := fullContent.String()
+			rememberLinkTitles(ctx, content[max(0, len(content)-linkScanBytes):])
 		}
 
-		if ollamaResp.Done {
-			break
+		if loopAt = loops.write(fullContent.String()); loopAt >= 0 {
+			cutOff = true
+			stop()
+		}
+	}
+	reasoning := newReasoningFilter(job.Reasoning)
+	headings := newHeadingFilter(job)
+	var firstText time.Duration
+	collect := func(chunk string) {
+		if firstText == 0 {
+			firstText = time.Since(start)
+		}
+		if chunk = headings.write(reasoning.write(chunk)); chunk != "" {
+			emit(chunk)
 		}
 	}
 
-	return nil
+	prompt := job.Prompt
+	var err error
+	if job.Lede {
+		prompt, job.Tokens, err = writeLede(generating, job, collect)
+		// The rest may well open with the title again
+		headings.echo = true
+	}
+	doneReason := ""
+	tokens := 0
+	if err == nil && !cutOff {
+		doneReason, tokens, err = streamGenerate(generating, job.Model, job.System, prompt, job.Options, collect)
+		job.Tokens += tokens
+	}
+	defer func() {
+		job.Elapsed = time.Since(start)
+		recordGeneration(ctx, job.Model, time.Since(start), utf8.RuneCountInString(fullContent.String()), err)
+		observeGeneration(ctx, firstText, err)
+		observeBackend(ctx, err)
+	}()
+
+	// Keep going with the tail as context if the model ran out of tokens
+	for i := 0; err == nil && !cutOff && doneReason == "length" && i < maxContinuations; i++ {
+		log.Printf("Article '%s' hit the token limit, continuing", job.Title)
+		tail := lastRunes(fullContent.String(), 2000)
+		doneReason, tokens, err = streamGenerate(generating, job.Model, job.System, fmt.Sprintf(continuationPrompt, job.Title, tail), job.Options, collect)
+		job.Tokens += tokens
+	}
+	if rest := headings.write(reasoning.flush()) + headings.flush(); rest != "" && err == nil {
+		emit(rest)
+	}
+
+	if cutOff && ctx.Err() == nil {
+		if loopAt >= 0 {
+			log.Printf("Article '%s' started repeating itself, trimming the loop", job.Title)
+			err = nil
+			return applyTrimRules(fullContent.String()[:loopAt], job.Trim), nil
+		}
+		log.Printf("Article '%s' reached the %d byte limit, cutting it off", job.Title, limit)
+		err = nil
+	}
+	if err != nil && ctx.Err() != nil {
+		log.Printf("Article generation cancelled for '%s'", job.Title)
+		return "", ctx.Err()
+	}
+	return applyTrimRules(fullContent.String(), job.Trim), err
 }
 
+// generateWhole generates a whole article outside of a reader's stream, for
+// the bots and APIs that only want the finished text. It waits for a
+// generation slot as client, and like any other article it is reported on the
+// activity feed and saved as a replay, whose id is returned. r supplies the
+// model, kind and lens the way a page request would.
+func generateWhole(ctx context.Context, r *http.Request, client, articleName string) (content, replayID string, err error) {
+	if articleHidden(ctx, articleName, requestKind(r)) {
+		return "", "", errors.New(hiddenMessage)
+	}
+	if cached, ok := storedArticle(r, articleName, requestKind(r)); ok {
+		noticeEdit(ctx, cached)
+		recordArticle(ctx, cached)
+		return cached.Content, "", nil
+	}
+	if unavailable, _ := generationUnavailable(r, articleName, requestKind(r)); unavailable {
+		return "", "", errGenerationUnavailable
+	}
 
-func ensureModelDownloaded() {
-	ollamaHost := os.Getenv("OLLAMA_HOST")
-	if ollamaHost == "" {
-		ollamaHost = "http://localhost:11434"
+	release, err := streams.acquire(ctx, client, func(int) {})
+	if err != nil {
+		return "", "", err
 	}
+	defer release()
+
+	seed, _ := seedFor(ctx, articleName, "")
+	job := prepareArticle(ctx, r, articleName, seed)
 
-	ollamaModel := os.Getenv("OLLAMA_MODEL")
-	if ollamaModel == "" {
-		ollamaModel = "llama2"
+	hub := activityFor(ctx)
+	hub.publish(ActivityEvent{Type: "generating", Title: articleName, Kind: job.Kind.Name})
+	replay := newReplay(articleName, job.Kind.Name)
+	content, err = generateArticle(ctx, job, replay.record)
+	if err != nil {
+		return "", "", err
+	}
+	replay.settle(content)
+	hub.publish(ActivityEvent{Type: "completed", Title: articleName, Kind: job.Kind.Name, Summary: articleSummary(content)})
+
+	replay.Language = recordArticle(ctx, job.stored(content)).Language
+	recordChange(r, job, content)
+	storeArticle(r, job, content, err)
+	replayID, err = saveReplay(replay)
+	if err != nil {
+		log.Printf("Error saving replay for '%s': %v", articleName, err)
+	}
+	return content, replayID, nil
+}
+
+// truncateBytes returns at most n bytes from the start of s, without
+// splitting a character.
+func truncateBytes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
+	return s[:n]
+}
 
-	log.Printf("Ensuring model '%s' is available at '%s'", ollamaModel, ollamaHost)
+// lastRunes returns at most n runes from the end of s.
+func lastRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[len(runes)-n:])
+}
 
-	// Try to pull the model
-	pullReq := struct {
-		Name string `json:"name"`
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Branding
+		Pinned []NavItem
 	}{
-		Name: ollamaModel,
+		Branding: brandingFor(r.Context()),
+	}
+	for i, link := range pinnedLinks(r) {
+		rememberRequestedTitle(r.Context(), link.Title)
+		item := navItem(r.Context(), link)
+		item.Pinned = i
+		data.Pinned = append(data.Pinned, item)
 	}
 
-	jsonData, err := json.Marshal(pullReq)
+	renderPage(w, "profile.html", data)
+}
+
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	requested, err := articleVar(r)
 	if err != nil {
-		log.Printf("Error marshaling pull request: %v", err)
+		renderError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	resp, err := http.Post(ollamaHost+"/api/pull", "application/json", bytes.NewBuffer(jsonData))
+	articleName, err := normalizeTitle(requested)
 	if err != nil {
-		log.Printf("Error pulling model (Ollama may not be ready yet): %v", err)
+		renderError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 200 {
-		log.Printf("Model '%s' is ready", ollamaModel)
-	} else {
-		log.Printf("Model pull returned status %d", resp.StatusCode)
+	// Compare the configured model against itself unless told otherwise
+	modelA := r.URL.Query().Get("a")
+	if modelA == "" {
+		modelA = settingsFor(r.Context()).Model
 	}
+	modelB := r.URL.Query().Get("b")
+	if modelB == "" {
+		modelB = settingsFor(r.Context()).Model
+	}
+	for _, model := range []string{modelA, modelB} {
+		if !configuredModel(model) {
+			renderError(w, http.StatusBadRequest, fmt.Sprintf("Model %q isn't one of this wiki's models", model))
+			return
+		}
+	}
+
+	data := struct {
+		Branding
+		Title  string
+		ModelA string
+		ModelB string
+		Models []string
+	}{
+		Branding: brandingFor(r.Context()),
+		Title:    articleName,
+		ModelA:   modelA,
+		ModelB:   modelB,
+		Models:   wikiModels(),
+	}
+
+	renderPage(w, "compare.html", data)
 }
 
-func renderStreamingWikiPage(w http.ResponseWriter, title string) {
-	tmpl, err := template.ParseFiles("templates/wiki.html")
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
+func renderStreamingWikiPage(w http.ResponseWriter, r *http.Request, title, kind string) {
+	if articleHidden(r.Context(), title, kind) {
+		renderError(w, http.StatusGone, hiddenMessage)
 		return
 	}
 
 	data := struct {
-		Title string
+		Branding
+		Title          string
+		Kind           string
+		DictionaryTabs bool
+		Breadcrumbs    []Breadcrumb
+		Leaf           string
+		Description    string
+		CanShare       bool
+		Share          string
+		Watching       bool
+		Nav            []NavItem
+		Pinned         bool
 	}{
-		Title: title,
+		Branding:       brandingFor(r.Context()),
+		Title:          title,
+		Kind:           kind,
+		DictionaryTabs: (kind == "" || kind == "dictionary") && isDictionaryWord(requestedWord(r, title)),
+		Breadcrumbs:    breadcrumbs(title),
+		Leaf:           title[strings.LastIndex(title, "/")+1:],
+		CanShare:       wikiPassword() != "" && isReader(r),
+		Share:          r.URL.Query().Get("share"),
+		Watching:       isWatching(r, title, kind),
+		Nav:            navFor(r, title, kind),
+		Pinned:         isPinned(r, title, kind),
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	if err := tmpl.Execute(w, data); err != nil {
-		log.Printf("Template execution error: %v", err)
+	if meta, ok := lookupArticleMeta(r.Context(), title, kind); ok {
+		data.Description = meta.Description
 	}
+
+	renderPage(w, "wiki.html", data)
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// /mcp exposes the wiki to LLM agents as a Model Context Protocol server over
+// the streamable HTTP transport. Every JSON-RPC request gets a plain JSON
+// response; the server never opens a stream of its own.
+//
+// Only generate_article is offered. get_article and search need articles to
+// be stored, and they aren't yet.
+
+const mcpProtocolVersion = "2025-03-26"
+
+type mcpRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type mcpResponse struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      interface{} `json:"id"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   *mcpError   `json:"error,omitempty"`
+}
+
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes
+const (
+	mcpParseError     = -32700
+	mcpMethodNotFound = -32601
+	mcpInvalidParams  = -32602
+)
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+var mcpTools = []map[string]interface{}{{
+	"name":        "generate_article",
+	"description": "Generate an encyclopedia article about any topic and return it as markdown. Topics in [[double brackets]] are links to other articles that can be generated the same way. Articles are invented and should not be treated as fact.",
+	"inputSchema": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{
+				"type":        "string",
+				"description": "Title of the article. Use / for sub-articles, like Roman Empire/Military",
+			},
+			"kind": map[string]interface{}{
+				"type":        "string",
+				"description": "Generate a different kind of page instead of an encyclopedia article",
+				"enum":        []string{"portal", "dictionary", "how-to", "news"},
+			},
+		},
+		"required": []string{"title"},
+	},
+}}
+
+func mcpHandler(w http.ResponseWriter, r *http.Request) {
+	var req mcpRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeMCPResponse(w, mcpResponse{Error: &mcpError{Code: mcpParseError, Message: "Invalid JSON-RPC request"}})
+		return
+	}
+
+	// Notifications and responses from the client need no answer
+	if len(req.ID) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	resp := mcpResponse{ID: req.ID}
+	switch req.Method {
+	case "initialize":
+		resp.Result = map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "endless-wiki", "version": "1.0.0"},
+		}
+	case "ping":
+		resp.Result = map[string]interface{}{}
+	case "tools/list":
+		resp.Result = map[string]interface{}{"tools": mcpTools}
+	case "tools/call":
+		var params struct {
+			Name      string `json:"name"`
+			Arguments struct {
+				Title string `json:"title"`
+				Kind  string `json:"kind"`
+			} `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Name != "generate_article" {
+			resp.Error = &mcpError{Code: mcpInvalidParams, Message: "Unknown tool"}
+			break
+		}
+		resp.Result = mcpGenerateArticle(r, params.Arguments.Title, params.Arguments.Kind)
+	default:
+		resp.Error = &mcpError{Code: mcpMethodNotFound, Message: "Method not found"}
+	}
+
+	writeMCPResponse(w, resp)
+}
+
+// mcpGenerateArticle runs the generate_article tool. Failures are reported in
+// the result so the calling model can see them.
+func mcpGenerateArticle(r *http.Request, title, kind string) mcpToolResult {
+	articleName, err := normalizeTitle(title)
+	if err != nil {
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}
+	}
+	if _, ok := articleKinds[kind]; kind != "" && !ok {
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: "Unknown kind " + kind}}, IsError: true}
+	}
+
+	if gateRequired(r) {
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: "This instance asks new visitors to prove they aren't a bot in a browser before generating"}}, IsError: true}
+	}
+	if err := throttle(r.Context(), r, articleName); err != nil {
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: "Cancelled"}}, IsError: true}
+	}
+
+	// Generate as if the article was requested from its page
+	query := url.Values{}
+	if kind != "" {
+		query.Set("kind", kind)
+	}
+	articleReq := r.Clone(r.Context())
+	articleReq.URL = &url.URL{Path: "/stream/" + url.PathEscape(articleName), RawQuery: query.Encode()}
+
+	content, _, err := generateWhole(r.Context(), articleReq, clientIP(r), articleName)
+	if err != nil {
+		alertAdmin("Error generating article for MCP: %v", err)
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: "Failed to generate article"}}, IsError: true}
+	}
+
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: content}}}
+}
+
+func writeMCPResponse(w http.ResponseWriter, resp mcpResponse) {
+	resp.JSONRPC = "2.0"
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error encoding MCP response: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// maxMemoryArticles caps how many articles the memory store keeps.
+const maxMemoryArticles = 10000
+
+// memoryStore keeps articles in memory until the server restarts.
+type memoryStore struct {
+	mu       sync.RWMutex
+	articles map[storeKey]memoryArticle
+}
+
+// memoryArticle is a stored article, with its text compressed into packed
+// if it was compressed.
+type memoryArticle struct {
+	StoredArticle
+	packed []byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{articles: map[storeKey]memoryArticle{}}
+}
+
+func (s *memoryStore) Get(ctx context.Context, wiki, kind, title string) (StoredArticle, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored, ok := s.articles[storeKey{wiki, kind, title}]
+	if !ok || stored.packed == nil {
+		return stored.StoredArticle, ok, nil
+	}
+	article := stored.StoredArticle
+	content, err := readArticle(bytes.NewReader(stored.packed))
+	if err != nil {
+		return StoredArticle{}, false, err
+	}
+	article.Content = string(content)
+	return article, true, nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, wiki string, article StoredArticle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := storeKey{wiki, article.Kind, article.Title}
+	if _, ok := s.articles[key]; !ok && len(s.articles) >= maxMemoryArticles {
+		// Make room by forgetting the oldest article
+		var oldest storeKey
+		for k, stored := range s.articles {
+			if oldest.title == "" || stored.Generated.Before(s.articles[oldest].Generated) {
+				oldest = k
+			}
+		}
+		delete(s.articles, oldest)
+	}
+	stored := memoryArticle{StoredArticle: article}
+	if packed := compressArticle([]byte(article.Content)); isCompressed(packed) {
+		stored.Content, stored.packed = "", packed
+	}
+	s.articles[key] = stored
+	return nil
+}
+
+func (s *memoryStore) List(ctx context.Context, wiki, kind string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var titles []string
+	for key := range s.articles {
+		if key.wiki == wiki && key.kind == kind {
+			titles = append(titles, key.title)
+		}
+	}
+	return titles, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, wiki, kind, title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.articles, storeKey{wiki, kind, title})
+	return nil
+}
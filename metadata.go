@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Once an article has been generated, what was learnt about it (a short
+// description and its language) is kept, so the next time its page is
+// served the description can go in the page's meta tags for search engines
+// and link previews. So is how it was generated: the model, when, how long
+// it took and how many tokens, which the page shows under the article. It is
+// refreshed every time the article is regenerated. Like replays it lives in
+// memory only, but the article store keeps how stored articles were
+// generated alongside them.
+
+// maxArticleMeta is how many articles' metadata is kept.
+const maxArticleMeta = 1000
+
+// maxMetaDescription is how long a description may be, which is about as
+// much as search engines show.
+const maxMetaDescription = 155
+
+// ArticleMeta is what is known about a generated article.
+type ArticleMeta struct {
+	Title       string    `json:"title"`
+	Slug        string    `json:"slug"`
+	Kind        string    `json:"kind,omitempty"`
+	Description string    `json:"description"`
+	Language    string    `json:"language,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	Generated   time.Time `json:"generated"`
+	// Duration is how many seconds it took to generate
+	Duration float64 `json:"duration,omitempty"`
+	Tokens   int     `json:"tokens,omitempty"`
+	// FallbackFrom is the model that failed, when a fallback wrote the
+	// article just now
+	FallbackFrom string `json:"fallback_from,omitempty"`
+}
+
+var (
+	articleMeta      = map[string]ArticleMeta{}
+	articleMetaOrder []string
+	articleMetaMu    sync.Mutex
+)
+
+func articleMetaKey(ctx context.Context, title, kind string) string {
+	return wikiFrom(ctx).Name + "\x00" + kind + "\x00" + title
+}
+
+// recordArticle works out the metadata of an article that was just
+// generated or served from the store and keeps it, replacing what was known
+// from earlier generations.
+func recordArticle(ctx context.Context, article StoredArticle) ArticleMeta {
+	rememberArticleTitles(ctx, article)
+	meta := storedMeta(article)
+	key := articleMetaKey(ctx, article.Title, article.Kind)
+
+	articleMetaMu.Lock()
+	defer articleMetaMu.Unlock()
+
+	if _, ok := articleMeta[key]; ok {
+		for i, k := range articleMetaOrder {
+			if k == key {
+				articleMetaOrder = append(articleMetaOrder[:i], articleMetaOrder[i+1:]...)
+				break
+			}
+		}
+	}
+	articleMeta[key] = meta
+	articleMetaOrder = append(articleMetaOrder, key)
+	if len(articleMetaOrder) > maxArticleMeta {
+		delete(articleMeta, articleMetaOrder[0])
+		articleMetaOrder = articleMetaOrder[1:]
+	}
+	return meta
+}
+
+// storedMeta works out the metadata of an article.
+func storedMeta(article StoredArticle) ArticleMeta {
+	return ArticleMeta{
+		Title:       article.Title,
+		Slug:        slugify(article.Title),
+		Kind:        article.Kind,
+		Description: metaDescription(article.Content),
+		Language:    detectLanguage(article.Content),
+		Model:       article.Model,
+		Generated:   article.Generated,
+		Duration:    article.Duration.Round(time.Millisecond).Seconds(),
+		Tokens:      article.Tokens,
+	}
+}
+
+// lookupArticleMeta returns what is known about an article, if it has been
+// generated lately.
+func lookupArticleMeta(ctx context.Context, title, kind string) (ArticleMeta, bool) {
+	articleMetaMu.Lock()
+	defer articleMetaMu.Unlock()
+
+	meta, ok := articleMeta[articleMetaKey(ctx, title, kind)]
+	return meta, ok
+}
+
+// metaDescription shortens an article's summary to fit a meta description,
+// cutting at a word boundary.
+func metaDescription(content string) string {
+	runes := []rune(strings.TrimSuffix(articleSummary(content), "…"))
+	if len(runes) <= maxMetaDescription {
+		return string(runes)
+	}
+
+	cut := string(runes[:maxMetaDescription-1])
+	if space := strings.LastIndex(cut, " "); space > 0 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " ,;:") + "…"
+}
+
+// articleMetaHandler serves what is known about an article as JSON.
+func articleMetaHandler(w http.ResponseWriter, r *http.Request) {
+	requested, err := articleVar(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	articleName, err := normalizeTitle(requested)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, ok := lookupArticleMeta(r.Context(), articleName, r.URL.Query().Get("kind"))
+	if !ok {
+		// Stored articles say how they were generated, however long ago
+		var cached StoredArticle
+		if cached, ok = storedArticle(r, articleName, requestKind(r)); ok {
+			meta = storedMeta(cached)
+		}
+	}
+	if !ok {
+		http.Error(w, "That article hasn't been generated lately", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		log.Printf("Error encoding article metadata: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// /metrics exposes article generation counters in the Prometheus text format,
+// labelled by wiki, model and provider so tenants can be observed and billed
+// separately. Models can be picked per request and wikis provisioned by
+// visitors, so each label only takes so many distinct values before the rest
+// are counted as "other".
+
+// maxLabelValues is how many distinct values a label may take.
+const maxLabelValues = 100
+
+// overflowLabel replaces label values past maxLabelValues.
+const overflowLabel = "other"
+
+type metricKey struct {
+	wiki, model, status string
+}
+
+type generationMetrics struct {
+	count      int64
+	seconds    float64
+	characters int64
+}
+
+var metrics = struct {
+	mu          sync.Mutex
+	generations map[metricKey]*generationMetrics
+	seen        map[string]map[string]bool
+}{
+	generations: map[metricKey]*generationMetrics{},
+	seen:        map[string]map[string]bool{},
+}
+
+// boundedLabel returns value, or overflowLabel once the label has taken
+// maxLabelValues other values. Callers hold metrics.mu.
+func boundedLabel(label, value string) string {
+	values := metrics.seen[label]
+	if values == nil {
+		values = map[string]bool{}
+		metrics.seen[label] = values
+	}
+	if values[value] {
+		return value
+	}
+	if len(values) >= maxLabelValues {
+		return overflowLabel
+	}
+	values[value] = true
+	return value
+}
+
+// recordGeneration counts a finished article generation.
+func recordGeneration(ctx context.Context, model string, elapsed time.Duration, characters int, err error) {
+	status := "ok"
+	if ctx.Err() != nil {
+		status = "cancelled"
+	} else if err != nil {
+		status = "error"
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	key := metricKey{
+		wiki:   boundedLabel("wiki", wikiFrom(ctx).Name),
+		model:  boundedLabel("model", model),
+		status: status,
+	}
+	m := metrics.generations[key]
+	if m == nil {
+		m = &generationMetrics{}
+		metrics.generations[key] = m
+	}
+	m.count++
+	m.seconds += elapsed.Seconds()
+	m.characters += int64(characters)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !settings.Metrics {
+		http.NotFound(w, r)
+		return
+	}
+
+	metrics.mu.Lock()
+	keys := make([]metricKey, 0, len(metrics.generations))
+	snapshot := make(map[metricKey]generationMetrics, len(metrics.generations))
+	for key, m := range metrics.generations {
+		keys = append(keys, key)
+		snapshot[key] = *m
+	}
+	metrics.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.wiki != b.wiki {
+			return a.wiki < b.wiki
+		}
+		if a.model != b.model {
+			return a.model < b.model
+		}
+		return a.status < b.status
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP endless_wiki_generations_total Article generations by outcome.")
+	fmt.Fprintln(w, "# TYPE endless_wiki_generations_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "endless_wiki_generations_total{%s,status=%q} %d\n", metricLabels(key), key.status, snapshot[key].count)
+	}
+
+	fmt.Fprintln(w, "# HELP endless_wiki_generation_seconds_total Time spent generating articles.")
+	fmt.Fprintln(w, "# TYPE endless_wiki_generation_seconds_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "endless_wiki_generation_seconds_total{%s,status=%q} %g\n", metricLabels(key), key.status, snapshot[key].seconds)
+	}
+
+	fmt.Fprintln(w, "# HELP endless_wiki_generated_characters_total Characters of article text generated.")
+	fmt.Fprintln(w, "# TYPE endless_wiki_generated_characters_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "endless_wiki_generated_characters_total{%s,status=%q} %d\n", metricLabels(key), key.status, snapshot[key].characters)
+	}
+
+	active, waiting := streams.stats()
+	fmt.Fprintln(w, "# HELP endless_wiki_streams_active Generations running now.")
+	fmt.Fprintln(w, "# TYPE endless_wiki_streams_active gauge")
+	fmt.Fprintf(w, "endless_wiki_streams_active %d\n", active)
+	fmt.Fprintln(w, "# HELP endless_wiki_streams_waiting Generations waiting in the queue.")
+	fmt.Fprintln(w, "# TYPE endless_wiki_streams_waiting gauge")
+	fmt.Fprintf(w, "endless_wiki_streams_waiting %d\n", waiting)
+	degraded := 0
+	if degradedBanner().Message != "" {
+		degraded = 1
+	}
+	fmt.Fprintln(w, "# HELP endless_wiki_degraded Whether generation is missing its objectives.")
+	fmt.Fprintln(w, "# TYPE endless_wiki_degraded gauge")
+	fmt.Fprintf(w, "endless_wiki_degraded %d\n", degraded)
+}
+
+func metricLabels(key metricKey) string {
+	return fmt.Sprintf("wiki=%q,model=%q,provider=%q", escapeLabel(key.wiki), escapeLabel(key.model), generationProvider().Name())
+}
+
+// escapeLabel keeps label values to what %q and the exposition format agree
+// on.
+func escapeLabel(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '_'
+		}
+		return r
+	}, value)
+}
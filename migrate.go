@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The admin migrate job writes stored articles afresh in the background,
+// after the wiki's prompt or model changed. POST /admin/migrate starts it,
+// for every stored article or those picked by ?wiki=, ?kind=, ?model= (the
+// model they were written with) and ?before= (written before a time or
+// date). GET /admin/migrate reports its progress and DELETE /admin/migrate
+// stops it. It waits MIGRATE_INTERVAL, or ?interval=, between articles so
+// readers keep their share of the models, and keeps the copies it replaces
+// as revisions, up to MIGRATE_REVISIONS of them for each article.
+
+// revisionPrefix starts the kinds that replaced articles are kept under.
+const revisionPrefix = "revisions"
+
+// maxMigrationErrors is how many of a migration's failures it reports.
+const maxMigrationErrors = 20
+
+// revisionKind is the kind the replaced copies of a kind are kept under.
+func revisionKind(kind string) string {
+	if kind == "" {
+		return revisionPrefix
+	}
+	return revisionPrefix + "-" + kind
+}
+
+// revisionTitle is the title of an article's nth newest revision.
+func revisionTitle(title string, n int) string {
+	return fmt.Sprintf("%s@%d", title, n)
+}
+
+// keepRevision keeps the copy of an article a migration replaced as its
+// newest revision, moving its older revisions down and dropping the oldest.
+// Revisions are kept in the store without its limits, like the titles of
+// slugs, so they don't crowd out the articles themselves.
+func keepRevision(ctx context.Context, wiki string, replaced StoredArticle) error {
+	keep := envInt("MIGRATE_REVISIONS", 3)
+	if keep <= 0 {
+		return nil
+	}
+	kind := revisionKind(replaced.Kind)
+	for n := keep - 1; n >= 1; n-- {
+		older, ok, err := slugStore.Get(ctx, wiki, kind, revisionTitle(replaced.Title, n))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		older.Title, older.Kind = revisionTitle(replaced.Title, n+1), kind
+		if err := slugStore.Put(ctx, wiki, older); err != nil {
+			return err
+		}
+	}
+	replaced.Title, replaced.Kind = revisionTitle(replaced.Title, 1), kind
+	return slugStore.Put(ctx, wiki, replaced)
+}
+
+// migrationTarget is a stored article a migration writes afresh.
+type migrationTarget struct {
+	wiki  *Wiki
+	kind  string
+	title string
+}
+
+// Migration is the progress of the migrate job.
+type Migration struct {
+	Running  bool       `json:"running"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Failed   int        `json:"failed"`
+	// Current is the article being written
+	Current string `json:"current,omitempty"`
+	// Errors are the latest articles that failed and why
+	Errors []string `json:"errors,omitempty"`
+	// Stopped is whether it was stopped before it finished
+	Stopped bool `json:"stopped,omitempty"`
+}
+
+var migration = struct {
+	mu       sync.Mutex
+	progress Migration
+	cancel   context.CancelFunc
+}{}
+
+// migrationProgress returns the progress of the migrate job.
+func migrationProgress() Migration {
+	migration.mu.Lock()
+	defer migration.mu.Unlock()
+	progress := migration.progress
+	progress.Errors = append([]string(nil), progress.Errors...)
+	return progress
+}
+
+// updateMigration changes the progress of the migrate job.
+func updateMigration(update func(*Migration)) {
+	migration.mu.Lock()
+	defer migration.mu.Unlock()
+	update(&migration.progress)
+}
+
+// migrationTargets returns the stored articles of wikis a migrate request
+// picks: those written with model and before a time, if they're given.
+func migrationTargets(ctx context.Context, r *http.Request, wikis []*Wiki, model string, before time.Time) ([]migrationTarget, error) {
+	var targets []migrationTarget
+	for _, wiki := range wikis {
+		for _, kind := range cacheKinds(r) {
+			titles, err := articles.List(ctx, wiki.Name, kind)
+			if err != nil {
+				return nil, fmt.Errorf("listing stored articles of wiki '%s': %v", wiki.Name, err)
+			}
+			sort.Strings(titles)
+			for _, title := range titles {
+				// Only read the articles when a filter needs what's in them
+				if model != "" || !before.IsZero() {
+					article, ok, err := articles.Get(ctx, wiki.Name, kind, title)
+					if err != nil {
+						return nil, fmt.Errorf("reading stored article '%s' of wiki '%s': %v", title, wiki.Name, err)
+					}
+					if !ok || (model != "" && article.Model != model) || (!before.IsZero() && !article.Generated.Before(before)) {
+						continue
+					}
+				}
+				targets = append(targets, migrationTarget{wiki: wiki, kind: kind, title: title})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// migrateArticle writes a stored article afresh the way a regeneration
+// does, keeping the copy it replaces as a revision.
+func migrateArticle(ctx context.Context, target migrationTarget) error {
+	ctx = context.WithValue(ctx, wikiContextKey{}, target.wiki)
+	ctx = context.WithValue(ctx, regenerateContextKey{}, true)
+
+	replaced, stored, err := articles.Get(ctx, target.wiki.Name, target.kind, target.title)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		// Forgotten since the migration started
+		return nil
+	}
+
+	query := url.Values{}
+	if target.kind != "" {
+		query.Set("kind", target.kind)
+	}
+	articleReq, _ := http.NewRequestWithContext(ctx, "GET", "/stream/"+url.PathEscape(target.title)+"?"+query.Encode(), nil)
+	dropTitleComponents(target.wiki.Name, target.title)
+	content, _, err := generateWhole(ctx, articleReq, "admin migrate", target.title)
+	if err != nil {
+		return err
+	}
+	// A blank article isn't stored, so the old copy is still the article
+	if strings.TrimSpace(content) == "" {
+		return errors.New("it came out empty")
+	}
+	if err := keepRevision(ctx, target.wiki.Name, replaced); err != nil {
+		log.Printf("Error keeping a revision of '%s' of wiki '%s': %v", target.title, target.wiki.Name, err)
+	}
+	return nil
+}
+
+// runMigration writes the targets afresh one at a time, interval apart,
+// until they're done or ctx is cancelled.
+func runMigration(ctx context.Context, targets []migrationTarget, interval time.Duration) {
+	defer updateMigration(func(m *Migration) {
+		finished := time.Now()
+		m.Running, m.Current, m.Finished = false, "", &finished
+		m.Stopped = ctx.Err() != nil
+	})
+
+	for i, target := range targets {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+		if ctx.Err() != nil {
+			log.Printf("Migration stopped after %d of %d articles", i, len(targets))
+			return
+		}
+
+		name := target.title
+		if target.kind != "" {
+			name = target.kind + ": " + name
+		}
+		name = target.wiki.Name + "/" + name
+		updateMigration(func(m *Migration) { m.Current = name })
+
+		err := migrateArticle(ctx, target)
+		if err != nil && ctx.Err() != nil {
+			log.Printf("Migration stopped after %d of %d articles", i, len(targets))
+			return
+		}
+		if err != nil {
+			log.Printf("Error migrating '%s' of wiki '%s': %v", target.title, target.wiki.Name, err)
+		}
+		updateMigration(func(m *Migration) {
+			m.Done++
+			if err != nil {
+				m.Failed++
+				m.Errors = append(m.Errors, name+": "+err.Error())
+				if len(m.Errors) > maxMigrationErrors {
+					m.Errors = m.Errors[len(m.Errors)-maxMigrationErrors:]
+				}
+			}
+		})
+	}
+	log.Printf("Migration finished, %d articles written afresh", len(targets))
+}
+
+func adminMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(migrationProgress())
+}
+
+// adminMigrateHandler starts the migrate job, unless it's already running.
+func adminMigrateHandler(w http.ResponseWriter, r *http.Request) {
+	if articles == nil {
+		http.Error(w, "This wiki doesn't store articles, so there are none to migrate", http.StatusNotFound)
+		return
+	}
+	wikis := allWikis()
+	if r.URL.Query().Get("wiki") != "" {
+		wiki, ok := cacheWiki(w, r)
+		if !ok {
+			return
+		}
+		wikis = []*Wiki{wiki}
+	}
+	interval := envDuration("MIGRATE_INTERVAL", 5*time.Second)
+	if value := r.URL.Query().Get("interval"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			http.Error(w, "interval must be a duration like 10s", http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+	var before time.Time
+	if value := r.URL.Query().Get("before"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", value); err != nil {
+				http.Error(w, "before must be a time like 2024-01-31T12:00:00Z or a date like 2024-01-31", http.StatusBadRequest)
+				return
+			}
+		}
+		before = t
+	}
+
+	// Hold the lock while listing so two migrations can't start at once
+	migration.mu.Lock()
+	defer migration.mu.Unlock()
+	if migration.progress.Running {
+		http.Error(w, "A migration is already running", http.StatusConflict)
+		return
+	}
+	targets, err := migrationTargets(r.Context(), r, wikis, r.URL.Query().Get("model"), before)
+	if err != nil {
+		log.Printf("Error starting a migration: %v", err)
+		http.Error(w, "Error listing the articles to migrate", http.StatusBadGateway)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	migration.cancel = cancel
+	migration.progress = Migration{Running: true, Started: time.Now(), Total: len(targets)}
+	log.Printf("Migration started for %d articles, %s apart", len(targets), interval)
+	go runMigration(ctx, targets, interval)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(migration.progress)
+}
+
+// adminStopMigrationHandler stops the migrate job, leaving the articles it
+// already wrote.
+func adminStopMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	migration.mu.Lock()
+	running, cancel := migration.progress.Running, migration.cancel
+	migration.mu.Unlock()
+	if !running {
+		http.Error(w, "No migration is running", http.StatusNotFound)
+		return
+	}
+	cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ModelProfile describes what a model can handle, as reported by /api/show.
+// It is used to size the generation so small models finish their articles
+// and large models write more than a stub.
+type ModelProfile struct {
+	ContextLength int
+	Parameters    float64 // in billions
+}
+
+type showResponse struct {
+	Details struct {
+		ParameterSize string `json:"parameter_size"`
+	} `json:"details"`
+	ModelInfo map[string]interface{} `json:"model_info"`
+}
+
+var (
+	modelProfiles   = map[string]ModelProfile{}
+	modelProfilesMu sync.Mutex
+)
+
+// modelProfileFor returns the cached profile for a model, fetching it from
+// Ollama the first time. An empty profile is returned if the model can't be
+// inspected, or another provider generates, which leaves the defaults
+// untouched.
+func modelProfileFor(model string) ModelProfile {
+	if generationProvider().Name() != "ollama" {
+		return ModelProfile{}
+	}
+	modelProfilesMu.Lock()
+	profile, ok := modelProfiles[model]
+	modelProfilesMu.Unlock()
+	if ok {
+		return profile
+	}
+
+	profile, err := fetchModelProfile(model)
+	if err != nil {
+		log.Printf("Error inspecting model '%s': %v", model, err)
+		return ModelProfile{}
+	}
+	log.Printf("Model '%s' has %.1fB parameters and a context length of %d", model, profile.Parameters, profile.ContextLength)
+
+	modelProfilesMu.Lock()
+	modelProfiles[model] = profile
+	modelProfilesMu.Unlock()
+	return profile
+}
+
+func fetchModelProfile(model string) (ModelProfile, error) {
+	jsonData, err := json.Marshal(map[string]string{"model": model})
+	if err != nil {
+		return ModelProfile{}, err
+	}
+
+	resp, err := http.Post(ollamaHostURL()+"/api/show", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return ModelProfile{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ModelProfile{}, fmt.Errorf("show returned status %d", resp.StatusCode)
+	}
+
+	var show showResponse
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return ModelProfile{}, err
+	}
+
+	var profile ModelProfile
+	profile.Parameters = parseParameterSize(show.Details.ParameterSize)
+	for key, value := range show.ModelInfo {
+		if strings.HasSuffix(key, ".context_length") {
+			if length, ok := value.(float64); ok {
+				profile.ContextLength = int(length)
+			}
+		}
+	}
+	return profile, nil
+}
+
+// parseParameterSize turns sizes like "7B", "1.0B" or "270M" into billions.
+func parseParameterSize(size string) float64 {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(size, "B"):
+		size = strings.TrimSuffix(size, "B")
+	case strings.HasSuffix(size, "M"):
+		size = strings.TrimSuffix(size, "M")
+		multiplier = 0.001
+	default:
+		return 0
+	}
+
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil {
+		return 0
+	}
+	return value * multiplier
+}
+
+// targetWords is the article length the model can comfortably finish.
+func (p ModelProfile) targetWords() int {
+	switch {
+	case p.Parameters == 0:
+		return 0
+	case p.Parameters < 3:
+		return 600
+	case p.Parameters < 10:
+		return 1200
+	default:
+		return 2000
+	}
+}
+
+// tune sets num_predict and num_ctx so the target length fits the model.
+func (p ModelProfile) tune(options *GenerateOptions) {
+	words := p.targetWords()
+	if words == 0 {
+		return
+	}
+
+	// Roughly two tokens per word leaves headroom for markdown
+	options.NumPredict = words * 2
+	if p.ContextLength > 0 {
+		// Leave room for the prompt on top of the output
+		options.NumCtx = min(p.ContextLength, options.NumPredict+1024)
+	}
+}
+
+// lengthHint is appended to the prompt to steer the article length.
+func (p ModelProfile) lengthHint() string {
+	words := p.targetWords()
+	if words == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\nAim for roughly %d words and make sure the article ends with a complete sentence.", words)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The navigation at the top of article pages is made of links the operator
+// picks with NAV_LINKS or a wiki's nav_links, Home and Back unless they're
+// set: the home, back and random buttons, articles of any kind, other pages
+// of the wiki and other sites. Readers can pin links of their own after
+// them, like favorite articles, from the article they're reading or from
+// their profile. There are no accounts, so a reader's links are kept in a
+// long lived cookie.
+
+const navCookie = "endless-wiki-nav"
+
+// navCookieAge is how long a reader's links are kept since they last changed
+// them.
+const navCookieAge = 365 * 24 * time.Hour
+
+// maxPinnedLinks caps how many links a reader may pin, and maxNavCookie how
+// long the cookie they're kept in may get.
+const (
+	maxPinnedLinks = 10
+	maxNavCookie   = 3800
+)
+
+// NavLink is a link in the navigation of article pages. It's a button, an
+// article of a kind or a URL, and is labelled after what it links to unless
+// it has a Label.
+type NavLink struct {
+	Label string `json:"label,omitempty"`
+	// Button is "home", "back" or "random"
+	Button string `json:"button,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Kind   string `json:"kind,omitempty"`
+	// URL is a path on the wiki or a page elsewhere
+	URL string `json:"url,omitempty"`
+}
+
+// navButtons are the buttons a link can be, with their labels.
+var navButtons = map[string]string{
+	"home":   "",
+	"back":   "Back",
+	"random": "Random article",
+}
+
+// defaultNavLinks are the links of wikis that haven't set their own.
+var defaultNavLinks = []NavLink{{Button: "home"}, {Button: "back"}}
+
+// navLinks are the links the wiki puts in its navigation.
+func (s *Settings) navLinks() []NavLink {
+	if len(s.NavLinks) == 0 {
+		return defaultNavLinks
+	}
+	return s.NavLinks
+}
+
+// parseNavLink parses a link like "random", "Ancient Rome", "portal:Science",
+// "/recent" or "https://blog.example.com", optionally labelled like
+// "Blog=https://blog.example.com".
+func parseNavLink(entry string) (NavLink, error) {
+	var link NavLink
+	if label, target, ok := strings.Cut(entry, "="); ok {
+		link.Label, entry = strings.TrimSpace(label), target
+	}
+	entry = strings.TrimSpace(entry)
+
+	if _, ok := navButtons[entry]; ok {
+		link.Button = entry
+	} else if validNavURL(entry) {
+		link.URL = entry
+	} else {
+		if kind, title, ok := strings.Cut(entry, ":"); ok {
+			if _, known := articleKinds[kind]; known {
+				link.Kind, entry = kind, title
+			}
+		}
+		title, err := normalizeTitle(entry)
+		if err != nil {
+			return NavLink{}, err
+		}
+		link.Title = title
+	}
+	return link, nil
+}
+
+// parseNavLinks parses a comma separated list of links.
+func parseNavLinks(list string) ([]NavLink, error) {
+	var links []NavLink
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		link, err := parseNavLink(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid link %q: %v", strings.TrimSpace(entry), err)
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// checkNavLinks checks that every link is one button, article or URL.
+func checkNavLinks(links []NavLink) error {
+	for _, link := range links {
+		switch {
+		case link.Button != "":
+			if _, ok := navButtons[link.Button]; !ok || link.Title != "" || link.URL != "" {
+				return fmt.Errorf("nav button %q must be home, back or random", link.Button)
+			}
+		case link.Title != "":
+			if _, ok := articleKinds[link.Kind]; link.Kind != "" && !ok {
+				return fmt.Errorf("nav links can't link to %q articles, there is no such kind", link.Kind)
+			}
+			if link.URL != "" {
+				return fmt.Errorf("nav link %q can't have both a title and a URL", link.Title)
+			}
+		case validNavURL(link.URL):
+		default:
+			return fmt.Errorf("every nav link needs a button, a title, a path on the wiki or a web address")
+		}
+	}
+	return nil
+}
+
+// validNavURL reports whether a link's URL is a path on the wiki or a web
+// address.
+func validNavURL(url string) bool {
+	local := strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "//") && !strings.HasPrefix(url, "/\\")
+	return local || strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// NavItem is a link of the navigation as a page shows it.
+type NavItem struct {
+	Label  string
+	URL    string
+	Button string
+	// Pinned is the index of a reader's own link, or -1 for the wiki's
+	Pinned int
+}
+
+// navItem works out where a link goes and what it says.
+func navItem(ctx context.Context, link NavLink) NavItem {
+	item := NavItem{Label: link.Label, Button: link.Button, Pinned: -1}
+	switch {
+	case link.Button == "home":
+		item.URL = "/"
+		if item.Label == "" {
+			item.Label = settingsFor(ctx).siteName()
+		}
+	case link.Button != "":
+		item.URL = "/" + link.Button
+		if item.Label == "" {
+			item.Label = navButtons[link.Button]
+		}
+	case link.Title != "":
+		item.URL = articlePath(link.Kind, link.Title)
+		if item.Label == "" {
+			item.Label = link.Title
+		}
+	default:
+		item.URL = link.URL
+		if item.Label == "" {
+			item.Label = strings.TrimPrefix(strings.TrimPrefix(link.URL, "https://"), "http://")
+		}
+	}
+	return item
+}
+
+// navFor is the navigation of an article page: the wiki's links, then the
+// reader's. Back goes to the article read before this one.
+func navFor(r *http.Request, title, kind string) []NavItem {
+	itemFor := func(link NavLink) NavItem {
+		item := navItem(r.Context(), link)
+		if item.Button == "back" {
+			item.URL = backPath(r, title, kind)
+		}
+		return item
+	}
+
+	var items []NavItem
+	for _, link := range settingsFor(r.Context()).navLinks() {
+		rememberTitle(r.Context(), link.Title)
+		items = append(items, itemFor(link))
+	}
+	for i, link := range pinnedLinks(r) {
+		rememberRequestedTitle(r.Context(), link.Title)
+		item := itemFor(link)
+		item.Pinned = i
+		items = append(items, item)
+	}
+	return items
+}
+
+// pinnedLinks are the links a reader pinned, from their cookie.
+func pinnedLinks(r *http.Request) []NavLink {
+	cookie, err := r.Cookie(navCookie)
+	if err != nil {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	var links []NavLink
+	if json.Unmarshal(data, &links) != nil || checkNavLinks(links) != nil || len(links) > maxPinnedLinks {
+		return nil
+	}
+	return links
+}
+
+// isPinned reports whether a reader pinned an article.
+func isPinned(r *http.Request, title, kind string) bool {
+	for _, link := range pinnedLinks(r) {
+		if link.Title == title && link.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// setPinnedLinks keeps a reader's links in their cookie. It has to be called
+// before anything is written to w.
+func setPinnedLinks(w http.ResponseWriter, r *http.Request, links []NavLink) error {
+	data, err := json.Marshal(links)
+	if err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(data)
+	if len(value) > maxNavCookie {
+		return fmt.Errorf("Those links are too long to keep, unpin some first")
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     navCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(navCookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   requestSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// navSaveHandler pins and unpins a reader's links, then sends them back to
+// the page they came from.
+func navSaveHandler(w http.ResponseWriter, r *http.Request) {
+	links := pinnedLinks(r)
+	switch r.FormValue("action") {
+	case "pin":
+		// Articles are pinned from their page by title, anything else is
+		// written out like a NAV_LINKS entry
+		var link NavLink
+		var err error
+		if title := r.FormValue("title"); title != "" {
+			link.Kind = r.FormValue("kind")
+			link.Title, err = normalizeTitle(title)
+		} else {
+			link, err = parseNavLink(r.FormValue("link"))
+		}
+		if err == nil {
+			err = checkNavLinks([]NavLink{link})
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(links, link) {
+			break
+		}
+		if len(links) >= maxPinnedLinks {
+			http.Error(w, fmt.Sprintf("You can pin at most %d links", maxPinnedLinks), http.StatusBadRequest)
+			return
+		}
+		links = append(links, link)
+	case "unpin":
+		title, kind := r.FormValue("title"), r.FormValue("kind")
+		index, err := strconv.Atoi(r.FormValue("index"))
+		kept := links[:0]
+		for i, link := range links {
+			if (err == nil && i == index) || (title != "" && link.Title == title && link.Kind == kind) {
+				continue
+			}
+			kept = append(kept, link)
+		}
+		links = kept
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if err := setPinnedLinks(w, r, links); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only back to a page of this wiki
+	back := r.FormValue("return")
+	if !strings.HasPrefix(back, "/") || !validNavURL(back) {
+		back = "/profile"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// randomHandler sends the reader to a random article the wiki has written:
+// one it stores or, without a store, one of those written lately, and one
+// of the home page's links on a wiki that hasn't written any yet.
+func randomHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var titles []string
+	if articles != nil {
+		stored, err := articles.List(ctx, wikiFrom(ctx).Name, "")
+		if err != nil {
+			alertAdmin("Error listing stored articles for a random one: %v", err)
+		}
+		titles = stored
+	} else {
+		titles = recentTitles(ctx)
+	}
+	if len(titles) == 0 {
+		for _, section := range settingsFor(ctx).homeSections() {
+			if section.Kind == "" {
+				titles = append(titles, section.Links...)
+			}
+		}
+	}
+
+	// A few tries to get past hidden articles
+	for try := 0; try < 5 && len(titles) > 0; try++ {
+		title := titles[rand.Intn(len(titles))]
+		if !articleHidden(ctx, title, "") {
+			rememberTitle(ctx, title)
+			http.Redirect(w, r, articlePath("", title), http.StatusFound)
+			return
+		}
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// recentTitles are the titles of the plain articles the wiki has written or
+// served lately.
+func recentTitles(ctx context.Context) []string {
+	prefix := articleMetaKey(ctx, "", "")
+
+	articleMetaMu.Lock()
+	defer articleMetaMu.Unlock()
+
+	var titles []string
+	for _, key := range articleMetaOrder {
+		if title, ok := strings.CutPrefix(key, prefix); ok {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
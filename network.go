@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Network access rules decide who may reach the instance at all, and which
+// proxies are trusted to say who a request really came from. Behind
+// Cloudflare or Traefik every request arrives from the proxy, so without
+// TRUSTED_PROXIES throttling, stream limits and the audit log would all see
+// one client.
+
+var (
+	allowedNetworks []netip.Prefix
+	deniedNetworks  []netip.Prefix
+	trustedProxies  []netip.Prefix
+)
+
+// loadNetworkRules reads ALLOW_IPS, DENY_IPS and TRUSTED_PROXIES. A typo in
+// an access rule shouldn't quietly open the instance, so invalid entries stop
+// startup.
+func loadNetworkRules() {
+	var err error
+	if allowedNetworks, err = parseNetworks(os.Getenv("ALLOW_IPS")); err != nil {
+		log.Fatalf("Error parsing ALLOW_IPS: %v", err)
+	}
+	if deniedNetworks, err = parseNetworks(os.Getenv("DENY_IPS")); err != nil {
+		log.Fatalf("Error parsing DENY_IPS: %v", err)
+	}
+	if trustedProxies, err = parseNetworks(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		log.Fatalf("Error parsing TRUSTED_PROXIES: %v", err)
+	}
+}
+
+// parseNetworks parses a comma separated list of CIDR ranges. Bare addresses
+// are taken as a range of one.
+func parseNetworks(list string) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", entry)
+		}
+		networks = append(networks, prefix.Masked())
+	}
+	return networks, nil
+}
+
+// inNetworks reports whether addr falls in any of networks.
+func inNetworks(addr netip.Addr, networks []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP identifies the client a request came from. When the request
+// arrives through a trusted proxy, X-Forwarded-For is walked from the right,
+// past any further trusted proxies, to the first address that isn't one.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !inNetworks(addr, trustedProxies) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// Anything left of a mangled entry can't be trusted either
+			break
+		}
+		host = hop.Unmap().String()
+		if !inNetworks(hop, trustedProxies) {
+			break
+		}
+	}
+	return host
+}
+
+// requestSecure reports whether a request reached the instance over HTTPS,
+// so cookies set in answer to it are marked Secure. Behind a trusted proxy
+// that terminates TLS the request arrives over plain HTTP, so the proxy's
+// X-Forwarded-Proto is believed, the last one being the one it set.
+// SECURE_COOKIES marks them Secure whatever the request says.
+func requestSecure(r *http.Request) bool {
+	if r.TLS != nil || envBool("SECURE_COOKIES", false) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !inNetworks(addr, trustedProxies) {
+		return false
+	}
+	protos := strings.Split(strings.Join(r.Header.Values("X-Forwarded-Proto"), ","), ",")
+	return strings.EqualFold(strings.TrimSpace(protos[len(protos)-1]), "https")
+}
+
+// withNetworkRules turns away clients outside ALLOW_IPS or inside DENY_IPS.
+func withNetworkRules(next http.Handler) http.Handler {
+	if len(allowedNetworks) == 0 && len(deniedNetworks) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientIP(r)
+		addr, err := netip.ParseAddr(client)
+		if err != nil || inNetworks(addr, deniedNetworks) || (len(allowedNetworks) > 0 && !inNetworks(addr, allowedNetworks)) {
+			audit(AuditEvent{Event: "denied", Client: client, Detail: r.Method + " " + r.URL.Path})
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	saved := trustedProxies
+	defer func() { trustedProxies = saved }()
+	trustedProxies = []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"no proxy", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted peer's header is ignored", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy without the header", "10.0.0.2:4000", nil, "10.0.0.2"},
+		{"spoofed leftmost entries", "10.0.0.2:4000", []string{"1.2.3.4, 5.6.7.8, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:4000", []string{"198.51.100.1, 10.1.1.1, 10.2.2.2"}, "198.51.100.1"},
+		{"spoof behind a chain", "10.0.0.2:4000", []string{"1.2.3.4, 198.51.100.1, 10.1.1.1"}, "198.51.100.1"},
+		{"headers repeated", "10.0.0.2:4000", []string{"1.2.3.4", "198.51.100.1, 10.1.1.1"}, "198.51.100.1"},
+		{"every hop trusted", "10.0.0.2:4000", []string{"10.3.3.3, 10.1.1.1"}, "10.3.3.3"},
+		{"mangled entry stops the walk", "10.0.0.2:4000", []string{"1.2.3.4, not-an-ip, 10.1.1.1"}, "10.1.1.1"},
+		{"mangled last entry", "10.0.0.2:4000", []string{"1.2.3.4, garbage"}, "10.0.0.2"},
+		{"IPv6 proxy and client", "[2001:db8::1]:4000", []string{"2001:db8:ffff::9, 2001:db8::2"}, "2001:db8:ffff::9"},
+		{"IPv6 client through an IPv4 proxy", "10.0.0.2:4000", []string{"2606:4700::1"}, "2606:4700::1"},
+		{"IPv4 mapped client", "10.0.0.2:4000", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"IPv4 mapped proxy", "[::ffff:10.0.0.2]:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"remote address without a port", "10.0.0.2", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remote
+			for _, value := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r); got != test.want {
+				t.Errorf("clientIP = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks("10.0.0.0/8, 192.0.2.1 ,2001:db8::/32,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 3 {
+		t.Fatalf("parsed %v, want 3 networks", networks)
+	}
+	for _, addr := range []string{"10.9.9.9", "192.0.2.1", "::ffff:192.0.2.1", "2001:db8::5"} {
+		if !inNetworks(netip.MustParseAddr(addr), networks) {
+			t.Errorf("%s isn't in %v", addr, networks)
+		}
+	}
+	for _, addr := range []string{"11.0.0.1", "192.0.2.2", "2001:db9::1"} {
+		if inNetworks(netip.MustParseAddr(addr), networks) {
+			t.Errorf("%s is in %v", addr, networks)
+		}
+	}
+
+	for _, list := range []string{"10.0.0.0/33", "example.com", "10.0.0.1-10.0.0.9"} {
+		if _, err := parseNetworks(list); err == nil {
+			t.Errorf("parseNetworks(%q) succeeded", list)
+		}
+	}
+}
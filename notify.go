@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Watchlist notifications go out as email alerts, to watchers who opted in,
+// and to webhooks as a JSON POST. Webhooks are given by readers, so they may
+// only reach public addresses unless WEBHOOK_ALLOW_PRIVATE is true, or anyone
+// could have the instance probe the network it runs in.
+
+// maxDeliveries caps how many emails and webhook posts each watcher is sent
+// per deliveryWindow.
+const (
+	maxDeliveries  = 20
+	deliveryWindow = time.Hour
+)
+
+// notifyTimeout bounds sending one notification.
+const notifyTimeout = 30 * time.Second
+
+// cgnatNetwork is the carrier-grade NAT range, private in all but name.
+var cgnatNetwork = netip.MustParsePrefix("100.64.0.0/10")
+
+// delivery is a notification on its way to a watcher's email or webhook.
+type delivery struct {
+	site         string
+	email        string
+	unsubscribe  string
+	webhook      string
+	notification Notification
+}
+
+// allowDelivery reports whether a watcher may be sent another email or
+// webhook post this window, counting it if so. Callers hold watchers.mu.
+func (w *Watcher) allowDelivery() bool {
+	now := time.Now()
+	kept := w.sent[:0]
+	for _, sent := range w.sent {
+		if now.Sub(sent) < deliveryWindow {
+			kept = append(kept, sent)
+		}
+	}
+	w.sent = kept
+	if len(w.sent) >= maxDeliveries {
+		return false
+	}
+	w.sent = append(w.sent, now)
+	return true
+}
+
+func (d delivery) send() {
+	n := d.notification
+	if d.email != "" && emailEnabled() {
+		data := struct {
+			Site         string
+			Notification Notification
+			Watchlist    string
+			Unsubscribe  string
+		}{d.site, n, publicLink("/watchlist"), d.unsubscribe}
+		if err := sendTemplatedEmail(d.email, "alert", d.unsubscribe, data); err != nil {
+			logWatchError("emailing", n, err)
+		}
+	}
+	if d.webhook != "" {
+		if err := postWebhook(d.webhook, d.site, n); err != nil {
+			logWatchError("posting a webhook for", n, err)
+		}
+	}
+}
+
+// logWatchError logs a failed delivery. Where it was going to is left out,
+// the address or webhook is the watcher's own business.
+func logWatchError(doing string, n Notification, err error) {
+	log.Printf("Error %s a watchlist notification about '%s': %v", doing, n.Title, err)
+}
+
+// validWebhook reports whether a webhook is a URL that can be posted to.
+func validWebhook(webhook string) bool {
+	u, err := url.Parse(webhook)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// webhookClient posts to webhooks, refusing to connect to addresses that
+// aren't public. The check runs on the address actually dialed, so neither
+// DNS nor redirects can get around it.
+var webhookClient = &http.Client{
+	Timeout: notifyTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: notifyTimeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				if envBool("WEBHOOK_ALLOW_PRIVATE", false) {
+					return nil
+				}
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				addr := addrPort.Addr().Unmap()
+				if !addr.IsGlobalUnicast() || addr.IsPrivate() || cgnatNetwork.Contains(addr) {
+					return fmt.Errorf("webhooks can't reach %s, it isn't a public address", addr)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: notifyTimeout,
+	},
+}
+
+// postWebhook posts a notification to a webhook as JSON.
+func postWebhook(webhook, site string, n Notification) error {
+	payload, err := json.Marshal(struct {
+		Site    string `json:"site"`
+		Message string `json:"message"`
+		Notification
+	}{site, n.Message(), n})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The ollama provider generates with the ollama server at OLLAMA_HOST, the
+// default, or spreads generations over several, see hosts.go. Models are
+// pulled at startup, inspected to size articles to them, and can be kept
+// loaded, see preload.go. Everything goes through the chat endpoint, so the
+// wiki's system prompt is sent as a system message of its own.
+
+type OllamaRequest struct {
+	Model    string           `json:"model"`
+	Messages []OllamaMessage  `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Format   string           `json:"format,omitempty"`
+	Options  *GenerateOptions `json:"options,omitempty"`
+	// KeepAlive is how long ollama keeps the model loaded afterwards
+	KeepAlive string `json:"keep_alive,omitempty"`
+}
+
+type OllamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type OllamaResponse struct {
+	Message    OllamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	// EvalCount is how many tokens were generated, sent with the last piece
+	EvalCount int `json:"eval_count"`
+	// Error is why ollama gave up on the generation midway
+	Error string `json:"error"`
+}
+
+type ollamaProvider struct{}
+
+func (ollamaProvider) Name() string { return "ollama" }
+
+func (ollamaProvider) Host() string { return strings.Join(ollamaHosts(), ", ") }
+
+// ollamaMessages are the messages of a chat asking a prompt, under a system
+// prompt if there is one.
+func ollamaMessages(system, prompt string) []OllamaMessage {
+	var messages []OllamaMessage
+	if system != "" {
+		messages = append(messages, OllamaMessage{Role: "system", Content: system})
+	}
+	return append(messages, OllamaMessage{Role: "user", Content: prompt})
+}
+
+// errNoOllamaHost is returned when none of the ollama hosts can be reached.
+var errNoOllamaHost = errors.New("no ollama host could be reached")
+
+// generate sends a chat request to the ollama host picked for it, moving
+// on to the next when one can't be reached. The generation counts towards
+// how busy its host is until release is called. There is a response unless
+// there is an error.
+func (ollamaProvider) generate(ctx context.Context, request OllamaRequest) (resp *http.Response, host string, release func(), err error) {
+	touchModel(request.Model)
+	request.KeepAlive = keepAliveParam(request.Model)
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, "", func() {}, err
+	}
+
+	var lastErr error
+	for range ollamaHosts() {
+		host, release = pickOllamaHost()
+		// Create HTTP request with context for cancellation
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, "POST", host+"/api/chat", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, host, release, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		client := &http.Client{}
+		resp, err = client.Do(req)
+		if err == nil || ctx.Err() != nil {
+			return resp, host, release, err
+		}
+		lastErr = err
+		hostUnreachable(ctx, host, err)
+		release()
+	}
+	return nil, host, func() {}, fmt.Errorf("%w: %v", errNoOllamaHost, lastErr)
+}
+
+func (p ollamaProvider) Stream(ctx context.Context, model, system, prompt string, options *GenerateOptions) <-chan Chunk {
+	chunks := make(chan Chunk)
+	go func() {
+		defer close(chunks)
+
+		resp, host, release, err := p.generate(ctx, OllamaRequest{Model: model, Messages: ollamaMessages(system, prompt), Stream: true, Options: options})
+		defer release()
+		if err == nil && resp == nil {
+			err = errNoOllamaHost
+		}
+		if err != nil {
+			sendChunk(ctx, chunks, Chunk{Err: err})
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			sendChunk(ctx, chunks, Chunk{Err: ollamaStatusError(resp, host, model)})
+			return
+		}
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var ollamaResp OllamaResponse
+			if err := decoder.Decode(&ollamaResp); err != nil {
+				switch {
+				case ctx.Err() != nil:
+					sendChunk(ctx, chunks, Chunk{Err: ctx.Err()})
+				case err == io.EOF:
+					sendChunk(ctx, chunks, Chunk{Err: errors.New("ollama stream ended early")})
+				default:
+					sendChunk(ctx, chunks, Chunk{Err: fmt.Errorf("reading the ollama stream: %v", err)})
+				}
+				return
+			}
+			if ollamaResp.Error != "" {
+				sendChunk(ctx, chunks, Chunk{Err: fmt.Errorf("ollama stopped generating: %s", ollamaResp.Error)})
+				return
+			}
+
+			chunk := Chunk{Text: ollamaResp.Message.Content}
+			if ollamaResp.Done {
+				chunk.Done, chunk.DoneReason, chunk.Tokens = true, ollamaResp.DoneReason, ollamaResp.EvalCount
+			}
+			if (chunk.Text != "" || chunk.Done) && !sendChunk(ctx, chunks, chunk) {
+				return
+			}
+			if chunk.Done {
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+// GenerateJSON uses ollama's JSON mode.
+func (p ollamaProvider) GenerateJSON(ctx context.Context, model, prompt string, v interface{}) error {
+	resp, host, release, err := p.generate(ctx, OllamaRequest{Model: model, Messages: ollamaMessages("", prompt), Format: "json"})
+	defer release()
+	if err == nil && resp == nil {
+		err = errNoOllamaHost
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ollamaStatusError(resp, host, model)
+	}
+
+	var ollamaResp OllamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(stripReasoning(ollamaResp.Message.Content)), v)
+}
+
+// Up asks each ollama host for its models, until one answers.
+func (ollamaProvider) Up(ctx context.Context) bool {
+	for _, host := range ollamaHosts() {
+		if ollamaUp(ctx, host) {
+			return true
+		}
+	}
+	return false
+}
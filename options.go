@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The options ollama generates articles with can be set for every article
+// with environment variables named after them, like OLLAMA_TEMPERATURE or
+// OLLAMA_NUM_CTX, and for one article with query parameters on the stream
+// URL, like ?temperature=0.2. Either takes the place of the context and
+// length the article is sized to for its model, and the query parameters
+// take the place of the environment. Anyone can set the query parameters, so
+// they can only shrink the context and length, not grow them past what the
+// operator allows, which could have ollama run out of memory. Like another
+// model or seed, an article asked for with options of its own is always
+// written afresh.
+
+// generationOptions are the options that can be set, as ollama names them.
+var generationOptions = []string{"num_ctx", "num_predict", "temperature", "top_p", "top_k", "min_p", "repeat_penalty"}
+
+// maxOptions are the largest values options can be set to.
+var maxOptions = map[string]float64{
+	"temperature":    2,
+	"top_p":          1,
+	"top_k":          1000,
+	"min_p":          1,
+	"repeat_penalty": 2,
+}
+
+// defaultMaxNumCtx and defaultMaxNumPredict are the largest context and
+// length a request may ask for when the model's size isn't known and the
+// environment doesn't set them.
+const (
+	defaultMaxNumCtx     = 8192
+	defaultMaxNumPredict = 4096
+)
+
+// setOption sets one of generationOptions from its text.
+func (o *GenerateOptions) setOption(name, value string) error {
+	switch name {
+	case "num_ctx", "num_predict", "top_k":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%s must be a whole number above zero", name)
+		}
+		if limit, ok := maxOptions[name]; ok && float64(n) > limit {
+			return fmt.Errorf("%s can be at most %v", name, limit)
+		}
+		switch name {
+		case "num_ctx":
+			o.NumCtx = n
+		case "num_predict":
+			o.NumPredict = n
+		default:
+			o.TopK = n
+		}
+	default:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("%s must be a number of at least zero", name)
+		}
+		if limit := maxOptions[name]; f > limit {
+			return fmt.Errorf("%s can be at most %v", name, limit)
+		}
+		switch name {
+		case "temperature":
+			o.Temperature = &f
+		case "top_p":
+			o.TopP = &f
+		case "min_p":
+			o.MinP = &f
+		default:
+			o.RepeatPenalty = &f
+		}
+	}
+	return nil
+}
+
+// optionEnv is the environment variable that sets an option.
+func optionEnv(name string) string {
+	return "OLLAMA_" + strings.ToUpper(name)
+}
+
+// checkOptionEnv checks the options set with environment variables.
+func checkOptionEnv() error {
+	var options GenerateOptions
+	for _, name := range generationOptions {
+		if value := os.Getenv(optionEnv(name)); value != "" {
+			if err := options.setOption(name, value); err != nil {
+				return fmt.Errorf("%s: %v", optionEnv(name), err)
+			}
+		}
+	}
+	return nil
+}
+
+// setRequestOptions sets the options of an article from the environment,
+// then from the query parameters of the request for it. The context and
+// length it asks for are capped at what the article is sized to or the
+// environment sets.
+func setRequestOptions(options *GenerateOptions, r *http.Request) error {
+	for _, name := range generationOptions {
+		if value := os.Getenv(optionEnv(name)); value != "" {
+			// Checked when the settings were loaded
+			options.setOption(name, value)
+		}
+	}
+
+	maxNumCtx, maxNumPredict := options.NumCtx, options.NumPredict
+	if maxNumCtx == 0 {
+		maxNumCtx = defaultMaxNumCtx
+	}
+	if maxNumPredict == 0 {
+		maxNumPredict = defaultMaxNumPredict
+	}
+	query := r.URL.Query()
+	for _, name := range generationOptions {
+		if value := query.Get(name); value != "" {
+			if err := options.setOption(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	options.NumCtx = min(options.NumCtx, maxNumCtx)
+	options.NumPredict = min(options.NumPredict, maxNumPredict)
+	return nil
+}
+
+// hasOptionParams reports whether a request sets options of its own.
+func hasOptionParams(r *http.Request) bool {
+	query := r.URL.Query()
+	for _, name := range generationOptions {
+		if query.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
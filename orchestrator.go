@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// An article's extras are only worked out once its prose has finished, and
+// after its generation slot is handed back, so they never hold up anyone's
+// first paragraph. Extras shown beside the article run straight away, side by
+// side, and stream to the page as each finishes. Extras further down the
+// page are left until the reader scrolls near them, when the page fetches
+// them from /api/extras.
+
+// lazyExtraLifetime is how long a page has to ask for a lazy extra.
+const lazyExtraLifetime = 10 * time.Minute
+
+// maxLazyExtras caps how many lazy extras are waiting to be asked for.
+const maxLazyExtras = 1000
+
+// extraTask works out one extra, returning nil if there's nothing to show.
+type extraTask func(ctx context.Context) interface{}
+
+type lazyExtra struct {
+	// wiki and article are what the extra goes with, so the article's share
+	// links cover it
+	wiki    string
+	article string
+
+	mu      sync.Mutex
+	run     extraTask
+	result  interface{}
+	done    bool
+	expires time.Time
+}
+
+var lazyExtras = struct {
+	mu    sync.Mutex
+	tasks map[string]*lazyExtra
+}{
+	tasks: map[string]*lazyExtra{},
+}
+
+// eventWriter lets several extras send events on one stream at once.
+type eventWriter struct {
+	http.ResponseWriter
+	mu sync.Mutex
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *eventWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// runExtras runs the eager extras of an article in parallel, sending each
+// one's result as an event named after it, and returns once all are done.
+// Lazy extras are announced in a single "lazy" event mapping their names to
+// ids for /api/extras. article names the article, as shareArticle does.
+func runExtras(ctx context.Context, w http.ResponseWriter, article string, eager, lazy map[string]extraTask) {
+	events := &eventWriter{ResponseWriter: w}
+
+	if len(lazy) > 0 {
+		ids := map[string]string{}
+		for name, task := range lazy {
+			if id, err := deferExtra(task, wikiFrom(ctx).Name, article); err == nil {
+				ids[name] = id
+			}
+		}
+		sendJSONEvent(events, "lazy", ids)
+	}
+
+	var wg sync.WaitGroup
+	for name, task := range eager {
+		wg.Add(1)
+		go func(name string, task extraTask) {
+			defer wg.Done()
+			if result := task(ctx); result != nil && ctx.Err() == nil {
+				sendJSONEvent(events, name, result)
+			}
+		}(name, task)
+	}
+	wg.Wait()
+}
+
+// deferExtra keeps a task of an article until a page asks for it, and
+// returns its id.
+func deferExtra(task extraTask, wiki, article string) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	lazyExtras.mu.Lock()
+	defer lazyExtras.mu.Unlock()
+
+	for id, extra := range lazyExtras.tasks {
+		if now.After(extra.expires) {
+			delete(lazyExtras.tasks, id)
+		}
+	}
+	if len(lazyExtras.tasks) >= maxLazyExtras {
+		return "", fmt.Errorf("too many extras waiting")
+	}
+	lazyExtras.tasks[id] = &lazyExtra{wiki: wiki, article: article, run: task, expires: now.Add(lazyExtraLifetime)}
+	return id, nil
+}
+
+// extraArticle returns the wiki and article a lazy extra goes with.
+func extraArticle(id string) (wiki, article string, ok bool) {
+	lazyExtras.mu.Lock()
+	defer lazyExtras.mu.Unlock()
+	extra, ok := lazyExtras.tasks[id]
+	if !ok {
+		return "", "", false
+	}
+	return extra.wiki, extra.article, true
+}
+
+// extraHandler works out a lazy extra the first time a page asks for it.
+func extraHandler(w http.ResponseWriter, r *http.Request) {
+	lazyExtras.mu.Lock()
+	extra, ok := lazyExtras.tasks[mux.Vars(r)["id"]]
+	lazyExtras.mu.Unlock()
+	if !ok || time.Now().After(extra.expires) {
+		http.Error(w, "That extra has expired or never existed", http.StatusNotFound)
+		return
+	}
+
+	// Work it out once, however many times the page asks
+	extra.mu.Lock()
+	if !extra.done {
+		extra.result = extra.run(r.Context())
+		extra.done = r.Context().Err() == nil
+	}
+	result := extra.result
+	extra.mu.Unlock()
+
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Error encoding extra: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A remote backend, or a busy ollama, tends to send text in bursts: nothing
+// for a second, then a whole paragraph at once. Sent on as it comes, the page
+// jumps in fits and starts and renders the markdown again for every little
+// chunk in between. With STREAM_RATE the article is sent at most that many
+// times a second instead, and a burst is spread over the next few updates,
+// each showing half of what's still to come, a word at a time, so the text
+// flows at an even pace whatever the backend does. Everything left is sent as
+// soon as the article is finished.
+//
+// Every update carries the whole article so far, which adds up on a bad
+// mobile connection. Updates are sent apart from the generation, so one the
+// reader is slow to take never holds it up, and whatever is written in the
+// meantime goes out together with the next. Once sending an update takes
+// longer than slowSendThreshold, the reader is taken for a slow one and only
+// sent the article a paragraph at a time from then on, so they keep up with
+// the stream rather than fall behind it and time out.
+
+// slowSendThreshold is how long sending an update may take before the
+// reader counts as slow.
+const slowSendThreshold = 500 * time.Millisecond
+
+// contentPacer sends the text of an article being written at a steady pace.
+type contentPacer struct {
+	send func(content string)
+	// rate is how many updates a second are sent, or zero for as fast as
+	// the reader takes them
+	rate int
+
+	mu     sync.Mutex
+	latest string
+
+	// shown and slow belong to the goroutine sending
+	shown string
+	slow  bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newContentPacer sends the content it's updated with using send, paced by
+// STREAM_RATE.
+func newContentPacer(send func(content string)) *contentPacer {
+	p := &contentPacer{
+		send: send,
+		rate: envInt("STREAM_RATE", 0),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.done)
+		var tick <-chan time.Time
+		if p.rate > 0 {
+			ticker := time.NewTicker(time.Second / time.Duration(p.rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-tick:
+			case <-p.wake:
+				if p.rate > 0 {
+					continue
+				}
+			case <-p.stop:
+				return
+			}
+			p.step()
+		}
+	}()
+	return p
+}
+
+// update hands the pacer the article as it stands.
+func (p *contentPacer) update(content string) {
+	p.mu.Lock()
+	p.latest = content
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// step sends what hasn't been shown yet: half of it, finishing the word it
+// ends in, when paced, and only up to the last whole paragraph for a slow
+// reader.
+func (p *contentPacer) step() {
+	p.mu.Lock()
+	next := p.latest
+	// Trim rules can take back text already shown, which is then replaced
+	// all at once
+	if p.rate > 0 && strings.HasPrefix(p.latest, p.shown) && p.latest != p.shown {
+		cut := len(p.shown) + (len(p.latest)-len(p.shown)+1)/2
+		if end := strings.IndexAny(p.latest[cut:], " \n"); end >= 0 {
+			next = p.latest[:cut+end]
+		}
+	}
+	if p.slow {
+		end := strings.LastIndex(next, "\n\n")
+		if end < len(p.shown) {
+			p.mu.Unlock()
+			return
+		}
+		next = next[:end+2]
+	}
+	if next == p.shown {
+		p.mu.Unlock()
+		return
+	}
+	p.shown = next
+	p.mu.Unlock()
+
+	start := time.Now()
+	p.send(next)
+	if time.Since(start) > slowSendThreshold && !p.slow {
+		log.Printf("Reader is slow to take the stream, sending it a paragraph at a time")
+		p.slow = true
+	}
+}
+
+// close stops pacing and sends whatever hasn't been shown yet.
+func (p *contentPacer) close() {
+	close(p.stop)
+	<-p.done
+
+	if p.latest != p.shown {
+		p.shown = p.latest
+		p.send(p.latest)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Ollama loads a model into memory on the first request for it and unloads
+// it once it has sat unused for a while, five minutes unless it's told
+// otherwise. The first reader after a quiet spell then waits for the model
+// to load, which takes up to a minute for a large one. With PRELOAD the
+// model of every wiki is loaded at startup, with a tiny request asking ollama
+// to keep it for KEEP_ALIVE, and loaded again before that runs out whenever
+// it goes unused, so a reader never finds it cold. KEEP_ALIVE is also asked
+// for with every generation when it's set.
+//
+// On a GPU shared with other work, keeping a model loaded all the time
+// wastes memory the rest could use. KEEP_ALIVE=auto asks for longer the
+// busier the model has been: KEEP_ALIVE_MIN after a lone generation, rising
+// with the generations of the last quarter of an hour to KEEP_ALIVE_MAX once
+// readers are browsing steadily. A burst of reading keeps the model around
+// for the next reader, and a quiet spell lets it go soon after. Preloading
+// then loads the models at startup only.
+
+// defaultKeepAlive is how long preloaded models are kept without KEEP_ALIVE.
+const defaultKeepAlive = 30 * time.Minute
+
+// preloadCheckInterval is how often models are checked for going idle.
+const preloadCheckInterval = time.Minute
+
+// preloadTimeout bounds loading a model, which can take a while from disk.
+const preloadTimeout = 5 * time.Minute
+
+// adaptiveWindow is how far back KEEP_ALIVE=auto looks at how busy a model
+// is, and adaptiveBusy how many generations in it count as steady browsing.
+const (
+	adaptiveWindow = 15 * time.Minute
+	adaptiveBusy   = 20
+)
+
+var modelUse = struct {
+	mu     sync.Mutex
+	last   map[string]time.Time
+	recent map[string][]time.Time
+}{
+	last:   map[string]time.Time{},
+	recent: map[string][]time.Time{},
+}
+
+// touchModel notes that a model was just used, which keeps it loaded.
+func touchModel(model string) {
+	modelUse.mu.Lock()
+	defer modelUse.mu.Unlock()
+
+	now := time.Now()
+	modelUse.last[model] = now
+	recent := modelUse.recent[model]
+	for len(recent) > 0 && (now.Sub(recent[0]) > adaptiveWindow || len(recent) >= adaptiveBusy) {
+		recent = recent[1:]
+	}
+	modelUse.recent[model] = append(recent, now)
+}
+
+// adaptiveKeepAlive reports whether KEEP_ALIVE=auto.
+func adaptiveKeepAlive() bool {
+	return os.Getenv("KEEP_ALIVE") == "auto"
+}
+
+// keepAliveParam is the keep_alive to ask ollama for on generations with a
+// model, or empty for its default.
+func keepAliveParam(model string) string {
+	switch {
+	case os.Getenv("KEEP_ALIVE") == "":
+		return ""
+	case adaptiveKeepAlive():
+		return keepAliveFor(model).String()
+	}
+	return envDuration("KEEP_ALIVE", defaultKeepAlive).String()
+}
+
+// keepAliveFor works out how long to keep a model by how busy it has been
+// lately.
+func keepAliveFor(model string) time.Duration {
+	least := envDuration("KEEP_ALIVE_MIN", 2*time.Minute)
+	most := envDuration("KEEP_ALIVE_MAX", time.Hour)
+
+	modelUse.mu.Lock()
+	uses := 0
+	for _, used := range modelUse.recent[model] {
+		if time.Since(used) <= adaptiveWindow {
+			uses++
+		}
+	}
+	modelUse.mu.Unlock()
+
+	if uses <= 1 || most <= least {
+		return least
+	}
+	// Not counting the generation asking
+	busy := float64(uses-1) / float64(adaptiveBusy-1)
+	if busy > 1 {
+		busy = 1
+	}
+	return (least + time.Duration(busy*float64(most-least))).Round(time.Second)
+}
+
+// wikiModels lists the models of every wiki, each once: its own, its
+// fallbacks, its routing classifier and those it routes to.
+func wikiModels() []string {
+	var models []string
+	seen := map[string]bool{}
+	add := func(model string) {
+		if !seen[model] {
+			seen[model] = true
+			models = append(models, model)
+		}
+	}
+	for _, wiki := range allWikis() {
+		add(wiki.Settings.Model)
+		for _, model := range wiki.Settings.Fallbacks {
+			add(model)
+		}
+		if len(wiki.Settings.Routes) == 0 {
+			continue
+		}
+		add(wiki.Settings.routingModel())
+		for _, model := range wiki.Settings.Routes {
+			add(model)
+		}
+	}
+	return models
+}
+
+// configuredModel reports whether a wiki is configured to use a model, the
+// only models readers may ask for and that are pulled when missing.
+func configuredModel(model string) bool {
+	return slices.Contains(wikiModels(), model)
+}
+
+// startPreload loads the models of the wikis and keeps them loaded, if
+// PRELOAD is set.
+func startPreload() {
+	if !envBool("PRELOAD", false) || generationProvider().Name() != "ollama" {
+		return
+	}
+	keepAlive := defaultKeepAlive
+	if adaptiveKeepAlive() {
+		keepAlive = envDuration("KEEP_ALIVE_MAX", time.Hour)
+	} else {
+		keepAlive = envDuration("KEEP_ALIVE", keepAlive)
+	}
+
+	go func() {
+		for {
+			for _, model := range wikiModels() {
+				modelUse.mu.Lock()
+				last := modelUse.last[model]
+				modelUse.mu.Unlock()
+
+				// Reload a little before ollama would unload it. A negative
+				// keep alive keeps it for good, so it's loaded only once, and
+				// an adaptive one lets it go when it's idle
+				idle := keepAlive > 0 && !adaptiveKeepAlive() && time.Since(last) >= keepAlive-preloadCheckInterval
+				if last.IsZero() || idle {
+					if err := preloadModel(model, keepAlive); err != nil {
+						log.Printf("Error preloading model '%s': %v", model, err)
+					}
+				}
+			}
+			time.Sleep(preloadCheckInterval)
+		}
+	}()
+}
+
+// preloadModel has every ollama host load a model and keep it for
+// keepAlive, without generating anything.
+func preloadModel(model string, keepAlive time.Duration) error {
+	jsonData, err := json.Marshal(OllamaRequest{Model: model, KeepAlive: keepAlive.String()})
+	if err != nil {
+		return err
+	}
+
+	hosts := ollamaHosts()
+	var errs []error
+	for _, host := range hosts {
+		start := time.Now()
+		client := &http.Client{Timeout: preloadTimeout}
+		resp, err := client.Post(host+"/api/chat", "application/json", bytes.NewReader(jsonData))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			errs = append(errs, fmt.Errorf("ollama at '%s' answered %s", host, resp.Status))
+			continue
+		}
+		log.Printf("Preloaded model '%s' at '%s' in %s", model, host, time.Since(start).Round(time.Millisecond))
+	}
+
+	if len(errs) < len(hosts) {
+		touchModel(model)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Setting WIKI_PASSWORD makes an instance private: every page asks for the
+// password with HTTP basic auth. Readers who know it can mint share links
+// giving read-only access to one article or replay for a few days, so
+// content can be shown to others without opening the whole wiki. Links are
+// signed with the password, so changing it revokes every link. A link is for
+// one kind of article, and reads it as the wiki writes it: the model, seed
+// and generation options of a request through a share link are dropped, so
+// it can't be used to run generations of the holder's choosing.
+
+// maxShareDays is the longest a share link may last.
+const maxShareDays = 90
+
+// publicPaths have their own authentication, or none is needed to use them.
+var publicPaths = []string{
+	"/admin", "/discord/interactions", "/gate",
+	"/static", "/theme.css", "/manifest.webmanifest", "/favicon.ico", "/apple-touch-icon.png", "/icons",
+}
+
+// shareScopes maps the first path segment of a shareable page to what a
+// share link for it covers. An article link also covers its stream, raw
+// text and extras, a replay link the replay's data.
+var shareScopes = map[string]string{
+	"wiki":       "article",
+	"stream":     "article",
+	"raw":        "article",
+	"portal":     "article",
+	"dictionary": "article",
+	"how-to":     "article",
+	"news":       "article",
+	"replay":     "replay",
+	"api/replay": "replay",
+}
+
+func wikiPassword() string {
+	return os.Getenv("WIKI_PASSWORD")
+}
+
+func shareKey() []byte {
+	key := sha256.Sum256([]byte("share:" + wikiPassword()))
+	return key[:]
+}
+
+// sharePurpose is what a share token is signed for: one article or replay on
+// one wiki.
+func sharePurpose(wiki, scope, name string) string {
+	return "share:" + wiki + ":" + scope + ":" + name
+}
+
+// shareArticle names an article for share links, by its kind and slug.
+func shareArticle(kind, title string) string {
+	return kind + ":" + slugify(title)
+}
+
+// sharedScope returns what a shareable page is for, by its path and query,
+// like "article" and ":moon" for /wiki/moon. Articles go by their kind and
+// slug, so a link shared from the page also lets its stream be read by
+// title. The kind is the page's own, or the one the stream or raw text asks
+// for.
+func sharedScope(path string, query url.Values) (scope, name string, ok bool) {
+	path = strings.TrimPrefix(path, "/")
+	for prefix, scope := range shareScopes {
+		rest, found := strings.CutPrefix(path, prefix+"/")
+		// Only the slugs of sub-articles have slashes of their own, the
+		// titles they were made from keep them encoded
+		if !found || rest == "" || (scope != "article" && strings.Contains(rest, "/")) {
+			continue
+		}
+		name, err := url.PathUnescape(rest)
+		if err != nil {
+			return "", "", false
+		}
+		if scope == "article" {
+			kind, isKind := articleKinds[prefix]
+			if !isKind {
+				kind = articleKinds[query.Get("kind")]
+			}
+			name = shareArticle(kind.Name, name)
+		}
+		return scope, name, true
+	}
+	return "", "", false
+}
+
+// hasShare reports whether a request carries a share link for the page it
+// asks for.
+func hasShare(r *http.Request) bool {
+	token := r.URL.Query().Get("share")
+	if token == "" || r.Method != http.MethodGet {
+		return false
+	}
+	wiki := wikiFrom(r.Context()).Name
+	scope, name, ok := sharedScope(r.URL.EscapedPath(), r.URL.Query())
+	if !ok {
+		// The lazy extras of an article go with its share link
+		id, isExtra := strings.CutPrefix(r.URL.Path, "/api/extras/")
+		if !isExtra {
+			return false
+		}
+		if wiki, name, ok = extraArticle(id); !ok {
+			return false
+		}
+		scope = "article"
+	}
+	return validUntil(shareKey(), sharePurpose(wiki, scope, name), token)
+}
+
+// sharedQuery is what is left of a request's query when it's let in by a
+// share link: the link and the kind of article, which the link is for.
+func sharedQuery(query url.Values) url.Values {
+	shared := url.Values{}
+	for _, name := range []string{"share", "kind"} {
+		if value := query.Get(name); value != "" {
+			shared.Set(name, value)
+		}
+	}
+	return shared
+}
+
+// isReader reports whether a request has the wiki password.
+func isReader(r *http.Request) bool {
+	_, given, ok := r.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(wikiPassword())) == 1
+}
+
+// withPrivacy asks for the wiki password on private instances, unless the
+// request has a share link for its page.
+func withPrivacy(next http.Handler) http.Handler {
+	if wikiPassword() == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range publicPaths {
+			if r.URL.Path == path || strings.HasPrefix(r.URL.Path, path+"/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if isReader(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !hasShare(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="endless wiki"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.URL.RawQuery = sharedQuery(r.URL.Query()).Encode()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shareHandler mints a share link for an article or replay.
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	if wikiPassword() == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req struct {
+		Path string `json:"path"`
+		Days int    `json:"days"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Days <= 0 {
+		req.Days = 7
+	}
+	if req.Days > maxShareDays {
+		http.Error(w, "Share links last at most 90 days", http.StatusBadRequest)
+		return
+	}
+
+	page, err := url.Parse(req.Path)
+	if err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	scope, name, ok := sharedScope(page.EscapedPath(), page.Query())
+	if !ok {
+		http.Error(w, "Only articles and replays can be shared", http.StatusBadRequest)
+		return
+	}
+
+	expires := time.Now().Add(time.Duration(req.Days) * 24 * time.Hour)
+	query := sharedQuery(page.Query())
+	query.Set("share", signedUntil(shareKey(), sharePurpose(wikiFrom(r.Context()).Name, scope, name), expires))
+	page.RawQuery = query.Encode()
+
+	audit(AuditEvent{Event: "share", Client: clientIP(r), Wiki: wikiFrom(r.Context()).Name, Detail: scope + " " + name})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":     page.EscapedPath() + "?" + page.RawQuery,
+		"expires": expires.UTC(),
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSharedScope(t *testing.T) {
+	tests := []struct {
+		path, query string
+		scope, name string
+	}{
+		{"/wiki/moon", "", "article", ":moon"},
+		{"/stream/Moon", "", "article", ":moon"},
+		{"/raw/Moon", "model=other", "article", ":moon"},
+		{"/portal/moon", "", "article", "portal:moon"},
+		{"/stream/Moon", "kind=portal", "article", "portal:moon"},
+		{"/wiki/moon", "kind=nonsense", "article", ":moon"},
+		{"/wiki/moon/craters", "", "article", ":moon/craters"},
+		{"/replay/abc123", "", "replay", "abc123"},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		scope, name, ok := sharedScope(test.path, query)
+		if !ok || scope != test.scope || name != test.name {
+			t.Errorf("sharedScope(%q, %q) = %q, %q, %v, want %q, %q", test.path, test.query, scope, name, ok, test.scope, test.name)
+		}
+	}
+	for _, path := range []string{"/search", "/wiki/", "/replay/a/b", "/admin/cache"} {
+		if _, _, ok := sharedScope(path, nil); ok {
+			t.Errorf("sharedScope(%q) is shareable", path)
+		}
+	}
+}
+
+func TestShareLinkRequests(t *testing.T) {
+	t.Setenv("WIKI_PASSWORD", "secret")
+	var seen *http.Request
+	handler := withPrivacy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r }))
+	token := signedUntil(shareKey(), sharePurpose(defaultWikiName, "article", shareArticle("", "Moon")), time.Now().Add(time.Hour))
+
+	get := func(target string) int {
+		seen = nil
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if seen == nil {
+			return w.Code
+		}
+		return http.StatusOK
+	}
+
+	if code := get("/wiki/moon?share=" + token); code != http.StatusOK {
+		t.Errorf("the shared article answered %d", code)
+	}
+	if code := get("/portal/moon?share=" + token); code != http.StatusUnauthorized {
+		t.Errorf("another kind of the shared article answered %d", code)
+	}
+	if code := get("/wiki/sun?share=" + token); code != http.StatusUnauthorized {
+		t.Errorf("another article answered %d", code)
+	}
+
+	// Generation options of the holder's choosing are dropped
+	if code := get("/stream/Moon?share=" + token + "&model=huge&seed=4&num_predict=99999&lens=pirate"); code != http.StatusOK {
+		t.Fatalf("the shared article's stream answered %d", code)
+	}
+	if query := seen.URL.Query(); len(query) != 1 || query.Get("share") != token {
+		t.Errorf("a shared stream was asked for with %v", query)
+	}
+
+	// So are the article's extras, and only its own
+	id, err := deferExtra(func(ctx context.Context) interface{} { return nil }, defaultWikiName, shareArticle("", "Moon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := deferExtra(func(ctx context.Context) interface{} { return nil }, defaultWikiName, shareArticle("", "Sun"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := get("/api/extras/" + id + "?share=" + token); code != http.StatusOK {
+		t.Errorf("the shared article's extra answered %d", code)
+	}
+	if code := get("/api/extras/" + other + "?share=" + token); code != http.StatusUnauthorized {
+		t.Errorf("another article's extra answered %d", code)
+	}
+	if code := get("/api/extras/" + id); code != http.StatusUnauthorized {
+		t.Errorf("an extra without a share link answered %d", code)
+	}
+}
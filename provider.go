@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// A provider is a backend articles are generated with: ollama, or the
+// Anthropic Messages API. Each takes care of its own HTTP calls and decoding,
+// and hands back text the same way, so the rest of the wiki doesn't care
+// which one is writing. PROVIDER picks it.
+
+// Provider generates text with a language model.
+type Provider interface {
+	// Name is what PROVIDER calls it, also used in metrics
+	Name() string
+	// Host is where it's reached, for the logs
+	Host() string
+	// Stream generates text for a prompt under a system prompt, which may
+	// be empty, sending it in chunks as it's written. The channel is closed
+	// after the last chunk, which is Done or carries an error, or early once
+	// ctx is cancelled.
+	Stream(ctx context.Context, model, system, prompt string, options *GenerateOptions) <-chan Chunk
+	// GenerateJSON answers a prompt with a JSON object, decoded into v
+	GenerateJSON(ctx context.Context, model, prompt string, v interface{}) error
+	// Up reports whether it answers, for the circuit breaker
+	Up(ctx context.Context) bool
+}
+
+// Chunk is a piece of a streamed generation.
+type Chunk struct {
+	Text string
+	// Done marks the last chunk, with why the model stopped, like "stop" or
+	// "length", and how many tokens it generated
+	Done       bool
+	DoneReason string
+	Tokens     int
+	Err        error
+}
+
+// GenerateOptions tune a generation, named as ollama names them. Providers
+// without an option do without it.
+type GenerateOptions struct {
+	Seed       int      `json:"seed,omitempty"`
+	NumCtx     int      `json:"num_ctx,omitempty"`
+	NumPredict int      `json:"num_predict,omitempty"`
+	Stop       []string `json:"stop,omitempty"`
+	// The sampling options are left to the model unless they're set, see
+	// options.go
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	TopK          int      `json:"top_k,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+}
+
+// providers are the providers PROVIDER can pick.
+var providers = map[string]Provider{
+	"ollama":    ollamaProvider{},
+	"anthropic": anthropicProvider{},
+}
+
+// generationProvider is the provider PROVIDER picks, ollama by default.
+// Unknown names are refused when the settings are loaded.
+func generationProvider() Provider {
+	if provider, ok := providers[os.Getenv("PROVIDER")]; ok {
+		return provider
+	}
+	return providers["ollama"]
+}
+
+// sendChunk sends a chunk of a stream, reporting false if the reader is gone.
+func sendChunk(ctx context.Context, chunks chan<- Chunk, chunk Chunk) bool {
+	select {
+	case chunks <- chunk:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// streamGenerate generates text for a prompt under a system prompt with the
+// provider and calls onChunk with every piece of it. It returns the reason
+// the model stopped, e.g. "stop" or "length", and how many tokens it
+// generated. A missing model is pulled first.
+func streamGenerate(ctx context.Context, model, system, prompt string, options *GenerateOptions, onChunk func(string)) (string, int, error) {
+	doneReason, tokens, err := streamChunks(ctx, model, system, prompt, options, onChunk)
+	if err != nil && pullMissing(ctx, err) {
+		return streamChunks(ctx, model, system, prompt, options, onChunk)
+	}
+	return doneReason, tokens, err
+}
+
+// streamChunks hands the chunks of a generation to onChunk.
+func streamChunks(ctx context.Context, model, system, prompt string, options *GenerateOptions, onChunk func(string)) (string, int, error) {
+	for chunk := range generationProvider().Stream(ctx, model, system, prompt, options) {
+		if chunk.Err != nil {
+			return "", chunk.Tokens, chunk.Err
+		}
+		if chunk.Text != "" {
+			onChunk(chunk.Text)
+		}
+		if chunk.Done {
+			return chunk.DoneReason, chunk.Tokens, nil
+		}
+	}
+	// Providers say why a stream ended, so one that just stops was cut short
+	if ctx.Err() != nil {
+		return "", 0, ctx.Err()
+	}
+	return "", 0, errors.New("the stream ended early")
+}
+
+// generateJSON asks the provider for a JSON answer to a prompt and decodes it
+// into v, pulling a missing model first.
+func generateJSON(ctx context.Context, model, prompt string, v interface{}) error {
+	err := generationProvider().GenerateJSON(ctx, model, prompt, v)
+	if err != nil && pullMissing(ctx, err) {
+		return generationProvider().GenerateJSON(ctx, model, prompt, v)
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ollama answers a generation with a 404 when it doesn't have the model, as
+// after a fresh install or once its volume has been wiped. The models of the
+// wikis are pulled at startup, but that may fail while ollama is still
+// starting, or the models may be removed later. With AUTO_PULL, on unless
+// it's false, a generation that finds its model missing has ollama pull it
+// and then tries again, and the page shows the download's progress instead
+// of waiting on a spinner. Without it the reader is told generation failed.
+
+// pullProgressInterval is the least time between two progress updates of a
+// pull that is still on the same step.
+const pullProgressInterval = 250 * time.Millisecond
+
+// PullProgress is an update on a model being pulled.
+type PullProgress struct {
+	Model     string `json:"model"`
+	Status    string `json:"status"`
+	Completed int64  `json:"completed,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// modelMissingError is how a generation fails when the ollama host it went
+// to doesn't have its model.
+type modelMissingError struct {
+	host, model string
+}
+
+func (e *modelMissingError) Error() string {
+	return fmt.Sprintf("model '%s' not found", e.model)
+}
+
+type pullProgressKey struct{}
+
+type backgroundPullKey struct{}
+
+// backgroundPulls are the models being pulled in the background, by host and
+// model.
+var backgroundPulls = struct {
+	mu     sync.Mutex
+	models map[[2]string]bool
+}{
+	models: map[[2]string]bool{},
+}
+
+// withPullProgress has pulls made for generations under ctx report their
+// progress to progress.
+func withPullProgress(ctx context.Context, progress func(PullProgress)) context.Context {
+	return context.WithValue(ctx, pullProgressKey{}, progress)
+}
+
+// withBackgroundPull has generations under ctx that find their model
+// missing fail straight away, and leave pulling it to the background, for
+// when there's another model to fall back on.
+func withBackgroundPull(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundPullKey{}, true)
+}
+
+// pullMissing pulls the model a generation failed for want of, if that's why
+// it failed, reporting whether it's worth trying again. Only the models the
+// wikis are configured with are pulled.
+func pullMissing(ctx context.Context, err error) bool {
+	var missing *modelMissingError
+	if !errors.As(err, &missing) || !envBool("AUTO_PULL", true) || !configuredModel(missing.model) {
+		return false
+	}
+	if ctx.Value(backgroundPullKey{}) != nil {
+		key := [2]string{missing.host, missing.model}
+		backgroundPulls.mu.Lock()
+		defer backgroundPulls.mu.Unlock()
+		if !backgroundPulls.models[key] {
+			backgroundPulls.models[key] = true
+			go func() {
+				ensureModelOn(missing.host, missing.model)
+				backgroundPulls.mu.Lock()
+				delete(backgroundPulls.models, key)
+				backgroundPulls.mu.Unlock()
+			}()
+		}
+		return false
+	}
+
+	log.Printf("Model '%s' is missing at '%s', pulling it", missing.model, missing.host)
+	progress, _ := ctx.Value(pullProgressKey{}).(func(PullProgress))
+	if err := pullModel(ctx, missing.host, missing.model, progress); err != nil {
+		if ctx.Err() == nil {
+			alertAdmin("Error pulling missing model '%s' at '%s': %v", missing.model, missing.host, err)
+		}
+		return false
+	}
+	log.Printf("Model '%s' is ready at '%s'", missing.model, missing.host)
+	return true
+}
+
+// pullModel has an ollama host download a model and waits until it's done.
+// progress, if any, is told of every new step, and a few times a second of
+// how far along a download is.
+func pullModel(ctx context.Context, host, model string, progress func(PullProgress)) error {
+	jsonData, err := json.Marshal(struct {
+		Name string `json:"name"`
+	}{Name: model})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", host+"/api/pull", bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ollamaStatusError(resp, host, model)
+	}
+
+	var last PullProgress
+	var reported time.Time
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		update := PullProgress{Model: model}
+		if err := decoder.Decode(&update); err != nil {
+			if err == io.EOF {
+				return errors.New("the pull ended before it succeeded")
+			}
+			return err
+		}
+		if update.Error != "" {
+			return errors.New(update.Error)
+		}
+		if progress != nil && (update.Status != last.Status || time.Since(reported) >= pullProgressInterval) {
+			progress(update)
+			reported = time.Now()
+		}
+		last = update
+		if update.Status == "success" {
+			return nil
+		}
+	}
+}
+
+// ollamaStatusError reads the error an ollama host answered a request for
+// model with.
+func ollamaStatusError(resp *http.Response, host, model string) error {
+	var answer struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&answer)
+	switch {
+	case resp.StatusCode == http.StatusNotFound && strings.Contains(answer.Error, "not found"):
+		return &modelMissingError{host: host, model: model}
+	case answer.Error != "":
+		return fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, answer.Error)
+	}
+	return fmt.Errorf("ollama returned status %d", resp.StatusCode)
+}
+
+// ensureModelsDownloaded pulls the model of every wiki on every host.
+func ensureModelsDownloaded() {
+	if generationProvider().Name() != "ollama" {
+		return
+	}
+	for _, model := range wikiModels() {
+		ensureModelDownloaded(model)
+	}
+}
+
+// ensureModelDownloaded pulls a model on every host.
+func ensureModelDownloaded(ollamaModel string) {
+	for _, host := range ollamaHosts() {
+		ensureModelOn(host, ollamaModel)
+	}
+}
+
+func ensureModelOn(host, ollamaModel string) {
+	log.Printf("Ensuring model '%s' is available at '%s'", ollamaModel, host)
+
+	// Log each step once, not every bit of the download
+	status := ""
+	err := pullModel(context.Background(), host, ollamaModel, func(update PullProgress) {
+		if update.Status != status {
+			status = update.Status
+			log.Printf("Pulling model '%s': %s", ollamaModel, status)
+		}
+	})
+	if err != nil {
+		alertAdmin("Error pulling model '%s' at '%s' (Ollama may not be ready yet): %v", ollamaModel, host, err)
+		return
+	}
+	log.Printf("Model '%s' is ready at '%s'", ollamaModel, host)
+}
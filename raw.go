@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// wantsPlainText reports whether a client asked for the article as plain
+// text, like curl -H 'Accept: text/plain', rather than server-sent events.
+func wantsPlainText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/event-stream")
+}
+
+// rawHandler streams the markdown of an article as plain text chunks, so
+// terminal clients can read a generation live without parsing SSE framing.
+func rawHandler(w http.ResponseWriter, r *http.Request) {
+	requested, err := articleVar(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	articleName, err := normalizeTitle(requested)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if articleHidden(r.Context(), articleName, requestKind(r)) {
+		http.Error(w, hiddenMessage, http.StatusGone)
+		return
+	}
+
+	seed, err := seedFor(r.Context(), articleName, r.URL.Query().Get("seed"))
+	if err != nil {
+		http.Error(w, "Seed must be an integer", http.StatusBadRequest)
+		return
+	}
+	if err := setRequestOptions(&GenerateOptions{}, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkModelParam(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop browsers from buffering the response to sniff its type
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	ctx := r.Context()
+	flusher, _ := w.(http.Flusher)
+
+	// Stored articles are sent without a challenge or a share of the
+	// throttle
+	if cached, ok := storedArticle(r, articleName, requestKind(r)); ok {
+		noticeEdit(ctx, cached)
+		recordArticle(ctx, cached)
+		io.WriteString(w, cached.Content+"\n")
+		return
+	}
+
+	if gateRequired(r) {
+		refuseUngated(w)
+		return
+	}
+	if err := throttle(ctx, r, articleName); err != nil {
+		return
+	}
+	if unavailable, _ := generationUnavailable(r, articleName, requestKind(r)); unavailable {
+		http.Error(w, unavailableMessage, http.StatusServiceUnavailable)
+		return
+	}
+
+	release, err := streams.acquire(ctx, clientIP(r), func(int) {})
+	if err != nil {
+		return
+	}
+	defer release()
+
+	job := prepareArticle(ctx, r, articleName, seed)
+
+	activityFor(ctx).publish(ActivityEvent{Type: "generating", Title: articleName, Kind: job.Kind.Name})
+	content, err := generateArticle(ctx, job, func(chunk string) {
+		// Trimmed text can't be taken back once sent, so with trim rules the
+		// article is written once it's finished
+		if len(job.Trim) > 0 {
+			return
+		}
+		io.WriteString(w, chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			log.Printf("Article generation cancelled for '%s' (client disconnected)", articleName)
+			return
+		}
+		alertAdmin("Error generating article '%s': %v", articleName, err)
+		io.WriteString(w, "\n\nFailed to generate article\n")
+		return
+	}
+
+	activityFor(ctx).publish(ActivityEvent{Type: "completed", Title: articleName, Kind: job.Kind.Name, Summary: articleSummary(content)})
+	recordArticle(ctx, job.stored(content))
+	recordChange(r, job, content)
+	storeArticle(r, job, content, err)
+	if len(job.Trim) > 0 {
+		io.WriteString(w, content)
+	}
+	io.WriteString(w, "\n")
+}
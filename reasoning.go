@@ -0,0 +1,111 @@
+package main
+
+import "strings"
+
+// Reasoning models like deepseek-r1 and qwq think out loud between <think>
+// and </think> before they answer. By default that is stripped from
+// articles as it streams in. REASONING=collapse keeps it folded away in a
+// details block at the top of the article instead, and keep leaves it as it
+// came.
+
+const (
+	reasoningOpen  = "<think>"
+	reasoningClose = "</think>"
+)
+
+const (
+	collapsedReasoningOpen  = "<details class=\"reasoning\"><summary>The model's reasoning</summary>\n\n"
+	collapsedReasoningClose = "\n\n</details>\n\n"
+)
+
+// reasoningFilter takes reasoning out of a stream of chunks. Tags can be
+// split across chunks, so anything that might be the start of one is held
+// back until the next chunk shows whether it is.
+type reasoningFilter struct {
+	mode    string
+	inside  bool
+	pending string
+}
+
+func newReasoningFilter(mode string) *reasoningFilter {
+	return &reasoningFilter{mode: mode}
+}
+
+// write filters a chunk and returns what should be passed on.
+func (f *reasoningFilter) write(chunk string) string {
+	if f.mode == "keep" {
+		return chunk
+	}
+
+	text := f.pending + chunk
+	f.pending = ""
+
+	var out strings.Builder
+	for text != "" {
+		tag := reasoningOpen
+		if f.inside {
+			tag = reasoningClose
+		}
+
+		i := strings.Index(text, tag)
+		if i < 0 {
+			// Hold back a possible start of the tag
+			keep := partialSuffix(text, tag)
+			f.pass(&out, text[:len(text)-keep])
+			f.pending = text[len(text)-keep:]
+			break
+		}
+
+		f.pass(&out, text[:i])
+		text = text[i+len(tag):]
+		if f.inside {
+			text = strings.TrimLeft(text, "\n")
+			if f.mode == "collapse" {
+				out.WriteString(collapsedReasoningClose)
+			}
+		} else if f.mode == "collapse" {
+			out.WriteString(collapsedReasoningOpen)
+		}
+		f.inside = !f.inside
+	}
+	return out.String()
+}
+
+// flush returns whatever is still held back once the stream has ended.
+func (f *reasoningFilter) flush() string {
+	if f.mode == "keep" {
+		return ""
+	}
+
+	var out strings.Builder
+	f.pass(&out, f.pending)
+	f.pending = ""
+	if f.inside && f.mode == "collapse" {
+		out.WriteString(collapsedReasoningClose)
+	}
+	return out.String()
+}
+
+// pass writes text out unless it is reasoning being stripped.
+func (f *reasoningFilter) pass(out *strings.Builder, text string) {
+	if !f.inside || f.mode == "collapse" {
+		out.WriteString(text)
+	}
+}
+
+// partialSuffix returns the length of the longest end of text that is the
+// start of tag.
+func partialSuffix(text, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// stripReasoning takes the reasoning out of a whole response.
+func stripReasoning(text string) string {
+	filter := newReasoningFilter("strip")
+	return filter.write(text) + filter.flush()
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The redis store keeps articles in redis, so every replica behind a load
+// balancer serves the same article for a title. REDIS_URL is where, like
+// redis://:password@host:6379/0, or rediss:// for TLS. With ARTICLE_TTL,
+// redis expires articles itself once they are that old. Each article is a
+// JSON value under its own key, and a set per wiki and kind lists the titles
+// stored.
+
+// redisTimeout bounds a command when the request has no deadline of its own.
+const redisTimeout = 5 * time.Second
+
+// maxRedisIdle is how many idle connections are kept for reuse.
+const maxRedisIdle = 8
+
+// redisKeyPrefix starts every key the store writes, so it can share a redis
+// with other applications.
+const redisKeyPrefix = "endless-wiki:"
+
+type redisStore struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      bool
+	ttl      time.Duration
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply from redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func newRedisStore(rawURL string, ttl time.Duration) (*redisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("REDIS_URL must start with redis:// or rediss://")
+	}
+
+	s := &redisStore{
+		addr: u.Host,
+		tls:  u.Scheme == "rediss",
+		ttl:  ttl,
+		idle: make(chan *redisConn, maxRedisIdle),
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("REDIS_URL database must be a number, not %q", db)
+		}
+	}
+	return s, nil
+}
+
+func (s *redisStore) articleKey(wiki, kind, title string) string {
+	return redisKeyPrefix + "article:" + wiki + ":" + kind + ":" + title
+}
+
+func (s *redisStore) titlesKey(wiki, kind string) string {
+	return redisKeyPrefix + "titles:" + wiki + ":" + kind
+}
+
+func (s *redisStore) Get(ctx context.Context, wiki, kind, title string) (StoredArticle, bool, error) {
+	reply, err := s.do(ctx, "GET", s.articleKey(wiki, kind, title))
+	value, ok := reply.(string)
+	if err != nil || !ok {
+		return StoredArticle{}, false, err
+	}
+
+	data, err := readArticle(strings.NewReader(value))
+	if err != nil {
+		return StoredArticle{}, false, fmt.Errorf("decompressing stored article: %v", err)
+	}
+	var article StoredArticle
+	if err := json.Unmarshal(data, &article); err != nil {
+		return StoredArticle{}, false, fmt.Errorf("decoding stored article: %v", err)
+	}
+	return article, true, nil
+}
+
+func (s *redisStore) Put(ctx context.Context, wiki string, article StoredArticle) error {
+	value, err := json.Marshal(article)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"SET", s.articleKey(wiki, article.Kind, article.Title), string(compressArticle(value))}
+	if s.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	}
+	if _, err := s.do(ctx, args...); err != nil {
+		return err
+	}
+	_, err = s.do(ctx, "SADD", s.titlesKey(wiki, article.Kind), article.Title)
+	return err
+}
+
+func (s *redisStore) List(ctx context.Context, wiki, kind string) ([]string, error) {
+	reply, err := s.do(ctx, "SMEMBERS", s.titlesKey(wiki, kind))
+	if err != nil {
+		return nil, err
+	}
+
+	// Titles whose articles have expired are listed until they're deleted
+	members, _ := reply.([]interface{})
+	var titles []string
+	for _, member := range members {
+		if title, ok := member.(string); ok {
+			titles = append(titles, title)
+		}
+	}
+	return titles, nil
+}
+
+func (s *redisStore) Delete(ctx context.Context, wiki, kind, title string) error {
+	if _, err := s.do(ctx, "DEL", s.articleKey(wiki, kind, title)); err != nil {
+		return err
+	}
+	_, err := s.do(ctx, "SREM", s.titlesKey(wiki, kind), title)
+	return err
+}
+
+// do runs a command and returns its reply: a string, an int64, nil or a
+// slice of those.
+func (s *redisStore) do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	reply, err := conn.command(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state after a network error
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case s.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+// conn takes an idle connection, or opens and sets up a new one.
+func (s *redisStore) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-s.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var raw net.Conn
+	var err error
+	if s.tls {
+		host, _, _ := net.SplitHostPort(s.addr)
+		raw, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		raw, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{Conn: raw, reader: bufio.NewReader(raw)}
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+	if s.password != "" {
+		args := []string{"AUTH", s.password}
+		if s.username != "" {
+			args = []string{"AUTH", s.username, s.password}
+		}
+		if _, err := conn.command(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.db != 0 {
+		if _, err := conn.command("SELECT", strconv.Itoa(s.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// command sends a command and reads its reply.
+func (c *redisConn) command(args ...string) (interface{}, error) {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c, buf.String()); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+// reply reads one reply in the redis protocol.
+func (c *redisConn) reply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := c.reply()
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				// An error within an array is one of its items
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a connection to a redis that answers with canned replies,
+// and keeps what was sent to it.
+type fakeRedis struct {
+	net.Conn
+	replies *strings.Reader
+	sent    bytes.Buffer
+	closed  bool
+}
+
+func (f *fakeRedis) Read(p []byte) (int, error)         { return f.replies.Read(p) }
+func (f *fakeRedis) Write(p []byte) (int, error)        { return f.sent.Write(p) }
+func (f *fakeRedis) Close() error                       { f.closed = true; return nil }
+func (f *fakeRedis) SetDeadline(t time.Time) error      { return nil }
+func (f *fakeRedis) SetReadDeadline(t time.Time) error  { return nil }
+func (f *fakeRedis) SetWriteDeadline(t time.Time) error { return nil }
+
+func newFakeRedis(replies string) (*fakeRedis, *redisConn) {
+	fake := &fakeRedis{replies: strings.NewReader(replies)}
+	return fake, &redisConn{Conn: fake, reader: bufio.NewReader(fake)}
+}
+
+func TestRedisReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    interface{}
+		wantErr error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"integer", ":42\r\n", int64(42), nil},
+		{"bulk string", "$5\r\nhello\r\n", "hello", nil},
+		{"empty bulk string", "$0\r\n\r\n", "", nil},
+		{"bulk string with line breaks", "$12\r\nline\r\nbreaks\r\n", "line\r\nbreaks", nil},
+		{"nil bulk string", "$-1\r\n", nil, nil},
+		{"nil array", "*-1\r\n", nil, nil},
+		{"empty array", "*0\r\n", []interface{}{}, nil},
+		{"array", "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n", []interface{}{"a", int64(1), nil}, nil},
+		{"error in an array", "*2\r\n-ERR one\r\n+two\r\n", []interface{}{redisError("ERR one"), "two"}, nil},
+		{"error", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", nil, redisError("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, conn := newFakeRedis(test.reply)
+			got, err := conn.reply()
+			if err != test.wantErr {
+				t.Fatalf("reply() error = %v, want %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("reply() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedisReplyMalformed(t *testing.T) {
+	for _, reply := range []string{
+		"",
+		"\r\n",
+		"$5\r\nhel",
+		"$x\r\n",
+		":many\r\n",
+		"*2\r\n+one\r\n",
+		"?what\r\n",
+	} {
+		_, conn := newFakeRedis(reply)
+		got, err := conn.reply()
+		var replyErr redisError
+		if err == nil || errors.As(err, &replyErr) {
+			t.Errorf("reply() of %q = %#v, %v, want a protocol error", reply, got, err)
+		}
+	}
+}
+
+func TestRedisCommand(t *testing.T) {
+	fake, conn := newFakeRedis("+OK\r\n")
+	if _, err := conn.command("SET", "key", "two words"); err != nil {
+		t.Fatal(err)
+	}
+	want := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$9\r\ntwo words\r\n"
+	if got := fake.sent.String(); got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+func TestRedisStoreReplies(t *testing.T) {
+	store, err := newRedisStore("redis://localhost:6379", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// A nil reply is an article that isn't stored
+	fake, conn := newFakeRedis("$-1\r\n")
+	store.idle <- conn
+	if _, ok, err := store.Get(ctx, "default", "", "Rome"); ok || err != nil {
+		t.Errorf("Get of a nil reply = %v, %v, want false, nil", ok, err)
+	}
+	if want := "*2\r\n$3\r\nGET\r\n$34\r\nendless-wiki:article:default::Rome\r\n"; fake.sent.String() != want {
+		t.Errorf("sent %q, want %q", fake.sent.String(), want)
+	}
+	if len(store.idle) != 1 {
+		t.Errorf("the connection wasn't kept after a nil reply")
+	}
+
+	// An error reply leaves the connection usable, a broken one doesn't
+	<-store.idle
+	fake, conn = newFakeRedis("-ERR no\r\n")
+	store.idle <- conn
+	var replyErr redisError
+	if _, _, err := store.Get(ctx, "default", "", "Rome"); !errors.As(err, &replyErr) {
+		t.Errorf("Get of an error reply = %v, want a redis error", err)
+	}
+	if len(store.idle) != 1 || fake.closed {
+		t.Errorf("the connection wasn't kept after an error reply")
+	}
+
+	<-store.idle
+	fake, conn = newFakeRedis("$10\r\ncut")
+	store.idle <- conn
+	if _, _, err := store.Get(ctx, "default", "", "Rome"); err == nil {
+		t.Errorf("Get of a cut off reply succeeded")
+	}
+	if len(store.idle) != 0 || !fake.closed {
+		t.Errorf("the connection was kept after a cut off reply")
+	}
+}
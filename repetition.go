@@ -0,0 +1,101 @@
+package main
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Small models sometimes get stuck saying the same sentence over and over
+// until they hit the token limit. Generation watches the tail of the article
+// for a run of words repeated several times in a row, stops the model as soon
+// as one shows up and trims the article back to the first time it was said.
+
+const (
+	// loopRepeats is how many times a run must be said in a row to count
+	loopRepeats = 3
+	// minLoopWords and maxLoopWords bound the length of a repeated run
+	minLoopWords = 6
+	maxLoopWords = 80
+	// minLoopBytes keeps short runs of punctuation, like table rules, from
+	// counting
+	minLoopBytes = 30
+)
+
+type wordSpan struct {
+	start, end int
+}
+
+// loopWatcher watches an article for a loop as it streams in, splitting
+// each bit of text into words once rather than the whole tail on every
+// chunk.
+type loopWatcher struct {
+	words []wordSpan
+	// start is where the word being written starts, or -1 between words
+	start int
+	// scanned is how much of the article has been split into words
+	scanned int
+}
+
+func newLoopWatcher() *loopWatcher {
+	return &loopWatcher{start: -1}
+}
+
+// write takes the article so far, which only grows between calls, and
+// returns the offset where a loop at its end starts repeating itself, like
+// findRepetition.
+func (l *loopWatcher) write(text string) int {
+	for l.scanned < len(text) {
+		r, size := utf8.DecodeRuneInString(text[l.scanned:])
+		// A character split across chunks is read once the rest comes
+		if r == utf8.RuneError && !utf8.FullRuneInString(text[l.scanned:]) {
+			break
+		}
+		if unicode.IsSpace(r) {
+			if l.start >= 0 {
+				l.words = append(l.words, wordSpan{l.start, l.scanned})
+				l.start = -1
+			}
+		} else if l.start < 0 {
+			l.start = l.scanned
+		}
+		l.scanned += size
+	}
+	// Only the last words can be in a loop that is still going
+	if keep := maxLoopWords * loopRepeats; len(l.words) >= 2*keep {
+		l.words = append(l.words[:0], l.words[len(l.words)-keep:]...)
+	}
+
+	words := l.words
+	if l.start >= 0 {
+		words = append(words, wordSpan{l.start, l.scanned})
+	}
+	word := func(i int) string { return text[words[i].start:words[i].end] }
+	for size := minLoopWords; size <= maxLoopWords && size*loopRepeats <= len(words); size++ {
+		first := len(words) - size*loopRepeats
+		looped := true
+		for i := first; i < len(words)-size && looped; i++ {
+			looped = word(i) == word(i+size)
+		}
+		if looped && words[first+size-1].end-words[first].start >= minLoopBytes {
+			return words[first+size-1].end
+		}
+	}
+	return -1
+}
+
+// findRepetition returns the offset in text where a loop at its end starts
+// repeating itself, so text[:offset] keeps the first time round. It returns
+// -1 if the text doesn't end in a loop.
+func findRepetition(text string) int {
+	// Only the tail can be in a loop that is still going
+	from := len(text) - (maxLoopWords*loopRepeats)*12
+	if from < 0 {
+		from = 0
+	}
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from++
+	}
+	watcher := newLoopWatcher()
+	watcher.scanned = from
+	return watcher.write(text)
+}
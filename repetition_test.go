@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoopWatcher(t *testing.T) {
+	loop := " The empire was very large and very old indeed."
+	tests := []struct {
+		name, text string
+		loops      bool
+	}{
+		{"prose", "Rome grew from a town on the Tiber into an empire around the Mediterranean. Its republic and later emperors left a mark on law, language and architecture that lasts to this day.", false},
+		{"loop", "Rome grew." + strings.Repeat(loop, loopRepeats), true},
+		{"short loop", "Rome grew." + strings.Repeat(" Yes yes.", 10), false},
+		{"unicode", "Rome grew." + strings.Repeat(" L'empire était très grand, très vieux et très ancien.", loopRepeats), true},
+	}
+	for _, test := range tests {
+		// Chunks of 3 bytes split the accented letters in two
+		var chunks []string
+		for text := test.text; text != ""; text = text[min(3, len(text)):] {
+			chunks = append(chunks, text[:min(3, len(text))])
+		}
+		watcher := newLoopWatcher()
+		var content strings.Builder
+		at := -1
+		for _, chunk := range chunks {
+			content.WriteString(chunk)
+			if at = watcher.write(content.String()); at >= 0 {
+				break
+			}
+		}
+		if (at >= 0) != test.loops {
+			t.Errorf("%s: loop at %d, want a loop %v", test.name, at, test.loops)
+			continue
+		}
+		if at >= 0 && at != findRepetition(content.String()) {
+			t.Errorf("%s: loop at %d, findRepetition says %d", test.name, at, findRepetition(content.String()))
+		}
+		if at >= 0 && strings.Count(content.String()[:at], "très vieux")+strings.Count(content.String()[:at], "very old") != 1 {
+			t.Errorf("%s: trimmed to %q, want the first time round", test.name, content.String()[:at])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxReplays is how many recent generations are kept for replaying. Replays
+// live in memory only and are lost on restart.
+const maxReplays = 100
+
+// ReplayChunk is one piece of a generation and when it arrived, in
+// milliseconds from the start.
+type ReplayChunk struct {
+	Offset int64  `json:"offset"`
+	Text   string `json:"text"`
+}
+
+// Replay is the timed chunk stream of a generation, so the article can be
+// re-animated being written.
+type Replay struct {
+	Title    string        `json:"title"`
+	Kind     string        `json:"kind,omitempty"`
+	Language string        `json:"language,omitempty"`
+	Chunks   []ReplayChunk `json:"chunks"`
+	Final    string        `json:"final,omitempty"`
+
+	start time.Time
+}
+
+func newReplay(title, kind string) *Replay {
+	return &Replay{Title: title, Kind: kind, start: time.Now()}
+}
+
+// record adds a chunk with its offset from the start of the generation.
+func (rp *Replay) record(chunk string) {
+	rp.Chunks = append(rp.Chunks, ReplayChunk{
+		Offset: time.Since(rp.start).Milliseconds(),
+		Text:   chunk,
+	})
+}
+
+// settle makes the replay end on the finished article, when trimming it made
+// it differ from what was written. If the article is what was written with
+// the end cut off, the replay stops there too. Otherwise the replay finishes
+// by showing the article as it was settled.
+func (rp *Replay) settle(content string) {
+	var written strings.Builder
+	for _, chunk := range rp.Chunks {
+		written.WriteString(chunk.Text)
+	}
+	if !strings.HasPrefix(written.String(), content) {
+		rp.Final = content
+		return
+	}
+
+	n := len(content)
+	for i, chunk := range rp.Chunks {
+		if len(chunk.Text) >= n {
+			rp.Chunks[i].Text = chunk.Text[:n]
+			rp.Chunks = rp.Chunks[:i+1]
+			return
+		}
+		n -= len(chunk.Text)
+	}
+}
+
+var (
+	replays     = map[string]*Replay{}
+	replayOrder []string
+	replaysMu   sync.Mutex
+)
+
+// saveReplay stores a finished replay, dropping the oldest once full, and
+// returns its id.
+func saveReplay(rp *Replay) (string, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return "", err
+	}
+
+	replaysMu.Lock()
+	defer replaysMu.Unlock()
+
+	replays[id] = rp
+	replayOrder = append(replayOrder, id)
+	if len(replayOrder) > maxReplays {
+		delete(replays, replayOrder[0])
+		replayOrder = replayOrder[1:]
+	}
+	return id, nil
+}
+
+func getReplay(id string) (*Replay, bool) {
+	replaysMu.Lock()
+	defer replaysMu.Unlock()
+
+	rp, ok := replays[id]
+	return rp, ok
+}
+
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["replay"]
+	rp, ok := getReplay(id)
+	if !ok {
+		renderError(w, http.StatusNotFound, "That replay has expired or never existed")
+		return
+	}
+
+	data := struct {
+		ID    string
+		Title string
+		Branding
+		CanShare bool
+		Share    string
+	}{
+		ID:       id,
+		Title:    rp.Title,
+		Branding: brandingFor(r.Context()),
+		CanShare: wikiPassword() != "" && isReader(r),
+		Share:    r.URL.Query().Get("share"),
+	}
+
+	renderPage(w, "replay.html", data)
+}
+
+func replayAPIHandler(w http.ResponseWriter, r *http.Request) {
+	rp, ok := getReplay(mux.Vars(r)["replay"])
+	if !ok {
+		http.Error(w, "Replay not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rp); err != nil {
+		log.Printf("Error encoding replay: %v", err)
+	}
+}
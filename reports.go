@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Readers can report an article as inaccurate, offensive or badly formatted,
+// with an optional note. Reports wait in the moderation queue of the admin
+// panel, where an article can be hidden or its reports dismissed. On a public
+// instance, one without WIKI_PASSWORD, an article is also hidden on its own
+// once REPORT_HIDE_THRESHOLD different readers have reported it. The queue
+// and hidden articles last until the next restart.
+
+// maxReportNote caps the length of a report's note.
+const maxReportNote = 500
+
+// maxReportedArticles caps how many articles the queue holds, and
+// maxArticleReports how many reports it keeps of each.
+const (
+	maxReportedArticles = 1000
+	maxArticleReports   = 50
+)
+
+// reportReasons maps the reasons an article can be reported for to how the
+// queue shows them.
+var reportReasons = map[string]string{
+	"inaccurate": "Inaccurate",
+	"offensive":  "Offensive",
+	"formatting": "Broken formatting",
+}
+
+// Report is one reader's report of an article.
+type Report struct {
+	Reason string
+	Note   string
+	Client string
+	Time   time.Time
+}
+
+// reportedArticle is an article in the moderation queue.
+type reportedArticle struct {
+	Wiki    string
+	Title   string
+	Kind    string
+	Reports []Report
+	Hidden  bool
+}
+
+type reportKey struct {
+	wiki, kind, title string
+}
+
+var reports = struct {
+	mu       sync.Mutex
+	articles map[reportKey]*reportedArticle
+}{
+	articles: map[reportKey]*reportedArticle{},
+}
+
+// latest is when the article was last reported.
+func (a *reportedArticle) latest() time.Time {
+	if len(a.Reports) == 0 {
+		return time.Time{}
+	}
+	return a.Reports[len(a.Reports)-1].Time
+}
+
+// addReport queues a report, replacing any the same client made of the
+// article before. It reports whether this report hid the article.
+func addReport(wiki, kind, title string, report Report) bool {
+	reports.mu.Lock()
+	defer reports.mu.Unlock()
+
+	key := reportKey{wiki, kind, title}
+	article := reports.articles[key]
+	if article == nil {
+		if len(reports.articles) >= maxReportedArticles && !dropOldestReported() {
+			return false
+		}
+		article = &reportedArticle{Wiki: wiki, Title: title, Kind: kind}
+		reports.articles[key] = article
+	}
+
+	kept := article.Reports[:0]
+	for _, earlier := range article.Reports {
+		if earlier.Client != report.Client {
+			kept = append(kept, earlier)
+		}
+	}
+	article.Reports = append(kept, report)
+	if len(article.Reports) > maxArticleReports {
+		article.Reports = article.Reports[len(article.Reports)-maxArticleReports:]
+	}
+
+	threshold := settings.ReportHideThreshold
+	if article.Hidden || threshold <= 0 || wikiPassword() != "" || len(article.Reports) < threshold {
+		return false
+	}
+	article.Hidden = true
+	return true
+}
+
+// dropOldestReported makes room in a full queue by forgetting the article
+// reported longest ago. Hidden articles stay until a moderator restores
+// them, so it reports false if every article is hidden.
+func dropOldestReported() bool {
+	var oldest *reportedArticle
+	var oldestKey reportKey
+	for key, article := range reports.articles {
+		if !article.Hidden && (oldest == nil || article.latest().Before(oldest.latest())) {
+			oldest, oldestKey = article, key
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(reports.articles, oldestKey)
+	return true
+}
+
+// articleHidden reports whether an article of the wiki serving a request has
+// been hidden.
+func articleHidden(ctx context.Context, title, kind string) bool {
+	reports.mu.Lock()
+	defer reports.mu.Unlock()
+
+	article, ok := reports.articles[reportKey{wikiFrom(ctx).Name, kind, title}]
+	return ok && article.Hidden
+}
+
+// hiddenMessage is what readers of a hidden article are told.
+const hiddenMessage = "This article has been hidden after readers reported it"
+
+// reportHandler takes a reader's report of an article.
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Title  string `json:"title"`
+		Kind   string `json:"kind"`
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	title, err := normalizeTitle(request.Title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := reportReasons[request.Reason]; !ok {
+		http.Error(w, "Unknown reason, it must be inaccurate, offensive or formatting", http.StatusBadRequest)
+		return
+	}
+	note := strings.TrimSpace(request.Note)
+	if len(note) > maxReportNote {
+		http.Error(w, "The note is too long", http.StatusBadRequest)
+		return
+	}
+	kind := articleKinds[request.Kind].Name
+
+	ctx := r.Context()
+	wiki := wikiFrom(ctx).Name
+	client := clientIP(r)
+	hidden := addReport(wiki, kind, title, Report{
+		Reason: request.Reason,
+		Note:   note,
+		Client: client,
+		Time:   time.Now(),
+	})
+	log.Printf("Article '%s' reported as %s", title, request.Reason)
+	if hidden {
+		audit(AuditEvent{
+			Event:  "article_hidden",
+			Client: client,
+			Wiki:   wiki,
+			Detail: fmt.Sprintf("'%s' after %d reports", title, settings.ReportHideThreshold),
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminReport is an article in the queue as shown in the panel.
+type adminReport struct {
+	Wiki    string
+	Title   string
+	Kind    string
+	Hidden  bool
+	Count   int
+	Reasons string
+	Notes   []string
+	Latest  time.Time
+}
+
+// moderationQueue lists the reported articles, most recently reported first.
+func moderationQueue() []adminReport {
+	reports.mu.Lock()
+	defer reports.mu.Unlock()
+
+	var queue []adminReport
+	for _, article := range reports.articles {
+		counts := map[string]int{}
+		entry := adminReport{
+			Wiki:   article.Wiki,
+			Title:  article.Title,
+			Kind:   article.Kind,
+			Hidden: article.Hidden,
+			Count:  len(article.Reports),
+			Latest: article.latest(),
+		}
+		for _, report := range article.Reports {
+			counts[report.Reason]++
+			if report.Note != "" {
+				entry.Notes = append(entry.Notes, report.Note)
+			}
+		}
+		var reasons []string
+		for reason, label := range reportReasons {
+			if counts[reason] > 0 {
+				reasons = append(reasons, fmt.Sprintf("%s %d", label, counts[reason]))
+			}
+		}
+		sort.Strings(reasons)
+		entry.Reasons = strings.Join(reasons, ", ")
+		queue = append(queue, entry)
+	}
+	sort.Slice(queue, func(i, j int) bool { return queue[i].Latest.After(queue[j].Latest) })
+	return queue
+}
+
+// adminReportHandler hides a reported article, or dismisses its reports and
+// shows it again.
+func adminReportHandler(w http.ResponseWriter, r *http.Request) {
+	key := reportKey{r.FormValue("wiki"), r.FormValue("kind"), r.FormValue("title")}
+
+	reports.mu.Lock()
+	article, ok := reports.articles[key]
+	if ok {
+		if r.FormValue("action") == "hide" {
+			article.Hidden = true
+		} else {
+			delete(reports.articles, key)
+		}
+	}
+	reports.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "No reports of that article", http.StatusNotFound)
+		return
+	}
+	if r.FormValue("action") == "hide" {
+		log.Printf("Hid article '%s' of wiki '%s'", key.title, key.wiki)
+	} else {
+		log.Printf("Dismissed the reports of article '%s' of wiki '%s'", key.title, key.wiki)
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
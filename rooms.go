@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Reading rooms push to their members with server-sent events, and members
+// move the room with a plain POST, rather than over a WebSocket. Followers
+// only ever receive, so a one-way stream is all a room needs, and it goes
+// through the same proxies, buffering settings and HTTP/2 connections as
+// the article stream, without another dependency or protocol upgrade to
+// configure. Rooms and their members are capped by MAX_ROOMS and
+// MAX_ROOM_MEMBERS, so they can't be used to fill the server's memory.
+
+// roomIdleTimeout is how long an empty room is kept around.
+const roomIdleTimeout = 24 * time.Hour
+
+// room is a shared reading session. Everyone following the room sees the
+// same article, and navigating in the room moves every follower along.
+type room struct {
+	article     string
+	kind        string
+	lastActive  time.Time
+	subscribers map[chan string]struct{}
+}
+
+var (
+	rooms   = map[string]*room{}
+	roomsMu sync.Mutex
+)
+
+// roomPath is the page for the room's current article.
+func (rm *room) path(id string) string {
+	kind := rm.kind
+	if kind == "" {
+		kind = "wiki"
+	}
+	return "/" + kind + "/" + slugPath(rm.article) + "?room=" + id
+}
+
+// broadcast sends an SSE frame to everyone in the room. Callers hold roomsMu.
+func (rm *room) broadcast(frame string) {
+	for ch := range rm.subscribers {
+		select {
+		case ch <- frame:
+		default:
+		}
+	}
+}
+
+// newRandomID returns a random hex id for rooms and replays.
+func newRandomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createRoomHandler starts a room on the given article and sends the creator
+// to it.
+func createRoomHandler(w http.ResponseWriter, r *http.Request) {
+	article, err := normalizeTitle(r.FormValue("article"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kind := r.FormValue("kind")
+	if _, ok := articleKinds[kind]; !ok {
+		kind = ""
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		log.Printf("Error creating room: %v", err)
+		http.Error(w, "Could not create room", http.StatusInternalServerError)
+		return
+	}
+
+	rm := &room{
+		article:     article,
+		kind:        kind,
+		lastActive:  time.Now(),
+		subscribers: map[chan string]struct{}{},
+	}
+
+	roomsMu.Lock()
+	// Forget rooms nobody has used in a while
+	for oldID, old := range rooms {
+		if len(old.subscribers) == 0 && time.Since(old.lastActive) > roomIdleTimeout {
+			delete(rooms, oldID)
+		}
+	}
+	if len(rooms) >= envInt("MAX_ROOMS", 1000) && !dropEmptyRoom() {
+		roomsMu.Unlock()
+		http.Error(w, "Too many reading rooms are open, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	rooms[id] = rm
+	roomsMu.Unlock()
+
+	http.Redirect(w, r, rm.path(id), http.StatusSeeOther)
+}
+
+// dropEmptyRoom forgets the empty room that has been idle longest, to make
+// space for a new one, reporting false if every room has members. Callers
+// hold roomsMu.
+func dropEmptyRoom() bool {
+	oldest := ""
+	for id, rm := range rooms {
+		if len(rm.subscribers) == 0 && (oldest == "" || rm.lastActive.Before(rooms[oldest].lastActive)) {
+			oldest = id
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	delete(rooms, oldest)
+	return true
+}
+
+// joinRoomHandler sends a visitor of a shared room link to the article the
+// room is currently reading.
+func joinRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["room"]
+
+	roomsMu.Lock()
+	rm, ok := rooms[id]
+	var path string
+	if ok {
+		path = rm.path(id)
+	}
+	roomsMu.Unlock()
+
+	if !ok {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, path, http.StatusSeeOther)
+}
+
+// navigateRoomHandler moves the room to a new article and tells followers.
+func navigateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["room"]
+	article, err := normalizeTitle(r.FormValue("article"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kind := r.FormValue("kind")
+	if _, ok := articleKinds[kind]; !ok {
+		kind = ""
+	}
+
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+
+	rm, ok := rooms[id]
+	if !ok {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	rm.lastActive = time.Now()
+	if rm.article != article || rm.kind != kind {
+		rm.article = article
+		rm.kind = kind
+		rm.broadcast(fmt.Sprintf("event: navigate\ndata: %s\n\n", rm.path(id)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// roomEventsHandler streams navigation and participant events for a room.
+func roomEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["room"]
+	ch := make(chan string, 8)
+
+	roomsMu.Lock()
+	rm, ok := rooms[id]
+	full := ok && len(rm.subscribers) >= envInt("MAX_ROOM_MEMBERS", 50)
+	if ok && !full {
+		rm.subscribers[ch] = struct{}{}
+		rm.lastActive = time.Now()
+		rm.broadcast(fmt.Sprintf("event: participants\ndata: %d\n\n", len(rm.subscribers)))
+	}
+	roomsMu.Unlock()
+
+	if !ok {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if full {
+		http.Error(w, "This reading room is full", http.StatusServiceUnavailable)
+		return
+	}
+
+	defer func() {
+		roomsMu.Lock()
+		delete(rm.subscribers, ch)
+		rm.lastActive = time.Now()
+		rm.broadcast(fmt.Sprintf("event: participants\ndata: %d\n\n", len(rm.subscribers)))
+		roomsMu.Unlock()
+	}()
+
+	// Set headers for Server-Sent Events
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame := <-ch:
+			fmt.Fprint(w, frame)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// A library of models each has its strengths: a coder model writes better
+// about programming, a storytelling one about myths and novels. Routes send
+// classes of topics to the model that suits them. Before an article is
+// written, a fast classifier model, ROUTING_MODEL or the wiki's own, is asked
+// which of the routed classes the title falls in, and the article is written
+// with that class's model. Titles that fit none of them, or that the
+// classifier can't place, are written with the wiki's model as usual. The
+// class a title falls in is cached like its topic type, so it's only asked
+// once.
+
+const routePrompt = `Classify the encyclopedia topic "%s" as exactly one of: %s, other.
+
+Respond with JSON in the form {"class": "..."}.`
+
+// parseRoutes parses a comma separated list of routes like
+// "code=qwen2.5-coder,creative=mistral".
+func parseRoutes(list string) (map[string]string, error) {
+	routes := map[string]string{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, model, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route %q, it must be like class=model", entry)
+		}
+		routes[strings.ToLower(strings.TrimSpace(class))] = strings.TrimSpace(model)
+	}
+	return routes, checkRoutes(routes)
+}
+
+// checkRoutes checks that every route has a lowercase class and a model.
+func checkRoutes(routes map[string]string) error {
+	for class, model := range routes {
+		switch {
+		case class == "" || model == "":
+			return fmt.Errorf("every route needs a class and a model")
+		case class != strings.ToLower(class):
+			return fmt.Errorf("route class %q must be lowercase", class)
+		case class == "other":
+			return fmt.Errorf("other can't be routed, it's what fits no class")
+		}
+	}
+	return nil
+}
+
+// routingModel is the model that classifies titles for routing.
+func (s *Settings) routingModel() string {
+	if s.RoutingModel != "" {
+		return s.RoutingModel
+	}
+	return s.Model
+}
+
+// routeModel picks the model to write an article with, by the class of topic
+// its title falls in.
+func routeModel(ctx context.Context, articleName string) string {
+	current := settingsFor(ctx)
+	if len(current.Routes) == 0 {
+		return current.Model
+	}
+
+	classes := make([]string, 0, len(current.Routes))
+	for class := range current.Routes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	classifier := current.routingModel()
+	key := classifier + "\x00" + strings.Join(classes, ",") + "\x00" + articleName
+	routed := component(ctx, "route", key, func() interface{} {
+		if class, ok := classifyRoute(ctx, articleName, classifier, classes); ok {
+			return class
+		}
+		return nil
+	})
+	class, _ := routed.(string)
+	model, ok := current.Routes[class]
+	if !ok {
+		return current.Model
+	}
+	log.Printf("Routing '%s', a %s topic, to model '%s'", articleName, class, model)
+	return model
+}
+
+// classifyRoute asks the classifier which of the classes a title falls in,
+// "other" if none. It reports false when the answer is missing or unknown.
+func classifyRoute(ctx context.Context, articleName, classifier string, classes []string) (string, bool) {
+	var result struct {
+		Class string `json:"class"`
+	}
+	prompt := fmt.Sprintf(routePrompt, articleName, strings.Join(classes, ", "))
+	if err := generateJSON(ctx, classifier, prompt, &result); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error classifying '%s' for routing: %v", articleName, err)
+		}
+		return "", false
+	}
+
+	class := strings.ToLower(strings.TrimSpace(result.Class))
+	if class != "other" && !slices.Contains(classes, class) {
+		return "", false
+	}
+	return class, true
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The S3 store keeps articles in a bucket of any S3 compatible object store,
+// like AWS S3, Cloudflare R2 or MinIO, so they outlive containers on
+// platforms without persistent volumes. Objects are the same markdown files
+// with front matter as the disk store writes, under a folder for the wiki
+// and kind, so a bucket and an ARTICLE_CACHE directory can be synced into one
+// another. Requests are signed with AWS Signature Version 4 and address the
+// bucket by path, which every S3 compatible store understands.
+
+// s3Timeout bounds a request to the object store.
+const s3Timeout = 30 * time.Second
+
+// maxS3ErrorBody caps how much of an error response is read.
+const maxS3ErrorBody = 1 << 16
+
+type s3Store struct {
+	endpoint     string
+	region       string
+	bucket       string
+	prefix       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// newS3Store sets up the S3 store from S3_BUCKET and the variables around
+// it, falling back on the usual AWS variables for credentials.
+func newS3Store() (*s3Store, error) {
+	s := &s3Store{
+		endpoint:     strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+		region:       os.Getenv("S3_REGION"),
+		bucket:       os.Getenv("S3_BUCKET"),
+		prefix:       strings.Trim(os.Getenv("S3_PREFIX"), "/"),
+		accessKey:    firstEnv("S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		secretKey:    firstEnv("S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+		sessionToken: firstEnv("S3_SESSION_TOKEN", "AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: s3Timeout},
+	}
+	if s.bucket == "" {
+		return nil, fmt.Errorf("ARTICLE_STORE=s3 needs S3_BUCKET")
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("ARTICLE_STORE=s3 needs S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+	}
+	if s.region == "" {
+		s.region = firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.endpoint == "" {
+		s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	if u, err := url.Parse(s.endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT must be a URL like https://s3.eu-west-1.amazonaws.com")
+	}
+	return s, nil
+}
+
+// firstEnv returns the first of the environment variables that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// folder is where the articles of a wiki of a kind are kept in the bucket.
+func (s *s3Store) folder(wiki, kind string) string {
+	if kind == "" {
+		kind = "wiki"
+	}
+	folder := wiki + "/" + kind + "/"
+	if s.prefix != "" {
+		folder = s.prefix + "/" + folder
+	}
+	return folder
+}
+
+// objectKey is the key of an article. Keys can be up to 1024 bytes of UTF-8,
+// so unlike file names only slashes, percent signs and control characters
+// need escaping, and every title fits.
+func (s *s3Store) objectKey(wiki, kind, title string) string {
+	var name strings.Builder
+	for _, c := range title {
+		if c < ' ' || c == '/' || c == '%' {
+			fmt.Fprintf(&name, "%%%02X", c)
+		} else {
+			name.WriteRune(c)
+		}
+	}
+	return s.folder(wiki, kind) + name.String() + ".md"
+}
+
+func (s *s3Store) Get(ctx context.Context, wiki, kind, title string) (StoredArticle, bool, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectKey(wiki, kind, title), nil, nil)
+	if err != nil {
+		return StoredArticle{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return StoredArticle{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return StoredArticle{}, false, s3Error(resp)
+	}
+
+	data, err := readArticle(resp.Body)
+	if err != nil {
+		return StoredArticle{}, false, err
+	}
+	article := parseArticleFile(string(data))
+	article.Title, article.Kind = title, kind
+	return article, true, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, wiki string, article StoredArticle) error {
+	body := compressArticle([]byte(formatArticleFile(article)))
+	resp, err := s.do(ctx, http.MethodPut, s.objectKey(wiki, article.Kind, article.Title), nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *s3Store) List(ctx context.Context, wiki, kind string) ([]string, error) {
+	folder := s.folder(wiki, kind)
+	query := url.Values{"list-type": {"2"}, "prefix": {folder}, "delimiter": {"/"}}
+
+	var titles []string
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding bucket listing: %v", err)
+		}
+
+		for _, object := range page.Contents {
+			name, ok := strings.CutSuffix(strings.TrimPrefix(object.Key, folder), ".md")
+			if !ok {
+				continue
+			}
+			if title, err := url.PathUnescape(name); err == nil {
+				titles = append(titles, title)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return titles, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *s3Store) Delete(ctx context.Context, wiki, kind, title string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectKey(wiki, kind, title), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// do sends a signed request for an object, or for the bucket itself when key
+// is empty.
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := "/" + s3Escape(s.bucket, false)
+	if key != "" {
+		path += "/" + s3Escape(key, false)
+	}
+	target := s.endpoint + path
+	if len(query) > 0 {
+		target += "?" + s3Query(query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/markdown; charset=utf-8")
+	}
+	s.sign(req, path, query, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 to a request.
+func (s *s3Store) sign(req *http.Request, path string, query url.Values, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	signed := sigV4{region: s.region, service: "s3", secretKey: s.secretKey}.sign(req.Method, path, s3Query(query), headers, payloadHash, now)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, signed.scope, signed.signedHeaders, signed.signature))
+}
+
+// sigV4 signs requests to a service in a region with Signature Version 4.
+type sigV4 struct {
+	region    string
+	service   string
+	secretKey string
+}
+
+// sigV4Signature is a request's signature and what went into it.
+type sigV4Signature struct {
+	canonicalRequest string
+	stringToSign     string
+	scope            string
+	signedHeaders    string
+	signature        string
+}
+
+// sign signs a request by its method, escaped path, canonical query string,
+// headers, lowercased and trimmed, and the hash of its payload.
+func (v sigV4) sign(method, path, query string, headers map[string]string, payloadHash string, now time.Time) sigV4Signature {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	var signed sigV4Signature
+	signed.signedHeaders = strings.Join(names, ";")
+	signed.canonicalRequest = strings.Join([]string{
+		method,
+		path,
+		query,
+		canonicalHeaders.String(),
+		signed.signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	signed.scope = date + "/" + v.region + "/" + v.service + "/aws4_request"
+	signed.stringToSign = "AWS4-HMAC-SHA256\n" + amzDate + "\n" + signed.scope + "\n" + sha256Hex([]byte(signed.canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+v.secretKey), date)
+	key = hmacSHA256(key, v.region)
+	key = hmacSHA256(key, v.service)
+	key = hmacSHA256(key, "aws4_request")
+	signed.signature = hex.EncodeToString(hmacSHA256(key, signed.stringToSign))
+	return signed
+}
+
+// s3Escape escapes a path or query string the way Signature Version 4 asks,
+// leaving only unreserved characters and, in paths, slashes.
+func s3Escape(s string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/' && !encodeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// s3Query writes a query string in the canonical order, sorted by name.
+func s3Query(query url.Values) string {
+	var names []string
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Error turns an error response into an error, with the store's reason.
+func s3Error(resp *http.Response) error {
+	var reply struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxS3ErrorBody))
+	if xml.Unmarshal(data, &reply) != nil || reply.Code == "" {
+		return fmt.Errorf("s3: %s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+	}
+	return fmt.Errorf("s3: %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, reply.Code, reply.Message)
+}
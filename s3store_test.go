@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestSigV4 signs requests from the AWS Signature Version 4 test suite, with
+// its credentials, and checks every step against the suite's.
+func TestSigV4(t *testing.T) {
+	signer := sigV4{region: "us-east-1", service: "service", secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	headers := map[string]string{"host": "example.amazonaws.com", "x-amz-date": "20150830T123600Z"}
+	emptyHash := sha256Hex(nil)
+
+	tests := []struct {
+		name         string
+		method       string
+		query        url.Values
+		canonical    string
+		stringToSign string
+		signature    string
+	}{
+		{
+			name:   "get-vanilla",
+			method: "GET",
+			canonical: "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" +
+				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			stringToSign: "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n" +
+				"bb579772317eb040ac9ed261061d46c1f17a8133879d6129b6e1c25292927e63",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-vanilla-query-order-key-case",
+			method: "GET",
+			query:  url.Values{"Param2": {"value2"}, "Param1": {"value1"}},
+			canonical: "GET\n/\nParam1=value1&Param2=value2\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" +
+				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			stringToSign: "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n" +
+				"816cd5b414d056048ba4f7c5386d6e0533120fb1fcfa93762cf0fc39e2cf19e0",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:   "post-vanilla",
+			method: "POST",
+			canonical: "POST\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\n" +
+				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			stringToSign: "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n" +
+				"553f88c9e4d10fc9e109e2aeb65f030801b70c2f6468faca261d401ae622fc87",
+			signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signed := signer.sign(test.method, "/", s3Query(test.query), headers, emptyHash, now)
+			if signed.canonicalRequest != test.canonical {
+				t.Errorf("canonical request:\n%s\nwant:\n%s", signed.canonicalRequest, test.canonical)
+			}
+			if signed.stringToSign != test.stringToSign {
+				t.Errorf("string to sign:\n%s\nwant:\n%s", signed.stringToSign, test.stringToSign)
+			}
+			if signed.signature != test.signature {
+				t.Errorf("signature = %s, want %s", signed.signature, test.signature)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// /search looks through the articles in the article store. By title, the
+// default, it finds stored articles whose titles have every word searched
+// for. In quotes mode it finds the article a remembered phrase came from:
+// the phrase is matched against the text of every stored article word by
+// word, forgiving a typo per word and a word or two missed or misremembered,
+// and the closest passages are shown in context.
+
+// maxSearchArticles caps how many stored articles one search reads.
+const maxSearchArticles = 5000
+
+// maxSearchResults caps how many results a search shows.
+const maxSearchResults = 20
+
+// maxQuoteWords caps how long a remembered phrase may be.
+const maxQuoteWords = 40
+
+// minQuoteScore is the least share of a phrase's words a passage must have,
+// in order, to count as where it came from.
+const minQuoteScore = 0.7
+
+// snippetContext is how many characters of text surround a quote.
+const snippetContext = 80
+
+// SearchResult is an article found by a search, with the passage a quote
+// was found in split around the match.
+type SearchResult struct {
+	Title  string
+	Kind   string
+	Before string
+	Match  string
+	After  string
+	score  float64
+}
+
+// searchWord is a word of text, lowercased, and where it is in the text.
+type searchWord struct {
+	text       string
+	start, end int
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	mode := r.URL.Query().Get("mode")
+	if mode != "quotes" {
+		mode = "titles"
+	}
+
+	data := struct {
+		Branding
+		Query     string
+		Mode      string
+		Available bool
+		Results   []SearchResult
+	}{
+		Branding:  brandingFor(r.Context()),
+		Query:     query,
+		Mode:      mode,
+		Available: articles != nil,
+	}
+	if query != "" && articles != nil {
+		data.Results = searchArticles(r.Context(), query, mode)
+	}
+
+	renderPage(w, "search.html", data)
+}
+
+// searchArticles searches the stored articles of the wiki serving a request,
+// returning the best results first.
+func searchArticles(ctx context.Context, query, mode string) []SearchResult {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+	if len(words) > maxQuoteWords {
+		words = words[:maxQuoteWords]
+	}
+
+	wiki := wikiFrom(ctx).Name
+	kinds := []string{""}
+	for name := range articleKinds {
+		kinds = append(kinds, name)
+	}
+	sort.Strings(kinds)
+
+	var results []SearchResult
+	read := 0
+	for _, kind := range kinds {
+		titles, err := articles.List(ctx, wiki, kind)
+		if err != nil {
+			log.Printf("Error listing stored articles for search: %v", err)
+			continue
+		}
+		for _, title := range titles {
+			if read >= maxSearchArticles || ctx.Err() != nil {
+				break
+			}
+			if articleHidden(ctx, title, kind) {
+				continue
+			}
+
+			if mode == "titles" {
+				if titleMatches(title, words) {
+					results = append(results, SearchResult{Title: title, Kind: kind})
+				}
+				continue
+			}
+
+			read++
+			article, ok, err := articles.Get(ctx, wiki, kind, title)
+			if err != nil || !ok {
+				continue
+			}
+			if result, ok := findQuote(article.Content, words); ok {
+				result.Title, result.Kind = title, kind
+				results = append(results, result)
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].Title < results[j].Title
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	return results
+}
+
+// titleMatches reports whether a title has every word searched for.
+func titleMatches(title string, words []searchWord) bool {
+	have := map[string]bool{}
+	for _, word := range searchWords(title) {
+		have[word.text] = true
+	}
+	for _, word := range words {
+		if !have[word.text] {
+			return false
+		}
+	}
+	return true
+}
+
+// findQuote finds the passage of an article closest to a phrase. Its score
+// is the share of the phrase's words found in order, less a little for every
+// word in between, so an exact quote scores 1.
+func findQuote(content string, phrase []searchWord) (SearchResult, bool) {
+	text := searchText(content)
+	words := searchWords(text)
+
+	var best SearchResult
+	found := false
+	// A passage may be a couple of words longer than the phrase
+	span := len(phrase) + 2
+	for i := range words {
+		if !similarWord(words[i].text, phrase[0].text) && (len(phrase) < 2 || !similarWord(words[i].text, phrase[1].text)) {
+			continue
+		}
+
+		matched, last := 0, i
+		next := i
+		for _, want := range phrase {
+			for j := next; j < len(words) && j < i+span; j++ {
+				if similarWord(words[j].text, want.text) {
+					matched++
+					last = j
+					next = j + 1
+					break
+				}
+			}
+		}
+
+		extra := (last - i + 1) - matched
+		score := (float64(matched) - 0.25*float64(extra)) / float64(len(phrase))
+		if score >= minQuoteScore && (!found || score > best.score) {
+			best = quoteSnippet(text, words[i].start, words[last].end)
+			best.score = score
+			found = true
+		}
+		if score == 1 {
+			break
+		}
+	}
+	return best, found
+}
+
+// quoteSnippet cuts the text around a match, at word boundaries.
+func quoteSnippet(text string, start, end int) SearchResult {
+	from := start - snippetContext
+	if from <= 0 {
+		from = 0
+	} else {
+		for from < start && text[from] != ' ' {
+			from++
+		}
+	}
+	to := end + snippetContext
+	if to >= len(text) {
+		to = len(text)
+	} else {
+		for to > end && text[to] != ' ' {
+			to--
+		}
+	}
+
+	result := SearchResult{
+		Before: strings.TrimLeft(text[from:start], " "),
+		Match:  text[start:end],
+		After:  strings.TrimRight(text[end:to], " "),
+	}
+	if from > 0 {
+		result.Before = "…" + result.Before
+	}
+	if to < len(text) {
+		result.After += "…"
+	}
+	return result
+}
+
+// searchText flattens the prose of an article to plain text on one line,
+// leaving out headings, which would run into the sentences around them.
+func searchText(content string) string {
+	content = markdownEmphasis.Replace(topicLinkPattern.ReplaceAllString(content, "$1"))
+
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "```") || strings.HasPrefix(line, "<") {
+			continue
+		}
+		lines = append(lines, strings.TrimLeft(line, ">-+ "))
+	}
+	return strings.Join(strings.Fields(strings.Join(lines, " ")), " ")
+}
+
+// searchWords splits text into lowercase words of letters and digits.
+func searchWords(text string) []searchWord {
+	var words []searchWord
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r) || (r == '\'' && start >= 0)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			words = append(words, newSearchWord(text, start, i))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, newSearchWord(text, start, len(text)))
+	}
+	return words
+}
+
+func newSearchWord(text string, start, end int) searchWord {
+	// A trailing apostrophe closes a quote rather than belonging to the word
+	for end > start && text[end-1] == '\'' {
+		end--
+	}
+	return searchWord{text: strings.ToLower(text[start:end]), start: start, end: end}
+}
+
+// similarWord reports whether two words are the same but for a typo. Words
+// of one or two letters have to match exactly.
+func similarWord(a, b string) bool {
+	if a == b {
+		return true
+	}
+	length := utf8.RuneCountInString(b)
+	diff := utf8.RuneCountInString(a) - length
+	if length < 3 || diff > 1 || diff < -1 {
+		return false
+	}
+	return editDistance(a, b) <= 1
+}
+
+// editDistance counts the letters that have to be added, removed or changed
+// to turn one word into another.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+)
+
+// A reader's session lives in an encrypted cookie, so it can't be read or
+// tampered with in the browser and nothing needs storing on the server. It
+// holds their lens, their generation gate pass and the trail of articles
+// they have read. The cookie has no expiry, so it lasts for the browser
+// session.
+
+const sessionCookie = "endless-wiki-session"
+
+// Session is what the server remembers about a reader.
+type Session struct {
+	Lens      string       `json:"lens,omitempty"`
+	PassUntil int64        `json:"pass_until,omitempty"`
+	Trail     []TrailEntry `json:"trail,omitempty"`
+}
+
+// TrailEntry is one article on a reader's trail.
+type TrailEntry struct {
+	Title string `json:"title"`
+	Kind  string `json:"kind,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+var sessionCipher = loadSessionCipher()
+
+// loadSessionCipher sets up the cipher sessions are sealed with. Without
+// SESSION_SECRET sessions are only good until the next restart.
+func loadSessionCipher() cipher.AEAD {
+	key := make([]byte, 32)
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		key = sum[:]
+	} else if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Error creating session key: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatalf("Error creating session cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Fatalf("Error creating session cipher: %v", err)
+	}
+	return aead
+}
+
+// sessionFrom returns the session a request carries, or an empty one if it
+// has none or it can't be opened.
+func sessionFrom(r *http.Request) *Session {
+	session := &Session{}
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(sealed) < sessionCipher.NonceSize() {
+		return session
+	}
+	nonce, sealed := sealed[:sessionCipher.NonceSize()], sealed[sessionCipher.NonceSize():]
+	data, err := sessionCipher.Open(nil, nonce, sealed, []byte(sessionCookie))
+	if err != nil {
+		return session
+	}
+	if err := json.Unmarshal(data, session); err != nil {
+		return &Session{}
+	}
+	return session
+}
+
+// saveSession sends an updated session back to the reader. It has to be
+// called before anything is written to w.
+func saveSession(w http.ResponseWriter, r *http.Request, session *Session) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		log.Printf("Error encoding session: %v", err)
+		return
+	}
+
+	nonce := make([]byte, sessionCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		log.Printf("Error sealing session: %v", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(sessionCipher.Seal(nonce, nonce, data, []byte(sessionCookie))),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   requestSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// sealSession saves a session and returns the cookie it was sent in.
+func sealSession(t *testing.T, r *http.Request, session *Session) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	saveSession(w, r, session)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie {
+		t.Fatalf("saveSession set %v, want one %s cookie", cookies, sessionCookie)
+	}
+	return cookies[0]
+}
+
+// openSession reads the session a request with a cookie carries.
+func openSession(cookie *http.Cookie) *Session {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	return sessionFrom(r)
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	session := &Session{
+		Lens:      "pirate",
+		PassUntil: 1700000000,
+		Trail:     []TrailEntry{{Title: "Ancient Rome"}, {Title: "run", Kind: "dictionary"}},
+	}
+	cookie := sealSession(t, httptest.NewRequest("GET", "/", nil), session)
+	if got := openSession(cookie); !reflect.DeepEqual(got, session) {
+		t.Errorf("opened %+v, want %+v", got, session)
+	}
+}
+
+func TestSessionRejectsTampering(t *testing.T) {
+	cookie := sealSession(t, httptest.NewRequest("GET", "/", nil), &Session{Lens: "pirate", PassUntil: 1700000000})
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(i int) string {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		return base64.RawURLEncoding.EncodeToString(tampered)
+	}
+	tests := map[string]string{
+		"nonce changed":      flip(0),
+		"ciphertext changed": flip(sessionCipher.NonceSize()),
+		"tag changed":        flip(len(sealed) - 1),
+		"cut short":          base64.RawURLEncoding.EncodeToString(sealed[:len(sealed)-4]),
+		"only a nonce":       base64.RawURLEncoding.EncodeToString(sealed[:sessionCipher.NonceSize()]),
+		"not base64":         "not a session!",
+		"empty":              "",
+		"plain JSON":         base64.RawURLEncoding.EncodeToString([]byte(`{"pass_until":9999999999}`)),
+	}
+	for name, value := range tests {
+		got := openSession(&http.Cookie{Name: sessionCookie, Value: value})
+		if !reflect.DeepEqual(got, &Session{}) {
+			t.Errorf("%s: opened %+v, want an empty session", name, got)
+		}
+	}
+}
+
+func TestSessionCookieSecure(t *testing.T) {
+	saved := trustedProxies
+	defer func() { trustedProxies = saved }()
+	trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name   string
+		remote string
+		proto  []string
+		want   bool
+	}{
+		{"plain HTTP", "203.0.113.7:4000", nil, false},
+		{"HTTPS at a trusted proxy", "10.0.0.2:4000", []string{"https"}, true},
+		{"HTTP at a trusted proxy", "10.0.0.2:4000", []string{"http"}, false},
+		{"proxy without the header", "10.0.0.2:4000", nil, false},
+		{"proxy after a spoofed https", "10.0.0.2:4000", []string{"https, http"}, false},
+		{"proxy appending https", "10.0.0.2:4000", []string{"http", "HTTPS"}, true},
+		{"untrusted peer claiming https", "203.0.113.7:4000", []string{"https"}, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		for _, proto := range test.proto {
+			r.Header.Add("X-Forwarded-Proto", proto)
+		}
+		if got := sealSession(t, r, &Session{}).Secure; got != test.want {
+			t.Errorf("%s: Secure = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	t.Setenv("SECURE_COOKIES", "true")
+	r := httptest.NewRequest("GET", "/", nil)
+	if !sealSession(t, r, &Session{}).Secure {
+		t.Errorf("SECURE_COOKIES didn't mark the cookie Secure")
+	}
+}
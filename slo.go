@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The error budget watches how ollama is doing for readers: the share of
+// generations that fail and how long the slowest take to start writing,
+// over the last SLO_WINDOW. When either goes past its objective,
+// SLO_ERROR_PERCENT or SLO_LATENCY, the instance is degraded: operators are
+// alerted at ALERT_WEBHOOK and ADMIN_EMAIL, and readers see a banner saying
+// articles may be slow or fail. They are alerted again once it recovers.
+// Cancelled generations say nothing about ollama and aren't counted.
+
+// sloCheckInterval is how often the objectives are checked.
+const sloCheckInterval = 30 * time.Second
+
+// maxSLOSamples caps how many generations the window remembers.
+const maxSLOSamples = 10000
+
+// sloLatencyPercentile is the share of generations that must start writing
+// within SLO_LATENCY.
+const sloLatencyPercentile = 0.95
+
+// degradedMessage is the banner readers see while the instance is degraded.
+const degradedMessage = "Article generation is having trouble right now, so articles may be slow to appear or fail. We're on it."
+
+// sloSample is one finished generation.
+type sloSample struct {
+	time    time.Time
+	failed  bool
+	latency time.Duration
+}
+
+// SLOStatus is how ollama is doing over the window.
+type SLOStatus struct {
+	Degraded     bool     `json:"degraded"`
+	Reasons      []string `json:"reasons,omitempty"`
+	Generations  int      `json:"generations"`
+	ErrorPercent float64  `json:"error_percent"`
+	// LatencySeconds is the time to first text of the slowest generations,
+	// at sloLatencyPercentile
+	LatencySeconds float64 `json:"latency_seconds"`
+	Window         string  `json:"window"`
+}
+
+var slo = struct {
+	mu           sync.Mutex
+	enabled      bool
+	window       time.Duration
+	errorPercent int
+	latency      time.Duration
+	minSamples   int
+	samples      []sloSample
+	degraded     bool
+}{}
+
+// observeGeneration remembers how a generation went, taking latency as how
+// long ollama took to send its first text. Failures before any text count
+// as failures only.
+func observeGeneration(ctx context.Context, latency time.Duration, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	slo.mu.Lock()
+	defer slo.mu.Unlock()
+
+	if !slo.enabled {
+		return
+	}
+	slo.samples = append(slo.samples, sloSample{time: time.Now(), failed: err != nil, latency: latency})
+	if len(slo.samples) > maxSLOSamples {
+		slo.samples = slo.samples[len(slo.samples)-maxSLOSamples:]
+	}
+}
+
+// sloStatus works out how ollama is doing, forgetting generations that have
+// left the window. Callers hold slo.mu.
+func sloStatus() SLOStatus {
+	cutoff := time.Now().Add(-slo.window)
+	start := sort.Search(len(slo.samples), func(i int) bool { return slo.samples[i].time.After(cutoff) })
+	slo.samples = slo.samples[start:]
+
+	status := SLOStatus{Generations: len(slo.samples), Window: slo.window.String()}
+	if len(slo.samples) == 0 {
+		return status
+	}
+
+	failed := 0
+	var latencies []time.Duration
+	for _, sample := range slo.samples {
+		if sample.failed {
+			failed++
+		}
+		if sample.latency > 0 {
+			latencies = append(latencies, sample.latency)
+		}
+	}
+	status.ErrorPercent = 100 * float64(failed) / float64(len(slo.samples))
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		index := int(float64(len(latencies)-1) * sloLatencyPercentile)
+		status.LatencySeconds = latencies[index].Seconds()
+	}
+
+	// A handful of generations is too few to judge by
+	if len(slo.samples) < slo.minSamples {
+		return status
+	}
+	if slo.errorPercent > 0 && status.ErrorPercent > float64(slo.errorPercent) {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("%.0f%% of generations failed, over the %d%% objective", status.ErrorPercent, slo.errorPercent))
+	}
+	if slo.latency > 0 && len(latencies) >= slo.minSamples && status.LatencySeconds > slo.latency.Seconds() {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("%.0f%% of generations took up to %.1fs to start, over the %s objective",
+			sloLatencyPercentile*100, status.LatencySeconds, slo.latency))
+	}
+	status.Degraded = len(status.Reasons) > 0
+	return status
+}
+
+// degradedBanner is the banner readers see while the instance is degraded,
+// with an empty message when it isn't.
+func degradedBanner() Banner {
+	slo.mu.Lock()
+	defer slo.mu.Unlock()
+
+	if !slo.degraded {
+		return Banner{}
+	}
+	return Banner{ID: "degraded", Message: degradedMessage, Level: "warning"}
+}
+
+// startErrorBudget checks the objectives until the process exits, if any
+// are set.
+func startErrorBudget() {
+	errorPercent := envInt("SLO_ERROR_PERCENT", 0)
+	latency := envDuration("SLO_LATENCY", 0)
+	if errorPercent <= 0 && latency <= 0 {
+		return
+	}
+
+	slo.mu.Lock()
+	slo.enabled = true
+	slo.window = envDuration("SLO_WINDOW", 15*time.Minute)
+	slo.errorPercent = errorPercent
+	slo.latency = latency
+	slo.minSamples = envInt("SLO_MIN_GENERATIONS", 5)
+	slo.mu.Unlock()
+
+	go func() {
+		for {
+			time.Sleep(sloCheckInterval)
+
+			slo.mu.Lock()
+			status := sloStatus()
+			changed := status.Degraded != slo.degraded
+			slo.degraded = status.Degraded
+			slo.mu.Unlock()
+
+			if changed {
+				if status.Degraded {
+					log.Printf("Generation is degraded: %s", strings.Join(status.Reasons, "; "))
+				} else {
+					log.Printf("Generation has recovered")
+				}
+				go sendSLOAlert(status)
+			}
+		}
+	}()
+}
+
+// sendSLOAlert tells the operators the instance went degraded or recovered.
+func sendSLOAlert(status SLOStatus) {
+	site := settings.siteName()
+
+	if webhook := os.Getenv("ALERT_WEBHOOK"); webhook != "" {
+		payload, _ := json.Marshal(struct {
+			Site string `json:"site"`
+			SLOStatus
+		}{site, status})
+		client := &http.Client{Timeout: notifyTimeout}
+		resp, err := client.Post(webhook, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("the webhook answered %s", resp.Status)
+			}
+		}
+		if err != nil {
+			log.Printf("Error posting the error budget alert: %v", err)
+		}
+	}
+
+	if to := os.Getenv("ADMIN_EMAIL"); to != "" && emailEnabled() {
+		data := struct {
+			Site   string
+			Status SLOStatus
+			Admin  string
+		}{site, status, publicLink("/admin")}
+		if err := sendTemplatedEmail(to, "slo", "", data); err != nil {
+			log.Printf("Error emailing the error budget alert: %v", err)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Articles live at URLs made from a slug of their title, like
+// /wiki/ancient-rome for "Ancient Rome" and /wiki/ancient-rome/military for
+// its sub-article, so links survive being copied, pasted and passed through
+// proxies that mangle spaces and punctuation. A slug is the title lowercased,
+// with accented Latin letters spelled without their accents, apostrophes
+// dropped and any other run of characters that aren't letters or numbers
+// turned into a hyphen. Letters of other scripts are kept as they are. A
+// level of the title that loses anything more than a single space, hyphen or
+// underscore between two words ends in a short hash of what it was, so "C",
+// "C++" and "C#" get slugs of their own: c, c-4c21a3 and c-9629f5.
+//
+// The page still shows the title, so each wiki remembers which title every
+// slug it has handed out stands for: the titles of its stored articles, of
+// the articles it has written or served and what they link to, and of its
+// navigation. Titles that differ only in case or accents share a slug, and
+// the first one seen keeps it. Readers don't get a say in that: a title
+// asked for by its old URL, which redirects to the slug, is only remembered
+// in lowercase. With an article store the titles of slugs are kept in it
+// too, so replicas sharing it agree on them and restarts don't forget them.
+// A slug the wiki doesn't know, typed in by hand, is read as a title with
+// its hyphens as spaces, in lowercase.
+
+// slugKind is the kind the titles of slugs are kept under in the article
+// store.
+const slugKind = "slugs"
+
+// slugStore is the article store the titles of slugs are kept in, without
+// the limits articles are evicted by, or nil when articles aren't kept.
+var slugStore ArticleStore
+
+// maxSlugTitles caps how many slugs a wiki remembers the titles of.
+const maxSlugTitles = 100000
+
+// slugLetters spells accented and other special Latin letters in plain
+// ASCII. static/slug.js has the same table, so pages link to the same slugs.
+var slugLetters = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae",
+	'ç': "c", 'ć': "c", 'č': "c",
+	'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ľ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'œ': "oe",
+	'ř': "r",
+	'ś': "s", 'š': "s", 'ş': "s", 'ș': "s",
+	'ß': "ss",
+	'ť': "t", 'ţ': "t", 'ț': "t",
+	'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// slugify makes the slug of a title, keeping the slashes between the levels
+// of a sub-article.
+func slugify(title string) string {
+	var segments []string
+	for _, segment := range strings.Split(title, "/") {
+		var slug strings.Builder
+		lossy := false
+		// run counts the characters since the last letter or number, and
+		// only a single space, hyphen or underscore between two words is
+		// spelled by the hyphen it becomes
+		run, separator := 0, false
+		lower := strings.ToLower(segment)
+		for _, r := range lower {
+			spelled, ok := slugLetters[r]
+			switch {
+			case ok:
+			case r == '\'' || r == '’':
+				continue
+			case unicode.IsLetter(r) || unicode.IsNumber(r):
+				spelled = string(r)
+			default:
+				run++
+				separator = unicode.IsSpace(r) || r == '-' || r == '_'
+				continue
+			}
+			if run > 0 {
+				lossy = lossy || slug.Len() == 0 || run > 1 || !separator
+				if slug.Len() > 0 {
+					slug.WriteByte('-')
+				}
+				run = 0
+			}
+			slug.WriteString(spelled)
+		}
+		if run > 0 {
+			lossy = true
+		}
+		if lossy {
+			if slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteString(slugHash(lower))
+		}
+		if slug.Len() > 0 {
+			segments = append(segments, slug.String())
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// slugHash tells apart the levels of titles that only differ in the
+// punctuation their slugs drop: the first six hex digits of the FNV-1a hash
+// of the lowercased level.
+func slugHash(segment string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(segment))
+	return fmt.Sprintf("%08x", hash.Sum32())[:6]
+}
+
+// isSlug reports whether a requested title is already a slug.
+func isSlug(title string) bool {
+	return title != "" && slugify(title) == title
+}
+
+// slugPath is the escaped path of an article's slug, to follow /wiki/ and
+// the like in links.
+func slugPath(title string) string {
+	segments := strings.Split(slugify(title), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// slugTitles maps the slugs of each wiki to the titles they stand for.
+var slugTitles = struct {
+	mu     sync.Mutex
+	titles map[string]map[string]string
+}{
+	titles: map[string]map[string]string{},
+}
+
+// rememberTitle remembers the title a slug of the wiki under ctx stands for.
+// The first title seen for a slug keeps it.
+func rememberTitle(ctx context.Context, title string) {
+	rememberWikiTitle(ctx, wikiFrom(ctx).Name, title)
+}
+
+// rememberRequestedTitle remembers the title of a slug as a reader asked
+// for it, in lowercase. Anyone can ask for any title, so the first reader to
+// ask for a slug doesn't get to pick how everyone else sees it, only the
+// wiki's own links and settings do. The slug already stands for the
+// lowercase title, which is only remembered so slugs that drop punctuation
+// lead back to it.
+func rememberRequestedTitle(ctx context.Context, title string) {
+	rememberTitle(ctx, strings.ToLower(title))
+}
+
+// rememberWikiTitle remembers the title a slug of a wiki stands for, and
+// those of a sub-article's ancestors, and keeps the new ones in the store in
+// the background.
+func rememberWikiTitle(ctx context.Context, wiki, title string) {
+	learned := learnTitle(wiki, title)
+	if len(learned) == 0 || slugStore == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for _, slug := range learned {
+			keepSlug(ctx, wiki, slug[0], slug[1])
+		}
+	}()
+}
+
+// learnTitle remembers the title a slug of a wiki stands for, and those of a
+// sub-article's ancestors, in memory only. It returns the slugs it hadn't
+// known, with their titles.
+func learnTitle(wiki, title string) [][2]string {
+	slugTitles.mu.Lock()
+	defer slugTitles.mu.Unlock()
+	titles, ok := slugTitles.titles[wiki]
+	if !ok {
+		titles = map[string]string{}
+		slugTitles.titles[wiki] = titles
+	}
+
+	var learned [][2]string
+	for ok := true; ok; title, ok = parentTitle(title) {
+		slug := slugify(title)
+		if _, known := titles[slug]; known || slug == "" || len(titles) >= maxSlugTitles {
+			continue
+		}
+		titles[slug] = title
+		learned = append(learned, [2]string{slug, title})
+	}
+	return learned
+}
+
+// keepSlug keeps the title of a slug in the store. When another replica
+// kept another title for it first, that title wins here too.
+func keepSlug(ctx context.Context, wiki, slug, title string) {
+	kept, ok, err := slugStore.Get(ctx, wiki, slugKind, slug)
+	switch {
+	case err != nil:
+		log.Printf("Error reading the title of slug '%s' of wiki '%s': %v", slug, wiki, err)
+	case ok && kept.Content != title:
+		setSlugTitle(wiki, slug, kept.Content)
+	case !ok:
+		article := StoredArticle{Title: slug, Kind: slugKind, Content: title}
+		if err := slugStore.Put(ctx, wiki, article); err != nil {
+			log.Printf("Error keeping the title of slug '%s' of wiki '%s': %v", slug, wiki, err)
+		}
+	}
+}
+
+// setSlugTitle sets the title a slug of a wiki stands for in memory.
+func setSlugTitle(wiki, slug, title string) {
+	slugTitles.mu.Lock()
+	defer slugTitles.mu.Unlock()
+	titles, ok := slugTitles.titles[wiki]
+	if !ok {
+		titles = map[string]string{}
+		slugTitles.titles[wiki] = titles
+	}
+	if _, known := titles[slug]; known || len(titles) < maxSlugTitles {
+		titles[slug] = title
+	}
+}
+
+// rememberArticleTitles remembers the title of an article and of every
+// article it links to, so the slugs of its links lead to their titles.
+func rememberArticleTitles(ctx context.Context, article StoredArticle) {
+	rememberTitle(ctx, article.Title)
+	rememberLinkTitles(ctx, article.Content)
+}
+
+// rememberLinkTitles remembers the titles of the articles some markdown
+// links to.
+func rememberLinkTitles(ctx context.Context, content string) {
+	for _, match := range topicLinkPattern.FindAllStringSubmatch(content, -1) {
+		if title, err := normalizeTitle(match[1]); err == nil {
+			rememberTitle(ctx, title)
+		}
+	}
+}
+
+// slugTitle returns the title a slug of a wiki stands for, looking in the
+// store for one it doesn't remember.
+func slugTitle(ctx context.Context, wiki, slug string) (string, bool) {
+	slugTitles.mu.Lock()
+	title, ok := slugTitles.titles[wiki][slug]
+	slugTitles.mu.Unlock()
+	if ok || slugStore == nil {
+		return title, ok
+	}
+
+	kept, ok, err := slugStore.Get(ctx, wiki, slugKind, slug)
+	if err != nil {
+		log.Printf("Error reading the title of slug '%s' of wiki '%s': %v", slug, wiki, err)
+	}
+	if !ok {
+		return "", false
+	}
+	setSlugTitle(wiki, slug, kept.Content)
+	return kept.Content, true
+}
+
+// titleForSlug returns the title a slug of the wiki under ctx stands for. A
+// sub-article the wiki doesn't know keeps the title of the closest ancestor
+// it does.
+func titleForSlug(ctx context.Context, slug string) string {
+	wiki := wikiFrom(ctx).Name
+	segments := strings.Split(slug, "/")
+	known, title := 0, ""
+	for i := len(segments); i > 0; i-- {
+		if t, ok := slugTitle(ctx, wiki, strings.Join(segments[:i], "/")); ok {
+			known, title = i, t
+			break
+		}
+	}
+
+	for _, segment := range segments[known:] {
+		if title != "" {
+			title += "/"
+		}
+		title += unslug(segment)
+	}
+	return title
+}
+
+// unslug reads one level of a slug nobody said the title of as a title, with
+// spaces for its hyphens. It keeps the slug's lowercase, so typing
+// /wiki/run still offers the dictionary entry for the word.
+func unslug(segment string) string {
+	return strings.ReplaceAll(segment, "-", " ")
+}
+
+// loadSlugTitles remembers the titles of every wiki's stored articles.
+func loadSlugTitles() {
+	if articles == nil {
+		return
+	}
+
+	ctx := context.Background()
+	kinds := []string{""}
+	for name := range articleKinds {
+		kinds = append(kinds, name)
+	}
+	for _, wiki := range allWikis() {
+		for _, kind := range kinds {
+			titles, err := articles.List(ctx, wiki.Name, kind)
+			if err != nil {
+				log.Printf("Error listing stored articles of wiki '%s' for their slugs: %v", wiki.Name, err)
+				continue
+			}
+			for _, title := range titles {
+				learnTitle(wiki.Name, title)
+			}
+		}
+	}
+}
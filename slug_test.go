@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// The slugs are the same ones static/slug.js makes, so pages link to them.
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title, slug string
+	}{
+		{"Ancient Rome", "ancient-rome"},
+		{"Café Society/Members", "cafe-society/members"},
+		{"Spider-Man", "spider-man"},
+		{"Don't Panic", "dont-panic"},
+		{"日本 語", "日本-語"},
+		{"C", "c"},
+		{"C++", "c-4c21a3"},
+		{"C#", "c-9629f5"},
+		{"C--", "c-2e307f"},
+		{"100%", "100-2f97df"},
+		{"Rome (city)", "rome-city-40ca6a"},
+		{"What? #hash 100%", "what-hash-100-8bea1d"},
+		{"AC/DC", "ac/dc"},
+		{"++", "fedbf2"},
+	}
+	for _, test := range tests {
+		if slug := slugify(test.title); slug != test.slug {
+			t.Errorf("slugify(%q) = %q, want %q", test.title, slug, test.slug)
+		}
+		if !isSlug(test.slug) {
+			t.Errorf("isSlug(%q) = false, want true", test.slug)
+		}
+	}
+}
+
+func TestSlugsDontCollide(t *testing.T) {
+	titles := []string{"C", "C++", "C#", "C--", "100", "100%", "What?", "What!", "What"}
+	seen := map[string]string{}
+	for _, title := range titles {
+		slug := slugify(title)
+		if other, ok := seen[slug]; ok {
+			t.Errorf("%q and %q share the slug %q", title, other, slug)
+		}
+		seen[slug] = title
+	}
+}
+
+// Readers asking for a title don't decide how it's written for everyone.
+func TestRequestedTitlesDontPickTheTitle(t *testing.T) {
+	ctx := context.Background()
+	rememberRequestedTitle(ctx, "Rust++")
+	if title, _ := slugTitle(ctx, defaultWikiName, slugify("Rust++")); title != "rust++" {
+		t.Errorf("title of a requested slug = %q, want %q", title, "rust++")
+	}
+	rememberTitle(ctx, "Zig++")
+	rememberRequestedTitle(ctx, "ZIG++")
+	if title, _ := slugTitle(ctx, defaultWikiName, slugify("Zig++")); title != "Zig++" {
+		t.Errorf("title of a linked slug = %q, want %q", title, "Zig++")
+	}
+}
+
+func TestRequestedWord(t *testing.T) {
+	tests := []struct {
+		path, title string
+		dictionary  bool
+	}{
+		{"/wiki/run", "Run", true},
+		{"/wiki/run", "run", true},
+		{"/wiki/ancient-rome", "Ancient Rome", false},
+		{"/wiki/c-4c21a3", "C++", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r = mux.SetURLVars(r, map[string]string{"article": strings.TrimPrefix(test.path, "/wiki/")})
+		if got := isDictionaryWord(requestedWord(r, test.title)); got != test.dictionary {
+			t.Errorf("dictionary word for %s titled %q = %v, want %v", test.path, test.title, got, test.dictionary)
+		}
+	}
+}
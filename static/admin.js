@@ -0,0 +1,7 @@
+document.querySelectorAll('form.delete-wiki').forEach(function(form) {
+    form.addEventListener('submit', function(event) {
+        if (!confirm('Delete this wiki?')) {
+            event.preventDefault();
+        }
+    });
+});
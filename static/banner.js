@@ -0,0 +1,9 @@
+// Keep the site banner dismissed until it changes
+const siteBanner = document.getElementById('siteBanner');
+if (localStorage.getItem('endless-wiki-banner-dismissed') === siteBanner.dataset.banner) {
+    siteBanner.hidden = true;
+}
+document.getElementById('siteBannerDismiss').addEventListener('click', function() {
+    localStorage.setItem('endless-wiki-banner-dismissed', siteBanner.dataset.banner);
+    siteBanner.hidden = true;
+});
@@ -0,0 +1,84 @@
+const page = document.body.dataset;
+const title = page.title;
+const markdown = { a: '', b: '' };
+const finished = { a: false, b: false };
+const diffToggle = document.getElementById('diffToggle');
+const diffDiv = document.getElementById('diff');
+const columnsDiv = document.getElementById('columns');
+
+function streamArticle(key, model, contentDiv) {
+    const eventSource = new EventSource('/stream/' + encodeURIComponent(title) + '?model=' + encodeURIComponent(model));
+
+    eventSource.addEventListener('content', function(event) {
+        let content = event.data.replace(/\\n/g, '\n');
+        content = content.replace(/^```[a-zA-Z]*\n?/, '').replace(/\n?```$/, '');
+        markdown[key] = content;
+        contentDiv.innerHTML = marked.parse(content);
+    });
+
+    eventSource.addEventListener('complete', function(event) {
+        eventSource.close();
+        finished[key] = true;
+        if (finished.a && finished.b) {
+            diffToggle.disabled = false;
+        }
+    });
+
+    eventSource.onerror = function(event) {
+        const error = document.createElement('p');
+        error.style.color = 'red';
+        error.textContent = 'Error generating article with ' + model + '.';
+        contentDiv.replaceChildren(error);
+        eventSource.close();
+    };
+}
+
+// Line based diff using the longest common subsequence
+function diffLines(a, b) {
+    const lines = [];
+    const m = a.length, n = b.length;
+    const lcs = Array.from({ length: m + 1 }, function() { return new Array(n + 1).fill(0); });
+    for (let i = m - 1; i >= 0; i--) {
+        for (let j = n - 1; j >= 0; j--) {
+            lcs[i][j] = a[i] === b[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+        }
+    }
+    let i = 0, j = 0;
+    while (i < m && j < n) {
+        if (a[i] === b[j]) {
+            lines.push({ type: 'same', text: a[i] });
+            i++; j++;
+        } else if (lcs[i + 1][j] >= lcs[i][j + 1]) {
+            lines.push({ type: 'removed', text: a[i++] });
+        } else {
+            lines.push({ type: 'added', text: b[j++] });
+        }
+    }
+    while (i < m) lines.push({ type: 'removed', text: a[i++] });
+    while (j < n) lines.push({ type: 'added', text: b[j++] });
+    return lines;
+}
+
+diffToggle.addEventListener('click', function() {
+    if (diffDiv.style.display === 'block') {
+        diffDiv.style.display = 'none';
+        columnsDiv.style.display = 'flex';
+        diffToggle.textContent = 'Show diff';
+        return;
+    }
+
+    diffDiv.innerHTML = '';
+    diffLines(markdown.a.split('\n'), markdown.b.split('\n')).forEach(function(line) {
+        const div = document.createElement('div');
+        const prefix = line.type === 'added' ? '+ ' : line.type === 'removed' ? '- ' : '  ';
+        div.className = line.type;
+        div.textContent = prefix + line.text;
+        diffDiv.appendChild(div);
+    });
+    diffDiv.style.display = 'block';
+    columnsDiv.style.display = 'none';
+    diffToggle.textContent = 'Show side by side';
+});
+
+streamArticle('a', page.modelA, document.getElementById('contentA'));
+streamArticle('b', page.modelB, document.getElementById('contentB'));
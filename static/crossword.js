@@ -0,0 +1,33 @@
+// The answers are in the page, so checking them needs no server. Typing a
+// letter moves on to the next square of the row
+var squares = Array.prototype.slice.call(document.querySelectorAll('.grid input'));
+
+squares.forEach(function(input) {
+    input.addEventListener('input', function() {
+        input.value = input.value.slice(-1).toUpperCase();
+        input.parentNode.classList.remove('right', 'wrong');
+        var next = input.parentNode.nextElementSibling;
+        if (input.value && next && next.querySelector('input')) {
+            next.querySelector('input').focus();
+        }
+    });
+});
+
+document.getElementById('check').addEventListener('click', function() {
+    squares.forEach(function(input) {
+        input.parentNode.classList.remove('right', 'wrong');
+        if (input.value) {
+            input.parentNode.classList.add(input.value.toUpperCase() === input.dataset.answer ? 'right' : 'wrong');
+        }
+    });
+});
+
+document.getElementById('reveal').addEventListener('click', function() {
+    squares.forEach(function(input) {
+        input.value = input.dataset.answer;
+        input.parentNode.classList.remove('right', 'wrong');
+    });
+    document.querySelectorAll('.clues .answer').forEach(function(link) {
+        link.hidden = false;
+    });
+});
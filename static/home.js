@@ -0,0 +1,64 @@
+document.getElementById('searchInput').addEventListener('keypress', function(event) {
+    if (event.key === 'Enter') {
+        searchWiki();
+    }
+});
+document.getElementById('searchButton').addEventListener('click', searchWiki);
+
+// Live activity ticker, opted in per browser
+const tickerToggle = document.getElementById('tickerToggle');
+let tickerSource = null;
+
+function articleURL(event) {
+    return '/' + (event.kind || 'wiki') + '/' + slugPath(event.title);
+}
+
+function startTicker() {
+    const list = document.getElementById('tickerList');
+    tickerSource = new EventSource('/activity');
+    tickerSource.addEventListener('activity', function(event) {
+        const activity = JSON.parse(event.data);
+        const item = document.createElement('li');
+        const link = document.createElement('a');
+        link.href = articleURL(activity);
+        link.textContent = activity.title;
+        if (activity.type === 'generating') {
+            item.className = 'generating';
+            item.append('Someone is generating ', link, '…');
+        } else {
+            item.append('Just written: ', link);
+        }
+        list.prepend(item);
+        while (list.children.length > 20) {
+            list.removeChild(list.lastChild);
+        }
+    });
+}
+
+function stopTicker() {
+    if (tickerSource) {
+        tickerSource.close();
+        tickerSource = null;
+    }
+    document.getElementById('tickerList').replaceChildren();
+}
+
+if (tickerToggle) {
+    tickerToggle.checked = localStorage.getItem('endless-wiki-ticker') === 'on';
+    if (tickerToggle.checked) {
+        startTicker();
+    }
+    tickerToggle.addEventListener('change', function() {
+        localStorage.setItem('endless-wiki-ticker', tickerToggle.checked ? 'on' : 'off');
+        tickerToggle.checked ? startTicker() : stopTicker();
+    });
+}
+
+function searchWiki() {
+    const input = document.getElementById('searchInput');
+    const topic = input.value.trim();
+    if (topic) {
+        // Go by the title, the server redirects to its slug and remembers it
+        window.location.href = '/wiki/' + encodeURIComponent(topic);
+    }
+}
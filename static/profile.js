@@ -0,0 +1,42 @@
+const progress = JSON.parse(localStorage.getItem('endless-wiki-progress') || '{}');
+const types = progress.types || {};
+
+// A streak only counts if the last read was today or yesterday
+const today = new Date().toISOString().slice(0, 10);
+const yesterday = new Date(Date.now() - 86400000).toISOString().slice(0, 10);
+const streak = (progress.lastDay === today || progress.lastDay === yesterday) ? progress.streak || 0 : 0;
+
+const badges = [
+    { icon: '📖', name: 'First steps', description: 'Read your first article', earned: (progress.articles || 0) >= 1 },
+    { icon: '📚', name: 'Bookworm', description: 'Read 25 articles', earned: (progress.articles || 0) >= 25 },
+    { icon: '🏛️', name: 'Encyclopedist', description: 'Read 100 articles', earned: (progress.articles || 0) >= 100 },
+    { icon: '🐇', name: 'Down the rabbit hole', description: 'Follow 5 articles in a row', earned: (progress.maxDepth || 0) >= 5 },
+    { icon: '🕳️', name: 'Lost in the wiki', description: 'Follow 15 articles in a row', earned: (progress.maxDepth || 0) >= 15 },
+    { icon: '🔥', name: 'Habit forming', description: 'Read on 7 days in a row', earned: (progress.bestStreak || 0) >= 7 },
+    { icon: '🧭', name: 'Well rounded', description: 'Read about a person, place, organism, event and concept',
+        earned: ['person', 'place', 'organism', 'event', 'concept'].every(function(type) { return types[type]; }) },
+    { icon: '🗺️', name: 'Off the beaten path', description: 'Read a portal, dictionary entry, how-to guide and news story',
+        earned: ['portal', 'dictionary', 'how-to', 'news'].every(function(type) { return types[type]; }) }
+];
+
+document.getElementById('articles').textContent = progress.articles || 0;
+document.getElementById('streak').textContent = streak;
+document.getElementById('maxDepth').textContent = progress.maxDepth || 0;
+
+const explored = Object.keys(types);
+if (explored.length > 0) {
+    document.getElementById('types').textContent = 'Explored: ' + explored.map(function(type) {
+        return type + ' (' + types[type] + ')';
+    }).join(', ');
+}
+
+const badgesDiv = document.getElementById('badges');
+badges.forEach(function(badge) {
+    const div = document.createElement('div');
+    div.className = 'badge' + (badge.earned ? '' : ' locked');
+    div.innerHTML = '<div class="icon"></div><div class="name"></div><div class="description"></div>';
+    div.querySelector('.icon').textContent = badge.icon;
+    div.querySelector('.name').textContent = badge.name;
+    div.querySelector('.description').textContent = badge.description;
+    badgesDiv.appendChild(div);
+});
@@ -0,0 +1,52 @@
+const contentDiv = document.getElementById('content');
+const clock = document.getElementById('clock');
+let replay = null;
+let timer = null;
+
+function render(markdown) {
+    markdown = markdown.replace(/^```[a-zA-Z]*\n?/, '').replace(/\n?```$/, '');
+    markdown = markdown.replace(/\[\[([^\]]+)\]\]/g, function(match, topic) {
+        return '[' + topic + '](/wiki/' + slugPath(topic) + ')';
+    });
+    contentDiv.innerHTML = marked.parse(markdown);
+}
+
+// Re-animate the chunks with their original spacing, divided by the speed
+function play() {
+    clearTimeout(timer);
+    const speed = parseFloat(document.getElementById('speed').value);
+    let index = 0;
+    let markdown = '';
+
+    function step() {
+        const chunk = replay.chunks[index];
+        markdown += chunk.text;
+        render(markdown);
+        clock.textContent = (chunk.offset / 1000).toFixed(1) + 's';
+        index++;
+        if (index < replay.chunks.length) {
+            timer = setTimeout(step, (replay.chunks[index].offset - chunk.offset) / speed);
+        } else if (replay.final) {
+            // What was written was trimmed once it was finished
+            render(replay.final);
+        }
+    }
+
+    if (replay.chunks.length > 0) {
+        timer = setTimeout(step, replay.chunks[0].offset / speed);
+    }
+}
+
+document.getElementById('play').addEventListener('click', play);
+
+const page = document.body.dataset;
+fetch('/api/replay/' + encodeURIComponent(page.id) + (page.share ? '?share=' + encodeURIComponent(page.share) : ''))
+    .then(function(response) { return response.json(); })
+    .then(function(data) {
+        replay = data;
+        if (data.language) {
+            contentDiv.lang = data.language;
+        }
+        document.getElementById('articleLink').href = '/' + (data.kind || 'wiki') + '/' + slugPath(data.title);
+        play();
+    });
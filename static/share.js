@@ -0,0 +1,22 @@
+// Share links let anyone read a page of a private wiki for a while
+document.getElementById('shareLink').addEventListener('click', function(e) {
+    e.preventDefault();
+    const days = prompt('Let anyone with the link read this page for how many days?', '7');
+    if (!days) {
+        return;
+    }
+    fetch('/share', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ path: window.location.pathname, days: parseInt(days, 10) })
+    }).then(function(response) {
+        if (!response.ok) {
+            return response.text().then(function(message) { throw new Error(message); });
+        }
+        return response.json();
+    }).then(function(link) {
+        prompt('Share link, valid until ' + new Date(link.expires).toLocaleDateString(), window.location.origin + link.url);
+    }).catch(function(err) {
+        alert(err.message);
+    });
+});
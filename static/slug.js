@@ -0,0 +1,81 @@
+// Articles live at the slug of their title, like /wiki/ancient-rome. This
+// makes the same slugs as slugify in slug.go, table and all.
+const slugLetters = {
+    'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ā': 'a', 'ă': 'a', 'ą': 'a',
+    'æ': 'ae',
+    'ç': 'c', 'ć': 'c', 'č': 'c',
+    'ď': 'd', 'đ': 'd', 'ð': 'd',
+    'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ē': 'e', 'ė': 'e', 'ę': 'e', 'ě': 'e',
+    'ğ': 'g',
+    'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i', 'ī': 'i', 'į': 'i', 'ı': 'i',
+    'ł': 'l', 'ľ': 'l',
+    'ñ': 'n', 'ń': 'n', 'ň': 'n',
+    'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o', 'ō': 'o', 'ő': 'o',
+    'œ': 'oe',
+    'ř': 'r',
+    'ś': 's', 'š': 's', 'ş': 's', 'ș': 's',
+    'ß': 'ss',
+    'ť': 't', 'ţ': 't', 'ț': 't',
+    'þ': 'th',
+    'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ū': 'u', 'ů': 'u', 'ű': 'u', 'ų': 'u',
+    'ý': 'y', 'ÿ': 'y',
+    'ź': 'z', 'ż': 'z', 'ž': 'z'
+};
+
+function slugify(title) {
+    return title.split('/').map(function(segment) {
+        let slug = '';
+        let lossy = false;
+        // Only a single space, hyphen or underscore between two words is
+        // spelled by the hyphen it becomes
+        let run = 0;
+        let separator = false;
+        const lower = segment.toLowerCase();
+        for (const c of lower) {
+            let spelled = slugLetters[c];
+            if (spelled === undefined) {
+                if (c === "'" || c === '’') {
+                    continue;
+                }
+                if (!/[\p{L}\p{N}]/u.test(c)) {
+                    run++;
+                    separator = /[\s_-]/u.test(c);
+                    continue;
+                }
+                spelled = c;
+            }
+            if (run > 0) {
+                lossy = lossy || slug === '' || run > 1 || !separator;
+                if (slug !== '') {
+                    slug += '-';
+                }
+                run = 0;
+            }
+            slug += spelled;
+        }
+        if (run > 0) {
+            lossy = true;
+        }
+        if (lossy) {
+            slug += (slug !== '' ? '-' : '') + slugHash(lower);
+        }
+        return slug;
+    }).filter(function(slug) {
+        return slug !== '';
+    }).join('/');
+}
+
+// slugHash is the first six hex digits of the FNV-1a hash of a level of a
+// title, like slugHash in slug.go
+function slugHash(segment) {
+    let hash = 0x811c9dc5;
+    for (const byte of new TextEncoder().encode(segment)) {
+        hash = Math.imul(hash ^ byte, 0x01000193) >>> 0;
+    }
+    return hash.toString(16).padStart(8, '0').slice(0, 6);
+}
+
+// slugPath is the escaped path of an article's slug, to follow /wiki/
+function slugPath(title) {
+    return slugify(title).split('/').map(encodeURIComponent).join('/');
+}
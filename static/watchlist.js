@@ -0,0 +1,8 @@
+// Ticking a box saves it right away, so the Save buttons are only needed
+// without JavaScript
+document.querySelectorAll('.watches input[name=subpages]').forEach(function(box) {
+    box.form.querySelector('.save').hidden = true;
+    box.addEventListener('change', function() {
+        box.form.submit();
+    });
+});
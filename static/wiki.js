@@ -0,0 +1,729 @@
+// The page's article comes from the data attributes of its body
+const page = document.body.dataset;
+const streamParams = new URLSearchParams();
+if (page.kind) {
+    streamParams.set('kind', page.kind);
+}
+if (page.share) {
+    streamParams.set('share', page.share);
+}
+const contentDiv = document.getElementById('content');
+const popup = document.getElementById('selectionPopup');
+let selectedText = '';
+
+// Going back to an article shouldn't write it all over again. Browsers that
+// kept the page in their back-forward cache show it as it was, and others
+// get the finished article back from the copy kept for this tab.
+const articleKey = 'endless-wiki-article:' + window.location.pathname + window.location.search;
+const maxKeptArticles = 20;
+const navigation = performance.getEntriesByType('navigation')[0];
+const keptArticle = navigation && navigation.type === 'back_forward'
+    ? JSON.parse(sessionStorage.getItem(articleKey) || 'null')
+    : null;
+let completed = keptArticle !== null;
+
+// A restored article has nothing to stream, so its listeners never hear
+// from this stand-in
+const eventSource = keptArticle
+    ? { readyState: EventSource.CLOSED, addEventListener: function() {}, close: function() {} }
+    : new EventSource('/stream/' + encodeURIComponent(page.title) + '?' + streamParams.toString());
+if (keptArticle) {
+    restoreArticle(keptArticle);
+}
+
+eventSource.onmessage = function(event) {
+    // Handle default messages
+};
+
+eventSource.addEventListener('queue', function(event) {
+    const position = JSON.parse(event.data);
+    const loading = document.createElement('div');
+    loading.className = 'loading';
+    loading.textContent = 'Waiting for a free generation slot, you are number ' + position + ' in line';
+    contentDiv.replaceChildren(loading);
+});
+
+// A model ollama doesn't have yet is downloaded before it writes
+eventSource.addEventListener('pull', function(event) {
+    const progress = JSON.parse(event.data);
+    const loading = document.createElement('div');
+    loading.className = 'loading';
+    let text = 'Downloading the model ' + progress.model + ' before generating: ' + progress.status;
+    if (progress.total > 0) {
+        text += ', ' + Math.floor(100 * (progress.completed || 0) / progress.total) + '%';
+    }
+    loading.textContent = text;
+    contentDiv.replaceChildren(loading);
+});
+
+// While ollama is down only articles written before are served. Others are
+// queued to be written once it's back, when the instance keeps articles.
+eventSource.addEventListener('unavailable', function(event) {
+    eventSource.close();
+    const queued = JSON.parse(event.data);
+    const notice = document.createElement('div');
+    notice.className = 'unavailable';
+    const heading = document.createElement('p');
+    heading.textContent = 'Generation is temporarily unavailable, and this article hasn\'t been written yet.';
+    const detail = document.createElement('p');
+    detail.textContent = queued
+        ? 'It will be written as soon as generation is back. Check again in a little while.'
+        : 'Check again in a little while.';
+    notice.append(heading, detail);
+    contentDiv.replaceChildren(notice);
+});
+
+// Some instances ask new sessions to prove they aren't a bot before
+// generating. Once the server hands out a pass the page starts over.
+eventSource.addEventListener('challenge', function(event) {
+    eventSource.close();
+    const challenge = JSON.parse(event.data);
+    const notice = document.createElement('div');
+    notice.className = 'loading';
+    contentDiv.replaceChildren(notice);
+
+    function submit(solution) {
+        fetch('/gate', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(solution)
+        }).then(function(response) {
+            if (response.ok) {
+                window.location.reload();
+            } else {
+                contentDiv.innerHTML = '<p class="error">The check failed. Please reload the page to try again.</p>';
+            }
+        });
+    }
+
+    if (challenge.type === 'pow') {
+        notice.textContent = 'Checking your browser before generating';
+        solveProofOfWork(challenge.puzzle, challenge.difficulty, function(nonce) {
+            submit({ puzzle: challenge.puzzle, nonce: nonce });
+        });
+        return;
+    }
+
+    notice.textContent = 'Please confirm you are human to generate articles';
+    const widget = document.createElement('div');
+    contentDiv.appendChild(widget);
+    const script = document.createElement('script');
+    const options = {
+        sitekey: challenge.site_key,
+        callback: function(token) { submit({ token: token }); }
+    };
+    if (challenge.type === 'turnstile') {
+        script.src = 'https://challenges.cloudflare.com/turnstile/v0/api.js?render=explicit';
+        script.onload = function() { turnstile.render(widget, options); };
+    } else {
+        script.src = 'https://js.hcaptcha.com/1/api.js?render=explicit';
+        script.onload = function() { hcaptcha.render(widget, options); };
+    }
+    document.head.appendChild(script);
+});
+
+// SHA-256 in plain JavaScript, since crypto.subtle is missing on
+// instances served over plain http
+const sha256K = new Uint32Array([
+    0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+    0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+    0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+    0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+    0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+    0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+    0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+    0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2
+]);
+
+function sha256(message) {
+    const bytes = new TextEncoder().encode(message);
+    const padded = new Uint8Array(((bytes.length + 72) >> 6) << 6);
+    padded.set(bytes);
+    padded[bytes.length] = 0x80;
+    const view = new DataView(padded.buffer);
+    view.setUint32(padded.length - 4, bytes.length * 8);
+
+    const rotr = function(x, n) { return (x >>> n) | (x << (32 - n)); };
+    const hash = new Uint32Array([0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19]);
+    const w = new Uint32Array(64);
+    for (let offset = 0; offset < padded.length; offset += 64) {
+        for (let i = 0; i < 16; i++) {
+            w[i] = view.getUint32(offset + i * 4);
+        }
+        for (let i = 16; i < 64; i++) {
+            const s0 = rotr(w[i - 15], 7) ^ rotr(w[i - 15], 18) ^ (w[i - 15] >>> 3);
+            const s1 = rotr(w[i - 2], 17) ^ rotr(w[i - 2], 19) ^ (w[i - 2] >>> 10);
+            w[i] = w[i - 16] + s0 + w[i - 7] + s1;
+        }
+        let [a, b, c, d, e, f, g, h] = hash;
+        for (let i = 0; i < 64; i++) {
+            const t1 = (h + (rotr(e, 6) ^ rotr(e, 11) ^ rotr(e, 25)) + ((e & f) ^ (~e & g)) + sha256K[i] + w[i]) >>> 0;
+            const t2 = ((rotr(a, 2) ^ rotr(a, 13) ^ rotr(a, 22)) + ((a & b) ^ (a & c) ^ (b & c))) >>> 0;
+            h = g; g = f; f = e; e = (d + t1) >>> 0;
+            d = c; c = b; b = a; a = (t1 + t2) >>> 0;
+        }
+        hash[0] += a; hash[1] += b; hash[2] += c; hash[3] += d;
+        hash[4] += e; hash[5] += f; hash[6] += g; hash[7] += h;
+    }
+    return hash;
+}
+
+function leadingZeroBits(hash) {
+    let zeros = 0;
+    for (let i = 0; i < hash.length; i++) {
+        const bits = Math.clz32(hash[i]);
+        zeros += bits;
+        if (bits < 32) {
+            break;
+        }
+    }
+    return zeros;
+}
+
+// Search for a nonce in batches so the page stays responsive
+function solveProofOfWork(puzzle, difficulty, done) {
+    let nonce = 0;
+    (function batch() {
+        for (let end = nonce + 5000; nonce < end; nonce++) {
+            if (leadingZeroBits(sha256(puzzle + ':' + nonce)) >= difficulty) {
+                done(String(nonce));
+                return;
+            }
+        }
+        setTimeout(batch, 0);
+    })();
+}
+
+eventSource.addEventListener('content', function(event) {
+    let content = event.data.replace(/\\n/g, '\n');
+
+    // Strip out markdown code fences if they appear at the start
+    content = content.replace(/^```[a-zA-Z]*\n?/, '').replace(/\n?```$/, '');
+
+    // Turn [[Topic]] references into article links
+    content = content.replace(/\[\[([^\]]+)\]\]/g, function(match, topic) {
+        return '[' + topic + '](/wiki/' + slugPath(topic) + ')';
+    });
+
+    // Parse markdown and render as HTML
+    const htmlContent = marked.parse(content);
+    contentDiv.innerHTML = htmlContent;
+});
+
+eventSource.addEventListener('infobox', function(event) {
+    const fields = JSON.parse(event.data);
+    const infobox = document.createElement('dl');
+    infobox.className = 'infobox';
+    fields.forEach(function(field) {
+        const label = document.createElement('dt');
+        label.textContent = field.label;
+        const value = document.createElement('dd');
+        value.textContent = field.value;
+        infobox.appendChild(label);
+        infobox.appendChild(value);
+    });
+    contentDiv.prepend(infobox);
+});
+
+// Mark the first occurrence of each glossary term with its definition
+eventSource.addEventListener('glossary', function(event) {
+    const terms = JSON.parse(event.data);
+    terms.forEach(function(entry) {
+        const walker = document.createTreeWalker(contentDiv, NodeFilter.SHOW_TEXT, {
+            acceptNode: function(node) {
+                return node.parentElement.closest('a, code, pre, abbr, h1, h2, h3, h4')
+                    ? NodeFilter.FILTER_REJECT
+                    : NodeFilter.FILTER_ACCEPT;
+            }
+        });
+        let node;
+        while ((node = walker.nextNode())) {
+            const index = node.textContent.indexOf(entry.term);
+            if (index === -1) {
+                continue;
+            }
+            const match = node.splitText(index);
+            match.splitText(entry.term.length);
+            const abbr = document.createElement('abbr');
+            abbr.className = 'term';
+            abbr.title = entry.definition;
+            abbr.textContent = entry.term;
+            match.replaceWith(abbr);
+            break;
+        }
+    });
+});
+
+function showSuggestions(titles) {
+    const list = document.getElementById('suggestionList');
+    titles.forEach(function(title) {
+        const item = document.createElement('li');
+        const link = document.createElement('a');
+        link.href = '/wiki/' + slugPath(title);
+        link.textContent = title;
+        item.appendChild(link);
+        list.appendChild(item);
+    });
+    document.getElementById('suggestions').hidden = false;
+}
+
+// Extras further down the page are only worked out once the reader
+// gets near them
+function whenNear(element, callback) {
+    if (!('IntersectionObserver' in window)) {
+        callback();
+        return;
+    }
+    const observer = new IntersectionObserver(function(entries) {
+        if (entries.some(function(entry) { return entry.isIntersecting; })) {
+            observer.disconnect();
+            callback();
+        }
+    }, { rootMargin: '400px' });
+    observer.observe(element);
+}
+
+eventSource.addEventListener('lazy', function(event) {
+    const extras = JSON.parse(event.data);
+    if (extras.suggestions) {
+        whenNear(document.getElementById('suggestionsAnchor'), function() {
+            fetch('/api/extras/' + extras.suggestions + (page.share ? '?share=' + encodeURIComponent(page.share) : ''))
+                .then(function(response) { return response.status === 200 ? response.json() : null; })
+                .then(function(titles) {
+                    if (titles) {
+                        showSuggestions(titles);
+                    }
+                });
+        });
+    }
+});
+
+eventSource.addEventListener('replay', function(event) {
+    const replayLink = document.getElementById('replayLink');
+    replayLink.href = '/replay/' + JSON.parse(event.data);
+    replayLink.hidden = false;
+});
+
+// Reading progress is kept in the browser and shown on /profile
+let topicType = page.kind || null;
+eventSource.addEventListener('topic', function(event) {
+    topicType = JSON.parse(event.data);
+});
+
+// Say how the article was generated under it, so pages written by an
+// older model can be told apart
+eventSource.addEventListener('meta', function(event) {
+    const meta = JSON.parse(event.data);
+    let text = '';
+    if (meta.model) {
+        text += ' by ' + meta.model;
+    }
+    if (meta.fallback_from) {
+        text += ' (' + meta.fallback_from + ' failed)';
+    }
+    const generated = Date.parse(meta.generated);
+    if (generated > 0) {
+        text += ' on ' + new Date(generated).toLocaleString();
+    }
+    if (meta.duration) {
+        text += ' in ' + meta.duration.toFixed(1) + 's';
+    }
+    if (meta.tokens) {
+        text += ', ' + meta.tokens.toLocaleString() + ' tokens';
+    }
+    const footer = document.getElementById('articleMeta');
+    footer.textContent = 'Generated' + text;
+    footer.hidden = text === '';
+});
+
+// Tag the article with the language it came out in, for hyphenation
+// and screen reader voices
+eventSource.addEventListener('language', function(event) {
+    const lang = JSON.parse(event.data);
+    contentDiv.lang = lang;
+    const badge = document.getElementById('languageBadge');
+    try {
+        badge.textContent = new Intl.DisplayNames([navigator.language], { type: 'language' }).of(lang);
+    } catch (err) {
+        badge.textContent = lang;
+    }
+    badge.hidden = false;
+});
+
+function recordRead() {
+    const progress = JSON.parse(localStorage.getItem('endless-wiki-progress') || '{}');
+    progress.articles = (progress.articles || 0) + 1;
+
+    // Consecutive days with at least one article read
+    const today = new Date().toISOString().slice(0, 10);
+    const yesterday = new Date(Date.now() - 86400000).toISOString().slice(0, 10);
+    if (progress.lastDay !== today) {
+        progress.streak = progress.lastDay === yesterday ? (progress.streak || 0) + 1 : 1;
+        progress.lastDay = today;
+    }
+    progress.bestStreak = Math.max(progress.bestStreak || 0, progress.streak);
+
+    // Rabbit hole depth counts articles reached by following another article
+    let depth = 1;
+    if (document.referrer.startsWith(window.location.origin + '/wiki/')) {
+        depth = parseInt(sessionStorage.getItem('endless-wiki-depth') || '0', 10) + 1;
+    }
+    sessionStorage.setItem('endless-wiki-depth', depth);
+    progress.maxDepth = Math.max(progress.maxDepth || 0, depth);
+
+    if (topicType) {
+        progress.types = progress.types || {};
+        progress.types[topicType] = (progress.types[topicType] || 0) + 1;
+    }
+
+    localStorage.setItem('endless-wiki-progress', JSON.stringify(progress));
+}
+
+// The server keeps the trail of articles read this session, and
+// tells the page when a reader keeps cycling through the same few
+function recordTrail() {
+    fetch('/api/trail', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ title: page.title, kind: page.kind, type: topicType || '' })
+    })
+        .then(function(response) { return response.ok ? response.json() : null; })
+        .then(function(result) {
+            if (result && result.loop) {
+                offerEscape();
+            }
+        });
+}
+
+function offerEscape() {
+    fetch('/api/escape')
+        .then(function(response) { return response.ok ? response.json() : null; })
+        .then(function(suggestion) {
+            if (!suggestion) {
+                return;
+            }
+            const link = document.getElementById('loopEscape');
+            link.href = '/wiki/' + slugPath(suggestion.topic);
+            link.textContent = suggestion.topic;
+            document.getElementById('loopNotice').hidden = false;
+        });
+}
+
+eventSource.addEventListener('complete', function(event) {
+    eventSource.close();
+    completed = true;
+    document.getElementById('savePage').hidden = false;
+    keepArticle();
+    recordRead();
+    recordTrail();
+});
+
+// keepArticle keeps a copy of the finished article for this tab, to restore
+// when the reader comes back to it. Only the last few are kept.
+function keepArticle() {
+    const footer = document.getElementById('articleMeta');
+    const badge = document.getElementById('languageBadge');
+    const replayLink = document.getElementById('replayLink');
+    const article = {
+        content: contentDiv.innerHTML,
+        lang: contentDiv.lang,
+        language: badge.hidden ? '' : badge.textContent,
+        meta: footer.hidden ? '' : footer.textContent,
+        replay: replayLink.hidden ? '' : replayLink.getAttribute('href')
+    };
+
+    const kept = JSON.parse(sessionStorage.getItem('endless-wiki-articles') || '[]')
+        .filter(function(key) { return key !== articleKey; });
+    kept.push(articleKey);
+    while (kept.length > maxKeptArticles) {
+        sessionStorage.removeItem(kept.shift());
+    }
+    try {
+        sessionStorage.setItem(articleKey, JSON.stringify(article));
+        sessionStorage.setItem('endless-wiki-articles', JSON.stringify(kept));
+    } catch (err) {
+        // Out of room, the article will just be written again
+    }
+}
+
+function restoreArticle(article) {
+    contentDiv.innerHTML = article.content;
+    if (article.lang) {
+        contentDiv.lang = article.lang;
+    }
+    const badge = document.getElementById('languageBadge');
+    badge.textContent = article.language;
+    badge.hidden = article.language === '';
+    const footer = document.getElementById('articleMeta');
+    footer.textContent = article.meta;
+    footer.hidden = article.meta === '';
+    if (article.replay) {
+        const replayLink = document.getElementById('replayLink');
+        replayLink.href = article.replay;
+        replayLink.hidden = false;
+    }
+    document.getElementById('savePage').hidden = false;
+}
+
+eventSource.addEventListener('error', function(event) {
+    contentDiv.innerHTML = '<p class="error">Error generating article. Please try again.</p>';
+    eventSource.close();
+});
+
+eventSource.onerror = function(event) {
+    contentDiv.innerHTML = '<p class="error">Connection error. Please try again.</p>';
+    eventSource.close();
+};
+
+// Handle text selection
+document.addEventListener('mouseup', function(event) {
+    // Don't interfere if clicking on the popup
+    if (event.target === popup || popup.contains(event.target)) {
+        return;
+    }
+
+    setTimeout(function() {
+        const selection = window.getSelection();
+        const text = selection.toString().trim();
+
+        if (text.length > 0 && text.length <= 100) {
+            selectedText = text;
+            const range = selection.getRangeAt(0);
+            const rect = range.getBoundingClientRect();
+
+            // Position popup above the selection
+            popup.style.left = Math.max(10, rect.left + rect.width / 2 - 75) + 'px';
+            popup.style.top = (rect.top - 50 + window.scrollY) + 'px';
+            popup.style.display = 'block';
+            popup.textContent = 'Go to "' + text + '"';
+        } else {
+            popup.style.display = 'none';
+        }
+    }, 100);
+});
+
+// Handle popup click
+popup.addEventListener('click', function() {
+    if (selectedText) {
+        // Go by the title, the server redirects to its slug and remembers it
+        window.location.href = articleURL('/wiki/' + encodeURIComponent(selectedText));
+    }
+});
+
+// Hide popup when clicking elsewhere
+document.addEventListener('click', function(event) {
+    if (event.target !== popup && !popup.contains(event.target)) {
+        popup.style.display = 'none';
+        // Don't clear selection immediately, let it persist briefly
+    }
+});
+
+// Hide popup when selection changes to empty (but with delay to allow popup clicks)
+document.addEventListener('selectionchange', function() {
+    setTimeout(function() {
+        const selection = window.getSelection();
+        if (selection.toString().trim().length === 0 && popup.style.display === 'block') {
+            // Only hide if we're not hovering over the popup
+            if (!popup.matches(':hover')) {
+                popup.style.display = 'none';
+            }
+        }
+    }, 200);
+});
+
+function escapeHTML(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML.replace(/"/g, '&quot;');
+}
+
+// Save the rendered article as a self-contained HTML file
+document.getElementById('savePage').addEventListener('click', function(event) {
+    event.preventDefault();
+
+    const article = contentDiv.cloneNode(true);
+    // Rewrite links to absolute URLs so they still work offline
+    article.querySelectorAll('a[href]').forEach(function(link) {
+        link.setAttribute('href', link.href);
+    });
+
+    const title = page.title;
+    const pageTitle = escapeHTML(document.title);
+    const pageURL = escapeHTML(window.location.href);
+    const styles = Array.from(document.styleSheets)
+        .filter(function(sheet) { return sheet.href && new URL(sheet.href).origin === window.location.origin; })
+        .map(function(sheet) {
+            return Array.from(sheet.cssRules).map(function(rule) { return rule.cssText; }).join('\n');
+        })
+        .join('\n');
+    const html = '<!DOCTYPE html>\n<html>\n<head>\n<meta charset="utf-8">\n' +
+        '<title>' + pageTitle + '</title>\n' +
+        '<style>' + styles + '</style>\n</head>\n<body class="' + document.body.className + '">\n' +
+        '<div class="header"><h1>' + pageTitle + '</h1>' +
+        '<p>Saved from <a href="' + pageURL + '">' + pageURL + '</a></p></div>\n' +
+        '<div class="content">' + article.innerHTML + '</div>\n</body>\n</html>\n';
+
+    const blob = new Blob([html], { type: 'text/html' });
+    const download = document.createElement('a');
+    download.href = URL.createObjectURL(blob);
+    download.download = title.replace(/[^a-zA-Z0-9 _-]/g, '_') + '.html';
+    document.body.appendChild(download);
+    download.click();
+    document.body.removeChild(download);
+    URL.revokeObjectURL(download.href);
+});
+
+// Reading rooms keep everyone who follows the room on the same article
+const roomID = new URLSearchParams(window.location.search).get('room');
+if (roomID) {
+    const roomFollow = document.getElementById('roomFollow');
+    roomFollow.checked = sessionStorage.getItem('endless-wiki-room-follow') !== 'off';
+    roomFollow.addEventListener('change', function() {
+        sessionStorage.setItem('endless-wiki-room-follow', roomFollow.checked ? 'on' : 'off');
+    });
+
+    document.getElementById('startRoom').hidden = true;
+    document.getElementById('roomStatus').hidden = false;
+    document.getElementById('roomCopy').addEventListener('click', function(event) {
+        event.preventDefault();
+        navigator.clipboard.writeText(window.location.origin + '/room/' + roomID);
+        this.textContent = 'copied!';
+    });
+
+    // Arriving on an article while following moves the whole room here
+    if (roomFollow.checked) {
+        const body = new URLSearchParams({ article: page.title, kind: page.kind });
+        fetch('/room/' + roomID + '/navigate', { method: 'POST', body: body });
+    }
+
+    const roomEvents = new EventSource('/room/' + roomID + '/events');
+    roomEvents.addEventListener('navigate', function(event) {
+        if (roomFollow.checked) {
+            window.location.href = event.data;
+        }
+    });
+    roomEvents.addEventListener('participants', function(event) {
+        document.getElementById('roomParticipants').textContent = event.data;
+    });
+
+    // Keep the room when following links to other articles
+    document.addEventListener('click', function(event) {
+        const link = event.target.closest('a[href]');
+        if (link && link.origin === window.location.origin && /^\/(wiki|portal|dictionary|how-to|news)\//.test(link.pathname)) {
+            const url = new URL(link.href);
+            url.searchParams.set('room', roomID);
+            link.href = url.toString();
+        }
+    }, true);
+}
+
+function articleURL(path) {
+    if (!roomID) {
+        return path;
+    }
+    return path + '?room=' + encodeURIComponent(roomID);
+}
+
+// Stop article generation when user navigates away. This listens for
+// pagehide rather than beforeunload, which keeps pages out of the
+// back-forward cache.
+window.addEventListener('pagehide', function() {
+    if (eventSource && eventSource.readyState !== EventSource.CLOSED) {
+        eventSource.close();
+    }
+});
+
+// A page the back-forward cache kept mid-article has lost its stream, so it
+// starts over. A finished one is shown as it was.
+window.addEventListener('pageshow', function(event) {
+    if (event.persisted && !completed) {
+        window.location.reload();
+    }
+});
+
+// Also stop generation when page becomes hidden (tab switching, etc.)
+document.addEventListener('visibilitychange', function() {
+    if (document.hidden && eventSource && eventSource.readyState !== EventSource.CLOSED) {
+        eventSource.close();
+    }
+});
+
+// Back links to the article read before this one, which the server knows
+// from the reader's trail. When that's also the page the browser came from,
+// going back through history brings it back as it was instead of loading it
+// afresh.
+document.querySelectorAll('.back-link').forEach(function(link) {
+    link.addEventListener('click', function(event) {
+        if (document.referrer && new URL(document.referrer).pathname === link.pathname && history.length > 1) {
+            event.preventDefault();
+            history.back();
+        }
+    });
+});
+
+// Pinning comes back to this page, room and all
+document.getElementById('pinLink').addEventListener('click', function(event) {
+    event.preventDefault();
+    const form = document.getElementById('pinForm');
+    form.elements['return'].value = window.location.pathname + window.location.search;
+    form.submit();
+});
+
+document.getElementById('startRoomLink').addEventListener('click', function(event) {
+    event.preventDefault();
+    document.getElementById('startRoom').submit();
+});
+
+// Readers can flag an article for the moderators
+const reportForm = document.getElementById('reportForm');
+
+document.getElementById('reportLink').addEventListener('click', function(event) {
+    event.preventDefault();
+    reportForm.hidden = !reportForm.hidden;
+});
+
+document.getElementById('reportCancel').addEventListener('click', function(event) {
+    event.preventDefault();
+    reportForm.hidden = true;
+});
+
+reportForm.addEventListener('submit', function(event) {
+    event.preventDefault();
+    fetch('/api/report', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            title: page.title,
+            kind: page.kind,
+            reason: reportForm.elements.reason.value,
+            note: reportForm.elements.note.value
+        })
+    }).then(function(response) {
+        if (!response.ok) {
+            return response.text().then(function(message) { alert(message); });
+        }
+        reportForm.hidden = true;
+        document.getElementById('reportSent').hidden = false;
+    });
+});
+
+// Watching an article puts news of it on the reader's watchlist
+const watchLink = document.getElementById('watchLink');
+let watching = page.watching === 'true';
+
+watchLink.addEventListener('click', function(event) {
+    event.preventDefault();
+    fetch('/api/watch', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ title: page.title, kind: page.kind, watch: !watching })
+    }).then(function(response) {
+        if (!response.ok) {
+            return response.text().then(function(message) { alert(message); });
+        }
+        return response.json().then(function(result) {
+            watching = result.watching;
+            watchLink.textContent = watching ? 'Unwatch' : 'Watch';
+        });
+    });
+});
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Finished articles can be kept in a store, so a title that was already
+// written is served from it instead of being generated again. It then reads
+// the same on every visit and costs no GPU time. ARTICLE_STORE picks the
+// store: "disk" keeps articles as markdown files under ARTICLE_CACHE,
+// "redis" keeps them in the redis at REDIS_URL for replicas to share, "s3"
+// keeps them in the object store bucket S3_BUCKET, and "memory" keeps them
+// until the server restarts. Without a store, articles are written afresh
+// on every visit. REGENERATE ignores what is stored and writes every article
+// afresh, replacing the stored copy.
+//
+// Only the plain article is stored. Requests for another model or seed, and
+// readers reading through a lens, always get a new generation.
+
+// StoredArticle is an article as kept in a store.
+type StoredArticle struct {
+	Title     string
+	Kind      string
+	Model     string
+	Topic     string
+	Content   string
+	Generated time.Time
+	// Duration and Tokens are what it took to generate, unknown for
+	// articles stored before they were kept
+	Duration time.Duration
+	Tokens   int
+}
+
+// ArticleStore keeps the finished articles of every wiki, by wiki, kind and
+// title. The kind of a plain article is empty.
+type ArticleStore interface {
+	// Get returns a stored article, reporting false if there is none
+	Get(ctx context.Context, wiki, kind, title string) (StoredArticle, bool, error)
+	// Put stores an article, replacing any stored under the same title
+	Put(ctx context.Context, wiki string, article StoredArticle) error
+	// List returns the titles of the articles stored of a kind
+	List(ctx context.Context, wiki, kind string) ([]string, error)
+	// Delete forgets an article, if it is stored
+	Delete(ctx context.Context, wiki, kind, title string) error
+}
+
+// articles is the article store, or nil when articles aren't kept.
+var articles ArticleStore
+
+// loadArticleStore sets up the store ARTICLE_STORE asks for.
+func loadArticleStore() {
+	store := os.Getenv("ARTICLE_STORE")
+	dir := os.Getenv("ARTICLE_CACHE")
+	redisURL := os.Getenv("REDIS_URL")
+	// A cache directory, redis or bucket on its own picks its store
+	if store == "" && dir != "" {
+		store = "disk"
+	}
+	if store == "" && redisURL != "" {
+		store = "redis"
+	}
+	if store == "" && os.Getenv("S3_BUCKET") != "" {
+		store = "s3"
+	}
+
+	switch store {
+	case "":
+	case "memory":
+		articles = newMemoryStore()
+	case "disk":
+		if dir == "" {
+			log.Fatalf("ARTICLE_STORE=disk needs ARTICLE_CACHE set to a directory")
+		}
+		articles = &diskStore{dir: dir}
+	case "redis":
+		if redisURL == "" {
+			log.Fatalf("ARTICLE_STORE=redis needs REDIS_URL")
+		}
+		redis, err := newRedisStore(redisURL, envDuration("ARTICLE_TTL", 0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		articles = redis
+	case "s3":
+		bucket, err := newS3Store()
+		if err != nil {
+			log.Fatal(err)
+		}
+		articles = bucket
+	default:
+		log.Fatalf("Unknown ARTICLE_STORE %q, it must be disk, redis, s3 or memory", store)
+	}
+	if articles != nil {
+		log.Printf("Keeping articles in the %s store", store)
+		slugStore = articles
+		articles = limitArticleStore(articles)
+	}
+}
+
+// plainRequest reports whether a request is for the wiki's own article, not
+// one written for another model, seed or options or through a lens.
+func plainRequest(r *http.Request) bool {
+	query := r.URL.Query()
+	return query.Get("model") == "" && query.Get("seed") == "" && !hasOptionParams(r) && lensFromRequest(r) == ""
+}
+
+// articleStorable reports whether the article a request asks for is the
+// plain one that the store keeps.
+func articleStorable(r *http.Request) bool {
+	return articles != nil && plainRequest(r)
+}
+
+// storedArticle returns the stored article a request asks for, if there is
+// one and it may be used.
+func storedArticle(r *http.Request, title, kind string) (StoredArticle, bool) {
+	if !articleStorable(r) || envBool("REGENERATE", false) || regenerating(r.Context()) {
+		return StoredArticle{}, false
+	}
+
+	article, ok, err := articles.Get(r.Context(), wikiFrom(r.Context()).Name, kind, title)
+	if err != nil {
+		alertAdmin("Error reading stored article '%s': %v", title, err)
+		return StoredArticle{}, false
+	}
+	return article, ok
+}
+
+// stored is a freshly generated article as a store keeps it.
+func (job *articleJob) stored(content string) StoredArticle {
+	return StoredArticle{
+		Title:     job.Title,
+		Kind:      job.Kind.Name,
+		Model:     job.Model,
+		Topic:     job.Topic.Name,
+		Content:   content,
+		Generated: time.Now(),
+		Duration:  job.Elapsed,
+		Tokens:    job.Tokens,
+	}
+}
+
+// storeArticle keeps a freshly generated article, if the request is for one
+// the store keeps. An article whose generation failed, or that came out
+// blank, isn't kept, so it's tried again on the next visit rather than
+// served as it is for good.
+func storeArticle(r *http.Request, job *articleJob, content string, genErr error) {
+	if !articleStorable(r) {
+		return
+	}
+	if genErr != nil || strings.TrimSpace(content) == "" {
+		log.Printf("Not storing article '%s', its generation failed or came out empty", job.Title)
+		return
+	}
+
+	if err := articles.Put(r.Context(), wikiFrom(r.Context()).Name, job.stored(content)); err != nil {
+		alertAdmin("Error storing article '%s': %v", job.Title, err)
+	}
+}
+
+// dropStoredArticles forgets the stored articles of a wiki, once it's gone
+// or its cache is purged. It returns how many it deleted.
+func dropStoredArticles(wiki string) int {
+	if articles == nil {
+		return 0
+	}
+
+	ctx := context.Background()
+	kinds := []string{""}
+	for name := range articleKinds {
+		kinds = append(kinds, name)
+	}
+	deleted := 0
+	// The titles of the wiki's slugs go with them, without counting
+	if titles, err := slugStore.List(ctx, wiki, slugKind); err == nil {
+		for _, slug := range titles {
+			slugStore.Delete(ctx, wiki, slugKind, slug)
+		}
+	}
+	// So do the revisions the migrate job kept
+	for _, kind := range kinds {
+		titles, err := slugStore.List(ctx, wiki, revisionKind(kind))
+		if err != nil {
+			log.Printf("Error listing revisions of wiki '%s': %v", wiki, err)
+			continue
+		}
+		for _, title := range titles {
+			slugStore.Delete(ctx, wiki, revisionKind(kind), title)
+		}
+	}
+	for _, kind := range kinds {
+		titles, err := articles.List(ctx, wiki, kind)
+		if err != nil {
+			log.Printf("Error listing stored articles of wiki '%s': %v", wiki, err)
+			continue
+		}
+		for _, title := range titles {
+			if err := articles.Delete(ctx, wiki, kind, title); err != nil {
+				log.Printf("Error deleting stored article '%s' of wiki '%s': %v", title, wiki, err)
+				continue
+			}
+			deleted++
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Once an article finishes, the page gets a handful of places to wander into
+// next. Candidates are the topics the article links to and the articles
+// popular on the wiki. Each is weighted by how often the article mentions
+// it, how popular it is and, with an embedding model configured, how close it
+// is to the article. A weighted draw picks the suggestions, so every page
+// view gets a fresh mix.
+
+// maxSuggestions is how many suggestions a page gets.
+const maxSuggestions = 5
+
+// maxMentionWeight caps how much repeated links to one topic count.
+const maxMentionWeight = 3
+
+// similarityWeight scales the embedding similarity, which ranges from -1 to 1.
+const similarityWeight = 3
+
+// suggestionsExtra picks the suggestions shown below an article.
+func suggestionsExtra(articleName, content string) extraTask {
+	return func(ctx context.Context) interface{} {
+		suggestions := suggestTopics(ctx, articleName, content)
+		if len(suggestions) == 0 {
+			return nil
+		}
+		return suggestions
+	}
+}
+
+// suggestTopics picks up to maxSuggestions titles to read after an article.
+func suggestTopics(ctx context.Context, articleName, content string) []string {
+	weights := map[string]float64{}
+	skip := strings.ToLower(articleName)
+
+	for _, match := range topicLinkPattern.FindAllStringSubmatch(content, -1) {
+		title, err := normalizeTitle(match[1])
+		if err != nil || strings.ToLower(title) == skip {
+			continue
+		}
+		weights[title] = math.Min(weights[title]+1, maxMentionWeight)
+	}
+
+	for title, count := range activityFor(ctx).popularTitles() {
+		if strings.ToLower(title) == skip {
+			continue
+		}
+		weights[title] += math.Log1p(float64(count))
+	}
+
+	if len(weights) == 0 {
+		return nil
+	}
+
+	candidates := make([]string, 0, len(weights))
+	for title := range weights {
+		candidates = append(candidates, title)
+	}
+	sort.Strings(candidates)
+
+	if model := settingsFor(ctx).EmbeddingModel; model != "" {
+		vectors, err := embedTexts(ctx, model, append([]string{articleName}, candidates...))
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error embedding suggestions for '%s': %v", articleName, err)
+			}
+		} else {
+			for i, title := range candidates {
+				weights[title] += similarityWeight * cosineSimilarity(vectors[0], vectors[i+1])
+			}
+		}
+	}
+
+	// Weighted sampling without replacement: each candidate draws a key of
+	// u^(1/weight) and the largest keys win
+	keys := map[string]float64{}
+	for _, title := range candidates {
+		weight := weights[title]
+		if weight <= 0 {
+			continue
+		}
+		keys[title] = math.Pow(rand.Float64(), 1/weight)
+	}
+
+	picked := make([]string, 0, len(keys))
+	for title := range keys {
+		picked = append(picked, title)
+	}
+	sort.Slice(picked, func(i, j int) bool { return keys[picked[i]] > keys[picked[j]] })
+	if len(picked) > maxSuggestions {
+		picked = picked[:maxSuggestions]
+	}
+	return picked
+}
+
+// embedTexts returns the embedding of each input from ollama's /api/embed.
+func embedTexts(ctx context.Context, model string, inputs []string) ([][]float64, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"model": model,
+		"input": inputs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	host, release := pickOllamaHost()
+	defer release()
+	req, err := http.NewRequestWithContext(ctx, "POST", host+"/api/embed", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		hostUnreachable(ctx, host, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embed returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("embed returned %d embeddings for %d inputs", len(result.Embeddings), len(inputs))
+	}
+	return result.Embeddings, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+)
+
+// The page templates are parsed once at startup, so a broken template stops
+// the server rather than failing its first reader, and are shared by every
+// request. Changes to them need a restart.
+
+// templateFuncs are the functions available to every page template.
+var templateFuncs = template.FuncMap{
+	// path escapes a title for use in a URL path
+	"path": url.PathEscape,
+	"slug": slugPath,
+	// asset is the fingerprinted URL of a static asset
+	"asset": assetPath,
+	// label names an article kind, like "Portal"
+	"label": func(kind string) string { return articleKinds[kind].Label },
+	// bytes writes a size like "1.2 MB"
+	"bytes": formatSize,
+}
+
+// formatSize writes a number of bytes for people to read.
+func formatSize(size int64) string {
+	if size < 1000 {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	value, units := float64(size)/1000, "kB"
+	for _, next := range []string{"MB", "GB", "TB"} {
+		if value < 1000 {
+			break
+		}
+		value, units = value/1000, next
+	}
+	return fmt.Sprintf("%.1f %s", value, units)
+}
+
+var templates map[string]*template.Template
+
+// loadTemplates parses every page template.
+func loadTemplates() {
+	files, err := filepath.Glob("templates/*.html")
+	if err != nil || len(files) == 0 {
+		log.Fatalf("No templates found in templates/")
+	}
+
+	// Partials, like the branding in every page's head, are shared by all
+	// pages
+	partials, err := filepath.Glob("templates/partials/*.html")
+	if err != nil {
+		log.Fatalf("Error listing template partials: %v", err)
+	}
+
+	templates = map[string]*template.Template{}
+	for _, file := range files {
+		name := filepath.Base(file)
+		tmpl, err := template.New(name).Funcs(templateFuncs).ParseFiles(append([]string{file}, partials...)...)
+		if err != nil {
+			log.Fatalf("Error parsing template: %v", err)
+		}
+		templates[name] = tmpl
+	}
+}
+
+// renderPage renders a page template as the response.
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := templates[name].Execute(w, data); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
+
+// maxHomePages caps how many renderings of the home page are cached.
+const maxHomePages = 100
+
+// homePage is everything the home page is rendered from. Its rendering is
+// cached under all of it, so a change to any of it, like a new featured
+// article, renders the page afresh.
+type homePage struct {
+	Branding
+	Lens     string
+	Activity bool
+	Featured *FeaturedArticle
+	Intro    string
+	Sections []HomeSection
+	// ReadOnly is set while generation is unavailable
+	ReadOnly bool
+}
+
+var homePages = struct {
+	mu       sync.Mutex
+	rendered map[string][]byte
+}{
+	rendered: map[string][]byte{},
+}
+
+// renderHomePage renders the home page, reusing an earlier rendering of the
+// same page.
+func renderHomePage(w http.ResponseWriter, page homePage) {
+	key, _ := json.Marshal(page)
+	homePages.mu.Lock()
+	html, ok := homePages.rendered[string(key)]
+	homePages.mu.Unlock()
+
+	if !ok {
+		var buf bytes.Buffer
+		if err := templates["home.html"].Execute(&buf, page); err != nil {
+			log.Printf("Template execution error: %v", err)
+			http.Error(w, "Template error", http.StatusInternalServerError)
+			return
+		}
+		html = buf.Bytes()
+
+		homePages.mu.Lock()
+		if len(homePages.rendered) >= maxHomePages {
+			homePages.rendered = map[string][]byte{}
+		}
+		homePages.rendered[string(key)] = html
+		homePages.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(html)
+}
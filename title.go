@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// maxTitleLength is the longest title, in characters, that will be generated.
+const maxTitleLength = 200
+
+var (
+	errTitleEmpty     = errors.New("Article name is required")
+	errTitleTooLong   = errors.New("Article names can be at most 200 characters long")
+	errTitleEncoding  = errors.New("Article names must be valid UTF-8")
+	errTitleControl   = errors.New("Article names can't contain control characters")
+	errTitleTraversal = errors.New("Article names can't contain . or .. path segments")
+	errTitleNoText    = errors.New("Article names need at least one letter or number")
+	errTitleEscaping  = errors.New("Article names must be correctly URL encoded")
+)
+
+// articleVar returns the decoded {article} route variable. The router
+// matches on the encoded path, so the variable arrives still encoded.
+func articleVar(r *http.Request) (string, error) {
+	title, err := url.PathUnescape(mux.Vars(r)["article"])
+	if err != nil {
+		return "", errTitleEscaping
+	}
+	return title, nil
+}
+
+// normalizeTitle cleans up a requested title and rejects pathological ones
+// before they reach the prompt. Surrounding whitespace is trimmed, runs of
+// whitespace are collapsed to a single space and empty sub-article levels are
+// dropped.
+func normalizeTitle(title string) (string, error) {
+	if !utf8.ValidString(title) {
+		return "", errTitleEncoding
+	}
+
+	hasText := false
+	for _, r := range title {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return "", errTitleControl
+		}
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			hasText = true
+		}
+	}
+
+	// Tidy up each level of a sub-article title like "Roman Empire / Military"
+	var segments []string
+	for _, segment := range strings.Split(title, "/") {
+		segment = strings.Join(strings.Fields(segment), " ")
+		if segment == "." || segment == ".." {
+			return "", errTitleTraversal
+		}
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	title = strings.Join(segments, "/")
+	if title == "" {
+		return "", errTitleEmpty
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return "", errTitleTooLong
+	}
+	if !hasText {
+		return "", errTitleNoText
+	}
+
+	return title, nil
+}
+
+// redirectToTitle permanently redirects to the canonical page for a title,
+// its slug, keeping the query string.
+func redirectToTitle(w http.ResponseWriter, r *http.Request, kind, title string) {
+	rememberRequestedTitle(r.Context(), title)
+	target := "/" + kind + "/" + slugPath(title)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// pageTitle returns the title of the article page a request is for. Pages
+// are addressed by slug, and requests by title, like the URLs of old, are
+// redirected to it, reporting false.
+func pageTitle(w http.ResponseWriter, r *http.Request, kind string) (string, bool) {
+	requested, err := articleVar(r)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	slug := isSlug(requested)
+	if slug {
+		requested = titleForSlug(r.Context(), requested)
+	}
+	articleName, err := normalizeTitle(requested)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+
+	if !slug {
+		redirectToTitle(w, r, kind, articleName)
+		return "", false
+	}
+	return articleName, true
+}
+
+// renderError shows an error page for requests a reader made from the browser.
+func renderError(w http.ResponseWriter, status int, message string) {
+	tmpl, ok := templates["error.html"]
+	if !ok {
+		http.Error(w, message, status)
+		return
+	}
+
+	data := struct {
+		Message string
+	}{
+		Message: message,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
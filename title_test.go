@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title, want string
+		err         error
+	}{
+		{"Ancient Rome", "Ancient Rome", nil},
+		{"AC/DC", "AC/DC", nil},
+		{"  What?   #hash 100% ", "What? #hash 100%", nil},
+		{"Roman Empire / Military", "Roman Empire/Military", nil},
+		{"Roman Empire//Military/", "Roman Empire/Military", nil},
+		{"Tab\tand\nnewline", "Tab and newline", nil},
+		{"", "", errTitleEmpty},
+		{" / ", "", errTitleEmpty},
+		{"..", "", errTitleTraversal},
+		{".", "", errTitleTraversal},
+		{"Rome/../etc", "", errTitleTraversal},
+		{"Bell\x07", "", errTitleControl},
+		{"Null\x00byte", "", errTitleControl},
+		{"Delete\x7f", "", errTitleControl},
+		{"\xff\xfe", "", errTitleEncoding},
+		{"?!#%", "", errTitleNoText},
+		{strings.Repeat("a", maxTitleLength), strings.Repeat("a", maxTitleLength), nil},
+		{strings.Repeat("a", maxTitleLength+1), "", errTitleTooLong},
+		{strings.Repeat("é", maxTitleLength+1), "", errTitleTooLong},
+	}
+	for _, test := range tests {
+		got, err := normalizeTitle(test.title)
+		if err != test.err || got != test.want {
+			t.Errorf("normalizeTitle(%q) = %q, %v, want %q, %v", test.title, got, err, test.want, test.err)
+		}
+	}
+}
+
+// titleRouter routes /stream/ and /wiki/ like the server does, answering
+// with the article variable.
+func titleRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.UseEncodedPath()
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		title, err := articleVar(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(title))
+	}
+	r.HandleFunc("/wiki/{article:.+}", echo)
+	r.HandleFunc("/stream/{article}", echo)
+	return r
+}
+
+// Titles get to the handlers as they were, however they're escaped in the
+// stream URL, and bad ones are then turned away by normalizeTitle.
+func TestArticleVarRoundTrip(t *testing.T) {
+	router := titleRouter()
+	titles := []string{
+		"Ancient Rome",
+		"AC/DC",
+		"What? #hash 100%",
+		"Bell\x07",
+		"Null\x00byte",
+		strings.Repeat("a", maxTitleLength+1),
+	}
+	for _, title := range titles {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/stream/"+url.PathEscape(title), nil))
+		if w.Code != http.StatusOK || w.Body.String() != title {
+			t.Errorf("/stream/ for %q = %d %q, want it back", title, w.Code, w.Body.String())
+		}
+	}
+
+	// Bad escaping is the handler's to report
+	r := mux.SetURLVars(httptest.NewRequest("GET", "/stream/x", nil), map[string]string{"article": "100%zz"})
+	if _, err := articleVar(r); err != errTitleEscaping {
+		t.Errorf("articleVar(100%%zz) = %v, want %v", err, errTitleEscaping)
+	}
+
+	// Dot segments are cleaned away before they reach a handler at all
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stream/"+url.PathEscape(".."), nil))
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("/stream/.. = %d, want %d", w.Code, http.StatusMovedPermanently)
+	}
+}
+
+// Article links lead to the slug of the title they name.
+func TestSlugPathRoundTrip(t *testing.T) {
+	router := titleRouter()
+	titles := []string{
+		"Ancient Rome",
+		"AC/DC",
+		"What? #hash 100%",
+		"Roman Empire/Military",
+		"日本 語",
+		strings.Repeat("a", maxTitleLength),
+	}
+	for _, title := range titles {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/wiki/"+slugPath(title), nil))
+		slug := w.Body.String()
+		if w.Code != http.StatusOK || slug != slugify(title) {
+			t.Errorf("/wiki/ link for %q = %d %q, want %q", title, w.Code, slug, slugify(title))
+		}
+		if !isSlug(slug) {
+			t.Errorf("isSlug(%q) = false for the link to %q", slug, title)
+		}
+		if _, err := normalizeTitle(slug); err != nil {
+			t.Errorf("normalizeTitle(%q) = %v for the link to %q", slug, err, title)
+		}
+	}
+}
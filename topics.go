@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// TopicType routes a class of topics to its own article structure and
+// infobox schema, so articles of the same type look alike.
+type TopicType struct {
+	Name      string
+	Structure string
+	Infobox   []infoboxKey
+}
+
+type infoboxKey struct {
+	Key   string
+	Label string
+}
+
+const defaultTopicType = "concept"
+
+var topicTypes = map[string]TopicType{
+	"person": {
+		Name:      "person",
+		Structure: "Structure the article as a biography with sections for early life, career, personal life and legacy.",
+		Infobox: []infoboxKey{
+			{"born", "Born"},
+			{"died", "Died"},
+			{"nationality", "Nationality"},
+			{"occupation", "Occupation"},
+			{"known_for", "Known for"},
+		},
+	},
+	"place": {
+		Name:      "place",
+		Structure: "Structure the article with sections for geography, history, demographics, economy and culture.",
+		Infobox: []infoboxKey{
+			{"country", "Country"},
+			{"region", "Region"},
+			{"population", "Population"},
+			{"area", "Area"},
+			{"founded", "Founded"},
+		},
+	},
+	"organism": {
+		Name:      "organism",
+		Structure: "Structure the article with sections for taxonomy, description, distribution and habitat, behaviour and conservation status.",
+		Infobox: []infoboxKey{
+			{"kingdom", "Kingdom"},
+			{"phylum", "Phylum"},
+			{"class", "Class"},
+			{"order", "Order"},
+			{"family", "Family"},
+			{"genus", "Genus"},
+			{"conservation_status", "Conservation status"},
+		},
+	},
+	"event": {
+		Name:      "event",
+		Structure: "Structure the article with sections for background, the course of events, aftermath and legacy.",
+		Infobox: []infoboxKey{
+			{"date", "Date"},
+			{"location", "Location"},
+			{"participants", "Participants"},
+			{"outcome", "Outcome"},
+		},
+	},
+	"concept": {
+		Name:      "concept",
+		Structure: "Structure the article with sections for definition, history, key ideas and applications.",
+	},
+}
+
+const classifyPrompt = `Classify the encyclopedia topic "%s" as exactly one of: person, place, organism, event, concept.
+
+Respond with JSON in the form {"type": "..."}.`
+
+// classifyTopic asks the model which type of topic a title is. It reports
+// false, with a concept as the fallback, when the answer is missing or
+// unknown.
+func classifyTopic(ctx context.Context, articleName, model string) (TopicType, bool) {
+	var result struct {
+		Type string `json:"type"`
+	}
+	if err := generateJSON(ctx, model, fmt.Sprintf(classifyPrompt, articleName), &result); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error classifying topic '%s': %v", articleName, err)
+		}
+		return topicTypes[defaultTopicType], false
+	}
+
+	topic, ok := topicTypes[strings.ToLower(strings.TrimSpace(result.Type))]
+	if !ok {
+		return topicTypes[defaultTopicType], false
+	}
+	log.Printf("Classified '%s' as a %s", articleName, topic.Name)
+	return topic, true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Every model dresses its articles up differently, with preambles like
+// "Sure, here's an article about..." or sign-offs offering to help further.
+// A wiki's trim rules are regular expressions, and whatever they match is cut
+// from its articles, both while they stream in and once they are finished.
+// Its stop sequences are passed to ollama, which ends generation as soon as
+// the model writes one.
+
+var trimRules = struct {
+	mu       sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{
+	compiled: map[string]*regexp.Regexp{},
+}
+
+// compileTrimRules compiles a wiki's trim rules, reusing earlier
+// compilations.
+func compileTrimRules(rules []string) ([]*regexp.Regexp, error) {
+	trimRules.mu.Lock()
+	defer trimRules.mu.Unlock()
+
+	var compiled []*regexp.Regexp
+	for _, rule := range rules {
+		re, ok := trimRules.compiled[rule]
+		if !ok {
+			var err error
+			if re, err = regexp.Compile(rule); err != nil {
+				return nil, fmt.Errorf("invalid trim rule %q: %v", rule, err)
+			}
+			trimRules.compiled[rule] = re
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// applyTrimRules cuts whatever the rules match out of an article.
+func applyTrimRules(content string, rules []*regexp.Regexp) string {
+	if len(rules) == 0 {
+		return content
+	}
+	for _, re := range rules {
+		content = re.ReplaceAllString(content, "")
+	}
+	return strings.TrimLeft(content, " \t\n")
+}
+
+// retrimBytes is how long an article streaming in is trimmed whole on every
+// chunk, so preambles never show.
+const retrimBytes = 1024
+
+// trimmer trims an article as it streams in. Trimming the whole article on
+// every chunk would take time growing with the square of its length, so
+// past retrimBytes it's trimmed whole again only once it has grown by a
+// quarter, and in between what was written since is added to the last
+// trimmed copy as it is. Whatever that lets through is taken back by the
+// next trim, and the finished article is always trimmed whole.
+type trimmer struct {
+	rules   []*regexp.Regexp
+	trimmed strings.Builder
+	// at is how much of the article there was when it was last trimmed,
+	// and added how much of it has been added to the trimmed copy since
+	at, added int
+}
+
+// update returns the article so far, which only grows between calls, with
+// the trim rules applied.
+func (t *trimmer) update(content string) string {
+	if len(t.rules) == 0 {
+		return content
+	}
+	if len(content) < retrimBytes || len(content)-t.at >= t.at/4 {
+		t.trimmed.Reset()
+		t.trimmed.WriteString(applyTrimRules(content, t.rules))
+		t.at, t.added = len(content), len(content)
+		return t.trimmed.String()
+	}
+	rest := content[t.added:]
+	if t.trimmed.Len() == 0 {
+		rest = strings.TrimLeft(rest, " \t\n")
+	}
+	t.trimmed.WriteString(rest)
+	t.added = len(content)
+	return t.trimmed.String()
+}
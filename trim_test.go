@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrimmer(t *testing.T) {
+	rules, err := compileTrimRules(benchTrimRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat(benchArticle+"\n\n", 8)
+	trim := &trimmer{rules: rules}
+	var content strings.Builder
+	for _, chunk := range benchChunks(text) {
+		content.WriteString(chunk)
+		got := trim.update(content.String())
+		want := applyTrimRules(content.String(), rules)
+		// Between trims only what the rules would still cut may show
+		if applyTrimRules(got, rules) != want {
+			t.Fatalf("at %d bytes got %q, want %q", content.Len(), got, want)
+		}
+	}
+}
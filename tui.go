@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// The tui subcommand is a Bubble Tea terminal browser for a running server.
+// It streams articles from /raw, lists the [[Topic]] links in each one to
+// pick with the arrow keys, and keeps a history stack to go back through.
+
+var topicLinkPattern = regexp.MustCompile(`\[\[([^\]]+)\]\]`)
+
+const tuiHelp = "↑/↓ pick a link · enter follow · b back · / open a topic · pgup/pgdn scroll · q quit"
+
+// tuiLinkRows is how many links are shown under the article at once.
+const tuiLinkRows = 8
+
+// runTUI runs the terminal browser with the subcommand's arguments.
+func runTUI(args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	defaultServer := os.Getenv("ENDLESS_WIKI_URL")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+	server := fs.String("server", defaultServer, "address of the endless wiki server")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: endless-wiki tui [-server URL] [topic]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	m := &tuiModel{base: strings.TrimRight(*server, "/"), typing: true}
+	if topic := strings.TrimSpace(strings.Join(fs.Args(), " ")); topic != "" {
+		m.typing = false
+		m.topic = topic
+	}
+	_, err := tea.NewProgram(m, tea.WithAltScreen()).Run()
+	m.stop()
+	return err
+}
+
+// tuiModel is the state of the terminal browser.
+type tuiModel struct {
+	base    string
+	topic   string
+	history []string
+
+	// article is what has streamed in so far, and links the topics it
+	// links to, in the order they first appear
+	article  strings.Builder
+	links    []string
+	selected int
+	scroll   int
+	loading  bool
+	err      error
+
+	// typing is whether a topic is being typed in, into input
+	typing bool
+	input  string
+
+	width, height int
+
+	// stream counts the articles opened, so chunks of one left behind are
+	// told apart, and cancel stops the one streaming
+	stream int
+	cancel context.CancelFunc
+}
+
+// tuiStreamMsg is an article's response arriving, tuiChunkMsg a piece of it
+// and tuiDoneMsg its end.
+type (
+	tuiStreamMsg struct {
+		stream int
+		body   io.ReadCloser
+	}
+	tuiChunkMsg struct {
+		stream int
+		body   io.ReadCloser
+		text   string
+	}
+	tuiDoneMsg struct {
+		stream int
+		err    error
+	}
+)
+
+func (m *tuiModel) Init() tea.Cmd {
+	if m.typing {
+		return nil
+	}
+	return m.open(m.topic)
+}
+
+// open starts streaming an article, leaving any other behind.
+func (m *tuiModel) open(topic string) tea.Cmd {
+	m.stop()
+	m.stream++
+	m.topic = topic
+	m.article.Reset()
+	m.links, m.selected, m.scroll = nil, 0, 0
+	m.loading, m.err = true, nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	stream, target := m.stream, m.base+"/raw/"+url.PathEscape(topic)
+	return func() tea.Msg {
+		req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+		if err != nil {
+			return tuiDoneMsg{stream: stream, err: err}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return tuiDoneMsg{stream: stream, err: err}
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+			return tuiDoneMsg{stream: stream, err: fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))}
+		}
+		return tuiStreamMsg{stream: stream, body: resp.Body}
+	}
+}
+
+// stop stops the article streaming, if any.
+func (m *tuiModel) stop() {
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+}
+
+// readChunk waits for the next piece of an article.
+func readChunk(stream int, body io.ReadCloser) tea.Cmd {
+	return func() tea.Msg {
+		buf := make([]byte, 4096)
+		n, err := body.Read(buf)
+		if n > 0 {
+			return tuiChunkMsg{stream: stream, body: body, text: string(buf[:n])}
+		}
+		body.Close()
+		if err == io.EOF {
+			err = nil
+		}
+		return tuiDoneMsg{stream: stream, err: err}
+	}
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tuiStreamMsg:
+		if msg.stream != m.stream {
+			msg.body.Close()
+			return m, nil
+		}
+		return m, readChunk(msg.stream, msg.body)
+	case tuiChunkMsg:
+		if msg.stream != m.stream {
+			msg.body.Close()
+			return m, nil
+		}
+		m.article.WriteString(msg.text)
+		m.links = articleLinks(m.article.String())
+		return m, readChunk(msg.stream, msg.body)
+	case tuiDoneMsg:
+		if msg.stream == m.stream {
+			m.loading, m.err = false, msg.err
+		}
+	case tea.KeyMsg:
+		if m.typing {
+			return m.typeKey(msg)
+		}
+		return m.browseKey(msg)
+	}
+	return m, nil
+}
+
+// typeKey handles a key while a topic is being typed in.
+func (m *tuiModel) typeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		if m.topic == "" {
+			return m, tea.Quit
+		}
+		m.typing, m.input = false, ""
+	case tea.KeyEnter:
+		topic := strings.TrimSpace(m.input)
+		if topic == "" {
+			return m, nil
+		}
+		if m.topic != "" {
+			m.history = append(m.history, m.topic)
+		}
+		m.typing, m.input = false, ""
+		return m, m.open(topic)
+	case tea.KeyBackspace:
+		if _, size := utf8.DecodeLastRuneInString(m.input); size > 0 {
+			m.input = m.input[:len(m.input)-size]
+		}
+	case tea.KeySpace:
+		m.input += " "
+	case tea.KeyRunes:
+		m.input += string(msg.Runes)
+	}
+	return m, nil
+}
+
+// browseKey handles a key while reading an article.
+func (m *tuiModel) browseKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		if m.selected > 0 {
+			m.selected--
+		}
+	case "down", "j":
+		if m.selected < len(m.links)-1 {
+			m.selected++
+		}
+	case "pgup":
+		m.scroll = max(0, m.scroll-m.articleRows())
+	case "pgdown", " ":
+		m.scroll += m.articleRows()
+	case "enter":
+		if m.selected < len(m.links) {
+			m.history = append(m.history, m.topic)
+			return m, m.open(m.links[m.selected])
+		}
+	case "b", "backspace":
+		if len(m.history) > 0 {
+			previous := m.history[len(m.history)-1]
+			m.history = m.history[:len(m.history)-1]
+			return m, m.open(previous)
+		}
+	case "/":
+		m.typing = true
+	}
+	return m, nil
+}
+
+// articleRows is how many lines of the article fit on the screen, around
+// the title, the links and the help.
+func (m *tuiModel) articleRows() int {
+	return max(1, m.height-min(len(m.links), tuiLinkRows)-5)
+}
+
+func (m *tuiModel) View() string {
+	var b strings.Builder
+	if m.typing {
+		fmt.Fprintf(&b, "Topic: %s█\n\nenter open · esc cancel\n", m.input)
+		return b.String()
+	}
+
+	status := ""
+	switch {
+	case m.err != nil:
+		status = " (error: " + m.err.Error() + ")"
+	case m.loading:
+		status = " (writing…)"
+	}
+	fmt.Fprintf(&b, "== %s ==%s\n\n", m.topic, status)
+
+	lines := wrapLines(m.article.String(), m.width)
+	rows := m.articleRows()
+	m.scroll = min(m.scroll, max(0, len(lines)-rows))
+	end := min(len(lines), m.scroll+rows)
+	for _, line := range lines[m.scroll:end] {
+		b.WriteString(line + "\n")
+	}
+	for i := end - m.scroll; i < rows; i++ {
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	first := max(0, min(m.selected-tuiLinkRows/2, len(m.links)-tuiLinkRows))
+	for i := first; i < len(m.links) && i < first+tuiLinkRows; i++ {
+		cursor := "  "
+		if i == m.selected {
+			cursor = "> "
+		}
+		b.WriteString(cursor + m.links[i] + "\n")
+	}
+	b.WriteString(tuiHelp)
+	return b.String()
+}
+
+// articleLinks returns the topics an article links to, in the order they
+// first appear.
+func articleLinks(article string) []string {
+	var links []string
+	seen := map[string]bool{}
+	for _, match := range topicLinkPattern.FindAllStringSubmatch(article, -1) {
+		link := strings.TrimSpace(match[1])
+		if link != "" && !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// wrapLines splits text into lines no wider than width, breaking long ones
+// between words where it can.
+func wrapLines(text string, width int) []string {
+	if width < 10 {
+		width = 80
+	}
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		for utf8.RuneCountInString(line) > width {
+			runes := []rune(line)
+			cut := width
+			if space := strings.LastIndex(string(runes[:width]), " "); space > 0 {
+				cut = utf8.RuneCountInString(string(runes[:width])[:space])
+			}
+			lines = append(lines, strings.TrimRight(string(runes[:cut]), " "))
+			line = strings.TrimLeft(string(runes[cut:]), " ")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
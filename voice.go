@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// /api/voice answers a spoken question for voice assistant skills. It pulls
+// the topic out of the transcript and replies with a few sentences meant to be
+// read aloud, plus a link to the full article for the companion app.
+
+// maxTranscriptLength caps the transcript, in characters, sent to the model.
+const maxTranscriptLength = 500
+
+const voicePrompt = `A listener asked a voice assistant for an encyclopedia: "%s"
+
+Work out which encyclopedia topic they are asking about, using the usual title of a wiki article for it. Then answer them in at most three short sentences meant to be read aloud: plain words, no markdown, lists, symbols or abbreviations.
+
+Respond with JSON in the form {"topic": "...", "speech": "..."}.`
+
+// VoiceAnswer is the reply to a voice query.
+type VoiceAnswer struct {
+	Topic  string `json:"topic"`
+	Speech string `json:"speech"`
+	URL    string `json:"url"`
+}
+
+func voiceHandler(w http.ResponseWriter, r *http.Request) {
+	transcript := r.FormValue("q")
+	if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		transcript = body.Query
+	}
+
+	transcript = strings.Join(strings.Fields(transcript), " ")
+	if transcript == "" {
+		http.Error(w, "A query is required", http.StatusBadRequest)
+		return
+	}
+	if runes := []rune(transcript); len(runes) > maxTranscriptLength {
+		transcript = string(runes[:maxTranscriptLength])
+	}
+
+	if gateRequired(r) {
+		refuseUngated(w)
+		return
+	}
+
+	ctx := r.Context()
+	release, err := streams.acquire(ctx, clientIP(r), func(int) {})
+	if err != nil {
+		return
+	}
+	defer release()
+
+	var result struct {
+		Topic  string `json:"topic"`
+		Speech string `json:"speech"`
+	}
+	if err := generateJSON(ctx, settingsFor(ctx).Model, fmt.Sprintf(voicePrompt, transcript), &result); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error answering voice query %q: %v", transcript, err)
+			http.Error(w, "Failed to answer the query", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	topic, err := normalizeTitle(result.Topic)
+	if err != nil {
+		// Fall back to treating the whole transcript as the title
+		if topic, err = normalizeTitle(transcript); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rememberRequestedTitle(r.Context(), topic)
+	answer := VoiceAnswer{
+		Topic:  topic,
+		Speech: strings.Join(strings.Fields(result.Speech), " "),
+		URL:    "/wiki/" + slugPath(topic),
+	}
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		answer.URL = strings.TrimRight(base, "/") + answer.URL
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(answer); err != nil {
+		log.Printf("Error encoding voice answer: %v", err)
+	}
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Readers can watch articles and be told when one is regenerated, edited by
+// hand, written for the first time or newly linked to from another article.
+// Watching an article with its sub-articles watches a whole category, like
+// everything under "Rome/". There are no accounts, so a watcher is known by
+// a random id in a long lived cookie. Notifications land in the inbox at
+// /watchlist, and can also be sent by email, once the address is confirmed,
+// or posted to a webhook. The email settings also take the weekly digest.
+// Watchlists are kept in WATCHLISTS_FILE, if set, and otherwise last until
+// the next restart, as inboxes always do.
+
+const watcherCookie = "endless-wiki-watcher"
+
+// watcherCookieAge is how long a watcher's cookie lasts since they last
+// changed what they watch.
+const watcherCookieAge = 365 * 24 * time.Hour
+
+// maxWatchers caps how many watchers the instance keeps, and maxWatches how
+// many articles each may watch.
+const (
+	maxWatchers = 100000
+	maxWatches  = 500
+)
+
+// maxInbox is how many notifications each watcher's inbox keeps.
+const maxInbox = 100
+
+// maxNewLinks caps how many newly linked articles one change notifies about.
+const maxNewLinks = 50
+
+// Watch is an article someone watches, with its sub-articles if Subpages.
+type Watch struct {
+	Wiki     string `json:"wiki"`
+	Title    string `json:"title"`
+	Kind     string `json:"kind,omitempty"`
+	Subpages bool   `json:"subpages,omitempty"`
+}
+
+// covers reports whether the watch is for an article.
+func (w Watch) covers(wiki, kind, title string) bool {
+	if w.Wiki != wiki || w.Kind != kind {
+		return false
+	}
+	return w.Title == title || (w.Subpages && strings.HasPrefix(title, w.Title+"/"))
+}
+
+// Watcher is what someone watches and how they want to hear about it.
+type Watcher struct {
+	Watches []Watch `json:"watches"`
+	// Email is a confirmed address, PendingEmail one waiting for its
+	// EmailToken to come back
+	Email        string `json:"email,omitempty"`
+	PendingEmail string `json:"pending_email,omitempty"`
+	EmailToken   string `json:"email_token,omitempty"`
+	// Unsubscribe is the token of the link in every email that stops them
+	Unsubscribe string `json:"unsubscribe,omitempty"`
+	// EmailAlerts sends notifications by email, and Digest the weekly
+	// digest of DigestWiki, last sent at LastDigest
+	EmailAlerts bool      `json:"email_alerts,omitempty"`
+	Digest      bool      `json:"digest,omitempty"`
+	DigestWiki  string    `json:"digest_wiki,omitempty"`
+	LastDigest  time.Time `json:"last_digest,omitempty"`
+	Webhook     string    `json:"webhook,omitempty"`
+
+	inbox  []Notification
+	unread int
+	sent   []time.Time
+}
+
+// Notification tells a watcher what happened to an article they watch.
+type Notification struct {
+	// Type is "new", "regenerated", "edited" or "linked"
+	Type  string `json:"type"`
+	Wiki  string `json:"wiki"`
+	Title string `json:"title"`
+	Kind  string `json:"kind,omitempty"`
+	// From is the article that newly links to a watched one
+	From string    `json:"from,omitempty"`
+	URL  string    `json:"url"`
+	Diff string    `json:"diff,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Message describes the notification in a sentence.
+func (n Notification) Message() string {
+	switch n.Type {
+	case "new":
+		return fmt.Sprintf("%s was written for the first time", n.Title)
+	case "regenerated":
+		return fmt.Sprintf("%s was written again", n.Title)
+	case "edited":
+		return fmt.Sprintf("%s was edited by hand", n.Title)
+	}
+	return fmt.Sprintf("%s now links to %s", n.From, n.Title)
+}
+
+var watchers = struct {
+	mu   sync.Mutex
+	byID map[string]*Watcher
+}{
+	byID: map[string]*Watcher{},
+}
+
+var (
+	watchlistsFile    string
+	watchlistsWriteMu sync.Mutex
+)
+
+// loadWatchlists reads the watchlists kept in WATCHLISTS_FILE, if set.
+func loadWatchlists() {
+	watchlistsFile = os.Getenv("WATCHLISTS_FILE")
+	if watchlistsFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(watchlistsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading watchlists file '%s': %v", watchlistsFile, err)
+		}
+		return
+	}
+
+	loaded := map[string]*Watcher{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Printf("Error parsing watchlists file '%s': %v", watchlistsFile, err)
+		return
+	}
+
+	watchers.mu.Lock()
+	watchers.byID = loaded
+	watchers.mu.Unlock()
+	log.Printf("Loaded %d watchlists from '%s'", len(loaded), watchlistsFile)
+}
+
+// saveWatchlists writes the watchlists back to WATCHLISTS_FILE, if set.
+func saveWatchlists() {
+	if watchlistsFile == "" {
+		return
+	}
+
+	// Hold the write lock from the snapshot on, so saves land in order
+	watchlistsWriteMu.Lock()
+	defer watchlistsWriteMu.Unlock()
+
+	watchers.mu.Lock()
+	data, err := json.MarshalIndent(watchers.byID, "", "  ")
+	watchers.mu.Unlock()
+	if err != nil {
+		log.Printf("Error encoding watchlists: %v", err)
+		return
+	}
+
+	// Write to a temporary file first so a crash can't leave it half written
+	tmp := watchlistsFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Error writing watchlists file '%s': %v", watchlistsFile, err)
+		return
+	}
+	if err := os.Rename(tmp, watchlistsFile); err != nil {
+		log.Printf("Error writing watchlists file '%s': %v", watchlistsFile, err)
+	}
+}
+
+// randomToken returns 16 random bytes in hex.
+func randomToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		log.Fatalf("Error creating random token: %v", err)
+	}
+	return hex.EncodeToString(token)
+}
+
+// watcherID returns the id a request's cookie names, or "" if it has none.
+func watcherID(r *http.Request) string {
+	cookie, err := r.Cookie(watcherCookie)
+	if err != nil || len(cookie.Value) != 32 {
+		return ""
+	}
+	if _, err := hex.DecodeString(cookie.Value); err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// setWatcherCookie gives a reader their watcher id, or renews it. It has to
+// be called before anything is written to w.
+func setWatcherCookie(w http.ResponseWriter, r *http.Request, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     watcherCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(watcherCookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   requestSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isWatching reports whether the reader behind a request watches an article
+// itself, rather than through a category.
+func isWatching(r *http.Request, title, kind string) bool {
+	id := watcherID(r)
+	if id == "" {
+		return false
+	}
+	wiki := wikiFrom(r.Context()).Name
+
+	watchers.mu.Lock()
+	defer watchers.mu.Unlock()
+
+	if watcher, ok := watchers.byID[id]; ok {
+		for _, watch := range watcher.Watches {
+			if watch.Wiki == wiki && watch.Kind == kind && watch.Title == title {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// articlePath is where an article is read.
+func articlePath(kind, title string) string {
+	if kind == "" {
+		kind = "wiki"
+	}
+	return "/" + kind + "/" + slugPath(title)
+}
+
+// publicLink makes a path absolute with PUBLIC_URL, if set.
+func publicLink(path string) string {
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		return strings.TrimRight(base, "/") + path
+	}
+	return path
+}
+
+// notifyWatchers tells everyone watching an article about a change to it,
+// and everyone watching an article it newly links to. previous is the text
+// the change replaced.
+func notifyWatchers(ctx context.Context, change Change, previous string) {
+	wiki := wikiFrom(ctx).Name
+	site := settingsFor(ctx).siteName()
+
+	notifications := []Notification{{
+		Type:  change.Type,
+		Wiki:  wiki,
+		Title: change.Title,
+		Kind:  change.Kind,
+		URL:   publicLink(articlePath(change.Kind, change.Title)),
+		Time:  change.Time,
+	}}
+	if change.Diff != "" {
+		notifications[0].Diff = publicLink(change.Diff)
+	}
+
+	linked := map[string]bool{}
+	for _, match := range topicLinkPattern.FindAllStringSubmatch(previous, -1) {
+		linked[strings.TrimSpace(match[1])] = true
+	}
+	for _, match := range topicLinkPattern.FindAllStringSubmatch(change.content, -1) {
+		target := strings.TrimSpace(match[1])
+		if linked[target] || target == change.Title || len(notifications) > maxNewLinks {
+			continue
+		}
+		linked[target] = true
+		notifications = append(notifications, Notification{
+			Type:  "linked",
+			Wiki:  wiki,
+			Title: target,
+			From:  change.Title,
+			URL:   publicLink(articlePath("", target)),
+			Time:  change.Time,
+		})
+	}
+
+	var deliveries []delivery
+	watchers.mu.Lock()
+	for _, watcher := range watchers.byID {
+		for _, notification := range notifications {
+			if !watcher.watches(wiki, notification.Kind, notification.Title) {
+				continue
+			}
+			watcher.inbox = append(watcher.inbox, notification)
+			if len(watcher.inbox) > maxInbox {
+				watcher.inbox = watcher.inbox[len(watcher.inbox)-maxInbox:]
+			}
+			watcher.unread = min(watcher.unread+1, maxInbox)
+
+			email := ""
+			if watcher.EmailAlerts && emailEnabled() {
+				email = watcher.Email
+			}
+			if (email != "" || watcher.Webhook != "") && watcher.allowDelivery() {
+				deliveries = append(deliveries, delivery{
+					site:         site,
+					email:        email,
+					unsubscribe:  watcher.unsubscribeLink(),
+					webhook:      watcher.Webhook,
+					notification: notification,
+				})
+			}
+		}
+	}
+	watchers.mu.Unlock()
+
+	for _, d := range deliveries {
+		go d.send()
+	}
+}
+
+// watches reports whether a watcher watches an article. Callers hold
+// watchers.mu.
+func (w *Watcher) watches(wiki, kind, title string) bool {
+	for _, watch := range w.Watches {
+		if watch.covers(wiki, kind, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchHandler starts or stops watching an article for the reader, giving
+// them a watcher id if they have none yet.
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Title    string `json:"title"`
+		Kind     string `json:"kind"`
+		Watch    bool   `json:"watch"`
+		Subpages bool   `json:"subpages"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	title, err := normalizeTitle(request.Title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	watch := Watch{
+		Wiki:     wikiFrom(r.Context()).Name,
+		Title:    title,
+		Kind:     articleKinds[request.Kind].Name,
+		Subpages: request.Subpages,
+	}
+
+	id := watcherID(r)
+	watchers.mu.Lock()
+	watcher, ok := watchers.byID[id]
+	if !ok && request.Watch {
+		if len(watchers.byID) >= maxWatchers {
+			watchers.mu.Unlock()
+			http.Error(w, "This instance can't take any more watchlists", http.StatusServiceUnavailable)
+			return
+		}
+		id = randomToken()
+		watcher = &Watcher{}
+		watchers.byID[id] = watcher
+	}
+	if watcher != nil {
+		kept := watcher.Watches[:0]
+		for _, earlier := range watcher.Watches {
+			if earlier.Wiki != watch.Wiki || earlier.Kind != watch.Kind || earlier.Title != watch.Title {
+				kept = append(kept, earlier)
+			}
+		}
+		watcher.Watches = kept
+		if request.Watch {
+			if len(watcher.Watches) >= maxWatches {
+				watchers.mu.Unlock()
+				http.Error(w, fmt.Sprintf("A watchlist can hold up to %d articles", maxWatches), http.StatusBadRequest)
+				return
+			}
+			watcher.Watches = append(watcher.Watches, watch)
+		}
+	}
+	watchers.mu.Unlock()
+	saveWatchlists()
+
+	if request.Watch {
+		setWatcherCookie(w, r, id)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"watching": request.Watch})
+}
+
+func watchlistHandler(w http.ResponseWriter, r *http.Request) {
+	wiki := wikiFrom(r.Context()).Name
+	data := struct {
+		Branding
+		Watches        []Watch
+		Notifications  []Notification
+		Unread         int
+		Email          string
+		PendingEmail   string
+		Webhook        string
+		EmailAlerts    bool
+		Digest         bool
+		EmailAvailable bool
+		Confirmed      bool
+		Unsubscribed   bool
+		Kept           bool
+	}{
+		Branding:       brandingFor(r.Context()),
+		EmailAvailable: emailEnabled(),
+		Confirmed:      r.URL.Query().Get("confirmed") != "",
+		Unsubscribed:   r.URL.Query().Get("unsubscribed") != "",
+		Kept:           watchlistsFile != "",
+	}
+
+	watchers.mu.Lock()
+	if watcher, ok := watchers.byID[watcherID(r)]; ok {
+		for _, watch := range watcher.Watches {
+			if watch.Wiki == wiki {
+				data.Watches = append(data.Watches, watch)
+			}
+		}
+		// The unread notifications are the latest ones
+		for i := len(watcher.inbox) - 1; i >= 0; i-- {
+			if watcher.inbox[i].Wiki != wiki {
+				continue
+			}
+			data.Notifications = append(data.Notifications, watcher.inbox[i])
+			if len(watcher.inbox)-i <= watcher.unread {
+				data.Unread++
+			}
+		}
+		watcher.unread = 0
+		data.Email = watcher.Email
+		data.PendingEmail = watcher.PendingEmail
+		data.Webhook = watcher.Webhook
+		data.EmailAlerts = watcher.EmailAlerts
+		data.Digest = watcher.Digest && watcher.DigestWiki == wiki
+	} else {
+		// Alerts are what most readers giving an address want
+		data.EmailAlerts = true
+	}
+	watchers.mu.Unlock()
+	sort.SliceStable(data.Watches, func(i, j int) bool { return data.Watches[i].Title < data.Watches[j].Title })
+
+	renderPage(w, "watchlist.html", data)
+}
+
+// watchlistSaveHandler changes a watchlist from the watchlist page: a watch
+// dropped or extended to sub-articles, the inbox cleared, or where
+// notifications are delivered.
+func watchlistSaveHandler(w http.ResponseWriter, r *http.Request) {
+	id := watcherID(r)
+	wiki := wikiFrom(r.Context()).Name
+	action := r.FormValue("action")
+
+	watchers.mu.Lock()
+	watcher, ok := watchers.byID[id]
+	// A reader can sign up for the digest before watching anything
+	if !ok && action == "delivery" && len(watchers.byID) < maxWatchers {
+		id = randomToken()
+		watcher = &Watcher{}
+		watchers.byID[id] = watcher
+		ok = true
+	}
+	if !ok {
+		watchers.mu.Unlock()
+		http.Error(w, "You aren't watching anything yet", http.StatusNotFound)
+		return
+	}
+
+	var confirm string
+	switch action {
+	case "unwatch", "subpages":
+		kind, title := r.FormValue("kind"), r.FormValue("title")
+		kept := watcher.Watches[:0]
+		for _, watch := range watcher.Watches {
+			if watch.Wiki == wiki && watch.Kind == kind && watch.Title == title {
+				if action == "unwatch" {
+					continue
+				}
+				watch.Subpages = r.FormValue("subpages") == "on"
+			}
+			kept = append(kept, watch)
+		}
+		watcher.Watches = kept
+	case "clear":
+		watcher.inbox = nil
+		watcher.unread = 0
+	case "delivery":
+		webhook := strings.TrimSpace(r.FormValue("webhook"))
+		if webhook != "" {
+			if !validWebhook(webhook) {
+				watchers.mu.Unlock()
+				renderError(w, http.StatusBadRequest, "The webhook must be an http or https URL")
+				return
+			}
+		}
+		watcher.Webhook = webhook
+
+		email := strings.TrimSpace(r.FormValue("email"))
+		if email != "" {
+			address, err := mail.ParseAddress(email)
+			if err != nil {
+				watchers.mu.Unlock()
+				renderError(w, http.StatusBadRequest, "That doesn't look like an email address")
+				return
+			}
+			email = address.Address
+		}
+		switch {
+		case email == "":
+			watcher.Email, watcher.PendingEmail, watcher.EmailToken = "", "", ""
+		case email != watcher.Email && email != watcher.PendingEmail && emailEnabled():
+			if !watcher.allowDelivery() {
+				watchers.mu.Unlock()
+				renderError(w, http.StatusTooManyRequests, "Too many emails were sent for this watchlist lately, please try again later")
+				return
+			}
+			watcher.PendingEmail = email
+			watcher.EmailToken = randomToken()
+			confirm = watcher.EmailToken
+		}
+
+		watcher.EmailAlerts = r.FormValue("alerts") == "on"
+		digest := r.FormValue("digest") == "on"
+		if digest && (!watcher.Digest || watcher.DigestWiki != wiki) {
+			// The first digest covers the week from now
+			watcher.DigestWiki = wiki
+			watcher.LastDigest = time.Now()
+		}
+		if digest || watcher.DigestWiki == wiki {
+			watcher.Digest = digest
+		}
+	default:
+		watchers.mu.Unlock()
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	pending := watcher.PendingEmail
+	watchers.mu.Unlock()
+	saveWatchlists()
+
+	if confirm != "" {
+		data := struct {
+			Site string
+			Link string
+		}{settingsFor(r.Context()).siteName(), publicLink("/watchlist/confirm?token=" + confirm)}
+		go func() {
+			if err := sendTemplatedEmail(pending, "confirm", "", data); err != nil {
+				log.Printf("Error sending watchlist confirmation email: %v", err)
+			}
+		}()
+	}
+	setWatcherCookie(w, r, id)
+	http.Redirect(w, r, "/watchlist", http.StatusSeeOther)
+}
+
+// confirmEmailHandler confirms the email address a watchlist's
+// notifications are sent to. It works from any browser, since the link is
+// opened wherever the email is read.
+func confirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	confirmed := false
+	watchers.mu.Lock()
+	for _, watcher := range watchers.byID {
+		if token != "" && watcher.EmailToken == token {
+			watcher.Email = watcher.PendingEmail
+			watcher.PendingEmail, watcher.EmailToken = "", ""
+			if watcher.Unsubscribe == "" {
+				watcher.Unsubscribe = randomToken()
+			}
+			confirmed = true
+			break
+		}
+	}
+	watchers.mu.Unlock()
+
+	if !confirmed {
+		renderError(w, http.StatusNotFound, "That confirmation link has been used already, or replaced by a newer one")
+		return
+	}
+	saveWatchlists()
+	http.Redirect(w, r, "/watchlist?confirmed=1", http.StatusSeeOther)
+}
+
+// unsubscribeLink is the link in a watcher's emails that stops them.
+// Callers hold watchers.mu.
+func (w *Watcher) unsubscribeLink() string {
+	if w.Unsubscribe == "" {
+		return ""
+	}
+	return publicLink("/watchlist/unsubscribe?token=" + w.Unsubscribe)
+}
+
+// unsubscribeHandler stops every email to a watchlist, from the link in
+// them.
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	found := false
+	watchers.mu.Lock()
+	for _, watcher := range watchers.byID {
+		if token != "" && watcher.Unsubscribe == token {
+			watcher.EmailAlerts, watcher.Digest = false, false
+			found = true
+			break
+		}
+	}
+	watchers.mu.Unlock()
+
+	if !found {
+		renderError(w, http.StatusNotFound, "That unsubscribe link isn't valid anymore")
+		return
+	}
+	saveWatchlists()
+	http.Redirect(w, r, "/watchlist?unsubscribed=1", http.StatusSeeOther)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// One instance can serve several wikis, each with its own flavor (model,
+// prompt, theme) and its own activity feed, picked by the hostname a request
+// arrives on. They are listed in WIKIS_FILE, or provisioned on the fly for
+// subdomains of WIKI_DOMAIN. Requests for any other host get the default
+// wiki, configured by the environment as usual.
+//
+// Wikis aren't picked by a path prefix like /lore/wiki/Dragons. Every page,
+// script and stored link addresses articles from the root, as /wiki/ and
+// /stream/, so a prefix would have to be threaded through all of them, and
+// a wiki is just as easily given a hostname of its own.
+
+// Wiki is one of the wikis served by the instance.
+type Wiki struct {
+	Name     string
+	Hosts    []string
+	Settings Settings
+
+	activity *activityHub
+	changes  *changeLog
+}
+
+// wikiConfig is how a wiki is written in WIKIS_FILE. Its settings are
+// layered over the instance's, so only what differs needs listing.
+type wikiConfig struct {
+	Name     string          `json:"name"`
+	Hosts    []string        `json:"hosts"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+}
+
+const defaultWikiName = "default"
+
+// wikiNamePattern is what wiki names look like. A name is a folder of the
+// disk store and a key of the others, so it can't be anything that reaches
+// outside of them, like "..".
+var wikiNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Wikis are replaced rather than modified when edited, so a request keeps a
+// consistent view of its wiki while it runs.
+var (
+	defaultWiki  = &Wiki{Name: defaultWikiName, activity: activity, changes: newChangeLog()}
+	wikis        = map[string]*Wiki{}
+	wikiConfigs  = map[string]wikiConfig{}
+	wikisByHost  = map[string]*Wiki{}
+	wikisMu      sync.RWMutex
+	wikisFile    string
+	wikisWriteMu sync.Mutex
+)
+
+type wikiContextKey struct{}
+
+// loadWikis sets up the default wiki from the instance settings and reads
+// the other wikis from WIKIS_FILE, if set.
+func loadWikis() {
+	defaultWiki.Settings = settings
+
+	wikisFile = os.Getenv("WIKIS_FILE")
+	if wikisFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(wikisFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading wikis file '%s': %v", wikisFile, err)
+		}
+		return
+	}
+
+	var configs []wikiConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		log.Printf("Error parsing wikis file '%s': %v", wikisFile, err)
+		return
+	}
+
+	wikisMu.Lock()
+	defer wikisMu.Unlock()
+
+	for _, config := range configs {
+		wiki, err := newWiki(config, nil)
+		if err != nil {
+			log.Printf("Skipping wiki '%s': %v", config.Name, err)
+			continue
+		}
+		addWiki(wiki, config)
+	}
+	log.Printf("Loaded %d wikis from '%s'", len(wikis), wikisFile)
+}
+
+// newWiki builds a wiki from its configuration, reusing the activity feed of
+// the wiki it replaces, if any.
+func newWiki(config wikiConfig, previous *Wiki) (*Wiki, error) {
+	if config.Name == defaultWikiName || !wikiNamePattern.MatchString(config.Name) {
+		return nil, fmt.Errorf("wikis need a name other than %q of only lowercase letters, digits and dashes", defaultWikiName)
+	}
+
+	wiki := &Wiki{Name: config.Name, Settings: settings}
+	for _, host := range config.Hosts {
+		wiki.Hosts = append(wiki.Hosts, strings.ToLower(host))
+	}
+	if len(config.Settings) > 0 {
+		// A wiki's routes replace the instance's rather than adding to them
+		wiki.Settings.Routes = nil
+		if err := json.Unmarshal(config.Settings, &wiki.Settings); err != nil {
+			return nil, fmt.Errorf("invalid settings: %v", err)
+		}
+		if wiki.Settings.Routes == nil {
+			wiki.Settings.Routes = settings.Routes
+		}
+	}
+	if err := checkPrompt(wiki.Settings.Prompt); err != nil {
+		return nil, err
+	}
+	if _, err := compileTrimRules(wiki.Settings.TrimRules); err != nil {
+		return nil, err
+	}
+	if accent := wiki.Settings.AccentColor; accent != "" && !accentPattern.MatchString(accent) {
+		return nil, fmt.Errorf("the accent color must be like #007cba")
+	}
+	if kind := unknownSectionKind(wiki.Settings.HomeSections); kind != "" {
+		return nil, fmt.Errorf("home sections can't link to %q articles, there is no such kind", kind)
+	}
+	if err := checkRoutes(wiki.Settings.Routes); err != nil {
+		return nil, err
+	}
+	if err := checkNavLinks(wiki.Settings.NavLinks); err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		wiki.activity = previous.activity
+		wiki.changes = previous.changes
+	} else {
+		wiki.activity = newActivityHub()
+		wiki.changes = newChangeLog()
+	}
+	return wiki, nil
+}
+
+// addWiki registers a wiki, replacing any with the same name. Callers hold
+// wikisMu for writing.
+func addWiki(wiki *Wiki, config wikiConfig) {
+	if old, ok := wikis[wiki.Name]; ok {
+		for _, host := range old.Hosts {
+			delete(wikisByHost, host)
+		}
+	}
+
+	wikis[wiki.Name] = wiki
+	wikiConfigs[wiki.Name] = config
+	for _, host := range wiki.Hosts {
+		wikisByHost[host] = wiki
+	}
+}
+
+// saveWikis writes the wikis back to WIKIS_FILE.
+func saveWikis() error {
+	if wikisFile == "" {
+		return fmt.Errorf("WIKIS_FILE is not set")
+	}
+
+	// Hold the write lock from the snapshot on, so saves land in order
+	wikisWriteMu.Lock()
+	defer wikisWriteMu.Unlock()
+
+	wikisMu.RLock()
+	configs := make([]wikiConfig, 0, len(wikiConfigs))
+	for _, config := range wikiConfigs {
+		configs = append(configs, config)
+	}
+	wikisMu.RUnlock()
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+
+	data, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave it half written
+	tmp := wikisFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, wikisFile)
+}
+
+// wikiNamed returns the wiki with a name, the default one included.
+func wikiNamed(name string) (*Wiki, bool) {
+	if name == defaultWikiName {
+		return defaultWiki, true
+	}
+
+	wikisMu.RLock()
+	defer wikisMu.RUnlock()
+
+	wiki, ok := wikis[name]
+	return wiki, ok
+}
+
+// allWikis lists every wiki, the default first.
+func allWikis() []*Wiki {
+	wikisMu.RLock()
+	defer wikisMu.RUnlock()
+
+	return allWikisLocked()
+}
+
+// allWikisLocked is allWikis for callers already holding wikisMu.
+func allWikisLocked() []*Wiki {
+	list := []*Wiki{defaultWiki}
+	names := make([]string, 0, len(wikis))
+	for name := range wikis {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		list = append(list, wikis[name])
+	}
+	return list
+}
+
+// maxProvisionedWikis caps how many wikis visitors can create through
+// subdomains.
+const maxProvisionedWikis = 500
+
+// reservedSubdomains are never provisioned as wikis.
+var reservedSubdomains = map[string]bool{"www": true, "admin": true, defaultWikiName: true}
+
+// provisionWiki creates a wiki with the default settings the first time a
+// subdomain of WIKI_DOMAIN is visited, like cats.wiki.example.com.
+func provisionWiki(host string) (*Wiki, bool) {
+	domain := strings.ToLower(strings.TrimPrefix(os.Getenv("WIKI_DOMAIN"), "."))
+	if domain == "" || !strings.HasSuffix(host, "."+domain) {
+		return nil, false
+	}
+	label := strings.TrimSuffix(host, "."+domain)
+	if !isDNSLabel(label) || reservedSubdomains[label] {
+		return nil, false
+	}
+
+	config := wikiConfig{Name: label, Hosts: []string{host}}
+	config.Settings, _ = json.Marshal(map[string]string{"site_name": subdomainSiteName(label)})
+
+	wikisMu.Lock()
+	// Someone else may have got here first
+	if wiki, ok := wikisByHost[host]; ok {
+		wikisMu.Unlock()
+		return wiki, true
+	}
+	if _, taken := wikis[label]; taken || len(wikis) >= maxProvisionedWikis {
+		wikisMu.Unlock()
+		return nil, false
+	}
+	wiki, err := newWiki(config, nil)
+	if err != nil {
+		wikisMu.Unlock()
+		log.Printf("Error provisioning wiki for '%s': %v", host, err)
+		return nil, false
+	}
+	addWiki(wiki, config)
+	wikisMu.Unlock()
+
+	log.Printf("Provisioned wiki '%s' for '%s'", label, host)
+	if wikisFile != "" {
+		if err := saveWikis(); err != nil {
+			log.Printf("Error saving wikis: %v", err)
+		}
+	}
+	return wiki, true
+}
+
+// isDNSLabel reports whether s is a single lowercase hostname label.
+func isDNSLabel(s string) bool {
+	if s == "" || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// subdomainSiteName names a provisioned wiki after its subdomain, so
+// cats.wiki.example.com becomes the Cats Wiki.
+func subdomainSiteName(label string) string {
+	words := strings.Fields(strings.ReplaceAll(label, "-", " "))
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ") + " Wiki"
+}
+
+// wikiForHost finds the wiki served on a hostname.
+func wikiForHost(host string) *Wiki {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+
+	wikisMu.RLock()
+	wiki, ok := wikisByHost[host]
+	wikisMu.RUnlock()
+
+	if ok {
+		return wiki
+	}
+	if wiki, ok := provisionWiki(host); ok {
+		return wiki
+	}
+	return defaultWiki
+}
+
+// withWiki picks the wiki for every request from its Host header.
+func withWiki(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), wikiContextKey{}, wikiForHost(r.Host))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// wikiFrom returns the wiki a request is for.
+func wikiFrom(ctx context.Context) *Wiki {
+	if wiki, ok := ctx.Value(wikiContextKey{}).(*Wiki); ok {
+		return wiki
+	}
+	return defaultWiki
+}
+
+// settingsFor returns the settings of the wiki a request is for.
+func settingsFor(ctx context.Context) *Settings {
+	return &wikiFrom(ctx).Settings
+}
+
+// activityFor returns the activity feed of the wiki a request is for.
+func activityFor(ctx context.Context) *activityHub {
+	return wikiFrom(ctx).activity
+}